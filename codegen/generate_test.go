@@ -0,0 +1,227 @@
+package codegen
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jonoans/mongo-gen/config"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/tools/go/packages"
+)
+
+var update = flag.Bool("update", false, "update the golden files of testdata")
+
+// TestGenerate generates the output package of each testdata directory and compares it with
+// the golden files of its golden directory, run with -update to rewrite them. Each directory
+// holds an orm.yml, the models in input, optionally an existing output package in output
+// and optionally tests of the generated package in tests
+func TestGenerate(t *testing.T) {
+	cases, err := filepath.Glob(filepath.Join("testdata", "*", "orm.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cfgFilename := range cases {
+		caseDir := filepath.Dir(cfgFilename)
+		t.Run(filepath.Base(caseDir), func(t *testing.T) {
+			dir := newFixtureModule(t, caseDir)
+			if err := Generate(config.ParseConfig(filepath.Join(dir, "orm.yml"))); err != nil {
+				t.Fatal(err)
+			}
+
+			outputDir := filepath.Join(dir, "output")
+			compareGoldenFiles(t, outputDir, filepath.Join(caseDir, "golden"))
+			typeCheckPackage(t, outputDir)
+			runPackageTests(t, filepath.Join(caseDir, "tests"), outputDir)
+		})
+	}
+}
+
+// newFixtureModule copies a testdata directory into a module of its own, mongo-gen is replaced
+// by this repository and the fixture is named after the directory as loaded packages are cached
+func newFixtureModule(t *testing.T, caseDir string) string {
+	dir := t.TempDir()
+	if err := os.CopyFS(dir, os.DirFS(caseDir)); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"golden", "tests"} {
+		if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, filename, _, _ := runtime.Caller(0)
+	repoDir := filepath.Dir(filepath.Dir(filename))
+	contents, err := os.ReadFile(filepath.Join(repoDir, "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modFile, err := modfile.Parse("go.mod", contents, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repoPath := modFile.Module.Mod.Path
+	if err := modFile.AddModuleStmt("fixtures/" + filepath.Base(caseDir)); err != nil {
+		t.Fatal(err)
+	}
+	if err := modFile.AddRequire(repoPath, "v0.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := modFile.AddReplace(repoPath, "", repoDir, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err = modFile.Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sums, err := os.ReadFile(filepath.Join(repoDir, "go.sum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "go.sum"), sums, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep the fixture out of any workspace of the repository
+	t.Setenv("GOWORK", "off")
+	return dir
+}
+
+// compareGoldenFiles compares the generated files and TypeScript declarations with the golden ones,
+// codegen_.go is a copy of the definitions package and is only type checked
+func compareGoldenFiles(t *testing.T, outputDir, goldenDir string) {
+	generated, err := filepath.Glob(filepath.Join(outputDir, "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	declarations, err := filepath.Glob(filepath.Join(outputDir, "*.d.ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated = append(generated, declarations...)
+
+	names := []string{}
+	for _, filename := range generated {
+		if name := filepath.Base(filename); name != "codegen_.go" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	if *update {
+		if err := os.RemoveAll(goldenDir); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(goldenDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range names {
+		got, err := os.ReadFile(filepath.Join(outputDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		goldenFilename := filepath.Join(goldenDir, name+".golden")
+		if *update {
+			if err := os.WriteFile(goldenFilename, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		want, err := os.ReadFile(goldenFilename)
+		if err != nil {
+			t.Errorf("Unexpected generated file %s: %s", name, err)
+			continue
+		}
+
+		if string(got) != string(want) {
+			t.Errorf("%s differs from %s, run go test ./codegen -update to accept the changes\n%s", name, goldenFilename, lineDiff(string(want), string(got)))
+		}
+	}
+
+	goldens, err := filepath.Glob(filepath.Join(goldenDir, "*.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(goldens) != len(names) && !*update {
+		t.Errorf("Generated %d files, expected %d golden files", len(names), len(goldens))
+	}
+}
+
+// lineDiff describes the first line differing between want and got
+func lineDiff(want, got string) string {
+	wantLines, gotLines := strings.Split(want, "\n"), strings.Split(got, "\n")
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		var wantLine, gotLine string
+		if i < len(wantLines) {
+			wantLine = wantLines[i]
+		}
+		if i < len(gotLines) {
+			gotLine = gotLines[i]
+		}
+
+		if wantLine != gotLine {
+			return fmt.Sprintf("line %d:\n  want: %s\n   got: %s", i+1, wantLine, gotLine)
+		}
+	}
+	return ""
+}
+
+// typeCheckPackage loads the generated package with go/types, catching generated code which does not compile
+func typeCheckPackage(t *testing.T, outputDir string) {
+	cfg := &packages.Config{Mode: packages.NeedName | packages.NeedImports | packages.NeedDeps | packages.NeedTypes | packages.NeedSyntax | packages.NeedTypesInfo, Dir: outputDir}
+	pkgs, err := packages.Load(cfg, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		for _, err := range pkg.Errors {
+			t.Errorf("Generated package does not type check: %s", err)
+		}
+	})
+}
+
+// runPackageTests copies the tests of the fixture into the generated package and runs them
+func runPackageTests(t *testing.T, testsDir, outputDir string) {
+	tests, err := filepath.Glob(filepath.Join(testsDir, "*_test.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tests) == 0 {
+		return
+	}
+
+	for _, filename := range tests {
+		contents, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(outputDir, filepath.Base(filename)), contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command("go", "test", ".")
+	cmd.Dir = outputDir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("Tests of the generated package failed: %s\n%s", err, output)
+	}
+}
@@ -0,0 +1,30 @@
+package codegen
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/jonoans/mongo-gen/codegen/internal"
+	"github.com/jonoans/mongo-gen/config"
+)
+
+// WriteGraph renders the collections of the target named target, or of every target when empty,
+// with their fields and references to w as a Mermaid or Graphviz DOT diagram
+func WriteGraph(cfg *config.ConfigFile, target string, format string, w io.Writer) error {
+	if target != "" && cfg.Target(target) == nil {
+		return fmt.Errorf("unknown target %q", target)
+	}
+
+	if format != internal.GraphFormatMermaid && format != internal.GraphFormatDOT {
+		return fmt.Errorf("unknown graph format %q, expected mermaid or dot", format)
+	}
+
+	graph := &internal.Graph{}
+	_, pkgs := loadPackages(cfg)
+	for i, pkg := range pkgs {
+		if target == "" || cfg.Targets[i].Name == target {
+			graph.AddPackage(pkg)
+		}
+	}
+	return graph.Write(w, format)
+}
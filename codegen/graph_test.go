@@ -0,0 +1,50 @@
+package codegen
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jonoans/mongo-gen/config"
+	"golang.org/x/tools/go/packages"
+)
+
+// TestWriteGraph renders the collections of the references testdata in each format and compares
+// them with the golden files of its graph directory, run with -update to rewrite them
+func TestWriteGraph(t *testing.T) {
+	caseDir := filepath.Join("testdata", "references")
+	dir := newFixtureModule(t, caseDir)
+
+	// The fixture may have been loaded by TestGenerate from a removed directory
+	cachedPkgs = map[string]*packages.Package{}
+
+	cfg := config.ParseConfig(filepath.Join(dir, "orm.yml"))
+	for format, extension := range map[string]string{"mermaid": "mmd", "dot": "dot"} {
+		t.Run(format, func(t *testing.T) {
+			got := bytes.NewBuffer(nil)
+			if err := WriteGraph(cfg, "", format, got); err != nil {
+				t.Fatal(err)
+			}
+
+			goldenFilename := filepath.Join(caseDir, "graph", "collections."+extension+".golden")
+			if *update {
+				if err := os.MkdirAll(filepath.Dir(goldenFilename), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(goldenFilename, got.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(goldenFilename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != string(want) {
+				t.Errorf("%s differs from %s, run go test ./codegen -update to accept the changes\n%s", format, goldenFilename, lineDiff(string(want), got.String()))
+			}
+		})
+	}
+}
@@ -3,66 +3,144 @@ package codegen
 import (
 	"bufio"
 	"fmt"
-	"go/ast"
 	"go/printer"
-	"go/token"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/jonoans/mongo-gen/codegen/internal"
 	"github.com/jonoans/mongo-gen/config"
 	"github.com/jonoans/mongo-gen/utils"
+	"golang.org/x/tools/go/packages"
 )
 
+// Generate writes the output package of every target
 func Generate(cfg *config.ConfigFile) error {
-	decls, reservedNames := getInternalDefinitions()
-	internal.InitReservedValues(reservedNames)
-	internal.ReadAllTemplateFiles()
+	return GenerateTarget(cfg, "")
+}
+
+// GenerateTarget writes the output package of the target named target, or of every target when empty.
+// Every target is loaded as models may reference the collections of other targets
+func GenerateTarget(cfg *config.ConfigFile, target string) error {
+	if target != "" && cfg.Target(target) == nil {
+		return fmt.Errorf("unknown target %q", target)
+	}
+
+	definitions, pkgs := loadPackages(cfg)
+	for i, pkg := range pkgs {
+		if target != "" && cfg.Targets[i].Name != target {
+			continue
+		}
 
-	pkg := initPackage(cfg)
-	pkgFiles := pkg.GeneratePackageFiles()
+		pkgFiles := pkg.GeneratePackageFiles()
+		outputCfg := &cfg.Targets[i].Output
+		slog.Info("Generating target", "target", cfg.Targets[i].Name, "models", cfg.Targets[i].Models.ImportPath, "output", outputCfg.ImportPath)
 
-	createOutputDirectory(&cfg.Output)
-	writeDefinitionsPackage(&cfg.Output, decls)
-	for _, pkgFile := range pkgFiles {
-		pkgFile.Init()
-		pkgFile.Sort()
-		pkgFile.WriteToFile(&cfg.Output)
+		createOutputDirectory(outputCfg)
+		writeDefinitionsPackage(outputCfg, definitions)
+		pkg.WriteMocks(outputCfg)
+		pkg.WriteValidators(outputCfg)
+		if outputCfg.TypeScript != nil {
+			pkg.WriteTypeScript()
+		}
+		for _, pkgFile := range pkgFiles {
+			pkgFile.Init()
+			pkgFile.Sort()
+			pkgFile.WriteToFile(outputCfg)
+		}
 	}
 
+	// The registry imports the output package of the migrations target
+	if cfg.Migrations != nil && (target == "" || target == cfg.Migrations.Target) {
+		return internal.WriteMigrationsRegistry(cfg)
+	}
 	return nil
 }
 
-func initPackage(cfg *config.ConfigFile) *internal.Package {
-	moduleRoot := cfg.Models.ModuleRoot
-	userModels, generatedModels := filepath.Join(moduleRoot, cfg.Models.PackagePath), filepath.Join(moduleRoot, cfg.Output.PackagePath)
-	userPackagePath, generatedPackagePath := filepath.ToSlash(userModels), filepath.ToSlash(generatedModels)
-	loadedPkgs, err := loadPackage(userPackagePath, generatedPackagePath)
-	if err != nil {
-		log.Fatal(err)
+// loadPackages parses the templates and loads the packages of every target
+func loadPackages(cfg *config.ConfigFile) (*internalDefinitions, []*internal.Package) {
+	definitions, reservedNames := getInternalDefinitions()
+	internal.InitReservedValues(reservedNames)
+	internal.ReadAllTemplateFiles(cfg.Templates)
+	return definitions, initPackages(cfg)
+}
+
+// initPackages loads the models and output packages of every target in a single load,
+// models may reference collections declared in the other targets
+func initPackages(cfg *config.ConfigFile) []*internal.Package {
+	patterns := []string{}
+	for _, pc := range cfg.Targets {
+		patterns = append(patterns, pc.Models.ImportPath, pc.Output.ImportPath)
 	}
 
-	userPkg, outputPkg := loadedPkgs[0], loadedPkgs[1]
-	generatedLines, err := readFiles(outputPkg.GoFiles)
+	// Run from the config directory so the go command picks the same module or workspace
+	loadedPkgs, err := loadPackage(cfg.Dir, patterns...)
 	if err != nil {
-		log.Fatal(err)
+		utils.Fatal("Could not load packages", "error", err)
 	}
 
-	delete(generatedLines, "codegen_.go")
-	for _, f := range cfg.Output.IgnoredFiles {
-		delete(generatedLines, f)
+	pkgs := []*internal.Package{}
+	siblings := map[string]*internal.Package{}
+	for i, pc := range cfg.Targets {
+		userPkg, outputPkg := loadedPkgs[2*i], loadedPkgs[2*i+1]
+		checkLoadedPackage(userPkg, pc.Models.Module)
+		generatedLines, err := readFiles(outputPkg.GoFiles)
+		if err != nil {
+			utils.Fatal("Could not read output package", "package", outputPkg.PkgPath, "error", err)
+		}
+
+		for f := range generatedLines {
+			if internal.IsCodegenFile(f) {
+				delete(generatedLines, f)
+			}
+		}
+		for _, f := range pc.Output.IgnoredFiles {
+			delete(generatedLines, f)
+		}
+
+		pkgObject := &internal.Package{
+			InputUser:             userPkg,
+			InputGenerated:        outputPkg,
+			InputGeneratedLines:   generatedLines,
+			IgnoredUserFiles:      pc.Models.IgnoredFiles,
+			IgnoredGeneratedFiles: pc.Output.IgnoredFiles,
+			OutputPkgName:         pc.Output.PackageName,
+			OutputPkgPath:         pc.Output.ImportPath,
+			Siblings:              siblings,
+			CollectionNaming:      pc.Output.CollectionNaming,
+			Tags:                  pc.Output.Tags,
+			TypeScript:            pc.Output.TypeScript,
+		}
+		siblings[userPkg.PkgPath] = pkgObject
+		pkgs = append(pkgs, pkgObject)
 	}
 
-	pkgObject := &internal.Package{
-		InputUser:             userPkg,
-		InputGenerated:        outputPkg,
-		InputGeneratedLines:   generatedLines,
-		IgnoredUserFiles:      cfg.Models.IgnoredFiles,
-		IgnoredGeneratedFiles: cfg.Output.IgnoredFiles,
+	// Structs of every package are parsed before references are resolved
+	for _, pkg := range pkgs {
+		pkg.Init()
+	}
+	for _, pkg := range pkgs {
+		pkg.InitReferences()
+	}
+
+	return pkgs
+}
+
+// checkLoadedPackage stops on models packages the go command could not load
+// or which it resolved to another module than the one found from their directory
+func checkLoadedPackage(pkg *packages.Package, module *config.Module) {
+	if pkg == nil {
+		utils.Fatal("Models package could not be loaded")
+	}
+
+	if len(pkg.Errors) > 0 {
+		utils.Fatal("Could not load models package", "package", pkg.PkgPath, "error", pkg.Errors[0])
+	}
+
+	if pkg.Module != nil && (pkg.Module.Path != module.Path || filepath.Clean(pkg.Module.Dir) != module.Dir) {
+		utils.Fatal("Models package was loaded from another module", "package", pkg.PkgPath, "module", pkg.Module.Path, "dir", pkg.Module.Dir, "expectedModule", module.Path, "expectedDir", module.Dir)
 	}
-	pkgObject.Init()
-	return pkgObject
 }
 
 func readFiles(files []string) (map[string][]string, error) {
@@ -91,38 +169,64 @@ func readFiles(files []string) (map[string][]string, error) {
 func createOutputDirectory(cfg *config.OutputConfig) {
 	err := os.MkdirAll(cfg.PackagePath, os.ModeDir|os.ModePerm)
 	if err != nil {
-		log.Fatalf("Error creating output directory: %s", err)
+		utils.Fatal("Error creating output directory", "error", err)
 	}
 }
 
-func writeDefinitionsPackage(cfg *config.OutputConfig, decls []ast.Decl) {
+func writeDefinitionsPackage(cfg *config.OutputConfig, definitions *internalDefinitions) {
 	outputFilepath := filepath.Join(cfg.PackagePath, "codegen_.go")
 	fh, err := os.OpenFile(outputFilepath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
 	defer fh.Close()
 	if err != nil {
-		log.Fatalf("Could not open output file: %s", err)
+		utils.Fatal("Could not open output file", "error", err)
 	}
 
 	_, err = fh.WriteString(fmt.Sprintf("package %s\n\n", cfg.PackageName))
 	if err != nil {
-		log.Fatalf("Could not write to output file: %s", err)
+		utils.Fatal("Could not write to output file", "error", err)
 	}
 
 	_, err = fh.WriteString("// Code generated by mongo-gen. DO NOT EDIT.\n\n")
 	if err != nil {
-		log.Fatalf("Could not write to output file: %s", err)
+		utils.Fatal("Could not write to output file", "error", err)
 	}
 
-	fset := token.NewFileSet()
-	for _, decl := range decls {
-		err = printer.Fprint(fh, fset, decl)
+	_, err = fh.WriteString(definitionsImportDecl(definitions.Imports))
+	if err != nil {
+		utils.Fatal("Could not write to output file", "error", err)
+	}
+
+	for _, decl := range definitions.Decls {
+		err = printer.Fprint(fh, definitions.Fset, decl)
 		if err != nil {
-			log.Fatalf("Could not write to output file: %s", err)
+			utils.Fatal("Could not write to output file", "error", err)
 		}
 
 		_, err = fh.WriteString("\n\n")
 		if err != nil {
-			log.Fatalf("Could not write to output file: %s", err)
+			utils.Fatal("Could not write to output file", "error", err)
+		}
+	}
+}
+
+// definitionsImportDecl renders the imports of the definitions files in a single declaration,
+// standard library packages grouped first
+func definitionsImportDecl(specs []string) string {
+	std, others := []string{}, []string{}
+	for _, spec := range specs {
+		path := importSpecPath(spec)
+		if strings.Contains(path[:strings.IndexAny(path, "/\"")], ".") {
+			others = append(others, "\t"+spec)
+		} else {
+			std = append(std, "\t"+spec)
+		}
+	}
+
+	groups := []string{}
+	for _, group := range [][]string{std, others} {
+		if len(group) > 0 {
+			groups = append(groups, strings.Join(group, "\n"))
 		}
 	}
+	return "import (\n" + strings.Join(groups, "\n\n") + "\n)\n\n"
 }
@@ -1,11 +1,15 @@
 package internal
 
 import (
+	"bytes"
 	"fmt"
 	"go/ast"
-	"log"
+	"go/printer"
+	"go/token"
 	"reflect"
 	"strings"
+
+	"github.com/jonoans/mongo-gen/utils"
 )
 
 func astIdentSliceToString(exprs []*ast.Ident) string {
@@ -16,31 +20,69 @@ func astIdentSliceToString(exprs []*ast.Ident) string {
 	return strings.Join(exprsStr, "")
 }
 
-func astObjectToString(expr ast.Expr) string {
+// newDocComment builds a doc comment, each line of the formatted text is prefixed with //
+func newDocComment(format string, args ...any) *ast.CommentGroup {
+	comments := &ast.CommentGroup{}
+	for _, line := range strings.Split(fmt.Sprintf(format, args...), "\n") {
+		comments.List = append(comments.List, &ast.Comment{Text: "// " + line})
+	}
+	return comments
+}
+
+// astCommentGroupToString renders the comments of a group on their own lines
+func astCommentGroupToString(comments *ast.CommentGroup) string {
+	if comments == nil {
+		return ""
+	}
+
+	lines := []string{}
+	for _, c := range comments.List {
+		lines = append(lines, c.Text)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// astTypeParamsToString renders a type parameter list, e.g. [K comparable, V any]
+func astTypeParamsToString(params *ast.FieldList) string {
+	if params == nil || len(params.List) == 0 {
+		return ""
+	}
+
+	paramsStr := make([]string, len(params.List))
+	for i, param := range params.List {
+		names := make([]string, len(param.Names))
+		for j, name := range param.Names {
+			names[j] = name.Name
+		}
+		paramsStr[i] = strings.Join(names, ", ") + " " + astObjectToString(param.Type)
+	}
+	return "[" + strings.Join(paramsStr, ", ") + "]"
+}
+
+// astReceiverTypeName returns the name of the struct a method receiver belongs to,
+// type parameters of generic receivers are dropped
+func astReceiverTypeName(expr ast.Expr) string {
 	switch eval := expr.(type) {
-	case *ast.Ident:
-		return eval.Name
-	case *ast.ParenExpr:
-		return fmt.Sprintf("(%s)", astObjectToString(eval.X))
 	case *ast.StarExpr:
-		return fmt.Sprintf("*%s", astObjectToString(eval.X))
-	case *ast.SelectorExpr:
-		return fmt.Sprintf("%s.%s", eval.X, eval.Sel)
-	case *ast.ArrayType:
-		return fmt.Sprintf("[%s]%s", astObjectToString(eval.Len), astObjectToString(eval.Elt))
+		return astReceiverTypeName(eval.X)
 	case *ast.IndexExpr:
-		return fmt.Sprintf("%s[%s]", astObjectToString(eval.X), astObjectToString(eval.Index))
-	case *ast.BasicLit:
-		if eval == nil {
-			return ""
-		}
-		return eval.Value
-	case *ast.MapType:
-		return fmt.Sprintf("map[%s]%s", astObjectToString(eval.Key), astObjectToString(eval.Value))
+		return astReceiverTypeName(eval.X)
+	case *ast.IndexListExpr:
+		return astReceiverTypeName(eval.X)
 	default:
-		if eval != nil {
-			log.Fatalf("need to implement support for: %s", reflect.TypeOf(eval).String())
-		}
+		return astObjectToString(expr)
+	}
+}
+
+// astObjectToString renders an expression as written in source, any type expression is supported
+func astObjectToString(expr ast.Expr) string {
+	if expr == nil || reflect.ValueOf(expr).IsNil() {
+		return ""
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	if err := printer.Fprint(buffer, token.NewFileSet(), expr); err != nil {
+		utils.Fatal("Could not render expression", "error", err)
 	}
-	return ""
+	return buffer.String()
 }
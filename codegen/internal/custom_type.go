@@ -6,4 +6,5 @@ type CustomType struct {
 	SourceFile string
 	Name       string
 	InputAST   *ast.TypeSpec
+	Doc        *ast.CommentGroup
 }
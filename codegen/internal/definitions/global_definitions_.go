@@ -3,13 +3,29 @@ package definitions
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
 	"time"
+	"unicode/utf8"
 
 	"github.com/jonoans/mongo-gen/codegen"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type ModelInterface interface {
@@ -52,6 +68,22 @@ type Config struct {
 	DatabaseName     string
 
 	TxnSessionOptions *options.SessionOptionsBuilder
+
+	// Store replaces the MongoDB client when set, e.g. with NewMemoryStore() in unit tests
+	Store Store
+
+	// Spans and metrics of the operations are recorded with the global providers when nil
+	TracerProvider trace.TracerProvider
+	MeterProvider  metric.MeterProvider
+
+	// Logger receives slow operations, hook failures and client lifecycle events, nothing is logged when nil
+	Logger *slog.Logger
+	// Operations taking longer are logged as warnings, disabled when zero
+	SlowQueryThreshold time.Duration
+
+	// Validation level and action of the validators set by ApplyValidators, strict and error by default
+	ValidationLevel  string
+	ValidationAction string
 }
 
 func Initialise(cfg Config, opts ...*options.ClientOptions) error {
@@ -59,48 +91,61 @@ func Initialise(cfg Config, opts ...*options.ClientOptions) error {
 		return err
 	}
 
-	if defaultClt != nil {
-		return errors.New("client is already initialised")
+	if defaultStore != nil {
+		return ErrAlreadyInitialised
 	}
 
 	defaultCfg = cfg
+	defaultTelemetry = newTelemetryInstruments(cfg.TracerProvider, cfg.MeterProvider)
+	if cfg.Store != nil {
+		defaultStore = cfg.Store
+		getLogger().Info("Store initialised", "store", fmt.Sprintf("%T", cfg.Store))
+		return nil
+	}
+
 	client, err := mongo.Connect(opts...)
 	if err != nil {
-		return err
+		getLogger().Error("Could not create the MongoDB client", "error", err)
+		return wrapError(err)
 	}
 
-	defaultClt = &databaseClient{
-		client:      client,
-		collections: map[string]*mongo.Collection{},
-	}
-	defaultClt.init()
+	defaultStore = newMongoStore(client, cfg.DatabaseName)
+	getLogger().Info("MongoDB client initialised", "database", cfg.DatabaseName)
 	return nil
 }
 
+// GetStore returns the store the models are read from and written to
+func GetStore() (Store, error) {
+	return getDefaultStore()
+}
+
 func GetClient() (*mongo.Client, error) {
-	clt, err := getDefaultClient()
+	store, err := getMongoStore()
 	if err != nil {
 		return nil, err
 	}
-	return clt.client, nil
+	return store.client, nil
 }
 
 func GetDatabase() (*mongo.Database, error) {
-	clt, err := getDefaultClient()
+	store, err := getMongoStore()
 	if err != nil {
 		return nil, err
 	}
-	return clt.getDatabase(), nil
+	return store.database, nil
 }
 
+// GetCollection returns the MongoDB collection, ErrNotSupported is returned by other stores such as MemoryStore
 func GetCollection(collectionName string) (*mongo.Collection, error) {
-	clt, err := getDefaultClient()
+	store, err := getMongoStore()
 	if err != nil {
 		return nil, err
 	}
-	return clt.getCollection(collectionName), nil
+	return store.getCollection(collectionName).coll, nil
 }
 
+// Coll returns the MongoDB collection of the model, nil with other stores such as MemoryStore,
+// GetCollection returns the error instead
 func Coll(model ModelInterface) *mongo.Collection {
 	name, err := getCollectionName(model)
 	if err != nil {
@@ -124,6 +169,18 @@ func AggregateFirst(model ModelInterface, pipeline any, opts ...options.Lister[o
 	return AggregateFirstWithCtx(ctx, model, pipeline, opts...)
 }
 
+func Count(model ModelInterface, filter any, opts ...options.Lister[options.CountOptions]) (int64, error) {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return CountWithCtx(ctx, model, filter, opts...)
+}
+
+func Distinct(model ModelInterface, fieldName string, filter any, results any, opts ...options.Lister[options.DistinctOptions]) error {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return DistinctWithCtx(ctx, model, fieldName, filter, results, opts...)
+}
+
 func Delete(model ModelInterface, opts ...options.Lister[options.DeleteOneOptions]) error {
 	ctx, cancel := newCtx()
 	defer cancel()
@@ -142,6 +199,18 @@ func DeleteMany(model ModelInterface, query any, opts ...options.Lister[options.
 	return DeleteManyWithCtx(ctx, model, query, opts...)
 }
 
+func EstimatedDocumentCount(model ModelInterface, opts ...options.Lister[options.EstimatedDocumentCountOptions]) (int64, error) {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return EstimatedDocumentCountWithCtx(ctx, model, opts...)
+}
+
+func Exists(model ModelInterface, filter any) (bool, error) {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return ExistsWithCtx(ctx, model, filter)
+}
+
 func FindOne(model ModelInterface, query any, opts ...options.Lister[options.FindOneOptions]) error {
 	ctx, cancel := newCtx()
 	defer cancel()
@@ -203,13 +272,16 @@ func UpdateMany(model ModelInterface, filter any, update any, opts ...options.Li
 
 // Section: Context Functions
 
-func AggregateWithCtx(ctx context.Context, results any, pipeline any, aggregateOpts ...options.Lister[options.AggregateOptions]) error {
+func AggregateWithCtx(ctx context.Context, results any, pipeline any, aggregateOpts ...options.Lister[options.AggregateOptions]) (err error) {
 	collectionName, err := getCollectionNameFromSlice(results)
 	if err != nil {
 		return err
 	}
 
-	collection, err := GetCollection(collectionName)
+	ctx, op := startOperation(ctx, "Aggregate", collectionName, pipeline)
+	defer func() { op.end(ctx, err) }()
+
+	collection, err := getStoreCollection(collectionName)
 	if err != nil {
 		return err
 	}
@@ -220,27 +292,29 @@ func AggregateWithCtx(ctx context.Context, results any, pipeline any, aggregateO
 	}
 
 	if err != nil {
-		return err
+		return wrapError(err)
 	}
 
 	if err := cur.All(ctx, results); err != nil {
-		return err
-	}
-
-	if err := runFuncOnResultsSliceItems(results, callAfterQueryHooks); err != nil {
-		return err
+		return wrapError(err)
 	}
+	op.setReturned(resultsLen(results))
 
-	return nil
+	return runFuncOnResultsSliceItems(results, func(model ModelInterface) error {
+		return callAfterQueryHooks(ctx, model)
+	})
 }
 
-func AggregateFirstWithCtx(ctx context.Context, result ModelInterface, pipeline any, aggregateOpts ...options.Lister[options.AggregateOptions]) (bool, error) {
+func AggregateFirstWithCtx(ctx context.Context, result ModelInterface, pipeline any, aggregateOpts ...options.Lister[options.AggregateOptions]) (found bool, err error) {
 	collectionName, err := getCollectionName(result)
 	if err != nil {
 		return false, err
 	}
 
-	collection, err := GetCollection(collectionName)
+	ctx, op := startOperation(ctx, "AggregateFirst", collectionName, pipeline)
+	defer func() { op.end(ctx, err) }()
+
+	collection, err := getStoreCollection(collectionName)
 	if err != nil {
 		return false, err
 	}
@@ -251,94 +325,205 @@ func AggregateFirstWithCtx(ctx context.Context, result ModelInterface, pipeline
 	}
 
 	if err != nil {
-		return false, err
+		return false, wrapError(err)
 	}
 
 	if cur.Next(ctx) {
 		if err := cur.Decode(result); err != nil {
-			return false, err
+			return false, wrapError(err)
 		}
-		return true, callAfterQueryHooks(result)
+		op.setReturned(1)
+		return true, callAfterQueryHooks(ctx, result)
+	}
+
+	op.setReturned(0)
+	return false, wrapError(cur.Err())
+}
+
+func CountWithCtx(ctx context.Context, model ModelInterface, filter any, opts ...options.Lister[options.CountOptions]) (count int64, err error) {
+	collectionName, err := getCollectionName(model)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, op := startOperation(ctx, "Count", collectionName, filter)
+	defer func() { op.end(ctx, err) }()
+
+	coll, err := getStoreCollection(collectionName)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err = coll.CountDocuments(ctx, filter, opts...)
+	op.setReturned(int(count))
+	return count, wrapError(err)
+}
+
+func DistinctWithCtx(ctx context.Context, model ModelInterface, fieldName string, filter any, results any, opts ...options.Lister[options.DistinctOptions]) (err error) {
+	collectionName, err := getCollectionName(model)
+	if err != nil {
+		return err
+	}
+
+	ctx, op := startOperation(ctx, "Distinct", collectionName, filter)
+	defer func() { op.end(ctx, err) }()
+
+	coll, err := getStoreCollection(collectionName)
+	if err != nil {
+		return err
 	}
 
-	return false, nil
+	if err := coll.Distinct(ctx, fieldName, filter, results, opts...); err != nil {
+		return wrapError(err)
+	}
+	op.setReturned(resultsLen(results))
+	return nil
 }
 
-func DeleteWithCtx(ctx context.Context, model ModelInterface, opts ...options.Lister[options.DeleteOneOptions]) error {
-	if err := callBeforeDeleteHooks(model); err != nil {
+func DeleteWithCtx(ctx context.Context, model ModelInterface, opts ...options.Lister[options.DeleteOneOptions]) (err error) {
+	collectionName, err := getCollectionName(model)
+	if err != nil {
+		return err
+	}
+
+	ctx, op := startOperation(ctx, "Delete", collectionName, nil)
+	defer func() { op.end(ctx, err) }()
+
+	if err := callBeforeDeleteHooks(ctx, model); err != nil {
 		return err
 	}
 
-	if _, err := DeleteOneWithCtx(ctx, model, bson.M{"_id": model.GetID()}, opts...); err != nil {
+	actions, hasActions := model.(deleteActionsModel)
+	if !hasActions {
+		if _, err := DeleteOneWithCtx(ctx, model, bson.M{"_id": model.GetID()}, opts...); err != nil {
+			return err
+		}
+		return afterCommit(ctx, func() error { return callAfterDeleteHooks(ctx, model) })
+	}
+
+	err = runInTransaction(ctx, func(ctx context.Context) error {
+		if err := actions.checkDeleteRestrictions(ctx); err != nil {
+			return err
+		}
+
+		if _, err := DeleteOneWithCtx(ctx, model, bson.M{"_id": model.GetID()}, opts...); err != nil {
+			return err
+		}
+
+		return actions.runDeleteActions(ctx)
+	})
+	if err != nil {
 		return err
 	}
 
-	return callAfterDeleteHooks(model)
+	return afterCommit(ctx, func() error { return callAfterDeleteHooks(ctx, model) })
 }
 
-func DeleteOneWithCtx(ctx context.Context, model ModelInterface, query any, opts ...options.Lister[options.DeleteOneOptions]) (*mongo.DeleteResult, error) {
+func DeleteOneWithCtx(ctx context.Context, model ModelInterface, query any, opts ...options.Lister[options.DeleteOneOptions]) (result *mongo.DeleteResult, err error) {
 	collectionName, err := getCollectionName(model)
 	if err != nil {
 		return nil, err
 	}
 
-	coll, err := GetCollection(collectionName)
+	ctx, op := startOperation(ctx, "DeleteOne", collectionName, query)
+	defer func() { op.end(ctx, err) }()
+
+	coll, err := getStoreCollection(collectionName)
 	if err != nil {
 		return nil, err
 	}
 
-	result, err := coll.DeleteOne(ctx, query, opts...)
+	result, err = coll.DeleteOne(ctx, query, opts...)
 	if err != nil {
-		return result, err
+		return result, wrapError(err)
 	}
 
+	op.setReturned(int(result.DeletedCount))
 	return result, nil
 }
 
-func DeleteManyWithCtx(ctx context.Context, model ModelInterface, query any, opts ...options.Lister[options.DeleteManyOptions]) (*mongo.DeleteResult, error) {
+func DeleteManyWithCtx(ctx context.Context, model ModelInterface, query any, opts ...options.Lister[options.DeleteManyOptions]) (result *mongo.DeleteResult, err error) {
 	collectionName, err := getCollectionName(model)
 	if err != nil {
 		return nil, err
 	}
 
-	coll, err := GetCollection(collectionName)
+	ctx, op := startOperation(ctx, "DeleteMany", collectionName, query)
+	defer func() { op.end(ctx, err) }()
+
+	coll, err := getStoreCollection(collectionName)
 	if err != nil {
 		return nil, err
 	}
 
-	result, err := coll.DeleteMany(ctx, query, opts...)
+	result, err = coll.DeleteMany(ctx, query, opts...)
 	if err != nil {
-		return result, err
+		return result, wrapError(err)
 	}
 
+	op.setReturned(int(result.DeletedCount))
 	return result, nil
 }
 
-func FindOneWithCtx(ctx context.Context, model ModelInterface, query any, opts ...options.Lister[options.FindOneOptions]) error {
+func EstimatedDocumentCountWithCtx(ctx context.Context, model ModelInterface, opts ...options.Lister[options.EstimatedDocumentCountOptions]) (count int64, err error) {
 	collectionName, err := getCollectionName(model)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	ctx, op := startOperation(ctx, "EstimatedDocumentCount", collectionName, nil)
+	defer func() { op.end(ctx, err) }()
+
+	coll, err := getStoreCollection(collectionName)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err = coll.EstimatedDocumentCount(ctx, opts...)
+	op.setReturned(int(count))
+	return count, wrapError(err)
+}
+
+func ExistsWithCtx(ctx context.Context, model ModelInterface, filter any) (bool, error) {
+	count, err := CountWithCtx(ctx, model, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
 	}
+	return count > 0, nil
+}
 
-	coll, err := GetCollection(collectionName)
+func FindOneWithCtx(ctx context.Context, model ModelInterface, query any, opts ...options.Lister[options.FindOneOptions]) (err error) {
+	collectionName, err := getCollectionName(model)
 	if err != nil {
 		return err
 	}
 
-	if err := coll.FindOne(ctx, query, opts...).Decode(model); err != nil {
+	ctx, op := startOperation(ctx, "FindOne", collectionName, query)
+	defer func() { op.end(ctx, err) }()
+
+	coll, err := getStoreCollection(collectionName)
+	if err != nil {
 		return err
 	}
 
-	return callAfterQueryHooks(model)
+	if err := coll.FindOne(ctx, query, model, opts...); err != nil {
+		return wrapError(err)
+	}
+	op.setReturned(1)
+
+	return callAfterQueryHooks(ctx, model)
 }
 
-func FindManyWithCtx(ctx context.Context, results any, query any, opts ...options.Lister[options.FindOptions]) error {
+func FindManyWithCtx(ctx context.Context, results any, query any, opts ...options.Lister[options.FindOptions]) (err error) {
 	collectionName, err := getCollectionNameFromSlice(results)
 	if err != nil {
 		return err
 	}
 
-	coll, err := GetCollection(collectionName)
+	ctx, op := startOperation(ctx, "FindMany", collectionName, query)
+	defer func() { op.end(ctx, err) }()
+
+	coll, err := getStoreCollection(collectionName)
 	if err != nil {
 		return err
 	}
@@ -349,18 +534,17 @@ func FindManyWithCtx(ctx context.Context, results any, query any, opts ...option
 	}
 
 	if err != nil {
-		return err
+		return wrapError(err)
 	}
 
 	if err := cur.All(ctx, results); err != nil {
-		return err
-	}
-
-	if err := runFuncOnResultsSliceItems(results, callAfterQueryHooks); err != nil {
-		return err
+		return wrapError(err)
 	}
+	op.setReturned(resultsLen(results))
 
-	return nil
+	return runFuncOnResultsSliceItems(results, func(model ModelInterface) error {
+		return callAfterQueryHooks(ctx, model)
+	})
 }
 
 func FindByObjectIDWithCtx(ctx context.Context, model ModelInterface, id any, opts ...options.Lister[options.FindOneOptions]) error {
@@ -371,86 +555,111 @@ func FindByObjectIDWithCtx(ctx context.Context, model ModelInterface, id any, op
 	return FindOneWithCtx(ctx, model, bson.M{"_id": oid}, opts...)
 }
 
-func InsertOneWithCtx(ctx context.Context, model ModelInterface, opts ...options.Lister[options.InsertOneOptions]) error {
+func InsertOneWithCtx(ctx context.Context, model ModelInterface, opts ...options.Lister[options.InsertOneOptions]) (err error) {
 	collectionName, err := getCollectionName(model)
 	if err != nil {
 		return err
 	}
 
-	if err := callBeforeCreateHooks(model); err != nil {
+	ctx, op := startOperation(ctx, "InsertOne", collectionName, nil)
+	defer func() { op.end(ctx, err) }()
+
+	if err := validateModel(model); err != nil {
+		return err
+	}
+
+	if err := callBeforeCreateHooks(ctx, model); err != nil {
 		return err
 	}
 
-	coll, err := GetCollection(collectionName)
+	coll, err := getStoreCollection(collectionName)
 	if err != nil {
 		return err
 	}
 
 	result, err := coll.InsertOne(ctx, model, opts...)
 	if err != nil {
-		return err
+		return wrapError(err)
 	}
+	op.setReturned(1)
 
-	return callAfterCreateHooks(model, result)
+	return callAfterCreateHooks(ctx, model, result)
 }
 
-func UpdateWithCtx(ctx context.Context, model ModelInterface, opts ...options.Lister[options.UpdateOneOptions]) error {
+func UpdateWithCtx(ctx context.Context, model ModelInterface, opts ...options.Lister[options.UpdateOneOptions]) (err error) {
 	collectionName, err := getCollectionName(model)
 	if err != nil {
 		return err
 	}
 
-	if err := callBeforeUpdateHooks(model); err != nil {
+	ctx, op := startOperation(ctx, "Update", collectionName, nil)
+	defer func() { op.end(ctx, err) }()
+
+	if err := validateModel(model); err != nil {
+		return err
+	}
+
+	if err := callBeforeUpdateHooks(ctx, model); err != nil {
 		return err
 	}
 
-	coll, err := GetCollection(collectionName)
+	coll, err := getStoreCollection(collectionName)
 	if err != nil {
 		return err
 	}
 
-	if _, err = coll.UpdateByID(ctx, model.GetID(), bson.M{"$set": model}, opts...); err != nil {
-		return err
+	result, err := coll.UpdateByID(ctx, model.GetID(), bson.M{"$set": model}, opts...)
+	if err != nil {
+		return wrapError(err)
 	}
+	op.setReturned(int(result.MatchedCount))
 
-	return callAfterUpdateHooks(model)
+	return callAfterUpdateHooks(ctx, model)
 }
 
-func UpdateOneWithCtx(ctx context.Context, model ModelInterface, filter any, update any, opts ...options.Lister[options.UpdateOneOptions]) (*mongo.UpdateResult, error) {
+func UpdateOneWithCtx(ctx context.Context, model ModelInterface, filter any, update any, opts ...options.Lister[options.UpdateOneOptions]) (result *mongo.UpdateResult, err error) {
 	collectionName, err := getCollectionName(model)
 	if err != nil {
 		return nil, err
 	}
 
-	coll, err := GetCollection(collectionName)
+	ctx, op := startOperation(ctx, "UpdateOne", collectionName, filter)
+	defer func() { op.end(ctx, err) }()
+
+	coll, err := getStoreCollection(collectionName)
 	if err != nil {
 		return nil, err
 	}
 
-	result, err := coll.UpdateOne(ctx, filter, update, opts...)
+	result, err = coll.UpdateOne(ctx, filter, update, opts...)
 	if err != nil {
-		return result, err
+		return result, wrapError(err)
 	}
 
+	op.setReturned(int(result.MatchedCount))
 	return result, nil
 }
 
-func UpdateManyWithCtx(ctx context.Context, model ModelInterface, filter any, update any, opts ...options.Lister[options.UpdateManyOptions]) (*mongo.UpdateResult, error) {
+func UpdateManyWithCtx(ctx context.Context, model ModelInterface, filter any, update any, opts ...options.Lister[options.UpdateManyOptions]) (result *mongo.UpdateResult, err error) {
 	collectionName, err := getCollectionName(model)
 	if err != nil {
 		return nil, err
 	}
 
-	coll, err := GetCollection(collectionName)
+	ctx, op := startOperation(ctx, "UpdateMany", collectionName, filter)
+	defer func() { op.end(ctx, err) }()
+
+	coll, err := getStoreCollection(collectionName)
 	if err != nil {
 		return nil, err
 	}
 
-	result, err := coll.UpdateMany(ctx, filter, update, opts...)
+	result, err = coll.UpdateMany(ctx, filter, update, opts...)
 	if err != nil {
-		return result, err
+		return result, wrapError(err)
 	}
 
+	op.setReturned(int(result.MatchedCount))
 	return result, nil
 }
 
@@ -470,223 +679,1789 @@ func TransactionWithOptions(fn codegen.TransactionFunc, opts *options.SessionOpt
 	return TransactionWithCtxOptions(ctx, fn, opts)
 }
 
-func TransactionWithCtxOptions(ctx context.Context, fn codegen.TransactionFunc, opts *options.SessionOptionsBuilder) error {
-	client, err := GetClient()
+func TransactionWithCtxOptions(ctx context.Context, fn codegen.TransactionFunc, opts *options.SessionOptionsBuilder) (err error) {
+	store, err := getDefaultStore()
 	if err != nil {
 		return err
 	}
 
-	return client.UseSessionWithOptions(ctx, opts, func(ctx context.Context) error {
-		sess := mongo.SessionFromContext(ctx)
-		if err := sess.StartTransaction(); err != nil {
-			return err
-		}
-		return fn(ctx)
-	})
-}
+	ctx, op := startOperation(ctx, "Transaction", "", nil)
+	defer func() { op.end(ctx, err) }()
 
-func Close() {
-	if defaultClt != nil {
-		ctx, cancel := newCtx()
-		defer cancel()
-		_ = defaultClt.client.Disconnect(ctx)
-		defaultClt = nil
-	}
+	return transaction(ctx, store, opts, fn)
 }
 
-// Section: Private Functions
+// afterCommitKey is the context key of the hooks deferred until the transaction commits
+type afterCommitKey struct{}
 
-type databaseClient struct {
-	client      *mongo.Client
-	database    *mongo.Database
-	collections map[string]*mongo.Collection
+type afterCommitHooks struct {
+	hooks []func() error
 }
 
-func (c *databaseClient) init() {
-	c.collections = make(map[string]*mongo.Collection)
+// afterCommit runs hook once the transaction of ctx commits, or right away outside of transactions
+func afterCommit(ctx context.Context, hook func() error) error {
+	if pending, ok := ctx.Value(afterCommitKey{}).(*afterCommitHooks); ok {
+		pending.hooks = append(pending.hooks, hook)
+		return nil
+	}
+	return hook()
 }
 
-func (c *databaseClient) getDatabase() *mongo.Database {
-	if c.database == nil {
-		c.database = c.client.Database(defaultCfg.DatabaseName)
+// transaction runs fn in a transaction of store then the hooks fn deferred with afterCommit,
+// the hooks of attempts which were retried or aborted are dropped
+func transaction(ctx context.Context, store Store, opts *options.SessionOptionsBuilder, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(afterCommitKey{}).(*afterCommitHooks); ok {
+		return store.Transaction(ctx, opts, fn)
 	}
-	return c.database
-}
 
-func (c *databaseClient) getCollection(name string) *mongo.Collection {
-	if coll, ok := c.collections[name]; ok {
-		return coll
+	pending := &afterCommitHooks{}
+	err := store.Transaction(ctx, opts, func(ctx context.Context) error {
+		pending.hooks = nil
+		return fn(context.WithValue(ctx, afterCommitKey{}, pending))
+	})
+	if err != nil {
+		return err
 	}
-	c.collections[name] = c.getDatabase().Collection(name)
-	return c.collections[name]
+
+	for _, hook := range pending.hooks {
+		if err := hook(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-var (
-	defaultClt *databaseClient
-	defaultCfg Config
-)
+// Section: Change Streams
 
-func Ctx() context.Context {
-	ctx, _ := newCtx()
-	return ctx
+// ResumeTokenStore persists change stream resume tokens so that a stream
+// can continue from the last delivered event after a restart
+type ResumeTokenStore interface {
+	LoadResumeToken(ctx context.Context, name string) (bson.Raw, error)
+	SaveResumeToken(ctx context.Context, name string, token bson.Raw) error
 }
 
-func newCtx() (context.Context, func()) {
-	// Can't cancel context
-	return context.WithTimeout(context.Background(), defaultCfg.OperationTimeout)
+type WatchOptions struct {
+	// Name identifies the stream in the ResumeTokenStore, defaults to the collection name
+	Name                string
+	ResumeTokenStore    ResumeTokenStore
+	ChangeStreamOptions *options.ChangeStreamOptionsBuilder
+	BufferSize          int
 }
 
-func getDefaultClient() (*databaseClient, error) {
-	if defaultClt == nil {
-		return nil, errors.New("client is not initialised, please call the Initialise method first!")
-	}
-	return defaultClt, nil
+type UpdateDescription struct {
+	UpdatedFields   bson.M   `bson:"updatedFields"`
+	RemovedFields   []string `bson:"removedFields"`
+	TruncatedArrays []bson.M `bson:"truncatedArrays"`
 }
 
-func assertObjectID(id any) (bson.ObjectID, error) {
-	switch v := id.(type) {
-	case bson.ObjectID:
-		return v, nil
-	case *bson.ObjectID:
-		return *v, nil
-	case string:
-		return bson.ObjectIDFromHex(v)
-	default:
-		return bson.NilObjectID, errors.New("invalid object id")
-	}
+// ChangeEvent is a change stream event decoded into the model type,
+// Err is set on the last event sent before the channel is closed due to an error.
+// Events which fail to decode or whose Queried hook fails stop the stream without
+// saving their resume token, a restarted stream receives them again
+type ChangeEvent[T any] struct {
+	OperationType     string
+	DocumentKey       bson.M
+	FullDocument      *T
+	UpdateDescription *UpdateDescription
+	ResumeToken       bson.Raw
+	Err               error
 }
 
-func checkConfig(cfg *Config) error {
-	if cfg.DatabaseName == "" {
-		return errors.New("database name is empty")
+type changeEventDocument struct {
+	OperationType     string             `bson:"operationType"`
+	DocumentKey       bson.M             `bson:"documentKey"`
+	FullDocument      bson.Raw           `bson:"fullDocument"`
+	UpdateDescription *UpdateDescription `bson:"updateDescription"`
+}
+
+func Watch[T any, P interface {
+	*T
+	ModelInterface
+}](ctx context.Context, pipeline any, opts *WatchOptions) (<-chan ChangeEvent[T], error) {
+	if opts == nil {
+		opts = &WatchOptions{}
 	}
 
-	// Fill default
-	if cfg.OperationTimeout == 0 {
-		cfg.OperationTimeout = time.Second * 15
+	collectionName, err := getCollectionName(P(new(T)))
+	if err != nil {
+		return nil, err
 	}
 
-	if cfg.TxnSessionOptions == nil {
-		cfg.TxnSessionOptions = options.Session()
+	coll, err := getStoreCollection(collectionName)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	name := opts.Name
+	if name == "" {
+		name = collectionName
+	}
+
+	streamOpts := []options.Lister[options.ChangeStreamOptions]{}
+	if opts.ChangeStreamOptions != nil {
+		streamOpts = append(streamOpts, opts.ChangeStreamOptions)
+	}
+
+	if opts.ResumeTokenStore != nil {
+		token, err := opts.ResumeTokenStore.LoadResumeToken(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+
+		// Applied after the options of the caller, which are left untouched
+		if token != nil {
+			streamOpts = append(streamOpts, options.ChangeStream().SetResumeAfter(token))
+		}
+	}
+
+	if pipeline == nil {
+		pipeline = mongo.Pipeline{}
+	}
+
+	stream, err := coll.Watch(ctx, pipeline, streamOpts...)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	events := make(chan ChangeEvent[T], opts.BufferSize)
+	go watchChangeStream[T, P](ctx, stream, name, opts.ResumeTokenStore, events)
+	return events, nil
 }
 
-func getCollectionName(model ModelInterface) (string, error) {
-	name := model.CollectionName()
-	if name == "" {
-		return "", errors.New("collection name is empty")
+// changeStream iterates over change stream events, *mongo.ChangeStream implements it
+type changeStream interface {
+	Next(ctx context.Context) bool
+	Decode(val any) error
+	ResumeToken() bson.Raw
+	Err() error
+	Close(ctx context.Context) error
+}
+
+// watchChangeStream sends the events of stream until it ends or an event fails,
+// the resume token of each event is saved once the event is sent
+func watchChangeStream[T any, P interface {
+	*T
+	ModelInterface
+}](ctx context.Context, stream changeStream, name string, tokenStore ResumeTokenStore, events chan<- ChangeEvent[T]) {
+	defer close(events)
+	defer stream.Close(context.Background())
+
+	send := func(event ChangeEvent[T]) bool {
+		select {
+		case events <- event:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for stream.Next(ctx) {
+		event := decodeChangeEvent[T, P](ctx, stream)
+		if !send(event) || event.Err != nil {
+			return
+		}
+
+		if tokenStore != nil {
+			if err := tokenStore.SaveResumeToken(ctx, name, event.ResumeToken); err != nil {
+				send(ChangeEvent[T]{Err: err})
+				return
+			}
+		}
+	}
+
+	if err := stream.Err(); err != nil && ctx.Err() == nil {
+		send(ChangeEvent[T]{Err: wrapError(err)})
 	}
-	return name, nil
 }
 
-func getCollectNameFromInterface(model any) (string, error) {
-	if v, ok := model.(ModelInterface); ok {
-		return getCollectionName(v)
+func decodeChangeEvent[T any, P interface {
+	*T
+	ModelInterface
+}](ctx context.Context, stream changeStream) ChangeEvent[T] {
+	event := ChangeEvent[T]{ResumeToken: stream.ResumeToken()}
+
+	doc := changeEventDocument{}
+	if err := stream.Decode(&doc); err != nil {
+		event.Err = wrapError(err)
+		return event
+	}
+
+	event.OperationType = doc.OperationType
+	event.DocumentKey = doc.DocumentKey
+	event.UpdateDescription = doc.UpdateDescription
+
+	if len(doc.FullDocument) > 0 {
+		fullDocument := new(T)
+		if err := bson.Unmarshal(doc.FullDocument, fullDocument); err != nil {
+			event.Err = wrapError(err)
+			return event
+		}
+
+		if err := callAfterQueryHooks(ctx, P(fullDocument)); err != nil {
+			event.Err = err
+			return event
+		}
+		event.FullDocument = fullDocument
 	}
-	return "", errors.New("model is not a ModelInterface")
+
+	return event
 }
 
-func getCollectionNameFromSlice(results any) (string, error) {
-	resultsType := reflect.TypeOf(results)
-	if resultsType.Kind() != reflect.Ptr {
-		return "", errors.New("results is not a pointer")
+// CollectionResumeTokenStore stores resume tokens in a collection of the default database
+type CollectionResumeTokenStore struct {
+	CollectionName string
+}
+
+func (s *CollectionResumeTokenStore) LoadResumeToken(ctx context.Context, name string) (bson.Raw, error) {
+	coll, err := getStoreCollection(s.CollectionName)
+	if err != nil {
+		return nil, err
 	}
 
-	resultsType = reflect.Indirect(reflect.ValueOf(results)).Type()
-	if resultsType.Kind() != reflect.Slice {
-		return "", errors.New("results is not a pointer to a slice")
+	doc := struct {
+		Token bson.Raw `bson:"token"`
+	}{}
+	err = coll.FindOne(ctx, bson.M{"_id": name}, &doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	} else if err != nil {
+		return nil, wrapError(err)
 	}
 
-	elemValue := reflect.New(resultsType.Elem()).Interface()
-	return getCollectNameFromInterface(elemValue)
+	return doc.Token, nil
 }
 
-// Only use when sure results is slice of ModelInterface
-func runFuncOnResultsSliceItems(results any, callback func(model ModelInterface) error) error {
-	resultsPtr := reflect.ValueOf(results)
-	resultsSlice := reflect.Indirect(resultsPtr)
-	resultsSliceLen := resultsSlice.Len()
-	for i := range resultsSliceLen {
-		item := resultsSlice.Index(i).Addr().Interface()
-		if err := callback(item.(ModelInterface)); err != nil {
-			return err
+func (s *CollectionResumeTokenStore) SaveResumeToken(ctx context.Context, name string, token bson.Raw) error {
+	coll, err := getStoreCollection(s.CollectionName)
+	if err != nil {
+		return err
+	}
+
+	_, err = coll.UpdateByID(ctx, name, bson.M{"$set": bson.M{"token": token}}, options.UpdateOne().SetUpsert(true))
+	return wrapError(err)
+}
+
+func Close() {
+	if defaultStore != nil {
+		ctx, cancel := newCtx()
+		defer cancel()
+		if err := defaultStore.Close(ctx); err != nil {
+			getLogger().Error("Could not close the store", "error", err)
+		} else {
+			getLogger().Info("Store closed")
 		}
+		defaultStore = nil
 	}
-	return nil
 }
 
-// Section: Hook Helpers
+// Section: Repositories
+
+// Repository is the collection API of the model type T, NewRepository returns the one
+// backed by the store and generated mocks implement it for unit tests
+type Repository[T any] interface {
+	FindByObjectID(ctx context.Context, id any, opts ...options.Lister[options.FindOneOptions]) (*T, error)
+	FindOne(ctx context.Context, query any, opts ...options.Lister[options.FindOneOptions]) (*T, error)
+	FindMany(ctx context.Context, query any, opts ...options.Lister[options.FindOptions]) ([]T, error)
+	Create(ctx context.Context, model *T, opts ...options.Lister[options.InsertOneOptions]) error
+	Update(ctx context.Context, model *T, opts ...options.Lister[options.UpdateOneOptions]) error
+	Delete(ctx context.Context, model *T, opts ...options.Lister[options.DeleteOneOptions]) error
+	Count(ctx context.Context, filter any, opts ...options.Lister[options.CountOptions]) (int64, error)
+}
+
+// NewRepository returns the Repository of the model type T, e.g. NewRepository[Model]()
+func NewRepository[T any, P interface {
+	*T
+	ModelInterface
+}]() Repository[T] {
+	return storeRepository[T, P]{}
+}
+
+type storeRepository[T any, P interface {
+	*T
+	ModelInterface
+}] struct{}
+
+func (storeRepository[T, P]) FindByObjectID(ctx context.Context, id any, opts ...options.Lister[options.FindOneOptions]) (*T, error) {
+	model := new(T)
+	if err := FindByObjectIDWithCtx(ctx, P(model), id, opts...); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+func (storeRepository[T, P]) FindOne(ctx context.Context, query any, opts ...options.Lister[options.FindOneOptions]) (*T, error) {
+	model := new(T)
+	if err := FindOneWithCtx(ctx, P(model), query, opts...); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+func (storeRepository[T, P]) FindMany(ctx context.Context, query any, opts ...options.Lister[options.FindOptions]) ([]T, error) {
+	results := []T{}
+	if err := FindManyWithCtx(ctx, &results, query, opts...); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (storeRepository[T, P]) Create(ctx context.Context, model *T, opts ...options.Lister[options.InsertOneOptions]) error {
+	return InsertOneWithCtx(ctx, P(model), opts...)
+}
+
+func (storeRepository[T, P]) Update(ctx context.Context, model *T, opts ...options.Lister[options.UpdateOneOptions]) error {
+	return UpdateWithCtx(ctx, P(model), opts...)
+}
+
+func (storeRepository[T, P]) Delete(ctx context.Context, model *T, opts ...options.Lister[options.DeleteOneOptions]) error {
+	return DeleteWithCtx(ctx, P(model), opts...)
+}
+
+func (storeRepository[T, P]) Count(ctx context.Context, filter any, opts ...options.Lister[options.CountOptions]) (int64, error) {
+	return CountWithCtx(ctx, P(new(T)), filter, opts...)
+}
+
+// Section: Validators
+
+// validatorSchemas holds the $jsonSchema of each collection, registered by the generated code
+var validatorSchemas = map[string]string{}
+
+func registerValidator(collectionName string, schema string) {
+	validatorSchemas[collectionName] = schema
+}
+
+// ApplyValidators sets the $jsonSchema validators generated from the models on their collections
+// with collMod, collections which do not exist yet are created with createCollection
+func ApplyValidators(ctx context.Context) error {
+	store, err := getMongoStore()
+	if err != nil {
+		return err
+	}
+
+	collectionNames := make([]string, 0, len(validatorSchemas))
+	for collectionName := range validatorSchemas {
+		collectionNames = append(collectionNames, collectionName)
+	}
+	sort.Strings(collectionNames)
+
+	for _, collectionName := range collectionNames {
+		if err := applyValidator(ctx, store.database, collectionName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyValidator(ctx context.Context, database *mongo.Database, collectionName string) (err error) {
+	ctx, op := startOperation(ctx, "ApplyValidator", collectionName, nil)
+	defer func() { op.end(ctx, err) }()
+
+	schema := bson.D{}
+	if err := bson.UnmarshalExtJSON([]byte(validatorSchemas[collectionName]), false, &schema); err != nil {
+		return err
+	}
+	validator := bson.D{{Key: "$jsonSchema", Value: schema}}
+
+	existing, err := database.ListCollectionNames(ctx, bson.D{{Key: "name", Value: collectionName}})
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if len(existing) == 0 {
+		opts := options.CreateCollection().
+			SetValidator(validator).
+			SetValidationLevel(defaultCfg.ValidationLevel).
+			SetValidationAction(defaultCfg.ValidationAction)
+		err = database.CreateCollection(ctx, collectionName, opts)
+	} else {
+		err = database.RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: collectionName},
+			{Key: "validator", Value: validator},
+			{Key: "validationLevel", Value: defaultCfg.ValidationLevel},
+			{Key: "validationAction", Value: defaultCfg.ValidationAction},
+		}).Err()
+	}
+	if err != nil {
+		return wrapError(err)
+	}
+
+	getLogger().InfoContext(ctx, "Validator applied", "collection", collectionName, "created", len(existing) == 0)
+	return nil
+}
+
+// Section: Validation
+
+// validation collects the rules broken by a document, checked by the generated Validate methods
+type validation struct {
+	fields []*FieldError
+}
+
+func (v *validation) add(path string, rule string, format string, args ...any) {
+	v.fields = append(v.fields, &FieldError{Path: path, Rule: rule, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validation) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: v.fields}
+}
+
+func (v *validation) required(path string, value any) {
+	rv := reflect.ValueOf(value)
+	switch {
+	case !rv.IsValid() || rv.IsZero():
+		v.add(path, "required", "is required")
+	case (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Map) && rv.Len() == 0:
+		v.add(path, "required", "is required")
+	}
+}
+
+func (v *validation) min(path string, value any, min float64) {
+	if size, unit, ok := validationSize(value); ok && size < min {
+		v.add(path, "min", "must be at least %v%s", min, unit)
+	}
+}
+
+func (v *validation) max(path string, value any, max float64) {
+	if size, unit, ok := validationSize(value); ok && size > max {
+		v.add(path, "max", "must be at most %v%s", max, unit)
+	}
+}
+
+func (v *validation) regex(path string, value any, pattern string) {
+	rv, ok := validationValue(value)
+	if !ok {
+		return
+	}
+
+	if !validationPattern(pattern).MatchString(rv.String()) {
+		v.add(path, "regex", "must match %s", pattern)
+	}
+}
+
+func (v *validation) enum(path string, value any, values ...string) {
+	rv, ok := validationValue(value)
+	if !ok {
+		return
+	}
+
+	current := fmt.Sprint(rv.Interface())
+	for _, allowed := range values {
+		if current == allowed {
+			return
+		}
+	}
+	v.add(path, "enum", "must be one of %s", strings.Join(values, ", "))
+}
+
+// nested validates the subdocuments held by value with their Validate method,
+// walking through pointers, slices and maps
+func (v *validation) nested(path string, value any) {
+	v.nestedValue(path, reflect.ValueOf(value))
+}
+
+func (v *validation) nestedValue(path string, rv reflect.Value) {
+	if rv.Kind() != reflect.Pointer && rv.CanAddr() {
+		rv = rv.Addr()
+	}
+
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return
+		}
+	}
+
+	if validator, ok := rv.Interface().(validatable); ok {
+		v.merge(path, validator.Validate())
+		return
+	}
+
+	if rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		v.nestedValue(path, rv)
+	case reflect.Slice, reflect.Array:
+		if !mayHoldSubdocuments(rv.Type().Elem()) {
+			return
+		}
+		for i := 0; i < rv.Len(); i++ {
+			v.nestedValue(joinValidationPath(path, strconv.Itoa(i)), rv.Index(i))
+		}
+	case reflect.Map:
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, key := range keys {
+			// Map values are not addressable, pointer receivers are reached through a copy
+			elem := reflect.New(rv.Type().Elem()).Elem()
+			elem.Set(rv.MapIndex(key))
+			v.nestedValue(joinValidationPath(path, fmt.Sprint(key.Interface())), elem)
+		}
+	}
+}
+
+// merge adds the rules broken by a subdocument stored at path, errors which are
+// not a *ValidationError of this package are reported as a single field
+func (v *validation) merge(path string, err error) {
+	if err == nil {
+		return
+	}
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		v.add(path, "validate", "%s", err)
+		return
+	}
+
+	for _, field := range validationErr.Fields {
+		v.fields = append(v.fields, &FieldError{Path: joinValidationPath(path, field.Path), Rule: field.Rule, Message: field.Message})
+	}
+}
+
+type validatable interface {
+	Validate() error
+}
+
+// validateModel runs the Validate method of models before they are written
+func validateModel(model ModelInterface) error {
+	if validator, ok := model.(validatable); ok {
+		return validator.Validate()
+	}
+	return nil
+}
+
+// mayHoldSubdocuments reports whether values of t may have a Validate method or hold such values,
+// slices of plain values such as ObjectIDs are not walked
+func mayHoldSubdocuments(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Array, reflect.Map:
+		return true
+	default:
+		return reflect.PointerTo(t).Implements(reflect.TypeFor[validatable]())
+	}
+}
+
+func joinValidationPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	if key == "" {
+		return path
+	}
+	return path + "." + key
+}
+
+// validationValue dereferences value, false is returned for nil pointers which only the required rule checks
+func validationValue(value any) (reflect.Value, bool) {
+	rv := reflect.ValueOf(value)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return rv, false
+		}
+		rv = rv.Elem()
+	}
+	return rv, rv.IsValid()
+}
+
+// validationSize returns the value compared by min and max with its unit:
+// numbers, the characters of strings or the elements of slices and maps
+func validationSize(value any) (float64, string, bool) {
+	rv, ok := validationValue(value)
+	if !ok {
+		return 0, "", false
+	}
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), "", true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), "", true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), "", true
+	case reflect.String:
+		return float64(utf8.RuneCountInString(rv.String())), " characters", true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(rv.Len()), " elements", true
+	default:
+		return 0, "", false
+	}
+}
+
+var validationPatterns sync.Map
+
+// validationPattern compiles the patterns of regex rules once, they are checked by the generator
+func validationPattern(pattern string) *regexp.Regexp {
+	if compiled, ok := validationPatterns.Load(pattern); ok {
+		return compiled.(*regexp.Regexp)
+	}
+
+	compiled := regexp.MustCompile(pattern)
+	validationPatterns.Store(pattern, compiled)
+	return compiled
+}
+
+// Section: Migrations
+
+// Migration is a versioned change of the database, listed by the registry generated into the migrations package
+type Migration struct {
+	Version string // UTC timestamp of the migration file, e.g. 20261019153000
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database) error
+	Down    func(ctx context.Context, db *mongo.Database) error // Nil for irreversible migrations
+}
+
+// MigrationStatus is a migration with the time it was applied, nil while pending
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+const (
+	migrationsCollectionName = "_migrations"
+	migrationLockID          = "lock"
+	// Locks left by crashed runs are taken over once expired
+	migrationLockTimeout = time.Hour
+)
+
+type migrationRecord struct {
+	Version   string    `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"appliedAt"`
+}
+
+// MigrateUp applies the pending migrations in version order, the applied migrations are returned
+func MigrateUp(ctx context.Context, migrations []Migration) (applied []Migration, err error) {
+	err = withMigrationLock(ctx, func(db *mongo.Database, records map[string]*migrationRecord) error {
+		for _, migration := range sortedMigrations(migrations) {
+			if records[migration.Version] != nil {
+				continue
+			}
+
+			if err := runMigration(ctx, "MigrateUp", migration, migration.Up, db); err != nil {
+				return err
+			}
+
+			record := &migrationRecord{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now().UTC()}
+			if _, err := db.Collection(migrationsCollectionName).InsertOne(ctx, record); err != nil {
+				return wrapError(err)
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// MigrateDown reverts the last steps applied migrations, latest first
+func MigrateDown(ctx context.Context, migrations []Migration, steps int) (reverted []Migration, err error) {
+	err = withMigrationLock(ctx, func(db *mongo.Database, records map[string]*migrationRecord) error {
+		known := map[string]Migration{}
+		for _, migration := range migrations {
+			known[migration.Version] = migration
+		}
+
+		versions := make([]string, 0, len(records))
+		for version := range records {
+			versions = append(versions, version)
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(versions)))
+
+		for _, version := range versions[:min(steps, len(versions))] {
+			migration, ok := known[version]
+			if !ok {
+				return fmt.Errorf("migration %s_%s is not registered", version, records[version].Name)
+			}
+			if migration.Down == nil {
+				return fmt.Errorf("migration %s_%s cannot be reverted", version, migration.Name)
+			}
+
+			if err := runMigration(ctx, "MigrateDown", migration, migration.Down, db); err != nil {
+				return err
+			}
+
+			if _, err := db.Collection(migrationsCollectionName).DeleteOne(ctx, bson.D{{Key: "_id", Value: version}}); err != nil {
+				return wrapError(err)
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// MigrationStatuses returns the registered migrations in version order, followed by
+// the applied migrations missing from the registry
+func MigrationStatuses(ctx context.Context, migrations []Migration) ([]MigrationStatus, error) {
+	store, err := getMongoStore()
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := findMigrationRecords(ctx, store.database)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []MigrationStatus{}
+	for _, migration := range sortedMigrations(migrations) {
+		status := MigrationStatus{Migration: migration}
+		if record := records[migration.Version]; record != nil {
+			status.AppliedAt = &record.AppliedAt
+			delete(records, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+
+	unknown := []MigrationStatus{}
+	for _, record := range records {
+		unknown = append(unknown, MigrationStatus{Migration: Migration{Version: record.Version, Name: record.Name}, AppliedAt: &record.AppliedAt})
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Version < unknown[j].Version })
+	return append(statuses, unknown...), nil
+}
+
+// RunMigrations runs the up, down [STEPS] or status command of args, reporting to w
+func RunMigrations(ctx context.Context, migrations []Migration, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("expected a command: up, down [STEPS] or status")
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := MigrateUp(ctx, migrations)
+		for _, migration := range applied {
+			fmt.Fprintf(w, "Applied %s_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintln(w, "No pending migrations")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+
+		reverted, err := MigrateDown(ctx, migrations, steps)
+		for _, migration := range reverted {
+			fmt.Fprintf(w, "Reverted %s_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(reverted) == 0 {
+			fmt.Fprintln(w, "No applied migrations")
+		}
+		return err
+	case "status":
+		statuses, err := MigrationStatuses(ctx, migrations)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			if status.Up == nil {
+				appliedAt += " (not registered)"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown command %q, expected up, down [STEPS] or status", args[0])
+	}
+}
+
+func sortedMigrations(migrations []Migration) []Migration {
+	sorted := append([]Migration{}, migrations...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	return sorted
+}
+
+func runMigration(ctx context.Context, operation string, migration Migration, fn func(context.Context, *mongo.Database) error, db *mongo.Database) (err error) {
+	ctx, op := startOperation(ctx, operation, migrationsCollectionName, nil)
+	defer func() { op.end(ctx, err) }()
+
+	if err = fn(ctx, db); err != nil {
+		return fmt.Errorf("migration %s_%s failed: %w", migration.Version, migration.Name, err)
+	}
+
+	getLogger().InfoContext(ctx, "Migration run", "operation", operation, "version", migration.Version, "name", migration.Name)
+	return nil
+}
+
+// withMigrationLock runs fn with the applied migrations while holding the lock document
+// of the _migrations collection, ErrMigrationLocked is returned when another run holds it
+func withMigrationLock(ctx context.Context, fn func(db *mongo.Database, records map[string]*migrationRecord) error) error {
+	store, err := getMongoStore()
+	if err != nil {
+		return err
+	}
+	coll := store.database.Collection(migrationsCollectionName)
+
+	// The upsert inserts the lock unless a lock which has not expired exists, failing on its _id
+	lockedAt := time.Now().UTC()
+	_, err = coll.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: migrationLockID}, {Key: "expiresAt", Value: bson.D{{Key: "$lt", Value: lockedAt}}}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "lockedAt", Value: lockedAt}, {Key: "expiresAt", Value: lockedAt.Add(migrationLockTimeout)}}}},
+		options.UpdateOne().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrMigrationLocked
+	}
+	if err != nil {
+		return wrapError(err)
+	}
+
+	defer func() {
+		filter := bson.D{{Key: "_id", Value: migrationLockID}, {Key: "lockedAt", Value: lockedAt}}
+		if _, err := coll.DeleteOne(context.WithoutCancel(ctx), filter); err != nil {
+			getLogger().ErrorContext(ctx, "Could not release the migrations lock", "error", err)
+		}
+	}()
+
+	records, err := findMigrationRecords(ctx, store.database)
+	if err != nil {
+		return err
+	}
+	return fn(store.database, records)
+}
+
+func findMigrationRecords(ctx context.Context, db *mongo.Database) (map[string]*migrationRecord, error) {
+	cursor, err := db.Collection(migrationsCollectionName).Find(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$ne", Value: migrationLockID}}}})
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	records := []*migrationRecord{}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, wrapError(err)
+	}
+
+	recordsByVersion := map[string]*migrationRecord{}
+	for _, record := range records {
+		recordsByVersion[record.Version] = record
+	}
+	return recordsByVersion, nil
+}
+
+// Section: Mocks
+
+// MockCall is a call recorded by a generated mock, Args excludes the context
+type MockCall struct {
+	Method string
+	Args   []any
+}
+
+// MockCalls records the calls of the mocks generated with output.mocks
+type MockCalls struct {
+	mu    sync.Mutex
+	calls []MockCall
+}
+
+func (c *MockCalls) Record(method string, args ...any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, MockCall{Method: method, Args: args})
+}
+
+// Calls returns the recorded calls in order, those of method only when given
+func (c *MockCalls) Calls(method ...string) []MockCall {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	calls := []MockCall{}
+	for _, call := range c.calls {
+		if len(method) == 0 || call.Method == method[0] {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (c *MockCalls) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = nil
+}
+
+// Section: Stores
+
+// Store holds the collections of the models, the MongoDB client is used unless Config.Store is set
+type Store interface {
+	Collection(name string) Collection
+	// Transaction runs fn atomically, fn runs in the ongoing transaction of ctx if any.
+	// ErrTransactionsNotSupported is returned before running fn when the deployment has no transactions
+	Transaction(ctx context.Context, opts *options.SessionOptionsBuilder, fn func(ctx context.Context) error) error
+	Close(ctx context.Context) error
+}
+
+// Collection holds the documents of a model, its methods mirror those of *mongo.Collection
+type Collection interface {
+	Aggregate(ctx context.Context, pipeline any, opts ...options.Lister[options.AggregateOptions]) (Cursor, error)
+	CountDocuments(ctx context.Context, filter any, opts ...options.Lister[options.CountOptions]) (int64, error)
+	DeleteOne(ctx context.Context, filter any, opts ...options.Lister[options.DeleteOneOptions]) (*mongo.DeleteResult, error)
+	DeleteMany(ctx context.Context, filter any, opts ...options.Lister[options.DeleteManyOptions]) (*mongo.DeleteResult, error)
+	Distinct(ctx context.Context, fieldName string, filter any, results any, opts ...options.Lister[options.DistinctOptions]) error
+	EstimatedDocumentCount(ctx context.Context, opts ...options.Lister[options.EstimatedDocumentCountOptions]) (int64, error)
+	Find(ctx context.Context, filter any, opts ...options.Lister[options.FindOptions]) (Cursor, error)
+	FindOne(ctx context.Context, filter any, result any, opts ...options.Lister[options.FindOneOptions]) error
+	InsertOne(ctx context.Context, document any, opts ...options.Lister[options.InsertOneOptions]) (*mongo.InsertOneResult, error)
+	UpdateByID(ctx context.Context, id any, update any, opts ...options.Lister[options.UpdateOneOptions]) (*mongo.UpdateResult, error)
+	UpdateOne(ctx context.Context, filter any, update any, opts ...options.Lister[options.UpdateOneOptions]) (*mongo.UpdateResult, error)
+	UpdateMany(ctx context.Context, filter any, update any, opts ...options.Lister[options.UpdateManyOptions]) (*mongo.UpdateResult, error)
+	Watch(ctx context.Context, pipeline any, opts ...options.Lister[options.ChangeStreamOptions]) (*mongo.ChangeStream, error)
+}
+
+// Cursor iterates over the documents found in a Collection, *mongo.Cursor implements it
+type Cursor interface {
+	Next(ctx context.Context) bool
+	Decode(val any) error
+	All(ctx context.Context, results any) error
+	Err() error
+	Close(ctx context.Context) error
+}
+
+// mongoStore is the Store of a MongoDB database
+type mongoStore struct {
+	client       *mongo.Client
+	database     *mongo.Database
+	mu           sync.Mutex
+	collections  map[string]*mongoCollection
+	transactions *bool
+}
+
+func newMongoStore(client *mongo.Client, databaseName string) *mongoStore {
+	return &mongoStore{
+		client:      client,
+		database:    client.Database(databaseName),
+		collections: map[string]*mongoCollection{},
+	}
+}
+
+func (s *mongoStore) Collection(name string) Collection {
+	return s.getCollection(name)
+}
+
+func (s *mongoStore) getCollection(name string) *mongoCollection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if coll, ok := s.collections[name]; ok {
+		return coll
+	}
+	s.collections[name] = &mongoCollection{coll: s.database.Collection(name)}
+	return s.collections[name]
+}
+
+func (s *mongoStore) Transaction(ctx context.Context, opts *options.SessionOptionsBuilder, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	supported, err := s.supportsTransactions(ctx)
+	if err != nil {
+		return wrapError(err)
+	}
+	if !supported {
+		return ErrTransactionsNotSupported
+	}
+
+	return s.client.UseSessionWithOptions(ctx, opts, func(ctx context.Context) error {
+		sess := mongo.SessionFromContext(ctx)
+		_, err := sess.WithTransaction(ctx, func(ctx context.Context) (any, error) {
+			return nil, fn(ctx)
+		})
+		return err
+	})
+}
+
+// supportsTransactions reports whether the deployment is a replica set or a sharded cluster,
+// standalone servers do not support transactions
+func (s *mongoStore) supportsTransactions(ctx context.Context) (bool, error) {
+	s.mu.Lock()
+	supported := s.transactions
+	s.mu.Unlock()
+	if supported != nil {
+		return *supported, nil
+	}
+
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := s.database.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return false, err
+	}
+
+	result := hello.SetName != "" || hello.Msg == "isdbgrid"
+	s.mu.Lock()
+	s.transactions = &result
+	s.mu.Unlock()
+	return result, nil
+}
+
+func (s *mongoStore) Close(ctx context.Context) error {
+	return s.client.Disconnect(ctx)
+}
+
+// mongoCollection adapts *mongo.Collection to Collection
+type mongoCollection struct {
+	coll *mongo.Collection
+}
+
+func (c *mongoCollection) Aggregate(ctx context.Context, pipeline any, opts ...options.Lister[options.AggregateOptions]) (Cursor, error) {
+	cur, err := c.coll.Aggregate(ctx, pipeline, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return cur, nil
+}
+
+func (c *mongoCollection) CountDocuments(ctx context.Context, filter any, opts ...options.Lister[options.CountOptions]) (int64, error) {
+	return c.coll.CountDocuments(ctx, filter, opts...)
+}
+
+func (c *mongoCollection) DeleteOne(ctx context.Context, filter any, opts ...options.Lister[options.DeleteOneOptions]) (*mongo.DeleteResult, error) {
+	return c.coll.DeleteOne(ctx, filter, opts...)
+}
+
+func (c *mongoCollection) DeleteMany(ctx context.Context, filter any, opts ...options.Lister[options.DeleteManyOptions]) (*mongo.DeleteResult, error) {
+	return c.coll.DeleteMany(ctx, filter, opts...)
+}
+
+func (c *mongoCollection) Distinct(ctx context.Context, fieldName string, filter any, results any, opts ...options.Lister[options.DistinctOptions]) error {
+	return c.coll.Distinct(ctx, fieldName, filter, opts...).Decode(results)
+}
+
+func (c *mongoCollection) EstimatedDocumentCount(ctx context.Context, opts ...options.Lister[options.EstimatedDocumentCountOptions]) (int64, error) {
+	return c.coll.EstimatedDocumentCount(ctx, opts...)
+}
+
+func (c *mongoCollection) Find(ctx context.Context, filter any, opts ...options.Lister[options.FindOptions]) (Cursor, error) {
+	cur, err := c.coll.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return cur, nil
+}
+
+func (c *mongoCollection) FindOne(ctx context.Context, filter any, result any, opts ...options.Lister[options.FindOneOptions]) error {
+	return c.coll.FindOne(ctx, filter, opts...).Decode(result)
+}
+
+func (c *mongoCollection) InsertOne(ctx context.Context, document any, opts ...options.Lister[options.InsertOneOptions]) (*mongo.InsertOneResult, error) {
+	return c.coll.InsertOne(ctx, document, opts...)
+}
+
+func (c *mongoCollection) UpdateByID(ctx context.Context, id any, update any, opts ...options.Lister[options.UpdateOneOptions]) (*mongo.UpdateResult, error) {
+	return c.coll.UpdateByID(ctx, id, update, opts...)
+}
+
+func (c *mongoCollection) UpdateOne(ctx context.Context, filter any, update any, opts ...options.Lister[options.UpdateOneOptions]) (*mongo.UpdateResult, error) {
+	return c.coll.UpdateOne(ctx, filter, update, opts...)
+}
+
+func (c *mongoCollection) UpdateMany(ctx context.Context, filter any, update any, opts ...options.Lister[options.UpdateManyOptions]) (*mongo.UpdateResult, error) {
+	return c.coll.UpdateMany(ctx, filter, update, opts...)
+}
+
+func (c *mongoCollection) Watch(ctx context.Context, pipeline any, opts ...options.Lister[options.ChangeStreamOptions]) (*mongo.ChangeStream, error) {
+	return c.coll.Watch(ctx, pipeline, opts...)
+}
+
+// Section: Telemetry
+
+const instrumentationName = "github.com/jonoans/mongo-gen"
+
+// telemetryInstruments records a span and metrics for every operation, the global
+// OpenTelemetry providers are no-ops until the application configures them
+type telemetryInstruments struct {
+	tracer   trace.Tracer
+	duration metric.Float64Histogram
+	errors   metric.Int64Counter
+}
+
+var defaultTelemetry = newTelemetryInstruments(nil, nil)
+
+func newTelemetryInstruments(tracerProvider trace.TracerProvider, meterProvider metric.MeterProvider) *telemetryInstruments {
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+	if meterProvider == nil {
+		meterProvider = otel.GetMeterProvider()
+	}
+
+	meter := meterProvider.Meter(instrumentationName)
+	duration, err := meter.Float64Histogram("mongogen.operation.duration", metric.WithUnit("s"), metric.WithDescription("Duration of the operations, hooks included"))
+	if err != nil {
+		otel.Handle(err)
+		duration = noop.Float64Histogram{}
+	}
+
+	errorCount, err := meter.Int64Counter("mongogen.operation.errors", metric.WithUnit("{error}"), metric.WithDescription("Number of failed operations"))
+	if err != nil {
+		otel.Handle(err)
+		errorCount = noop.Int64Counter{}
+	}
+
+	return &telemetryInstruments{
+		tracer:   tracerProvider.Tracer(instrumentationName),
+		duration: duration,
+		errors:   errorCount,
+	}
+}
+
+type operationSpanKey struct{}
+
+// operationSpan is the span of a running operation, hooks called with its context add to its hook time
+type operationSpan struct {
+	span       trace.Span
+	start      time.Time
+	attrs      []attribute.KeyValue
+	operation  string
+	collection string
+	filter     any
+	returned   int
+	hookTime   time.Duration
+}
+
+// startOperation starts the span of an operation on collection, the filter is recorded with its values redacted
+func startOperation(ctx context.Context, operation string, collection string, filter any) (context.Context, *operationSpan) {
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system.name", "mongodb"),
+		attribute.String("db.operation.name", operation),
+	}
+	if collection != "" {
+		attrs = append(attrs, attribute.String("db.collection.name", collection))
+	}
+
+	name := operation
+	if collection != "" {
+		name += " " + collection
+	}
+
+	ctx, span := defaultTelemetry.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	if defaultCfg.DatabaseName != "" {
+		span.SetAttributes(attribute.String("db.namespace", defaultCfg.DatabaseName))
+	}
+	if filter != nil && span.IsRecording() {
+		span.SetAttributes(attribute.String("db.query.summary", filterShape(filter)))
+	}
+
+	op := &operationSpan{span: span, start: time.Now(), attrs: attrs, operation: operation, collection: collection, filter: filter, returned: -1}
+	return context.WithValue(ctx, operationSpanKey{}, op), op
+}
+
+// startResolver starts the span of a GetResolved_ method, its queries are recorded as child spans
+func startResolver(ctx context.Context, model string, method string) (context.Context, *operationSpan) {
+	attrs := []attribute.KeyValue{
+		attribute.String("mongogen.model", model),
+		attribute.String("mongogen.resolver", method),
+	}
+
+	ctx, span := defaultTelemetry.tracer.Start(ctx, model+"."+method, trace.WithAttributes(attrs...))
+	return ctx, &operationSpan{span: span, start: time.Now(), returned: -1}
+}
+
+// setReturned records the number of documents returned or affected by the operation
+func (o *operationSpan) setReturned(n int) {
+	o.returned = n
+}
+
+// end records the outcome of the operation and ends its span, operations without
+// attributes are resolvers and are not recorded in the metrics nor logged as slow
+func (o *operationSpan) end(ctx context.Context, err error) {
+	elapsed := time.Since(o.start)
+	if o.returned >= 0 {
+		o.span.SetAttributes(attribute.Int("db.response.returned_rows", o.returned))
+	}
+	if o.hookTime > 0 {
+		o.span.SetAttributes(attribute.Float64("mongogen.hook.duration", o.hookTime.Seconds()))
+	}
+
+	if err != nil {
+		o.span.RecordError(err)
+		o.span.SetStatus(codes.Error, err.Error())
+	}
+
+	if o.attrs != nil {
+		attrs := metric.WithAttributes(o.attrs...)
+		defaultTelemetry.duration.Record(ctx, elapsed.Seconds(), attrs)
+		if err != nil {
+			defaultTelemetry.errors.Add(ctx, 1, attrs)
+		}
+
+		if threshold := defaultCfg.SlowQueryThreshold; threshold > 0 && elapsed >= threshold {
+			args := []any{"operation", o.operation, "collection", o.collection, "duration", elapsed, "hookDuration", o.hookTime}
+			if o.filter != nil {
+				args = append(args, "filter", filterShape(o.filter))
+			}
+			if o.returned >= 0 {
+				args = append(args, "returned", o.returned)
+			}
+			getLogger().WarnContext(ctx, "Slow operation", args...)
+		}
+	}
+
+	o.span.End()
+}
+
+// addHookTime adds the time spent in a hook to the operation running in ctx
+func addHookTime(ctx context.Context, elapsed time.Duration) {
+	if op, ok := ctx.Value(operationSpanKey{}).(*operationSpan); ok {
+		op.hookTime += elapsed
+	}
+}
+
+// resultsLen returns the length of the slice results points to
+func resultsLen(results any) int {
+	value := reflect.Indirect(reflect.ValueOf(results))
+	if value.Kind() != reflect.Slice {
+		return 0
+	}
+	return value.Len()
+}
+
+// filterShape renders the filter with its values replaced by ?, keeping the fields and operators
+func filterShape(filter any) string {
+	raw, err := bson.Marshal(bson.M{"v": filter})
+	if err != nil {
+		return "?"
+	}
+
+	wrapped := bson.D{}
+	if err := bson.Unmarshal(raw, &wrapped); err != nil || len(wrapped) != 1 {
+		return "?"
+	}
+
+	shape := &strings.Builder{}
+	writeFilterShape(shape, wrapped[0].Value)
+	return shape.String()
+}
+
+func writeFilterShape(shape *strings.Builder, value any) {
+	switch v := value.(type) {
+	case bson.D:
+		shape.WriteString("{")
+		for i, elem := range v {
+			if i > 0 {
+				shape.WriteString(", ")
+			}
+			shape.WriteString(strconv.Quote(elem.Key))
+			shape.WriteString(": ")
+			writeFilterShape(shape, elem.Value)
+		}
+		shape.WriteString("}")
+	case bson.A:
+		// Arrays of values are redacted as a whole so that their length is not recorded
+		documents := len(v) > 0
+		for _, elem := range v {
+			if _, ok := elem.(bson.D); !ok {
+				documents = false
+			}
+		}
+		if !documents {
+			shape.WriteString("?")
+			return
+		}
+
+		shape.WriteString("[")
+		for i, elem := range v {
+			if i > 0 {
+				shape.WriteString(", ")
+			}
+			writeFilterShape(shape, elem)
+		}
+		shape.WriteString("]")
+	default:
+		shape.WriteString("?")
+	}
+}
+
+// Section: Errors
+
+var (
+	ErrNotInitialised           = errors.New("client is not initialised, please call the Initialise method first")
+	ErrAlreadyInitialised       = errors.New("client is already initialised")
+	ErrNotFound                 = errors.New("document not found")
+	ErrInvalidID                = errors.New("invalid object id")
+	ErrEmptyDatabaseName        = errors.New("database name is empty")
+	ErrEmptyCollectionName      = errors.New("collection name is empty")
+	ErrNotModel                 = errors.New("model is not a ModelInterface")
+	ErrInvalidResults           = errors.New("results is not a pointer to a slice")
+	ErrDeleteRestricted         = errors.New("delete restricted by referencing documents")
+	ErrTransactionsNotSupported = errors.New("deployment does not support transactions")
+	ErrNotSupported             = errors.New("operation is not supported by the store")
+	ErrValidation               = errors.New("validation failed")
+	ErrMigrationLocked          = errors.New("migrations are locked by another run")
+)
+
+// HookError is returned when a model hook fails
+type HookError struct {
+	Hook  string
+	Model string
+	Err   error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook of %s failed: %s", e.Hook, e.Model, e.Err)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError is returned by Validate and by the writes of documents breaking
+// the rules of their mongogen tags, it matches ErrValidation
+type ValidationError struct {
+	Fields []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Error()
+	}
+	return fmt.Sprintf("%s: %s", ErrValidation, strings.Join(messages, "; "))
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// FieldError is a rule broken by the value stored at Path, e.g. ownerships.0.name
+type FieldError struct {
+	Path    string
+	Rule    string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s %s", e.Path, e.Message)
+}
+
+// DuplicateKeyError is returned when a write violates a unique index
+type DuplicateKeyError struct {
+	Index string
+	Keys  bson.M
+	Err   error
+}
+
+func (e *DuplicateKeyError) Error() string {
+	if e.Index == "" {
+		return fmt.Sprintf("duplicate key: %s", e.Err)
+	}
+	return fmt.Sprintf("duplicate key on index %s: %s", e.Index, e.Err)
+}
+
+func (e *DuplicateKeyError) Unwrap() error {
+	return e.Err
+}
+
+var duplicateKeyIndexReg = regexp.MustCompile(`index: (\S+) dup key`)
+
+// wrapError wraps driver errors with the package's error types,
+// the original driver error remains available through errors.Is/errors.As
+func wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	if mongo.IsDuplicateKeyError(err) {
+		return newDuplicateKeyError(err)
+	}
+
+	return err
+}
+
+func newDuplicateKeyError(err error) *DuplicateKeyError {
+	dupErr := &DuplicateKeyError{Err: err}
+
+	var writeException mongo.WriteException
+	if errors.As(err, &writeException) {
+		for _, writeErr := range writeException.WriteErrors {
+			if writeErr.HasErrorCode(11000) {
+				if keyValue, lookupErr := writeErr.Raw.LookupErr("keyValue"); lookupErr == nil {
+					_ = keyValue.Unmarshal(&dupErr.Keys)
+				}
+				break
+			}
+		}
+	}
+
+	if matches := duplicateKeyIndexReg.FindStringSubmatch(err.Error()); matches != nil {
+		dupErr.Index = matches[1]
+	}
+
+	return dupErr
+}
+
+func modelName(model ModelInterface) string {
+	return reflect.Indirect(reflect.ValueOf(model)).Type().Name()
+}
+
+// Section: Private Functions
+
+var (
+	defaultStore Store
+	defaultCfg   Config
+)
+
+func Ctx() context.Context {
+	ctx, _ := newCtx()
+	return ctx
+}
+
+func newCtx() (context.Context, func()) {
+	// Can't cancel context
+	return context.WithTimeout(context.Background(), defaultCfg.OperationTimeout)
+}
+
+// getLogger returns the configured logger, discarding the records when none is configured
+func getLogger() *slog.Logger {
+	if defaultCfg.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return defaultCfg.Logger
+}
+
+func getDefaultStore() (Store, error) {
+	if defaultStore == nil {
+		return nil, ErrNotInitialised
+	}
+	return defaultStore, nil
+}
+
+// getMongoStore returns the default store for functions exposing the driver's types
+func getMongoStore() (*mongoStore, error) {
+	store, err := getDefaultStore()
+	if err != nil {
+		return nil, err
+	}
+
+	mongoStore, ok := store.(*mongoStore)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T is not a MongoDB store", ErrNotSupported, store)
+	}
+	return mongoStore, nil
+}
+
+func getStoreCollection(name string) (Collection, error) {
+	store, err := getDefaultStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.Collection(name), nil
+}
+
+func assertObjectID(id any) (bson.ObjectID, error) {
+	switch v := id.(type) {
+	case bson.ObjectID:
+		return v, nil
+	case *bson.ObjectID:
+		return *v, nil
+	case string:
+		oid, err := bson.ObjectIDFromHex(v)
+		if err != nil {
+			return bson.NilObjectID, fmt.Errorf("%w: %w", ErrInvalidID, err)
+		}
+		return oid, nil
+	default:
+		return bson.NilObjectID, ErrInvalidID
+	}
+}
+
+func checkConfig(cfg *Config) error {
+	if cfg.DatabaseName == "" && cfg.Store == nil {
+		return ErrEmptyDatabaseName
+	}
+
+	// Fill default
+	if cfg.OperationTimeout == 0 {
+		cfg.OperationTimeout = time.Second * 15
+	}
+
+	if cfg.TxnSessionOptions == nil {
+		cfg.TxnSessionOptions = options.Session()
+	}
+
+	if cfg.ValidationLevel == "" {
+		cfg.ValidationLevel = "strict"
+	}
+
+	if cfg.ValidationAction == "" {
+		cfg.ValidationAction = "error"
+	}
+
+	return nil
+}
+
+func getCollectionName(model ModelInterface) (string, error) {
+	name := model.CollectionName()
+	if name == "" {
+		return "", ErrEmptyCollectionName
+	}
+	return name, nil
+}
+
+func getCollectNameFromInterface(model any) (string, error) {
+	if v, ok := model.(ModelInterface); ok {
+		return getCollectionName(v)
+	}
+	return "", ErrNotModel
+}
+
+func getCollectionNameFromSlice(results any) (string, error) {
+	resultsType := reflect.TypeOf(results)
+	if resultsType.Kind() != reflect.Ptr {
+		return "", ErrInvalidResults
+	}
+
+	resultsType = reflect.Indirect(reflect.ValueOf(results)).Type()
+	if resultsType.Kind() != reflect.Slice {
+		return "", ErrInvalidResults
+	}
+
+	elemValue := reflect.New(resultsType.Elem()).Interface()
+	return getCollectNameFromInterface(elemValue)
+}
+
+// inverseReferenceFilter matches documents whose field at path references id,
+// shape lists the slice ('s') and map ('m') containers wrapping the reference
+func inverseReferenceFilter(path string, id any, shape string) bson.M {
+	if shape == "" || shape == "s" {
+		return bson.M{path: id}
+	}
+	return bson.M{"$expr": bson.M{"$in": bson.A{id, flattenReferencesExpr("$"+path, shape)}}}
+}
+
+// flattenReferencesExpr builds an aggregation expression flattening
+// nested slices and maps of references into a single array
+func flattenReferencesExpr(expr any, shape string) any {
+	if shape == "" {
+		return bson.A{expr}
+	}
+
+	var input, item any
+	switch shape[0] {
+	case 'm':
+		input = bson.M{"$objectToArray": bson.M{"$ifNull": bson.A{expr, bson.M{}}}}
+		item = "$$this.v"
+	default:
+		input = bson.M{"$ifNull": bson.A{expr, bson.A{}}}
+		item = "$$this"
+	}
+
+	return bson.M{"$reduce": bson.M{
+		"input":        input,
+		"initialValue": bson.A{},
+		"in":           bson.M{"$concatArrays": bson.A{"$$value", flattenReferencesExpr(item, shape[1:])}},
+	}}
+}
+
+// Section: Referential Actions
+
+// deleteActionsModel is implemented by models referenced by fields declaring onDelete actions
+type deleteActionsModel interface {
+	checkDeleteRestrictions(ctx context.Context) error
+	runDeleteActions(ctx context.Context) error
+}
+
+func restrictDelete(ctx context.Context, model ModelInterface, field string, filter any) error {
+	exists, err := ExistsWithCtx(ctx, model, filter)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return fmt.Errorf("%w: referenced by %s.%s", ErrDeleteRestricted, modelName(model), field)
+	}
+	return nil
+}
+
+// cascadeDelete deletes the documents matching filter and runs their own referential actions,
+// their Deleting hooks run in the transaction and may run again when it is retried,
+// their Deleted hooks run once it commits
+func cascadeDelete[T any, P interface {
+	*T
+	ModelInterface
+}](ctx context.Context, filter any) error {
+	results := []T{}
+	if err := FindManyWithCtx(ctx, &results, filter); err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		return nil
+	}
+
+	ids := make(bson.A, 0, len(results))
+	for i := range results {
+		model := P(&results[i])
+		if err := callBeforeDeleteHooks(ctx, model); err != nil {
+			return err
+		}
+		if actions, ok := any(model).(deleteActionsModel); ok {
+			if err := actions.checkDeleteRestrictions(ctx); err != nil {
+				return err
+			}
+		}
+		ids = append(ids, model.GetID())
+	}
+
+	if _, err := DeleteManyWithCtx(ctx, P(new(T)), bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return err
+	}
+
+	for i := range results {
+		model := P(&results[i])
+		if actions, ok := any(model).(deleteActionsModel); ok {
+			if err := actions.runDeleteActions(ctx); err != nil {
+				return err
+			}
+		}
+		if err := afterCommit(ctx, func() error { return callAfterDeleteHooks(ctx, model) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runInTransaction runs fn in the context's transaction, or a new transaction
+// when the deployment supports them
+func runInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(afterCommitKey{}).(*afterCommitHooks); ok {
+		return fn(ctx)
+	}
+
+	store, err := getDefaultStore()
+	if err != nil {
+		return err
+	}
+
+	// Standalone deployments do not support transactions, the store
+	// reports it before fn runs so that its writes are never repeated
+	err = transaction(ctx, store, defaultCfg.TxnSessionOptions, fn)
+	if errors.Is(err, ErrTransactionsNotSupported) {
+		return fn(ctx)
+	}
+	return err
+}
+
+// Only use when sure results is slice of ModelInterface
+func runFuncOnResultsSliceItems(results any, callback func(model ModelInterface) error) error {
+	resultsPtr := reflect.ValueOf(results)
+	resultsSlice := reflect.Indirect(resultsPtr)
+	resultsSliceLen := resultsSlice.Len()
+	for i := range resultsSliceLen {
+		item := resultsSlice.Index(i).Addr().Interface()
+		if err := callback(item.(ModelInterface)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Section: Hook Helpers
+
+// callHook runs a hook of the model, its duration is added to the hook time of the operation running in ctx
+func callHook(ctx context.Context, model ModelInterface, hook string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	addHookTime(ctx, time.Since(start))
+
+	if err != nil {
+		getLogger().ErrorContext(ctx, "Hook failed", "model", modelName(model), "hook", hook, "error", err)
+		return &HookError{Hook: hook, Model: modelName(model), Err: err}
+	}
+	return nil
+}
 
-func callAfterQueryHooks(model ModelInterface) error {
-	if err := model.Queried(); err != nil {
+func callAfterQueryHooks(ctx context.Context, model ModelInterface) error {
+	if err := callHook(ctx, model, "Queried", model.Queried); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func callBeforeCreateHooks(model ModelInterface) error {
-	if err := model.Creating(); err != nil {
+func callBeforeCreateHooks(ctx context.Context, model ModelInterface) error {
+	if err := callHook(ctx, model, "Creating", model.Creating); err != nil {
 		return err
 	}
 
-	if err := model.Saving(); err != nil {
+	if err := callHook(ctx, model, "Saving", model.Saving); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func callAfterCreateHooks(model ModelInterface, result *mongo.InsertOneResult) error {
+func callAfterCreateHooks(ctx context.Context, model ModelInterface, result *mongo.InsertOneResult) error {
 	model.SetID(result.InsertedID)
 
-	if err := model.Created(); err != nil {
+	if err := callHook(ctx, model, "Created", model.Created); err != nil {
 		return err
 	}
 
-	if err := model.Saved(); err != nil {
+	if err := callHook(ctx, model, "Saved", model.Saved); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func callBeforeUpdateHooks(model ModelInterface) error {
-	if err := model.Updating(); err != nil {
+func callBeforeUpdateHooks(ctx context.Context, model ModelInterface) error {
+	if err := callHook(ctx, model, "Updating", model.Updating); err != nil {
 		return err
 	}
 
-	if err := model.Saving(); err != nil {
+	if err := callHook(ctx, model, "Saving", model.Saving); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func callAfterUpdateHooks(model ModelInterface) error {
-	if err := model.Updated(); err != nil {
+func callAfterUpdateHooks(ctx context.Context, model ModelInterface) error {
+	if err := callHook(ctx, model, "Updated", model.Updated); err != nil {
 		return err
 	}
 
-	if err := model.Saved(); err != nil {
+	if err := callHook(ctx, model, "Saved", model.Saved); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func callBeforeDeleteHooks(model ModelInterface) error {
-	if err := model.Deleting(); err != nil {
+func callBeforeDeleteHooks(ctx context.Context, model ModelInterface) error {
+	if err := callHook(ctx, model, "Deleting", model.Deleting); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func callAfterDeleteHooks(model ModelInterface) error {
-	if err := model.Deleted(); err != nil {
+func callAfterDeleteHooks(ctx context.Context, model ModelInterface) error {
+	if err := callHook(ctx, model, "Deleted", model.Deleted); err != nil {
 		return err
 	}
 
@@ -0,0 +1,253 @@
+package definitions
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// testModel is a model whose Queried hook fails for documents named broken,
+// its Deleted hook records the deleted IDs in testDeleted
+type testModel struct {
+	ID     int    `bson:"_id"`
+	Name   string `bson:"name"`
+	Parent int    `bson:"parent,omitempty"`
+}
+
+var testDeleted []int
+
+func (*testModel) CollectionName() string { return "testModels" }
+func (m *testModel) GetID() any           { return m.ID }
+func (m *testModel) SetID(id any)         { m.ID, _ = id.(int) }
+func (*testModel) Creating() error        { return nil }
+func (*testModel) Created() error         { return nil }
+func (*testModel) Saving() error          { return nil }
+func (*testModel) Saved() error           { return nil }
+func (*testModel) Updating() error        { return nil }
+func (*testModel) Updated() error         { return nil }
+func (*testModel) Deleting() error        { return nil }
+
+func (m *testModel) Deleted() error {
+	testDeleted = append(testDeleted, m.ID)
+	return nil
+}
+
+func (m *testModel) Queried() error {
+	if m.Name == "broken" {
+		return errors.New("broken document")
+	}
+	return nil
+}
+
+// testChangeStream replays events, the resume token of each event is its index
+type testChangeStream struct {
+	events []bson.D
+	next   int
+}
+
+func (s *testChangeStream) Next(ctx context.Context) bool {
+	s.next++
+	return s.next <= len(s.events)
+}
+
+func (s *testChangeStream) Decode(val any) error {
+	raw, err := bson.Marshal(s.events[s.next-1])
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, val)
+}
+
+func (s *testChangeStream) ResumeToken() bson.Raw {
+	raw, _ := bson.Marshal(bson.D{{Key: "_data", Value: s.next - 1}})
+	return raw
+}
+
+func (s *testChangeStream) Err() error                      { return nil }
+func (s *testChangeStream) Close(ctx context.Context) error { return nil }
+
+// testResumeTokenStore keeps the last saved token of each stream
+type testResumeTokenStore map[string]bson.Raw
+
+func (s testResumeTokenStore) LoadResumeToken(ctx context.Context, name string) (bson.Raw, error) {
+	return s[name], nil
+}
+
+func (s testResumeTokenStore) SaveResumeToken(ctx context.Context, name string, token bson.Raw) error {
+	s[name] = token
+	return nil
+}
+
+func TestWatchChangeStream(t *testing.T) {
+	insert := func(fullDocument bson.D) bson.D {
+		return bson.D{{Key: "operationType", Value: "insert"}, {Key: "fullDocument", Value: fullDocument}}
+	}
+	ann := insert(bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: "ann"}})
+	bob := insert(bson.D{{Key: "_id", Value: 2}, {Key: "name", Value: "bob"}})
+
+	cases := []struct {
+		name      string
+		events    []bson.D
+		wantNames []string
+		wantErr   bool
+		wantToken int // Index of the last saved token, -1 when none is saved
+	}{
+		{"every event is sent", []bson.D{ann, bob}, []string{"ann", "bob"}, false, 1},
+		{"failed hook", []bson.D{ann, insert(bson.D{{Key: "_id", Value: 3}, {Key: "name", Value: "broken"}}), bob}, []string{"ann"}, true, 0},
+		{"failed decoding", []bson.D{ann, insert(bson.D{{Key: "_id", Value: 3}, {Key: "name", Value: 4}}), bob}, []string{"ann"}, true, 0},
+		{"failed first event", []bson.D{insert(bson.D{{Key: "_id", Value: "three"}})}, []string{}, true, -1},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tokens := testResumeTokenStore{}
+			events := make(chan ChangeEvent[testModel])
+			go watchChangeStream[testModel](context.Background(), &testChangeStream{events: tc.events}, "models", tokens, events)
+
+			names, gotErr := []string{}, false
+			for event := range events {
+				if event.Err != nil {
+					if gotErr {
+						t.Fatalf("got an event after the error %v", event.Err)
+					}
+					gotErr = true
+					continue
+				}
+				names = append(names, event.FullDocument.Name)
+			}
+
+			if len(names) != len(tc.wantNames) || gotErr != tc.wantErr {
+				t.Fatalf("got events %v and error %t, want %v and %t", names, gotErr, tc.wantNames, tc.wantErr)
+			}
+			for i := range names {
+				if names[i] != tc.wantNames[i] {
+					t.Errorf("got events %v, want %v", names, tc.wantNames)
+				}
+			}
+
+			token, saved := tokens["models"]
+			switch {
+			case tc.wantToken < 0 && saved:
+				t.Errorf("got saved token %s, want none", token)
+			case tc.wantToken >= 0 && (!saved || token.Lookup("_data").Int32() != int32(tc.wantToken)):
+				t.Errorf("got saved token %v, want the token of event %d", token, tc.wantToken)
+			}
+		})
+	}
+}
+
+// testParent cascades its deletion to the test models referencing it, and fails afterwards when Fail is set
+type testParent struct {
+	ID   int  `bson:"_id"`
+	Fail bool `bson:"fail"`
+}
+
+func (*testParent) CollectionName() string { return "testParents" }
+func (m *testParent) GetID() any           { return m.ID }
+func (m *testParent) SetID(id any)         { m.ID, _ = id.(int) }
+func (*testParent) Queried() error         { return nil }
+func (*testParent) Creating() error        { return nil }
+func (*testParent) Created() error         { return nil }
+func (*testParent) Saving() error          { return nil }
+func (*testParent) Saved() error           { return nil }
+func (*testParent) Updating() error        { return nil }
+func (*testParent) Updated() error         { return nil }
+func (*testParent) Deleting() error        { return nil }
+func (*testParent) Deleted() error         { return nil }
+
+func (m *testParent) checkDeleteRestrictions(ctx context.Context) error { return nil }
+
+func (m *testParent) runDeleteActions(ctx context.Context) error {
+	if err := cascadeDelete[testModel](ctx, bson.M{"parent": m.ID}); err != nil {
+		return err
+	}
+	if m.Fail {
+		return errors.New("failed")
+	}
+	return nil
+}
+
+func TestCascadeDelete(t *testing.T) {
+	cases := []struct {
+		name        string
+		delete      func(ctx context.Context, parent *testParent) error
+		parentFails bool
+		wantErr     bool
+		wantDeleted []int
+		wantKept    []int
+	}{
+		{
+			name:        "cascaded",
+			delete:      func(ctx context.Context, parent *testParent) error { return DeleteWithCtx(ctx, parent) },
+			wantDeleted: []int{1, 2},
+			wantKept:    []int{3},
+		},
+		{
+			name:        "aborted transaction runs no Deleted hooks",
+			delete:      func(ctx context.Context, parent *testParent) error { return DeleteWithCtx(ctx, parent) },
+			parentFails: true,
+			wantErr:     true,
+			wantKept:    []int{1, 2, 3},
+		},
+		{
+			name: "hooks wait for the enclosing transaction",
+			delete: func(ctx context.Context, parent *testParent) error {
+				return TransactionWithCtx(ctx, func(ctx context.Context) error {
+					if err := DeleteWithCtx(ctx, parent); err != nil {
+						return err
+					}
+					if len(testDeleted) > 0 {
+						t.Errorf("got Deleted hooks of %v before the commit", testDeleted)
+					}
+					return errors.New("failed")
+				})
+			},
+			wantErr:  true,
+			wantKept: []int{1, 2, 3},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			if err := Initialise(Config{Store: store}); err != nil {
+				t.Fatal(err)
+			}
+			defer Close()
+			testDeleted = nil
+
+			ctx := context.Background()
+			parent := &testParent{ID: 1, Fail: tc.parentFails}
+			models := []ModelInterface{parent, &testParent{ID: 2}, &testModel{ID: 1, Parent: 1}, &testModel{ID: 2, Parent: 1}, &testModel{ID: 3, Parent: 2}}
+			for _, model := range models {
+				if _, err := store.Collection(model.CollectionName()).InsertOne(ctx, model); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := tc.delete(ctx, parent); (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want an error %t", err, tc.wantErr)
+			}
+
+			if !reflect.DeepEqual(testDeleted, tc.wantDeleted) {
+				t.Errorf("got Deleted hooks of %v, want %v", testDeleted, tc.wantDeleted)
+			}
+
+			kept := []testModel{}
+			if err := FindManyWithCtx(ctx, &kept, bson.M{}, options.Find().SetSort(bson.M{"_id": 1})); err != nil {
+				t.Fatal(err)
+			}
+			keptIDs := []int{}
+			for _, model := range kept {
+				keptIDs = append(keptIDs, model.ID)
+			}
+			if !reflect.DeepEqual(keptIDs, tc.wantKept) {
+				t.Errorf("got kept models %v, want %v", keptIDs, tc.wantKept)
+			}
+		})
+	}
+}
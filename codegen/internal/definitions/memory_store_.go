@@ -0,0 +1,1589 @@
+package definitions
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Section: Memory Store
+
+// MemoryStore keeps collections in memory for unit tests of code using the models.
+// Filters support equality, $eq, $ne, $gt, $gte, $lt, $lte, $in, $nin, $exists, $not,
+// $and, $or, $nor and $expr, updates support $set, $unset, $inc, $push, $addToSet and $pull
+// with the $, $[] and $[identifier] positional operators, aggregations support the $match,
+// $addFields, $set, $project, $sort, $skip and $limit stages, expressions the operators listed
+// by evalMemoryExpression. Other operators and change streams return ErrNotSupported.
+// GetClient, GetDatabase and GetCollection return ErrNotSupported and Coll returns nil
+type MemoryStore struct {
+	mu          sync.Mutex
+	txnMu       sync.Mutex
+	collections map[string][]bson.Raw
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{collections: map[string][]bson.Raw{}}
+}
+
+func (s *MemoryStore) Collection(name string) Collection {
+	return &memoryCollection{store: s, name: name}
+}
+
+type memoryTransactionKey struct{}
+
+// memoryTransaction journals the documents written by a transaction to undo them when it fails
+type memoryTransaction struct {
+	store   *MemoryStore
+	journal []memoryJournalEntry
+}
+
+// memoryJournalEntry holds a document as it was before a write, before is nil for inserted documents
+type memoryJournalEntry struct {
+	collection string
+	id         bson.RawValue
+	before     bson.Raw
+}
+
+// Transaction runs transactions one at a time and undoes the writes of fn when it fails,
+// writes made outside of the transaction are kept unless they changed the documents it wrote
+func (s *MemoryStore) Transaction(ctx context.Context, _ *options.SessionOptionsBuilder, fn func(ctx context.Context) error) error {
+	if txn, ok := ctx.Value(memoryTransactionKey{}).(*memoryTransaction); ok && txn.store == s {
+		return fn(ctx)
+	}
+
+	s.txnMu.Lock()
+	defer s.txnMu.Unlock()
+
+	txn := &memoryTransaction{store: s}
+	if err := fn(context.WithValue(ctx, memoryTransactionKey{}, txn)); err != nil {
+		txn.rollback()
+		return err
+	}
+	return nil
+}
+
+// rollback restores the documents written by the transaction, the latest writes first
+func (t *memoryTransaction) rollback() {
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+
+	for i := len(t.journal) - 1; i >= 0; i-- {
+		entry := t.journal[i]
+		docs := t.store.collections[entry.collection]
+
+		index := -1
+		for j, raw := range docs {
+			if id, err := raw.LookupErr("_id"); err == nil && id.Equal(entry.id) {
+				index = j
+				break
+			}
+		}
+
+		switch {
+		case index >= 0 && entry.before == nil:
+			docs = append(docs[:index:index], docs[index+1:]...)
+		case index >= 0:
+			docs[index] = entry.before
+		case entry.before != nil:
+			docs = append(docs, entry.before)
+		}
+		t.store.collections[entry.collection] = docs
+	}
+}
+
+func (s *MemoryStore) Close(ctx context.Context) error {
+	return nil
+}
+
+// memoryCollection stores documents as raw BSON, each operation works on decoded copies
+type memoryCollection struct {
+	store *MemoryStore
+	name  string
+}
+
+func (c *memoryCollection) documents() ([]bson.D, error) {
+	docs := make([]bson.D, len(c.store.collections[c.name]))
+	for i, raw := range c.store.collections[c.name] {
+		if err := bson.Unmarshal(raw, &docs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+// journal records raw as written by the transaction of ctx, if any, before is nil for inserted documents.
+// It is called with the store locked
+func (c *memoryCollection) journal(ctx context.Context, raw bson.Raw, before bson.Raw) {
+	txn, ok := ctx.Value(memoryTransactionKey{}).(*memoryTransaction)
+	if !ok || txn.store != c.store {
+		return
+	}
+	txn.journal = append(txn.journal, memoryJournalEntry{collection: c.name, id: raw.Lookup("_id"), before: before})
+}
+
+// matching returns the indexes and documents matching filter
+func (c *memoryCollection) matching(filter any) ([]int, []bson.D, error) {
+	filterDoc, err := toMemoryDocument(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	docs, err := c.documents()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	indexes, matches := []int{}, []bson.D{}
+	for i, doc := range docs {
+		ok, err := matchMemoryFilter(doc, filterDoc)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if ok {
+			indexes, matches = append(indexes, i), append(matches, doc)
+		}
+	}
+	return indexes, matches, nil
+}
+
+func (c *memoryCollection) Aggregate(ctx context.Context, pipeline any, opts ...options.Lister[options.AggregateOptions]) (Cursor, error) {
+	stages, err := toMemoryValue(pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	c.store.mu.Lock()
+	docs, err := c.documents()
+	c.store.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	stageList, ok := stages.(bson.A)
+	if !ok {
+		return nil, fmt.Errorf("%w: pipeline is not an array of stages", ErrNotSupported)
+	}
+
+	for _, stage := range stageList {
+		stageDoc, ok := stage.(bson.D)
+		if !ok || len(stageDoc) != 1 {
+			return nil, fmt.Errorf("%w: stage %v", ErrNotSupported, stage)
+		}
+
+		if docs, err = applyMemoryStage(docs, stageDoc[0]); err != nil {
+			return nil, err
+		}
+	}
+	return newMemoryCursor(docs)
+}
+
+func (c *memoryCollection) CountDocuments(ctx context.Context, filter any, opts ...options.Lister[options.CountOptions]) (int64, error) {
+	countOpts, err := applyMemoryOptions(opts)
+	if err != nil {
+		return 0, err
+	}
+
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	indexes, _, err := c.matching(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	count := int64(len(indexes))
+	if countOpts.Skip != nil {
+		count = max(count-*countOpts.Skip, 0)
+	}
+	if countOpts.Limit != nil && *countOpts.Limit > 0 {
+		count = min(count, *countOpts.Limit)
+	}
+	return count, nil
+}
+
+func (c *memoryCollection) DeleteOne(ctx context.Context, filter any, opts ...options.Lister[options.DeleteOneOptions]) (*mongo.DeleteResult, error) {
+	return c.delete(ctx, filter, 1)
+}
+
+func (c *memoryCollection) DeleteMany(ctx context.Context, filter any, opts ...options.Lister[options.DeleteManyOptions]) (*mongo.DeleteResult, error) {
+	return c.delete(ctx, filter, -1)
+}
+
+// delete removes up to limit matching documents, every one when limit is negative
+func (c *memoryCollection) delete(ctx context.Context, filter any, limit int) (*mongo.DeleteResult, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	indexes, _, err := c.matching(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit >= 0 && len(indexes) > limit {
+		indexes = indexes[:limit]
+	}
+
+	deleted := map[int]bool{}
+	for _, i := range indexes {
+		deleted[i] = true
+	}
+
+	kept := []bson.Raw{}
+	for i, raw := range c.store.collections[c.name] {
+		if !deleted[i] {
+			kept = append(kept, raw)
+			continue
+		}
+		c.journal(ctx, raw, raw)
+	}
+	c.store.collections[c.name] = kept
+	return &mongo.DeleteResult{DeletedCount: int64(len(indexes)), Acknowledged: true}, nil
+}
+
+func (c *memoryCollection) Distinct(ctx context.Context, fieldName string, filter any, results any, opts ...options.Lister[options.DistinctOptions]) error {
+	c.store.mu.Lock()
+	_, docs, err := c.matching(filter)
+	c.store.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	values := bson.A{}
+	for _, doc := range docs {
+		for _, value := range memoryPathValues(doc, strings.Split(fieldName, ".")) {
+			if _, isArray := value.(bson.A); isArray || containsMemoryValue(values, value) {
+				continue
+			}
+			values = append(values, value)
+		}
+	}
+
+	raw, err := bson.Marshal(bson.D{{Key: "values", Value: values}})
+	if err != nil {
+		return err
+	}
+	return bson.Raw(raw).Lookup("values").Unmarshal(results)
+}
+
+func (c *memoryCollection) EstimatedDocumentCount(ctx context.Context, opts ...options.Lister[options.EstimatedDocumentCountOptions]) (int64, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	return int64(len(c.store.collections[c.name])), nil
+}
+
+func (c *memoryCollection) Find(ctx context.Context, filter any, opts ...options.Lister[options.FindOptions]) (Cursor, error) {
+	findOpts, err := applyMemoryOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c.store.mu.Lock()
+	_, docs, err := c.matching(filter)
+	c.store.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	limit := int64(0)
+	if findOpts.Limit != nil {
+		limit = *findOpts.Limit
+	}
+
+	docs, err = sliceMemoryDocuments(docs, findOpts.Sort, findOpts.Skip, limit, findOpts.Projection)
+	if err != nil {
+		return nil, err
+	}
+	return newMemoryCursor(docs)
+}
+
+func (c *memoryCollection) FindOne(ctx context.Context, filter any, result any, opts ...options.Lister[options.FindOneOptions]) error {
+	findOpts, err := applyMemoryOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	c.store.mu.Lock()
+	_, docs, err := c.matching(filter)
+	c.store.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	docs, err = sliceMemoryDocuments(docs, findOpts.Sort, findOpts.Skip, 1, findOpts.Projection)
+	if err != nil {
+		return err
+	}
+
+	if len(docs) == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return decodeMemoryDocument(docs[0], result)
+}
+
+func (c *memoryCollection) InsertOne(ctx context.Context, document any, opts ...options.Lister[options.InsertOneOptions]) (*mongo.InsertOneResult, error) {
+	doc, err := toMemoryDocument(document)
+	if err != nil {
+		return nil, err
+	}
+
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	id, err := c.insert(ctx, doc)
+	if err != nil {
+		return nil, err
+	}
+	return &mongo.InsertOneResult{InsertedID: id, Acknowledged: true}, nil
+}
+
+// insert adds doc, generating its _id when missing
+func (c *memoryCollection) insert(ctx context.Context, doc bson.D) (any, error) {
+	id, ok := lookupMemoryField(doc, "_id")
+	if !ok {
+		id = bson.NewObjectID()
+		doc = append(bson.D{{Key: "_id", Value: id}}, doc...)
+	}
+
+	docs, err := c.documents()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, existing := range docs {
+		if existingID, _ := lookupMemoryField(existing, "_id"); compareMemoryValues(existingID, id) == 0 {
+			return nil, mongo.WriteException{WriteErrors: mongo.WriteErrors{{
+				Code:    11000,
+				Message: fmt.Sprintf("E11000 duplicate key error collection: %s index: _id_ dup key: { _id: %v }", c.name, id),
+			}}}
+		}
+	}
+
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	c.store.collections[c.name] = append(c.store.collections[c.name], raw)
+	c.journal(ctx, raw, nil)
+	return id, nil
+}
+
+func (c *memoryCollection) UpdateByID(ctx context.Context, id any, update any, opts ...options.Lister[options.UpdateOneOptions]) (*mongo.UpdateResult, error) {
+	return c.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}}, update, opts...)
+}
+
+func (c *memoryCollection) UpdateOne(ctx context.Context, filter any, update any, opts ...options.Lister[options.UpdateOneOptions]) (*mongo.UpdateResult, error) {
+	updateOpts, err := applyMemoryOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.update(ctx, filter, update, updateOpts.ArrayFilters, 1, updateOpts.Upsert != nil && *updateOpts.Upsert)
+}
+
+func (c *memoryCollection) UpdateMany(ctx context.Context, filter any, update any, opts ...options.Lister[options.UpdateManyOptions]) (*mongo.UpdateResult, error) {
+	updateOpts, err := applyMemoryOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.update(ctx, filter, update, updateOpts.ArrayFilters, -1, updateOpts.Upsert != nil && *updateOpts.Upsert)
+}
+
+// update applies update to up to limit matching documents, every one when limit is negative,
+// upserted documents start from the equality conditions of filter
+func (c *memoryCollection) update(ctx context.Context, filter any, update any, arrayFilters []any, limit int, upsert bool) (*mongo.UpdateResult, error) {
+	updateDoc, err := toMemoryDocument(update)
+	if err != nil {
+		return nil, err
+	}
+
+	filterDoc, err := toMemoryDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	positional, err := newMemoryPositional(filterDoc, arrayFilters)
+	if err != nil {
+		return nil, err
+	}
+
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	indexes, docs, err := c.matching(filterDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &mongo.UpdateResult{Acknowledged: true}
+	if len(indexes) == 0 && upsert {
+		doc := bson.D{}
+		for _, e := range filterDoc {
+			if cond, isDoc := e.Value.(bson.D); !strings.HasPrefix(e.Key, "$") && (!isDoc || !isMemoryOperatorDocument(cond)) {
+				doc = append(doc, e)
+			}
+		}
+
+		if doc, err = applyMemoryUpdate(doc, updateDoc, positional); err != nil {
+			return nil, err
+		}
+
+		if result.UpsertedID, err = c.insert(ctx, doc); err != nil {
+			return nil, err
+		}
+		result.UpsertedCount = 1
+		return result, nil
+	}
+
+	if limit >= 0 && len(indexes) > limit {
+		indexes = indexes[:limit]
+	}
+
+	for n, i := range indexes {
+		updated, err := applyMemoryUpdate(docs[n], updateDoc, positional)
+		if err != nil {
+			return nil, err
+		}
+
+		raw, err := bson.Marshal(updated)
+		if err != nil {
+			return nil, err
+		}
+
+		result.MatchedCount++
+		if before := c.store.collections[c.name][i]; !bytes.Equal(raw, before) {
+			result.ModifiedCount++
+			c.store.collections[c.name][i] = raw
+			c.journal(ctx, before, before)
+		}
+	}
+	return result, nil
+}
+
+func (c *memoryCollection) Watch(ctx context.Context, pipeline any, opts ...options.Lister[options.ChangeStreamOptions]) (*mongo.ChangeStream, error) {
+	return nil, fmt.Errorf("%w: change streams", ErrNotSupported)
+}
+
+// memoryCursor iterates over documents decoded from the memory store
+type memoryCursor struct {
+	docs    []bson.Raw
+	current bson.Raw
+}
+
+func newMemoryCursor(docs []bson.D) (*memoryCursor, error) {
+	cur := &memoryCursor{}
+	for _, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		cur.docs = append(cur.docs, raw)
+	}
+	return cur, nil
+}
+
+func (c *memoryCursor) Next(ctx context.Context) bool {
+	if len(c.docs) == 0 {
+		return false
+	}
+	c.current, c.docs = c.docs[0], c.docs[1:]
+	return true
+}
+
+func (c *memoryCursor) Decode(val any) error {
+	return bson.Unmarshal(c.current, val)
+}
+
+func (c *memoryCursor) All(ctx context.Context, results any) error {
+	resultsValue := reflect.ValueOf(results)
+	if resultsValue.Kind() != reflect.Ptr || resultsValue.Elem().Kind() != reflect.Slice {
+		return ErrInvalidResults
+	}
+
+	sliceValue := resultsValue.Elem()
+	sliceValue.Set(sliceValue.Slice(0, 0))
+	for c.Next(ctx) {
+		item := reflect.New(sliceValue.Type().Elem())
+		if err := c.Decode(item.Interface()); err != nil {
+			return err
+		}
+		sliceValue.Set(reflect.Append(sliceValue, item.Elem()))
+	}
+	return nil
+}
+
+func (c *memoryCursor) Err() error {
+	return nil
+}
+
+func (c *memoryCursor) Close(ctx context.Context) error {
+	c.docs = nil
+	return nil
+}
+
+// Section: Memory Store Helpers
+
+func applyMemoryOptions[T any](listers []options.Lister[T]) (*T, error) {
+	opts := new(T)
+	for _, lister := range listers {
+		if lister == nil {
+			continue
+		}
+
+		for _, set := range lister.List() {
+			if err := set(opts); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return opts, nil
+}
+
+// toMemoryValue converts a Go value to its BSON form, documents become bson.D and arrays bson.A
+func toMemoryValue(value any) (any, error) {
+	raw, err := bson.Marshal(bson.D{{Key: "v", Value: value}})
+	if err != nil {
+		return nil, err
+	}
+
+	doc := bson.D{}
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	return doc[0].Value, nil
+}
+
+func toMemoryDocument(value any) (bson.D, error) {
+	if value == nil {
+		return bson.D{}, nil
+	}
+
+	converted, err := toMemoryValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, ok := converted.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T is not a document", ErrNotSupported, value)
+	}
+	return doc, nil
+}
+
+func decodeMemoryDocument(doc bson.D, result any) error {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, result)
+}
+
+func lookupMemoryField(doc bson.D, key string) (any, bool) {
+	for _, e := range doc {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+func isMemoryOperatorDocument(doc bson.D) bool {
+	return len(doc) > 0 && strings.HasPrefix(doc[0].Key, "$")
+}
+
+// memoryPathValues returns the values at a dotted path, arrays along the path are traversed
+// and arrays at its end contribute both themselves and their elements, as in MongoDB filters
+func memoryPathValues(value any, path []string) []any {
+	if len(path) == 0 {
+		if array, ok := value.(bson.A); ok {
+			return append([]any{array}, array...)
+		}
+		return []any{value}
+	}
+
+	switch v := value.(type) {
+	case bson.D:
+		field, ok := lookupMemoryField(v, path[0])
+		if !ok {
+			return nil
+		}
+		return memoryPathValues(field, path[1:])
+	case bson.A:
+		if i, err := strconv.Atoi(path[0]); err == nil {
+			if i < len(v) {
+				return memoryPathValues(v[i], path[1:])
+			}
+			return nil
+		}
+
+		values := []any{}
+		for _, item := range v {
+			values = append(values, memoryPathValues(item, path)...)
+		}
+		return values
+	}
+	return nil
+}
+
+// memoryPathValue returns the value at a dotted path as aggregation expressions do,
+// arrays along the path map to the arrays of the values of their elements
+func memoryPathValue(value any, path []string) any {
+	if len(path) == 0 {
+		return value
+	}
+
+	switch v := value.(type) {
+	case bson.D:
+		field, _ := lookupMemoryField(v, path[0])
+		return memoryPathValue(field, path[1:])
+	case bson.A:
+		values := bson.A{}
+		for _, item := range v {
+			if itemValue := memoryPathValue(item, path); itemValue != nil {
+				values = append(values, itemValue)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func matchMemoryFilter(doc bson.D, filter bson.D) (bool, error) {
+	for _, e := range filter {
+		var matched bool
+		var err error
+
+		switch e.Key {
+		case "$and", "$or", "$nor":
+			matched, err = matchMemoryLogical(doc, e.Key, e.Value)
+		case "$expr":
+			var value any
+			value, err = evalMemoryExpression(doc, e.Value, nil)
+			matched = isMemoryTruthy(value)
+		default:
+			if strings.HasPrefix(e.Key, "$") {
+				return false, fmt.Errorf("%w: filter operator %s", ErrNotSupported, e.Key)
+			}
+			matched, err = matchMemoryCondition(memoryPathValues(doc, strings.Split(e.Key, ".")), e.Value)
+		}
+
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchMemoryLogical(doc bson.D, op string, value any) (bool, error) {
+	filters, ok := value.(bson.A)
+	if !ok {
+		return false, fmt.Errorf("%s requires an array", op)
+	}
+
+	matches := 0
+	for _, filter := range filters {
+		filterDoc, ok := filter.(bson.D)
+		if !ok {
+			return false, fmt.Errorf("%s requires an array of documents", op)
+		}
+
+		matched, err := matchMemoryFilter(doc, filterDoc)
+		if err != nil {
+			return false, err
+		}
+
+		if matched {
+			matches++
+		}
+	}
+
+	switch op {
+	case "$and":
+		return matches == len(filters), nil
+	case "$or":
+		return matches > 0, nil
+	default:
+		return matches == 0, nil
+	}
+}
+
+// matchMemoryCondition matches the values found at a path against a value or a document of operators
+func matchMemoryCondition(values []any, cond any) (bool, error) {
+	ops, ok := cond.(bson.D)
+	if !ok || !isMemoryOperatorDocument(ops) {
+		return memoryValuesEqual(values, cond), nil
+	}
+
+	for _, op := range ops {
+		var matched bool
+		switch op.Key {
+		case "$eq":
+			matched = memoryValuesEqual(values, op.Value)
+		case "$ne":
+			matched = !memoryValuesEqual(values, op.Value)
+		case "$gt", "$gte", "$lt", "$lte":
+			matched = memoryValuesCompare(values, op.Key, op.Value)
+		case "$in", "$nin":
+			candidates, ok := op.Value.(bson.A)
+			if !ok {
+				return false, fmt.Errorf("%s requires an array", op.Key)
+			}
+
+			for _, candidate := range candidates {
+				if memoryValuesEqual(values, candidate) {
+					matched = true
+					break
+				}
+			}
+			matched = matched == (op.Key == "$in")
+		case "$exists":
+			matched = (len(values) > 0) == isMemoryTruthy(op.Value)
+		case "$not":
+			notMatched, err := matchMemoryCondition(values, op.Value)
+			if err != nil {
+				return false, err
+			}
+			matched = !notMatched
+		default:
+			return false, fmt.Errorf("%w: filter operator %s", ErrNotSupported, op.Key)
+		}
+
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// memoryValuesEqual reports whether a value equals target, null matches missing fields
+func memoryValuesEqual(values []any, target any) bool {
+	if target == nil && len(values) == 0 {
+		return true
+	}
+
+	return containsMemoryValue(values, target)
+}
+
+func memoryValuesCompare(values []any, op string, target any) bool {
+	for _, value := range values {
+		if memoryTypeRank(value) != memoryTypeRank(target) {
+			continue
+		}
+
+		cmp := compareMemoryValues(value, target)
+		if (op == "$gt" && cmp > 0) || (op == "$gte" && cmp >= 0) || (op == "$lt" && cmp < 0) || (op == "$lte" && cmp <= 0) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsMemoryValue(values bson.A, value any) bool {
+	for _, v := range values {
+		if memoryTypeRank(v) == memoryTypeRank(value) && compareMemoryValues(v, value) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func isMemoryTruthy(value any) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case int32, int64, float64:
+		return memoryNumber(v) != 0
+	default:
+		return true
+	}
+}
+
+func memoryNumber(value any) float64 {
+	switch v := value.(type) {
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	default:
+		return 0
+	}
+}
+
+// memoryTypeRank orders values of different types as MongoDB's comparison order does
+func memoryTypeRank(value any) int {
+	switch value.(type) {
+	case nil, bson.Undefined:
+		return 1
+	case int32, int64, float64:
+		return 2
+	case string, bson.Symbol:
+		return 3
+	case bson.D:
+		return 4
+	case bson.A:
+		return 5
+	case bson.Binary:
+		return 6
+	case bson.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case bson.DateTime:
+		return 9
+	case bson.Timestamp:
+		return 10
+	case bson.Regex:
+		return 11
+	default:
+		return 12
+	}
+}
+
+func compareMemoryValues(a, b any) int {
+	if rankA, rankB := memoryTypeRank(a), memoryTypeRank(b); rankA != rankB {
+		return rankA - rankB
+	}
+
+	switch x := a.(type) {
+	case int32, int64, float64:
+		numA, numB := memoryNumber(x), memoryNumber(b)
+		switch {
+		case numA < numB:
+			return -1
+		case numA > numB:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(x, fmt.Sprint(b))
+	case bson.D:
+		y := b.(bson.D)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if cmp := strings.Compare(x[i].Key, y[i].Key); cmp != 0 {
+				return cmp
+			}
+			if cmp := compareMemoryValues(x[i].Value, y[i].Value); cmp != 0 {
+				return cmp
+			}
+		}
+		return len(x) - len(y)
+	case bson.A:
+		y := b.(bson.A)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if cmp := compareMemoryValues(x[i], y[i]); cmp != 0 {
+				return cmp
+			}
+		}
+		return len(x) - len(y)
+	case bson.Binary:
+		return bytes.Compare(x.Data, b.(bson.Binary).Data)
+	case bson.ObjectID:
+		y := b.(bson.ObjectID)
+		return bytes.Compare(x[:], y[:])
+	case bool:
+		if x == b.(bool) {
+			return 0
+		} else if x {
+			return 1
+		}
+		return -1
+	case bson.DateTime:
+		y := b.(bson.DateTime)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case nil:
+		return 0
+	default:
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	}
+}
+
+// sliceMemoryDocuments sorts, skips, limits and projects found documents as find options do
+func sliceMemoryDocuments(docs []bson.D, sortSpec any, skip *int64, limit int64, projection any) ([]bson.D, error) {
+	if sortSpec != nil {
+		if err := sortMemoryDocuments(docs, sortSpec); err != nil {
+			return nil, err
+		}
+	}
+
+	if skip != nil {
+		docs = docs[min(max(*skip, 0), int64(len(docs))):]
+	}
+
+	if limit < 0 {
+		limit = -limit
+	}
+	if limit > 0 && int64(len(docs)) > limit {
+		docs = docs[:limit]
+	}
+
+	if projection != nil {
+		projectionDoc, err := toMemoryDocument(projection)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range docs {
+			if docs[i], err = projectMemoryDocument(docs[i], projectionDoc); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return docs, nil
+}
+
+func sortMemoryDocuments(docs []bson.D, sortSpec any) error {
+	spec, err := toMemoryDocument(sortSpec)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range spec {
+		if direction := memoryNumber(e.Value); direction != 1 && direction != -1 {
+			return fmt.Errorf("%w: sort direction %v of %s", ErrNotSupported, e.Value, e.Key)
+		}
+	}
+
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, e := range spec {
+			path := strings.Split(e.Key, ".")
+			cmp := compareMemoryValues(memoryPathValue(docs[i], path), memoryPathValue(docs[j], path))
+			if cmp != 0 {
+				return (cmp < 0) == (memoryNumber(e.Value) > 0)
+			}
+		}
+		return false
+	})
+	return nil
+}
+
+// projectMemoryDocument includes or excludes top level fields, _id is included unless excluded
+// and a projection of _id alone includes it only
+func projectMemoryDocument(doc bson.D, projection bson.D) (bson.D, error) {
+	include, excludeID := false, false
+	for _, e := range projection {
+		if strings.Contains(e.Key, ".") {
+			return nil, fmt.Errorf("%w: projection of nested field %s", ErrNotSupported, e.Key)
+		}
+
+		if e.Key == "_id" {
+			excludeID = !isMemoryTruthy(e.Value)
+		} else if isMemoryTruthy(e.Value) {
+			include = true
+		}
+	}
+	if len(projection) == 1 && projection[0].Key == "_id" && !excludeID {
+		include = true
+	}
+
+	projected := bson.D{}
+	for _, e := range doc {
+		value, listed := lookupMemoryField(projection, e.Key)
+		switch {
+		case e.Key == "_id":
+			if !excludeID {
+				projected = append(projected, e)
+			}
+		case include && listed && isMemoryTruthy(value), !include && !listed:
+			projected = append(projected, e)
+		}
+	}
+	return projected, nil
+}
+
+func applyMemoryStage(docs []bson.D, stage bson.E) ([]bson.D, error) {
+	switch stage.Key {
+	case "$match":
+		filter, ok := stage.Value.(bson.D)
+		if !ok {
+			return nil, errors.New("$match requires a document")
+		}
+
+		matches := []bson.D{}
+		for _, doc := range docs {
+			matched, err := matchMemoryFilter(doc, filter)
+			if err != nil {
+				return nil, err
+			}
+
+			if matched {
+				matches = append(matches, doc)
+			}
+		}
+		return matches, nil
+	case "$addFields", "$set":
+		fields, ok := stage.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("%s requires a document", stage.Key)
+		}
+
+		for i, doc := range docs {
+			for _, field := range fields {
+				value, err := evalMemoryExpression(doc, field.Value, nil)
+				if err != nil {
+					return nil, err
+				}
+
+				if docs[i], err = setMemoryPath(docs[i], field.Key, value); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return docs, nil
+	case "$project":
+		projection, ok := stage.Value.(bson.D)
+		if !ok {
+			return nil, errors.New("$project requires a document")
+		}
+		return sliceMemoryDocuments(docs, nil, nil, 0, projection)
+	case "$sort":
+		return docs, sortMemoryDocuments(docs, stage.Value)
+	case "$skip", "$limit":
+		n := int64(memoryNumber(stage.Value))
+		if stage.Key == "$skip" {
+			return sliceMemoryDocuments(docs, nil, &n, 0, nil)
+		}
+		return sliceMemoryDocuments(docs, nil, nil, n, nil)
+	default:
+		return nil, fmt.Errorf("%w: aggregation stage %s", ErrNotSupported, stage.Key)
+	}
+}
+
+// evalMemoryExpression evaluates field paths, $$variables, literals and the $literal, $eq, $in,
+// $indexOfArray, $ifNull, $concatArrays, $objectToArray and $reduce operators
+func evalMemoryExpression(doc bson.D, expr any, vars map[string]any) (any, error) {
+	switch e := expr.(type) {
+	case string:
+		if strings.HasPrefix(e, "$$") {
+			path := strings.Split(e[2:], ".")
+			value, ok := vars[path[0]]
+			if !ok {
+				return nil, fmt.Errorf("%w: variable %s", ErrNotSupported, e)
+			}
+			return memoryPathValue(value, path[1:]), nil
+		}
+		if strings.HasPrefix(e, "$") {
+			return memoryPathValue(doc, strings.Split(e[1:], ".")), nil
+		}
+		return e, nil
+	case bson.A:
+		values := bson.A{}
+		for _, item := range e {
+			value, err := evalMemoryExpression(doc, item, vars)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case bson.D:
+		if !isMemoryOperatorDocument(e) {
+			values := bson.D{}
+			for _, field := range e {
+				value, err := evalMemoryExpression(doc, field.Value, vars)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, bson.E{Key: field.Key, Value: value})
+			}
+			return values, nil
+		}
+		return evalMemoryOperator(doc, e[0], vars)
+	default:
+		return expr, nil
+	}
+}
+
+func evalMemoryOperator(doc bson.D, op bson.E, vars map[string]any) (any, error) {
+	switch op.Key {
+	case "$literal":
+		return op.Value, nil
+	case "$reduce":
+		return evalMemoryReduce(doc, op.Value, vars)
+	}
+
+	args, err := evalMemoryExpression(doc, op.Value, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	argList, ok := args.(bson.A)
+	if !ok {
+		argList = bson.A{args}
+	}
+
+	switch op.Key {
+	case "$eq", "$in", "$indexOfArray":
+		if len(argList) != 2 {
+			return nil, fmt.Errorf("%s requires 2 arguments", op.Key)
+		}
+
+		if op.Key == "$eq" {
+			return memoryValuesEqual([]any{argList[0]}, argList[1]), nil
+		}
+
+		array, item := argList[0], argList[1]
+		if op.Key == "$in" {
+			array, item = argList[1], argList[0]
+		}
+
+		arrayList, ok := array.(bson.A)
+		if !ok && (op.Key == "$in" || array != nil) {
+			return nil, fmt.Errorf("%s requires an array", op.Key)
+		}
+
+		for i, candidate := range arrayList {
+			if memoryValuesEqual([]any{candidate}, item) {
+				if op.Key == "$in" {
+					return true, nil
+				}
+				return int32(i), nil
+			}
+		}
+
+		if op.Key == "$in" {
+			return false, nil
+		} else if array == nil {
+			return nil, nil
+		}
+		return int32(-1), nil
+	case "$ifNull":
+		for _, arg := range argList {
+			if arg != nil {
+				return arg, nil
+			}
+		}
+		return nil, nil
+	case "$concatArrays":
+		values := bson.A{}
+		for _, arg := range argList {
+			if arg == nil {
+				return nil, nil
+			}
+
+			array, ok := arg.(bson.A)
+			if !ok {
+				return nil, errors.New("$concatArrays requires arrays")
+			}
+			values = append(values, array...)
+		}
+		return values, nil
+	case "$objectToArray":
+		if len(argList) != 1 {
+			return nil, errors.New("$objectToArray requires 1 argument")
+		}
+
+		object, ok := argList[0].(bson.D)
+		if !ok {
+			return nil, errors.New("$objectToArray requires a document")
+		}
+
+		values := bson.A{}
+		for _, field := range object {
+			values = append(values, bson.D{{Key: "k", Value: field.Key}, {Key: "v", Value: field.Value}})
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("%w: expression operator %s", ErrNotSupported, op.Key)
+	}
+}
+
+func evalMemoryReduce(doc bson.D, spec any, vars map[string]any) (any, error) {
+	specDoc, ok := spec.(bson.D)
+	if !ok {
+		return nil, errors.New("$reduce requires a document")
+	}
+
+	input, _ := lookupMemoryField(specDoc, "input")
+	initialValue, _ := lookupMemoryField(specDoc, "initialValue")
+	in, _ := lookupMemoryField(specDoc, "in")
+
+	inputValue, err := evalMemoryExpression(doc, input, vars)
+	if err != nil || inputValue == nil {
+		return nil, err
+	}
+
+	array, ok := inputValue.(bson.A)
+	if !ok {
+		return nil, errors.New("$reduce requires an array input")
+	}
+
+	value, err := evalMemoryExpression(doc, initialValue, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range array {
+		itemVars := map[string]any{"this": item, "value": value}
+		for name, v := range vars {
+			if name != "this" && name != "value" {
+				itemVars[name] = v
+			}
+		}
+
+		if value, err = evalMemoryExpression(doc, in, itemVars); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+func applyMemoryUpdate(doc bson.D, update bson.D, positional *memoryPositional) (bson.D, error) {
+	if !isMemoryOperatorDocument(update) {
+		return nil, fmt.Errorf("%w: replacement updates", ErrNotSupported)
+	}
+
+	for _, op := range update {
+		fields, ok := op.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("%s requires a document", op.Key)
+		}
+
+		for _, field := range fields {
+			var err error
+			switch op.Key {
+			case "$set":
+				doc, err = updateMemoryPath(doc, field.Key, positional, func(any, bool) (any, bool, error) {
+					return field.Value, false, nil
+				})
+			case "$unset":
+				doc, err = updateMemoryPath(doc, field.Key, positional, func(any, bool) (any, bool, error) {
+					return nil, true, nil
+				})
+			case "$inc":
+				doc, err = updateMemoryPath(doc, field.Key, positional, func(current any, exists bool) (any, bool, error) {
+					return addMemoryNumbers(current, field.Value), false, nil
+				})
+			case "$push", "$addToSet":
+				doc, err = updateMemoryPath(doc, field.Key, positional, func(current any, exists bool) (any, bool, error) {
+					return pushMemoryValues(current, field.Value, op.Key == "$addToSet")
+				})
+			case "$pull":
+				doc, err = updateMemoryPath(doc, field.Key, positional, func(current any, exists bool) (any, bool, error) {
+					return pullMemoryValues(current, field.Value)
+				})
+			default:
+				return nil, fmt.Errorf("%w: update operator %s", ErrNotSupported, op.Key)
+			}
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return doc, nil
+}
+
+func setMemoryPath(doc bson.D, path string, value any) (bson.D, error) {
+	return updateMemoryPath(doc, path, &memoryPositional{}, func(any, bool) (any, bool, error) {
+		return value, false, nil
+	})
+}
+
+// memoryPositional selects the array elements updated through the positional operators of update paths,
+// $ from the conditions of the query filter on the array and $[identifier] from the array filters
+type memoryPositional struct {
+	filter       bson.D
+	arrayFilters map[string][]memoryElementCondition
+}
+
+// memoryElementCondition holds a condition on the value at path within array elements
+type memoryElementCondition struct {
+	path []string
+	cond any
+}
+
+func newMemoryPositional(filter bson.D, arrayFilters []any) (*memoryPositional, error) {
+	positional := &memoryPositional{filter: filter, arrayFilters: map[string][]memoryElementCondition{}}
+	for _, arrayFilter := range arrayFilters {
+		doc, err := toMemoryDocument(arrayFilter)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range doc {
+			if strings.HasPrefix(e.Key, "$") {
+				return nil, fmt.Errorf("%w: array filter operator %s", ErrNotSupported, e.Key)
+			}
+
+			path := strings.Split(e.Key, ".")
+			positional.arrayFilters[path[0]] = append(positional.arrayFilters[path[0]], memoryElementCondition{path: path[1:], cond: e.Value})
+		}
+	}
+	return positional, nil
+}
+
+// selected returns which elements of the array found at prefix are selected by the path segment,
+// $[] selects every element, $ the first one matching the filter, $[identifier] the ones matching
+// its array filter and a number the element at that index
+func (p *memoryPositional) selected(segment string, prefix []string, array bson.A) ([]bool, error) {
+	selected := make([]bool, len(array))
+	switch {
+	case segment == "$[]":
+		for i := range selected {
+			selected[i] = true
+		}
+	case segment == "$":
+		conditions := p.filterConditions(p.filter, prefix)
+		for i, item := range array {
+			matched, err := matchMemoryElement(item, conditions)
+			if err != nil {
+				return nil, err
+			}
+
+			if matched && len(conditions) > 0 {
+				selected[i] = true
+				return selected, nil
+			}
+		}
+		return nil, fmt.Errorf("the positional operator did not find the match needed from the query for %s", strings.Join(prefix, "."))
+	case strings.HasPrefix(segment, "$[") && strings.HasSuffix(segment, "]"):
+		identifier := segment[2 : len(segment)-1]
+		conditions, ok := p.arrayFilters[identifier]
+		if !ok {
+			return nil, fmt.Errorf("no array filter found for identifier %s", identifier)
+		}
+
+		for i, item := range array {
+			matched, err := matchMemoryElement(item, conditions)
+			if err != nil {
+				return nil, err
+			}
+			selected[i] = matched
+		}
+	default:
+		i, err := strconv.Atoi(segment)
+		if err != nil || i < 0 {
+			return nil, fmt.Errorf("cannot update %s of an array", segment)
+		}
+
+		if i >= len(array) {
+			return nil, fmt.Errorf("%w: updating array element %d past the end", ErrNotSupported, i)
+		}
+		selected[i] = true
+	}
+	return selected, nil
+}
+
+// filterConditions returns the conditions of filter, and of its $and filters, on the elements of the array at prefix
+func (p *memoryPositional) filterConditions(filter bson.D, prefix []string) []memoryElementCondition {
+	conditions := []memoryElementCondition{}
+	for _, e := range filter {
+		if e.Key == "$and" {
+			filters, _ := e.Value.(bson.A)
+			for _, f := range filters {
+				if filterDoc, ok := f.(bson.D); ok {
+					conditions = append(conditions, p.filterConditions(filterDoc, prefix)...)
+				}
+			}
+			continue
+		}
+
+		path := strings.Split(e.Key, ".")
+		if len(path) >= len(prefix) && slices.Equal(path[:len(prefix)], prefix) {
+			conditions = append(conditions, memoryElementCondition{path: path[len(prefix):], cond: e.Value})
+		}
+	}
+	return conditions
+}
+
+// matchMemoryElement reports whether an array element satisfies every condition
+func matchMemoryElement(item any, conditions []memoryElementCondition) (bool, error) {
+	for _, c := range conditions {
+		matched, err := matchMemoryCondition(memoryPathValues(item, c.path), c.cond)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// updateMemoryPath replaces the value at a dotted path with the result of fn or removes it,
+// documents are created along the path and positional operators apply the rest of the path
+// to the array elements they select
+func updateMemoryPath(doc bson.D, path string, positional *memoryPositional, fn func(current any, exists bool) (any, bool, error)) (bson.D, error) {
+	updated, err := updateMemoryValue(doc, strings.Split(path, "."), nil, positional, fn)
+	if err != nil {
+		return nil, err
+	}
+	return updated.(bson.D), nil
+}
+
+// updateMemoryValue updates the value at path within value, prefix holds the field names traversed to reach it
+func updateMemoryValue(value any, path []string, prefix []string, positional *memoryPositional, fn func(current any, exists bool) (any, bool, error)) (any, error) {
+	if array, ok := value.(bson.A); ok {
+		selected, err := positional.selected(path[0], prefix, array)
+		if err != nil {
+			return nil, err
+		}
+
+		updated := bson.A{}
+		for i, item := range array {
+			if !selected[i] {
+				updated = append(updated, item)
+				continue
+			}
+
+			if len(path) == 1 {
+				// Elements are not removed from arrays, $unset sets them to null as MongoDB does
+				newItem, remove, err := fn(item, true)
+				if err != nil {
+					return nil, err
+				}
+
+				if remove {
+					newItem = nil
+				}
+				updated = append(updated, newItem)
+				continue
+			}
+
+			newItem, err := updateMemoryValue(item, path[1:], prefix, positional, fn)
+			if err != nil {
+				return nil, err
+			}
+			updated = append(updated, newItem)
+		}
+		return updated, nil
+	}
+
+	doc, ok := value.(bson.D)
+	if value == nil {
+		doc, ok = bson.D{}, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("cannot update %s of a %T", path[0], value)
+	}
+
+	index := -1
+	for i, e := range doc {
+		if e.Key == path[0] {
+			index = i
+			break
+		}
+	}
+
+	var current any
+	if index >= 0 {
+		current = doc[index].Value
+	}
+
+	var newValue any
+	if len(path) == 1 {
+		var remove bool
+		var err error
+		if newValue, remove, err = fn(current, index >= 0); err != nil {
+			return nil, err
+		}
+
+		if remove {
+			if index >= 0 {
+				doc = append(doc[:index:index], doc[index+1:]...)
+			}
+			return doc, nil
+		}
+	} else {
+		var err error
+		if newValue, err = updateMemoryValue(current, path[1:], append(prefix[:len(prefix):len(prefix)], path[0]), positional, fn); err != nil {
+			return nil, err
+		}
+	}
+
+	if index >= 0 {
+		doc[index].Value = newValue
+	} else {
+		doc = append(doc, bson.E{Key: path[0], Value: newValue})
+	}
+	return doc, nil
+}
+
+func addMemoryNumbers(a, b any) any {
+	switch {
+	case a == nil:
+		return b
+	case reflect.TypeOf(a) == reflect.TypeOf(b) && reflect.TypeOf(a).Kind() == reflect.Int32:
+		return a.(int32) + b.(int32)
+	default:
+		if _, isFloat := a.(float64); isFloat {
+			return memoryNumber(a) + memoryNumber(b)
+		}
+		if _, isFloat := b.(float64); isFloat {
+			return memoryNumber(a) + memoryNumber(b)
+		}
+		return int64(memoryNumber(a)) + int64(memoryNumber(b))
+	}
+}
+
+// pushMemoryValues appends value, or the values of $each, to an array
+func pushMemoryValues(current any, value any, unique bool) (any, bool, error) {
+	array, ok := current.(bson.A)
+	if current != nil && !ok {
+		return nil, false, fmt.Errorf("cannot push to a %T", current)
+	}
+
+	values := bson.A{value}
+	if each, ok := value.(bson.D); ok && len(each) == 1 && each[0].Key == "$each" {
+		if values, ok = each[0].Value.(bson.A); !ok {
+			return nil, false, errors.New("$each requires an array")
+		}
+	}
+
+	for _, v := range values {
+		if !unique || !containsMemoryValue(array, v) {
+			array = append(array, v)
+		}
+	}
+	return array, false, nil
+}
+
+// pullMemoryValues removes the elements equal to cond, or matching its operators or filter
+func pullMemoryValues(current any, cond any) (any, bool, error) {
+	array, ok := current.(bson.A)
+	if !ok {
+		return current, current == nil, nil
+	}
+
+	kept := bson.A{}
+	for _, item := range array {
+		var matched bool
+		var err error
+
+		condDoc, isDoc := cond.(bson.D)
+		itemDoc, itemIsDoc := item.(bson.D)
+		switch {
+		case isDoc && !isMemoryOperatorDocument(condDoc) && itemIsDoc:
+			matched, err = matchMemoryFilter(itemDoc, condDoc)
+		default:
+			matched, err = matchMemoryCondition([]any{item}, cond)
+		}
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		if !matched {
+			kept = append(kept, item)
+		}
+	}
+	return kept, false, nil
+}
@@ -0,0 +1,513 @@
+package definitions
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// newMemoryTestCollection returns the people collection of a new store holding docs
+func newMemoryTestCollection(t *testing.T, docs ...bson.D) (*MemoryStore, Collection) {
+	t.Helper()
+	store := NewMemoryStore()
+	coll := store.Collection("people")
+	for _, doc := range docs {
+		if _, err := coll.InsertOne(context.Background(), doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store, coll
+}
+
+// memoryTestJSON returns the documents of the collection matching filter as relaxed extended JSON, sorted by _id
+func memoryTestJSON(t *testing.T, coll Collection, filter any, opts ...options.Lister[options.FindOptions]) string {
+	t.Helper()
+	if len(opts) == 0 {
+		opts = append(opts, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	}
+
+	cursor, err := coll.Find(context.Background(), filter, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs := []bson.D{}
+	if err := cursor.All(context.Background(), &docs); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded := []string{}
+	for _, doc := range docs {
+		b, err := bson.MarshalExtJSON(doc, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded = append(encoded, string(b))
+	}
+	return strings.Join(encoded, "\n")
+}
+
+var memoryTestPeople = []bson.D{
+	{{Key: "_id", Value: 1}, {Key: "name", Value: "ann"}, {Key: "age", Value: 30}, {Key: "tags", Value: bson.A{"x", "y"}}, {Key: "address", Value: bson.D{{Key: "city", Value: "Paris"}}}},
+	{{Key: "_id", Value: 2}, {Key: "name", Value: "bob"}, {Key: "age", Value: 20}, {Key: "tags", Value: bson.A{"y"}}},
+	{{Key: "_id", Value: 3}, {Key: "name", Value: "cat"}, {Key: "age", Value: 40}, {Key: "address", Value: bson.D{{Key: "city", Value: "Oslo"}}}},
+}
+
+func TestMemoryStoreFilter(t *testing.T) {
+	cases := []struct {
+		name    string
+		filter  bson.D
+		want    string
+		wantErr error
+	}{
+		{"equality", bson.D{{Key: "name", Value: "bob"}}, "2", nil},
+		{"$eq", bson.D{{Key: "age", Value: bson.D{{Key: "$eq", Value: 30}}}}, "1", nil},
+		{"$ne", bson.D{{Key: "name", Value: bson.D{{Key: "$ne", Value: "bob"}}}}, "1,3", nil},
+		{"$gt", bson.D{{Key: "age", Value: bson.D{{Key: "$gt", Value: 25}}}}, "1,3", nil},
+		{"$gte and $lt", bson.D{{Key: "age", Value: bson.D{{Key: "$gte", Value: 30}, {Key: "$lt", Value: 40}}}}, "1", nil},
+		{"$lte", bson.D{{Key: "age", Value: bson.D{{Key: "$lte", Value: 30}}}}, "1,2", nil},
+		{"$in", bson.D{{Key: "name", Value: bson.D{{Key: "$in", Value: bson.A{"ann", "cat"}}}}}, "1,3", nil},
+		{"$nin", bson.D{{Key: "name", Value: bson.D{{Key: "$nin", Value: bson.A{"ann", "cat"}}}}}, "2", nil},
+		{"$exists", bson.D{{Key: "address", Value: bson.D{{Key: "$exists", Value: true}}}}, "1,3", nil},
+		{"$exists false", bson.D{{Key: "tags", Value: bson.D{{Key: "$exists", Value: false}}}}, "3", nil},
+		{"$not", bson.D{{Key: "age", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$gt", Value: 25}}}}}}, "2", nil},
+		{"array element", bson.D{{Key: "tags", Value: "x"}}, "1", nil},
+		{"whole array", bson.D{{Key: "tags", Value: bson.A{"y"}}}, "2", nil},
+		{"array index", bson.D{{Key: "tags.1", Value: "y"}}, "1", nil},
+		{"nested path", bson.D{{Key: "address.city", Value: "Oslo"}}, "3", nil},
+		{"null matches missing", bson.D{{Key: "address", Value: nil}}, "2", nil},
+		{"$and", bson.D{{Key: "$and", Value: bson.A{bson.D{{Key: "tags", Value: "y"}}, bson.D{{Key: "age", Value: 20}}}}}, "2", nil},
+		{"$or", bson.D{{Key: "$or", Value: bson.A{bson.D{{Key: "name", Value: "ann"}}, bson.D{{Key: "age", Value: 40}}}}}, "1,3", nil},
+		{"$nor", bson.D{{Key: "$nor", Value: bson.A{bson.D{{Key: "name", Value: "ann"}}, bson.D{{Key: "age", Value: 40}}}}}, "2", nil},
+		{"$expr", bson.D{{Key: "$expr", Value: bson.D{{Key: "$in", Value: bson.A{"$name", bson.A{"ann", "cat"}}}}}}, "1,3", nil},
+		{"unsupported operator", bson.D{{Key: "name", Value: bson.D{{Key: "$regex", Value: "^a"}}}}, "", ErrNotSupported},
+		{"unsupported top level operator", bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: "ann"}}}}, "", ErrNotSupported},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, coll := newMemoryTestCollection(t, memoryTestPeople...)
+			cursor, err := coll.Find(context.Background(), tc.filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			results := []struct {
+				ID int `bson:"_id"`
+			}{}
+			if err := cursor.All(context.Background(), &results); err != nil {
+				t.Fatal(err)
+			}
+
+			ids := []string{}
+			for _, r := range results {
+				ids = append(ids, strconv.Itoa(r.ID))
+			}
+			if got := strings.Join(ids, ","); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreUpdate(t *testing.T) {
+	items := bson.D{{Key: "_id", Value: 1}, {Key: "items", Value: bson.A{
+		bson.D{{Key: "sku", Value: "a"}, {Key: "qty", Value: 1}},
+		bson.D{{Key: "sku", Value: "b"}, {Key: "qty", Value: 2}},
+		bson.D{{Key: "sku", Value: "c"}, {Key: "qty", Value: 3}},
+	}}}
+	history := bson.D{{Key: "_id", Value: 1}, {Key: "history", Value: bson.A{
+		bson.D{{Key: "editor", Value: "ann"}, {Key: "others", Value: bson.A{"ann", "bob"}}},
+		bson.D{{Key: "editor", Value: "bob"}, {Key: "others", Value: bson.A{"ann"}}},
+	}}}
+
+	cases := []struct {
+		name         string
+		doc          bson.D
+		filter       bson.D
+		update       bson.D
+		arrayFilters []any
+		want         string
+		wantErr      error
+	}{
+		{
+			name:   "$set creates documents along the path",
+			doc:    bson.D{{Key: "_id", Value: 1}},
+			update: bson.D{{Key: "$set", Value: bson.D{{Key: "a.b", Value: 1}}}},
+			want:   `{"_id":1,"a":{"b":1}}`,
+		},
+		{
+			name:   "$unset",
+			doc:    bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 1}, {Key: "b", Value: 2}},
+			update: bson.D{{Key: "$unset", Value: bson.D{{Key: "a", Value: ""}}}},
+			want:   `{"_id":1,"b":2}`,
+		},
+		{
+			name:   "$inc",
+			doc:    bson.D{{Key: "_id", Value: 1}, {Key: "n", Value: 1}},
+			update: bson.D{{Key: "$inc", Value: bson.D{{Key: "n", Value: 2}, {Key: "m", Value: 1.5}}}},
+			want:   `{"_id":1,"n":3,"m":1.5}`,
+		},
+		{
+			name:   "$push with $each",
+			doc:    bson.D{{Key: "_id", Value: 1}, {Key: "tags", Value: bson.A{"x"}}},
+			update: bson.D{{Key: "$push", Value: bson.D{{Key: "tags", Value: bson.D{{Key: "$each", Value: bson.A{"x", "y"}}}}}}},
+			want:   `{"_id":1,"tags":["x","x","y"]}`,
+		},
+		{
+			name:   "$addToSet",
+			doc:    bson.D{{Key: "_id", Value: 1}, {Key: "tags", Value: bson.A{"x"}}},
+			update: bson.D{{Key: "$addToSet", Value: bson.D{{Key: "tags", Value: bson.D{{Key: "$each", Value: bson.A{"x", "y"}}}}}}},
+			want:   `{"_id":1,"tags":["x","y"]}`,
+		},
+		{
+			name:   "$pull by condition",
+			doc:    bson.D{{Key: "_id", Value: 1}, {Key: "n", Value: bson.A{1, 5, 9}}},
+			update: bson.D{{Key: "$pull", Value: bson.D{{Key: "n", Value: bson.D{{Key: "$gt", Value: 4}}}}}},
+			want:   `{"_id":1,"n":[1]}`,
+		},
+		{
+			name:   "$pull subdocuments by filter",
+			doc:    items,
+			update: bson.D{{Key: "$pull", Value: bson.D{{Key: "items", Value: bson.D{{Key: "qty", Value: bson.D{{Key: "$lt", Value: 3}}}}}}}},
+			want:   `{"_id":1,"items":[{"sku":"c","qty":3}]}`,
+		},
+		{
+			name:   "$[] updates every element",
+			doc:    items,
+			update: bson.D{{Key: "$set", Value: bson.D{{Key: "items.$[].qty", Value: 0}}}},
+			want:   `{"_id":1,"items":[{"sku":"a","qty":0},{"sku":"b","qty":0},{"sku":"c","qty":0}]}`,
+		},
+		{
+			name:   "$ updates the element matched by the filter",
+			doc:    items,
+			filter: bson.D{{Key: "items.sku", Value: "b"}},
+			update: bson.D{{Key: "$inc", Value: bson.D{{Key: "items.$.qty", Value: 10}}}},
+			want:   `{"_id":1,"items":[{"sku":"a","qty":1},{"sku":"b","qty":12},{"sku":"c","qty":3}]}`,
+		},
+		{
+			name:   "$ updates the first element matched by $and filters",
+			doc:    items,
+			filter: bson.D{{Key: "$and", Value: bson.A{bson.D{{Key: "items.qty", Value: bson.D{{Key: "$gt", Value: 1}}}}}}},
+			update: bson.D{{Key: "$set", Value: bson.D{{Key: "items.$.sku", Value: "z"}}}},
+			want:   `{"_id":1,"items":[{"sku":"a","qty":1},{"sku":"z","qty":2},{"sku":"c","qty":3}]}`,
+		},
+		{
+			name:    "$ without a condition on the array",
+			doc:     items,
+			filter:  bson.D{{Key: "_id", Value: 1}},
+			update:  bson.D{{Key: "$set", Value: bson.D{{Key: "items.$.qty", Value: 0}}}},
+			wantErr: errMemoryTestAny,
+		},
+		{
+			name:         "$[identifier] updates the elements matched by the array filter",
+			doc:          items,
+			update:       bson.D{{Key: "$set", Value: bson.D{{Key: "items.$[big].qty", Value: 0}}}},
+			arrayFilters: []any{bson.D{{Key: "big.qty", Value: bson.D{{Key: "$gte", Value: 2}}}}},
+			want:         `{"_id":1,"items":[{"sku":"a","qty":1},{"sku":"b","qty":0},{"sku":"c","qty":0}]}`,
+		},
+		{
+			name:         "$[identifier] nullifies the matching subdocument fields",
+			doc:          history,
+			update:       bson.D{{Key: "$set", Value: bson.D{{Key: "history.$[elem].editor", Value: nil}}}},
+			arrayFilters: []any{bson.D{{Key: "elem.editor", Value: "ann"}}},
+			want:         `{"_id":1,"history":[{"editor":null,"others":["ann","bob"]},{"editor":"bob","others":["ann"]}]}`,
+		},
+		{
+			name:         "$[identifier] on scalar elements",
+			doc:          bson.D{{Key: "_id", Value: 1}, {Key: "n", Value: bson.A{1, 5, 9}}},
+			update:       bson.D{{Key: "$set", Value: bson.D{{Key: "n.$[small]", Value: 0}}}},
+			arrayFilters: []any{bson.D{{Key: "small", Value: bson.D{{Key: "$lt", Value: 6}}}}},
+			want:         `{"_id":1,"n":[0,0,9]}`,
+		},
+		{
+			name:         "$[identifier] without an array filter",
+			doc:          items,
+			update:       bson.D{{Key: "$set", Value: bson.D{{Key: "items.$[big].qty", Value: 0}}}},
+			arrayFilters: []any{bson.D{{Key: "small.qty", Value: 1}}},
+			wantErr:      errMemoryTestAny,
+		},
+		{
+			name:   "$pull through $[]",
+			doc:    history,
+			update: bson.D{{Key: "$pull", Value: bson.D{{Key: "history.$[].others", Value: "ann"}}}},
+			want:   `{"_id":1,"history":[{"editor":"ann","others":["bob"]},{"editor":"bob","others":[]}]}`,
+		},
+		{
+			name:   "array index",
+			doc:    items,
+			update: bson.D{{Key: "$set", Value: bson.D{{Key: "items.2.qty", Value: 0}}}},
+			want:   `{"_id":1,"items":[{"sku":"a","qty":1},{"sku":"b","qty":2},{"sku":"c","qty":0}]}`,
+		},
+		{
+			name:   "$unset of an array element sets it to null",
+			doc:    bson.D{{Key: "_id", Value: 1}, {Key: "n", Value: bson.A{1, 5, 9}}},
+			update: bson.D{{Key: "$unset", Value: bson.D{{Key: "n.1", Value: ""}}}},
+			want:   `{"_id":1,"n":[1,null,9]}`,
+		},
+		{
+			name:    "array index past the end",
+			doc:     items,
+			update:  bson.D{{Key: "$set", Value: bson.D{{Key: "items.5.qty", Value: 0}}}},
+			wantErr: ErrNotSupported,
+		},
+		{
+			name:    "replacement update",
+			doc:     items,
+			update:  bson.D{{Key: "items", Value: bson.A{}}},
+			wantErr: ErrNotSupported,
+		},
+		{
+			name:    "unsupported operator",
+			doc:     items,
+			update:  bson.D{{Key: "$rename", Value: bson.D{{Key: "items", Value: "lines"}}}},
+			wantErr: ErrNotSupported,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, coll := newMemoryTestCollection(t, tc.doc)
+			filter := tc.filter
+			if filter == nil {
+				filter = bson.D{{Key: "_id", Value: 1}}
+			}
+
+			opts := options.UpdateOne()
+			if tc.arrayFilters != nil {
+				opts.SetArrayFilters(tc.arrayFilters)
+			}
+
+			_, err := coll.UpdateOne(context.Background(), filter, tc.update, opts)
+			switch {
+			case tc.wantErr == errMemoryTestAny && err != nil:
+				return
+			case tc.wantErr != nil:
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tc.wantErr)
+				}
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+
+			if got := memoryTestJSON(t, coll, bson.D{}); got != tc.want {
+				t.Errorf("got %s\nwant %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// errMemoryTestAny expects an error of any kind
+var errMemoryTestAny = errors.New("any error")
+
+func TestMemoryStoreUpsert(t *testing.T) {
+	_, coll := newMemoryTestCollection(t)
+	filter := bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: "ann"}, {Key: "age", Value: bson.D{{Key: "$gt", Value: 1}}}}
+	result, err := coll.UpdateOne(context.Background(), filter, bson.D{{Key: "$inc", Value: bson.D{{Key: "visits", Value: 1}}}}, options.UpdateOne().SetUpsert(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.UpsertedCount != 1 {
+		t.Errorf("got %d upserted documents, want 1", result.UpsertedCount)
+	}
+	if got, want := memoryTestJSON(t, coll, bson.D{}), `{"_id":1,"name":"ann","visits":1}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestMemoryStoreFindOptions(t *testing.T) {
+	cases := []struct {
+		name    string
+		opts    *options.FindOptionsBuilder
+		want    string
+		wantErr error
+	}{
+		{
+			name: "sort descending",
+			opts: options.Find().SetSort(bson.D{{Key: "age", Value: -1}}).SetProjection(bson.D{{Key: "age", Value: 1}}),
+			want: `{"_id":3,"age":40}` + "\n" + `{"_id":1,"age":30}` + "\n" + `{"_id":2,"age":20}`,
+		},
+		{
+			name: "sort by a nested field, missing values first",
+			opts: options.Find().SetSort(bson.D{{Key: "address.city", Value: 1}}).SetProjection(bson.D{{Key: "_id", Value: 1}}),
+			want: `{"_id":2}` + "\n" + `{"_id":3}` + "\n" + `{"_id":1}`,
+		},
+		{
+			name: "skip and limit",
+			opts: options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetSkip(1).SetLimit(1).SetProjection(bson.D{{Key: "name", Value: 1}}),
+			want: `{"_id":2,"name":"bob"}`,
+		},
+		{
+			name: "skip past the end",
+			opts: options.Find().SetSkip(5),
+			want: ``,
+		},
+		{
+			name: "exclusion projection",
+			opts: options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(1).SetProjection(bson.D{{Key: "_id", Value: 0}, {Key: "tags", Value: 0}, {Key: "address", Value: 0}}),
+			want: `{"name":"ann","age":30}`,
+		},
+		{
+			name:    "unsupported sort direction",
+			opts:    options.Find().SetSort(bson.D{{Key: "name", Value: "text"}}),
+			wantErr: ErrNotSupported,
+		},
+		{
+			name:    "unsupported nested projection",
+			opts:    options.Find().SetProjection(bson.D{{Key: "address.city", Value: 1}}),
+			wantErr: ErrNotSupported,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, coll := newMemoryTestCollection(t, memoryTestPeople...)
+			if tc.wantErr != nil {
+				if _, err := coll.Find(context.Background(), bson.D{}, tc.opts); !errors.Is(err, tc.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+
+			if got := memoryTestJSON(t, coll, bson.D{}, tc.opts); got != tc.want {
+				t.Errorf("got %s\nwant %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreTransaction(t *testing.T) {
+	errFailed := errors.New("failed")
+	ann := bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: "ann"}}
+	bob := bson.D{{Key: "_id", Value: 2}, {Key: "name", Value: "bob"}}
+	setName := func(name string) bson.D {
+		return bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: name}}}}
+	}
+
+	cases := []struct {
+		name string
+		fn   func(ctx context.Context, store *MemoryStore, coll Collection) error
+		want string
+	}{
+		{
+			name: "committed writes are kept",
+			fn: func(ctx context.Context, store *MemoryStore, coll Collection) error {
+				_, err := coll.InsertOne(ctx, bob)
+				return err
+			},
+			want: `{"_id":1,"name":"ann"}` + "\n" + `{"_id":2,"name":"bob"}`,
+		},
+		{
+			name: "inserts are undone",
+			fn: func(ctx context.Context, store *MemoryStore, coll Collection) error {
+				if _, err := coll.InsertOne(ctx, bob); err != nil {
+					return err
+				}
+				return errFailed
+			},
+			want: `{"_id":1,"name":"ann"}`,
+		},
+		{
+			name: "updates and deletes are undone",
+			fn: func(ctx context.Context, store *MemoryStore, coll Collection) error {
+				if _, err := coll.UpdateOne(ctx, bson.D{{Key: "_id", Value: 1}}, setName("amy")); err != nil {
+					return err
+				}
+				if _, err := coll.UpdateOne(ctx, bson.D{{Key: "_id", Value: 1}}, setName("eve")); err != nil {
+					return err
+				}
+				if _, err := coll.DeleteOne(ctx, bson.D{{Key: "_id", Value: 1}}); err != nil {
+					return err
+				}
+				return errFailed
+			},
+			want: `{"_id":1,"name":"ann"}`,
+		},
+		{
+			name: "upserts are undone",
+			fn: func(ctx context.Context, store *MemoryStore, coll Collection) error {
+				if _, err := coll.UpdateOne(ctx, bson.D{{Key: "_id", Value: 3}}, setName("cat"), options.UpdateOne().SetUpsert(true)); err != nil {
+					return err
+				}
+				return errFailed
+			},
+			want: `{"_id":1,"name":"ann"}`,
+		},
+		{
+			name: "writes made outside of the transaction are kept",
+			fn: func(ctx context.Context, store *MemoryStore, coll Collection) error {
+				if _, err := coll.UpdateOne(ctx, bson.D{{Key: "_id", Value: 1}}, setName("amy")); err != nil {
+					return err
+				}
+
+				// Written concurrently without the context of the transaction
+				done := make(chan error)
+				go func() {
+					_, err := coll.InsertOne(context.Background(), bob)
+					done <- err
+				}()
+				if err := <-done; err != nil {
+					return err
+				}
+				return errFailed
+			},
+			want: `{"_id":1,"name":"ann"}` + "\n" + `{"_id":2,"name":"bob"}`,
+		},
+		{
+			name: "nested transactions are undone with the outer one",
+			fn: func(ctx context.Context, store *MemoryStore, coll Collection) error {
+				err := store.Transaction(ctx, nil, func(ctx context.Context) error {
+					_, err := coll.InsertOne(ctx, bob)
+					return err
+				})
+				if err != nil {
+					return err
+				}
+				return errFailed
+			},
+			want: `{"_id":1,"name":"ann"}`,
+		},
+		{
+			name: "writes of other collections are undone",
+			fn: func(ctx context.Context, store *MemoryStore, coll Collection) error {
+				if _, err := store.Collection("teams").InsertOne(ctx, bob); err != nil {
+					return err
+				}
+				return errFailed
+			},
+			want: `{"_id":1,"name":"ann"}`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			store, coll := newMemoryTestCollection(t, ann)
+			err := store.Transaction(context.Background(), nil, func(ctx context.Context) error {
+				return tc.fn(ctx, store, coll)
+			})
+			if err != nil && !errors.Is(err, errFailed) {
+				t.Fatal(err)
+			}
+
+			if got := memoryTestJSON(t, coll, bson.D{}); got != tc.want {
+				t.Errorf("got %s\nwant %s", got, tc.want)
+			}
+			if got := memoryTestJSON(t, store.Collection("teams"), bson.D{}); got != "" {
+				t.Errorf("got teams %s, want none", got)
+			}
+		})
+	}
+}
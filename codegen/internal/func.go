@@ -10,6 +10,7 @@ type Func struct {
 	SourceFile string
 	InputAST   *ast.FuncDecl
 	FileSet    *token.FileSet
+	Source     string // Rendered by a template, written as is
 
 	Name      string
 	Generated bool
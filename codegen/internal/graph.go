@@ -0,0 +1,270 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Cardinalities of the reference edges
+const (
+	CardinalityOne  = "one"
+	CardinalityMany = "many"
+	CardinalityMap  = "map"
+)
+
+// Graph formats
+const (
+	GraphFormatMermaid = "mermaid"
+	GraphFormatDOT     = "dot"
+)
+
+// GraphEntity is a collection with its top level fields
+type GraphEntity struct {
+	Collection string
+	Fields     []*GraphField
+}
+
+// GraphField is a top level field with its BSON type
+type GraphField struct {
+	Name        string
+	Type        string
+	IsID        bool
+	IsReference bool
+}
+
+// GraphEdge is a reference from the documents of a collection to another collection
+type GraphEdge struct {
+	From        string
+	To          string
+	Path        string // Dotted document path of the reference
+	Cardinality string
+	Optional    bool // Whether references of cardinality one may be missing
+}
+
+// Graph holds the entities and edges of the collections of packages
+type Graph struct {
+	Entities []*GraphEntity
+	Edges    []*GraphEdge
+}
+
+// AddPackage adds the collections of the package and their references to the graph
+func (g *Graph) AddPackage(p *Package) {
+	for _, schema := range p.CollectionSchemas() {
+		s := schema.Struct
+		edges := s.graphEdges()
+		references := map[string]bool{}
+		for _, edge := range edges {
+			references[strings.Split(edge.Path, ".")[0]] = true
+		}
+
+		entity := &GraphEntity{Collection: schema.Collection}
+		for name, node := range schema.node.properties {
+			entity.Fields = append(entity.Fields, &GraphField{Name: name, Type: graphFieldType(node), IsID: name == "_id", IsReference: references[name]})
+		}
+		sort.Slice(entity.Fields, func(i, j int) bool {
+			if entity.Fields[i].IsID != entity.Fields[j].IsID {
+				return entity.Fields[i].IsID
+			}
+			return entity.Fields[i].Name < entity.Fields[j].Name
+		})
+
+		g.Entities = append(g.Entities, entity)
+		g.Edges = append(g.Edges, edges...)
+	}
+
+	sort.Slice(g.Entities, func(i, j int) bool { return g.Entities[i].Collection < g.Entities[j].Collection })
+	sort.SliceStable(g.Edges, func(i, j int) bool {
+		if g.Edges[i].From != g.Edges[j].From {
+			return g.Edges[i].From < g.Edges[j].From
+		}
+		return g.Edges[i].Path < g.Edges[j].Path
+	})
+}
+
+// graphEdges returns the references of the collection struct, nested references and
+// collections passed to generic structs included
+func (s *Struct) graphEdges() []*GraphEdge {
+	edges := []*GraphEdge{}
+	for _, path := range s.ReferencePaths {
+		edge := &GraphEdge{From: s.collectionName(), To: path.Field().ReferencedStruct.collectionName(), Path: path.BSONPath()}
+		edge.setCardinality(path.Fields...)
+		edges = append(edges, edge)
+	}
+
+	for _, field := range s.Fields {
+		if field.IsResolvable || field.BSONName() == "" || !hasGenericReference(s.Parent, field.OwnType) {
+			continue
+		}
+
+		// Generic structs may be held in slices and maps, e.g. []Pair[string, Model]
+		elem := referenceElem(field.OwnType)
+		genericStruct := s.Parent.lookupStruct(elem)
+		for _, ref := range s.Parent.genericReferences(elem) {
+			key := strings.ToLower(ref.field.Name())
+			if genericStruct != nil {
+				for _, genericField := range genericStruct.Fields {
+					if genericField.Name == ref.field.Name() {
+						key = genericField.BSONName()
+					}
+				}
+			}
+
+			edge := &GraphEdge{From: s.collectionName(), To: ref.referencedStruct.collectionName(), Path: field.BSONName() + "." + key}
+			edge.setCardinality(field, &Field{OwnType: ref.field.Type(), IsPointer: isPointer(ref.field.Type())})
+			edges = append(edges, edge)
+		}
+	}
+	return edges
+}
+
+// setCardinality derives the cardinality from the fields leading to the reference, references held
+// in maps are of cardinality map and those held in slices at any level of cardinality many
+func (e *GraphEdge) setCardinality(fields ...*Field) {
+	e.Cardinality = CardinalityOne
+	for i, f := range fields {
+		shape := referenceShape(f.OwnType)
+		switch {
+		case i == len(fields)-1 && strings.Contains(shape, "m"):
+			e.Cardinality = CardinalityMap
+		case shape != "" && e.Cardinality == CardinalityOne:
+			e.Cardinality = CardinalityMany
+		}
+		e.Optional = e.Optional || f.IsPointer
+	}
+}
+
+// graphFieldType renders the BSON type of a field, e.g. objectId or string[]
+func graphFieldType(node *schemaNode) string {
+	switch {
+	case node.items != nil:
+		return graphFieldType(node.items) + "[]"
+	case node.values != nil:
+		return "map"
+	case len(node.bsonTypes) > 0:
+		return node.bsonTypes[0]
+	default:
+		return "any"
+	}
+}
+
+// Write renders the graph in the format, mermaid or dot
+func (g *Graph) Write(w io.Writer, format string) error {
+	switch format {
+	case GraphFormatMermaid:
+		return g.writeMermaid(w)
+	case GraphFormatDOT:
+		return g.writeDOT(w)
+	default:
+		return fmt.Errorf("unknown graph format %q, expected mermaid or dot", format)
+	}
+}
+
+// mermaidCardinalities are the right hand side markers of the referenced collection
+var mermaidCardinalities = map[string]string{
+	CardinalityOne:  "||",
+	CardinalityMany: "o{",
+	CardinalityMap:  "o{",
+}
+
+func (g *Graph) writeMermaid(w io.Writer) error {
+	b := &strings.Builder{}
+	fmt.Fprintln(b, "erDiagram")
+	for _, entity := range g.Entities {
+		fmt.Fprintf(b, "    %s {\n", mermaidEntityName(entity.Collection))
+		for _, field := range entity.Fields {
+			key := ""
+			switch {
+			case field.IsID:
+				key = " PK"
+			case field.IsReference:
+				key = " FK"
+			}
+			fmt.Fprintf(b, "        %s %s%s\n", mermaidAttributeName(field.Type), mermaidAttributeName(field.Name), key)
+		}
+		fmt.Fprintln(b, "    }")
+	}
+
+	for _, edge := range g.Edges {
+		marker := mermaidCardinalities[edge.Cardinality]
+		if edge.Optional && edge.Cardinality == CardinalityOne {
+			marker = "o|"
+		}
+		fmt.Fprintf(b, "    %s }o--%s %s : \"%s\"\n", mermaidEntityName(edge.From), marker, mermaidEntityName(edge.To), mermaidEscape(edge.label()))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var (
+	mermaidIdentifierRegex       = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	mermaidAttributeInvalidRegex = regexp.MustCompile(`[^A-Za-z0-9_\-\[\]()]`)
+)
+
+// mermaidEntityName quotes collection names Mermaid cannot parse as identifiers, e.g. audit.log-entries
+func mermaidEntityName(name string) string {
+	if mermaidIdentifierRegex.MatchString(name) {
+		return name
+	}
+	return `"` + mermaidEscape(name) + `"`
+}
+
+// mermaidAttributeName replaces the characters Mermaid does not accept in attribute names and types
+func mermaidAttributeName(name string) string {
+	return mermaidAttributeInvalidRegex.ReplaceAllString(name, "_")
+}
+
+// mermaidEscape escapes double quotes of quoted text with their entity code
+func mermaidEscape(s string) string {
+	return strings.ReplaceAll(s, `"`, "#quot;")
+}
+
+// dotArrowheads mark the cardinality at the referenced collection as crow's foot notation does
+var dotArrowheads = map[string]string{
+	CardinalityOne:  "tee",
+	CardinalityMany: "crow",
+	CardinalityMap:  "crow",
+}
+
+func (g *Graph) writeDOT(w io.Writer) error {
+	b := &strings.Builder{}
+	fmt.Fprintln(b, "digraph collections {")
+	fmt.Fprintln(b, "\trankdir=LR;")
+	fmt.Fprintln(b, "\tnode [shape=record];")
+	for _, entity := range g.Entities {
+		fields := make([]string, len(entity.Fields))
+		for i, field := range entity.Fields {
+			fields[i] = dotRecordEscape(field.Name+": "+field.Type) + `\l`
+		}
+		fmt.Fprintf(b, "\t%q [label=\"{%s|%s}\"];\n", entity.Collection, dotRecordEscape(entity.Collection), strings.Join(fields, ""))
+	}
+
+	for _, edge := range g.Edges {
+		arrowhead := dotArrowheads[edge.Cardinality]
+		if edge.Optional && edge.Cardinality == CardinalityOne {
+			arrowhead = "teeodot"
+		}
+		fmt.Fprintf(b, "\t%q -> %q [label=%q, arrowhead=%s];\n", edge.From, edge.To, edge.label(), arrowhead)
+	}
+	fmt.Fprintln(b, "}")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// label renders the path and cardinality of the edge, e.g. referenceMap (map)
+func (e *GraphEdge) label() string {
+	cardinality := e.Cardinality
+	if e.Optional && cardinality == CardinalityOne {
+		cardinality = "optional " + cardinality
+	}
+	return fmt.Sprintf("%s (%s)", e.Path, cardinality)
+}
+
+// dotRecordEscape escapes the characters delimiting the fields of record labels
+func dotRecordEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "{", `\{`, "}", `\}`, "|", `\|`, "<", `\<`, ">", `\>`).Replace(s)
+}
@@ -0,0 +1,322 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonoans/mongo-gen/config"
+)
+
+// MigrationsFilename is the registry of the migrations written into the migrations package
+const MigrationsFilename = "codegen_migrations.go"
+
+// migrationFilenameRegex matches migration files, e.g. 20261019153000_add_email_index.go
+var migrationFilenameRegex = regexp.MustCompile(`^(\d{14})_([a-z0-9_]+)\.go$`)
+
+// MigrationFile is a migration of the migrations package with its up and down functions
+type MigrationFile struct {
+	Version string
+	Name    string
+	Up      string
+	Down    string // Empty for irreversible migrations
+}
+
+// MigrationsTemplateData is passed to the migrations registry template
+type MigrationsTemplateData struct {
+	OutputPackageName string
+	OutputImportPath  string
+	Migrations        []*MigrationFile
+	Main              bool // Whether the package lacks a main function of its own
+}
+
+// MigrationTemplateData is passed to the template of new migration files
+type MigrationTemplateData struct {
+	Version string
+	Name    string
+	Changes []*CollectionChange // Scaffolded by migrate diff
+}
+
+// CollectionChange lists the fields of a collection which changed since the schema snapshot
+type CollectionChange struct {
+	Collection string
+	Dropped    bool // The collection is no longer generated
+	Renamed    []*FieldRename
+	Removed    []string
+	Added      []string
+}
+
+// FieldRename is a removed field paired with an added field of the same schema under the same parent
+type FieldRename struct {
+	From string
+	To   string
+}
+
+// NewMigrationVersion returns the version of a migration created at t
+func NewMigrationVersion(t time.Time) string {
+	return t.UTC().Format("20060102150405")
+}
+
+// WriteMigrationFile writes a new migration into dir as [VERSION]_[NAME].go, the name is converted to snake case
+func WriteMigrationFile(dir string, data *MigrationTemplateData) (string, error) {
+	data.Name = applyNamingCase(data.Name, config.CaseSnake, "")
+	filename := data.Version + "_" + data.Name + ".go"
+	if !migrationFilenameRegex.MatchString(filename) {
+		return "", fmt.Errorf("invalid migration name %q, use letters, digits and underscores", data.Name)
+	}
+
+	if err := os.MkdirAll(dir, os.ModeDir|os.ModePerm); err != nil {
+		return "", err
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	if err := GetTemplate("migration").Execute(buffer, data); err != nil {
+		return "", err
+	}
+
+	(&PackageFile{Filename: filename}).writeBufferToFile(dir, buffer)
+	return filepath.Join(dir, filename), nil
+}
+
+// WriteMigrationsRegistry lists the migration files of cfg in the registry of the migrations package
+func WriteMigrationsRegistry(cfg *config.ConfigFile) error {
+	migrations, hasMain, err := readMigrationFiles(cfg.Migrations.PackagePath)
+	if err != nil {
+		return err
+	}
+
+	output := cfg.Target(cfg.Migrations.Target).Output
+	data := &MigrationsTemplateData{
+		OutputPackageName: output.PackageName,
+		OutputImportPath:  output.ImportPath,
+		Migrations:        migrations,
+		Main:              !hasMain,
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	if err := GetTemplate("migrations").Execute(buffer, data); err != nil {
+		return err
+	}
+
+	(&PackageFile{Filename: MigrationsFilename}).writeBufferToFile(cfg.Migrations.PackagePath, buffer)
+	return nil
+}
+
+// readMigrationFiles returns the migrations of dir sorted by version
+// and whether another file of the package declares main
+func readMigrationFiles(dir string) ([]*MigrationFile, bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, false, err
+	}
+
+	migrations, hasMain, versions := []*MigrationFile{}, false, map[string]string{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".go" || entry.Name() == MigrationsFilename || strings.HasSuffix(entry.Name(), "_test.go") {
+			continue
+		}
+
+		file, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dir, entry.Name()), nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, false, err
+		}
+
+		funcs := map[string]bool{}
+		for _, decl := range file.Decls {
+			if decl, ok := decl.(*ast.FuncDecl); ok && decl.Recv == nil {
+				funcs[decl.Name.Name] = true
+			}
+		}
+		hasMain = hasMain || funcs["main"]
+
+		matches := migrationFilenameRegex.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+
+		version, name := matches[1], matches[2]
+		if other, ok := versions[version]; ok {
+			return nil, false, fmt.Errorf("migrations %s and %s have the same version", other, entry.Name())
+		}
+		versions[version] = entry.Name()
+
+		migration := &MigrationFile{Version: version, Name: name, Up: "up" + version}
+		if !funcs[migration.Up] {
+			return nil, false, fmt.Errorf("migration %s does not declare %s", entry.Name(), migration.Up)
+		}
+		if funcs["down"+version] {
+			migration.Down = "down" + version
+		}
+		migrations = append(migrations, migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, hasMain, nil
+}
+
+// ReadSchemaSnapshot returns the validators registered by the codegen_validators.go
+// file of the output package, the schemas of the collections when last generated
+func ReadSchemaSnapshot(packagePath string) (map[string]string, error) {
+	filename := filepath.Join(packagePath, ValidatorsFilename)
+	file, err := parser.ParseFile(token.NewFileSet(), filename, nil, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+
+	validators := map[string]string{}
+	var readErr error
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || astObjectToString(call.Fun) != "registerValidator" || len(call.Args) != 2 {
+			return true
+		}
+
+		collection, collectionOk := call.Args[0].(*ast.BasicLit)
+		schema, schemaOk := call.Args[1].(*ast.BasicLit)
+		if !collectionOk || !schemaOk {
+			return true
+		}
+
+		collectionName, err1 := strconv.Unquote(collection.Value)
+		validator, err2 := strconv.Unquote(schema.Value)
+		readErr = errors.Join(readErr, err1, err2)
+		validators[collectionName] = validator
+		return false
+	})
+	return validators, readErr
+}
+
+// SchemaChanges compares the validators of a snapshot with the current schemas, fields are compared
+// through subdocuments but not through arrays as $rename and $unset do not apply to their elements
+func SchemaChanges(snapshot map[string]string, schemas []*CollectionSchema) ([]*CollectionChange, error) {
+	current := map[string]string{}
+	for _, schema := range schemas {
+		current[schema.Collection] = schema.Validator()
+	}
+
+	collections := []string{}
+	for collection := range snapshot {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+
+	changes := []*CollectionChange{}
+	for _, collection := range collections {
+		validator, ok := current[collection]
+		if !ok {
+			changes = append(changes, &CollectionChange{Collection: collection, Dropped: true})
+			continue
+		}
+
+		previousFields, err := schemaFieldPaths(snapshot[collection])
+		if err != nil {
+			return nil, fmt.Errorf("schema snapshot of %s: %w", collection, err)
+		}
+		currentFields, err := schemaFieldPaths(validator)
+		if err != nil {
+			return nil, err
+		}
+
+		if change := fieldChanges(collection, previousFields, currentFields); change != nil {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+// fieldChanges pairs the removed and added fields of a collection into renames,
+// nil is returned when the fields did not change
+func fieldChanges(collection string, previous, current map[string]string) *CollectionChange {
+	removed, added := changedFieldPaths(previous, current), changedFieldPaths(current, previous)
+	if len(removed) == 0 && len(added) == 0 {
+		return nil
+	}
+
+	change := &CollectionChange{Collection: collection}
+	paired := map[string]bool{}
+	for _, from := range removed {
+		candidates := []string{}
+		for _, to := range added {
+			if !paired[to] && parentPath(from) == parentPath(to) && previous[from] == current[to] {
+				candidates = append(candidates, to)
+			}
+		}
+
+		// Renames are only guessed when a single added field matches
+		if len(candidates) == 1 {
+			paired[candidates[0]] = true
+			change.Renamed = append(change.Renamed, &FieldRename{From: from, To: candidates[0]})
+			continue
+		}
+		change.Removed = append(change.Removed, from)
+	}
+
+	for _, to := range added {
+		if !paired[to] {
+			change.Added = append(change.Added, to)
+		}
+	}
+	return change
+}
+
+// changedFieldPaths returns the paths of fields missing from other, fields of missing subdocuments are omitted
+func changedFieldPaths(fields, other map[string]string) []string {
+	paths := []string{}
+	for path := range fields {
+		if _, ok := other[path]; ok {
+			continue
+		}
+		if parent := parentPath(path); parent != "" {
+			if _, ok := other[parent]; !ok {
+				continue
+			}
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func parentPath(path string) string {
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+// schemaFieldPaths returns the schema of each field of a validator by dotted path
+func schemaFieldPaths(validator string) (map[string]string, error) {
+	schema := map[string]any{}
+	if err := json.Unmarshal([]byte(validator), &schema); err != nil {
+		return nil, err
+	}
+
+	paths := map[string]string{}
+	collectSchemaFieldPaths(paths, "", schema)
+	return paths, nil
+}
+
+func collectSchemaFieldPaths(paths map[string]string, prefix string, schema map[string]any) {
+	properties, _ := schema["properties"].(map[string]any)
+	for name, property := range properties {
+		propertySchema, ok := property.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		path := prefix + name
+		paths[path] = marshalSchema(propertySchema)
+		collectSchemaFieldPaths(paths, path+".", propertySchema)
+	}
+}
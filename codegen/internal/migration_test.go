@@ -0,0 +1,261 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testSchemaNode returns an object node of the properties
+func testSchemaNode(properties map[string]*schemaNode) *schemaNode {
+	return &schemaNode{bsonTypes: []string{"object"}, properties: properties}
+}
+
+func testStringNode() *schemaNode {
+	return &schemaNode{bsonTypes: []string{"string"}}
+}
+
+func testIntNode() *schemaNode {
+	return &schemaNode{bsonTypes: []string{"int"}}
+}
+
+func testCollectionSchema(collection string, properties map[string]*schemaNode) *CollectionSchema {
+	return &CollectionSchema{Struct: &Struct{Name: collection}, Collection: collection, node: testSchemaNode(properties)}
+}
+
+func TestSchemaChanges(t *testing.T) {
+	cases := []struct {
+		name     string
+		previous []*CollectionSchema
+		current  []*CollectionSchema
+		want     []*CollectionChange
+	}{
+		{
+			name:     "unchanged",
+			previous: []*CollectionSchema{testCollectionSchema("users", map[string]*schemaNode{"name": testStringNode()})},
+			current:  []*CollectionSchema{testCollectionSchema("users", map[string]*schemaNode{"name": testStringNode()})},
+			want:     []*CollectionChange{},
+		},
+		{
+			name:     "rename of a field of the same schema",
+			previous: []*CollectionSchema{testCollectionSchema("users", map[string]*schemaNode{"name": testStringNode(), "age": testIntNode()})},
+			current:  []*CollectionSchema{testCollectionSchema("users", map[string]*schemaNode{"fullName": testStringNode(), "age": testIntNode()})},
+			want:     []*CollectionChange{{Collection: "users", Renamed: []*FieldRename{{From: "name", To: "fullName"}}}},
+		},
+		{
+			name:     "fields of another schema are not renamed",
+			previous: []*CollectionSchema{testCollectionSchema("users", map[string]*schemaNode{"name": testStringNode()})},
+			current:  []*CollectionSchema{testCollectionSchema("users", map[string]*schemaNode{"count": testIntNode()})},
+			want:     []*CollectionChange{{Collection: "users", Removed: []string{"name"}, Added: []string{"count"}}},
+		},
+		{
+			name:     "ambiguous candidates are not renamed",
+			previous: []*CollectionSchema{testCollectionSchema("users", map[string]*schemaNode{"name": testStringNode()})},
+			current:  []*CollectionSchema{testCollectionSchema("users", map[string]*schemaNode{"first": testStringNode(), "last": testStringNode()})},
+			want:     []*CollectionChange{{Collection: "users", Removed: []string{"name"}, Added: []string{"first", "last"}}},
+		},
+		{
+			name:     "a candidate is paired once",
+			previous: []*CollectionSchema{testCollectionSchema("users", map[string]*schemaNode{"a": testStringNode(), "b": testStringNode()})},
+			current:  []*CollectionSchema{testCollectionSchema("users", map[string]*schemaNode{"c": testStringNode()})},
+			want:     []*CollectionChange{{Collection: "users", Renamed: []*FieldRename{{From: "a", To: "c"}}, Removed: []string{"b"}}},
+		},
+		{
+			name:     "dropped collection",
+			previous: []*CollectionSchema{testCollectionSchema("teams", map[string]*schemaNode{"title": testStringNode()}), testCollectionSchema("users", nil)},
+			current:  []*CollectionSchema{testCollectionSchema("users", nil)},
+			want:     []*CollectionChange{{Collection: "teams", Dropped: true}},
+		},
+		{
+			name:     "new collections are not listed",
+			previous: []*CollectionSchema{},
+			current:  []*CollectionSchema{testCollectionSchema("users", map[string]*schemaNode{"name": testStringNode()})},
+			want:     []*CollectionChange{},
+		},
+		{
+			name: "rename within a subdocument",
+			previous: []*CollectionSchema{testCollectionSchema("users", map[string]*schemaNode{
+				"address": testSchemaNode(map[string]*schemaNode{"city": testStringNode(), "zip": testIntNode()}),
+			})},
+			current: []*CollectionSchema{testCollectionSchema("users", map[string]*schemaNode{
+				"address": testSchemaNode(map[string]*schemaNode{"town": testStringNode(), "zip": testIntNode()}),
+			})},
+			want: []*CollectionChange{{Collection: "users", Renamed: []*FieldRename{{From: "address.city", To: "address.town"}}}},
+		},
+		{
+			name: "fields are not renamed across subdocuments",
+			previous: []*CollectionSchema{testCollectionSchema("users", map[string]*schemaNode{
+				"home": testSchemaNode(map[string]*schemaNode{"city": testStringNode()}),
+				"work": testSchemaNode(map[string]*schemaNode{}),
+			})},
+			current: []*CollectionSchema{testCollectionSchema("users", map[string]*schemaNode{
+				"home": testSchemaNode(map[string]*schemaNode{}),
+				"work": testSchemaNode(map[string]*schemaNode{"city": testStringNode()}),
+			})},
+			want: []*CollectionChange{{Collection: "users", Removed: []string{"home.city"}, Added: []string{"work.city"}}},
+		},
+		{
+			name: "renamed subdocument, its fields are omitted",
+			previous: []*CollectionSchema{testCollectionSchema("users", map[string]*schemaNode{
+				"address": testSchemaNode(map[string]*schemaNode{"city": testStringNode()}),
+			})},
+			current: []*CollectionSchema{testCollectionSchema("users", map[string]*schemaNode{
+				"location": testSchemaNode(map[string]*schemaNode{"city": testStringNode()}),
+			})},
+			want: []*CollectionChange{{Collection: "users", Renamed: []*FieldRename{{From: "address", To: "location"}}}},
+		},
+		{
+			name: "fields of array elements are not compared",
+			previous: []*CollectionSchema{testCollectionSchema("users", map[string]*schemaNode{
+				"emails": {bsonTypes: []string{"array"}, items: testSchemaNode(map[string]*schemaNode{"address": testStringNode()})},
+			})},
+			current: []*CollectionSchema{testCollectionSchema("users", map[string]*schemaNode{
+				"emails": {bsonTypes: []string{"array"}, items: testSchemaNode(map[string]*schemaNode{"value": testStringNode()})},
+			})},
+			want: []*CollectionChange{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			snapshot := map[string]string{}
+			for _, schema := range tc.previous {
+				snapshot[schema.Collection] = schema.Validator()
+			}
+
+			changes, err := SchemaChanges(snapshot, tc.current)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(changes, tc.want) {
+				t.Errorf("got %s, want %s", formatCollectionChanges(changes), formatCollectionChanges(tc.want))
+			}
+		})
+	}
+}
+
+func TestSchemaChangesInvalidSnapshot(t *testing.T) {
+	snapshot := map[string]string{"users": "{"}
+	current := []*CollectionSchema{testCollectionSchema("users", nil)}
+	if _, err := SchemaChanges(snapshot, current); err == nil {
+		t.Error("got no error for an invalid snapshot")
+	}
+}
+
+func TestChangedFieldPaths(t *testing.T) {
+	cases := []struct {
+		name   string
+		fields map[string]string
+		other  map[string]string
+		want   []string
+	}{
+		{"none", map[string]string{"a": "s"}, map[string]string{"a": "s"}, []string{}},
+		{"sorted", map[string]string{"b": "s", "a": "s", "c": "s"}, map[string]string{"c": "s"}, []string{"a", "b"}},
+		{"fields of missing subdocuments are omitted", map[string]string{"a": "o", "a.b": "s"}, map[string]string{}, []string{"a"}},
+		{"fields of kept subdocuments", map[string]string{"a": "o", "a.b": "s"}, map[string]string{"a": "o"}, []string{"a.b"}},
+		{"schema changes are not path changes", map[string]string{"a": "s"}, map[string]string{"a": "i"}, []string{}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := changedFieldPaths(tc.fields, tc.other); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func formatCollectionChanges(changes []*CollectionChange) string {
+	lines := []string{}
+	for _, c := range changes {
+		renames := []string{}
+		for _, r := range c.Renamed {
+			renames = append(renames, r.From+"->"+r.To)
+		}
+		lines = append(lines, fmt.Sprintf("%s dropped=%t renamed=%v removed=%v added=%v", c.Collection, c.Dropped, renames, c.Removed, c.Added))
+	}
+	return "[" + strings.Join(lines, "; ") + "]"
+}
+
+func TestMigrationsRegistry(t *testing.T) {
+	ReadAllTemplateFiles("")
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"20261019153000_add_email_index.go": "package main\n\nfunc up20261019153000() {}\n\nfunc down20261019153000() {}\n",
+		"20260101000000_rename_name.go":     "package main\n\nfunc up20260101000000() {}\n",
+		"helpers.go":                        "package main\n\nfunc helper() {}\n",
+		"20260101000000_helpers_test.go":    "package main\n",
+		MigrationsFilename:                  "package main\n\nfunc main() {}\n",
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	migrations, hasMain, err := readMigrationFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	data := &MigrationsTemplateData{OutputPackageName: "models", OutputImportPath: "example.com/app/models", Migrations: migrations, Main: !hasMain}
+	if err := GetTemplate("migrations").Execute(buffer, data); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `// migrations lists the migration files of the package in version order, run by ` + "`mongo-gen migrate`" + `
+var migrations = []models.Migration{
+	{Version: "20260101000000", Name: "rename_name", Up: up20260101000000},
+	{Version: "20261019153000", Name: "add_email_index", Up: up20261019153000, Down: down20261019153000},
+}`
+	if got := buffer.String(); !strings.Contains(got, want) {
+		t.Errorf("got %s, want it to contain %s", got, want)
+	}
+	if !strings.Contains(buffer.String(), "func main() {") {
+		t.Error("got no main function, the registry itself does not declare main")
+	}
+}
+
+func TestMigrationsRegistryErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{
+			name:  "missing up function",
+			files: map[string]string{"20261019153000_add_index.go": "package main\n\nfunc down20261019153000() {}\n"},
+			want:  "does not declare up20261019153000",
+		},
+		{
+			name: "duplicate versions",
+			files: map[string]string{
+				"20261019153000_a.go": "package main\n\nfunc up20261019153000() {}\n",
+				"20261019153000_b.go": "package main\n",
+			},
+			want: "have the same version",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, contents := range tc.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, _, err := readMigrationFiles(dir); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got error %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
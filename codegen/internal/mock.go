@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	"github.com/jonoans/mongo-gen/config"
+	"github.com/jonoans/mongo-gen/utils"
+)
+
+// MocksFilename is the file of the mocks generated when output.mocks is set
+const MocksFilename = "codegen_mock.go"
+
+// MockTemplateData is passed to the mock template
+type MockTemplateData struct {
+	PackageName string
+	Structs     []*Struct // Collection structs
+}
+
+// IsCodegenFile reports whether filename is written whole by the generator,
+// such files are never read back from the output package
+func IsCodegenFile(filename string) bool {
+	return filename == "codegen_.go" || filename == MocksFilename || filename == ValidatorsFilename
+}
+
+// WriteMocks writes the mocks of the collection structs, the mocks of
+// a previous run are removed when they are no longer enabled
+func (p *Package) WriteMocks(cfg *config.OutputConfig) {
+	outputFilepath := filepath.Join(cfg.PackagePath, MocksFilename)
+	if !cfg.Mocks {
+		if err := os.Remove(outputFilepath); err != nil && !os.IsNotExist(err) {
+			utils.Fatal("Could not remove mocks file", "error", err)
+		}
+		return
+	}
+
+	data := &MockTemplateData{PackageName: cfg.PackageName}
+	for _, s := range p.sortedStructs() {
+		if s.IsCollection {
+			data.Structs = append(data.Structs, s)
+		}
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	if err := GetTemplate("mock").Execute(buffer, data); err != nil {
+		utils.Fatal("Could not write mocks", "error", err)
+	}
+
+	(&PackageFile{Filename: MocksFilename}).writeBufferToFile(cfg.PackagePath, buffer)
+}
@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/iancoleman/strcase"
+	"github.com/jonoans/mongo-gen/config"
+)
+
+// irregularPlurals maps lower case singular words to their plural
+var irregularPlurals = map[string]string{
+	"child":   "children",
+	"datum":   "data",
+	"foot":    "feet",
+	"goose":   "geese",
+	"man":     "men",
+	"mouse":   "mice",
+	"news":    "news",
+	"person":  "people",
+	"series":  "series",
+	"species": "species",
+	"tooth":   "teeth",
+	"woman":   "women",
+}
+
+// pluralise returns the English plural of name, only the last word of camel case names is pluralised
+func pluralise(name string) string {
+	return pluraliseWith(name, nil)
+}
+
+// pluraliseWith pluralises name, irregular plurals take precedence over the built-in ones
+func pluraliseWith(name string, irregular map[string]string) string {
+	if name == "" {
+		return name
+	}
+
+	start := lastWordStart(name)
+	prefix, word := name[:start], name[start:]
+	lower := strings.ToLower(word)
+
+	plural, ok := irregular[lower]
+	if !ok {
+		plural, ok = irregularPlurals[lower]
+	}
+	if ok {
+		if unicode.IsUpper(rune(word[0])) {
+			plural = strings.ToUpper(plural[:1]) + plural[1:]
+		}
+		return prefix + plural
+	}
+
+	switch {
+	case strings.HasSuffix(lower, "s"), strings.HasSuffix(lower, "x"), strings.HasSuffix(lower, "z"),
+		strings.HasSuffix(lower, "ch"), strings.HasSuffix(lower, "sh"):
+		return name + "es"
+	case strings.HasSuffix(lower, "y") && len(lower) > 1 && !strings.ContainsRune("aeiou", rune(lower[len(lower)-2])):
+		return name[:len(name)-1] + "ies"
+	default:
+		return name + "s"
+	}
+}
+
+// lastWordStart returns the index of the last word of a camel case name
+func lastWordStart(name string) int {
+	for i := len(name) - 1; i > 0; i-- {
+		if !unicode.IsUpper(rune(name[i])) {
+			continue
+		}
+
+		prevLower := unicode.IsLower(rune(name[i-1])) || unicode.IsDigit(rune(name[i-1]))
+		nextLower := i+1 < len(name) && unicode.IsLower(rune(name[i+1]))
+		if prevLower || nextLower {
+			return i
+		}
+	}
+	return 0
+}
+
+// applyCollectionNaming derives a collection name from a struct name
+func applyCollectionNaming(name string, naming config.CollectionNamingConfig) string {
+	if naming.Plural {
+		name = pluraliseWith(name, naming.Irregular)
+	}
+
+	return naming.Prefix + applyNamingCase(name, naming.Case, config.CaseLowerCamel) + naming.Suffix
+}
+
+// applyNamingCase converts name to the naming case, fallback is used when unset
+func applyNamingCase(name, namingCase, fallback string) string {
+	if namingCase == "" {
+		namingCase = fallback
+	}
+
+	switch namingCase {
+	case config.CaseLower:
+		return strings.ToLower(name)
+	case config.CaseSnake:
+		return strcase.ToSnake(name)
+	case config.CaseKebab:
+		return strcase.ToKebab(name)
+	default:
+		return strcase.ToLowerCamel(name)
+	}
+}
@@ -1,11 +1,18 @@
 package internal
 
 import (
+	"fmt"
 	"go/ast"
 	"go/token"
 	"go/types"
-	"log"
-
+	"log/slog"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jonoans/mongo-gen/config"
 	"github.com/jonoans/mongo-gen/utils"
 	"golang.org/x/tools/go/packages"
 )
@@ -18,6 +25,15 @@ type Package struct {
 	InputGenerated      *packages.Package
 	InputGeneratedLines map[string][]string
 
+	// Output package, models of Siblings may be referenced (key: model package path)
+	OutputPkgName string
+	OutputPkgPath string
+	Siblings      map[string]*Package
+
+	CollectionNaming config.CollectionNamingConfig
+	Tags             config.TagsConfig
+	TypeScript       *config.TypeScriptConfig // Nil when no .d.ts file is written
+
 	// Struct-related Values
 	CustomTypes   map[string]*CustomType
 	Structs       map[string]*Struct // Key: Struct name
@@ -41,7 +57,19 @@ func (p *Package) Init() {
 	p.parseUserStructs()
 	p.parseGenerated()
 	p.prepareStructs()
+}
+
+// InitReferences resolves the references between structs,
+// every sibling package must have been initialised beforehand
+func (p *Package) InitReferences() {
+	for _, s := range p.sortedStructs() {
+		if !s.Generated {
+			s.checkDuplicateBSONKeys()
+		}
+	}
 	p.prepareResolvableFields()
+	p.prepareCollectionFuncs()
+	p.prepareValidateMethods()
 }
 
 func (p *Package) GeneratePackageFiles() map[string]*PackageFile {
@@ -135,9 +163,194 @@ func (p *Package) GeneratePackageFiles() map[string]*PackageFile {
 }
 
 func (p *Package) prepareResolvableFields() {
-	for _, s := range p.Structs {
+	// Sorted as fields may add methods to the structs they reference
+	for _, s := range p.sortedStructs() {
 		s.InitResolverFieldsAndMethods()
 	}
+
+	for _, s := range p.sortedStructs() {
+		s.InitReferencePaths()
+		s.InitReferencePathMethods()
+	}
+
+	for _, s := range p.Structs {
+		s.InitDeleteActionMethods()
+	}
+}
+
+// typeSpecDoc returns the doc comment of a type, ungrouped declarations carry it on the GenDecl
+func typeSpecDoc(decl *ast.GenDecl, spec *ast.TypeSpec) *ast.CommentGroup {
+	if spec.Doc == nil && len(decl.Specs) == 1 {
+		return decl.Doc
+	}
+	return spec.Doc
+}
+
+func valueSpecDoc(decl *ast.GenDecl, spec *ast.ValueSpec) *ast.CommentGroup {
+	if spec.Doc == nil && len(decl.Specs) == 1 {
+		return decl.Doc
+	}
+	return spec.Doc
+}
+
+// lookupStruct returns the parsed struct of a type declared in a models package
+func (p *Package) lookupStruct(t types.Type) *Struct {
+	named, ok := t.(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return nil
+	}
+
+	pkg, ok := p.Siblings[named.Obj().Pkg().Path()]
+	if !ok {
+		return nil
+	}
+	return pkg.Structs[named.Obj().Name()]
+}
+
+// qualifyModelTypes points the package qualifiers of a user type expression
+// referencing other models packages to their output packages, importing them in filename
+func (p *Package) qualifyModelTypes(expr ast.Expr, typeStr, filename string) string {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+
+		x, ok := sel.X.(*ast.Ident)
+		if !ok {
+			return true
+		}
+
+		pkgName, ok := p.InputUser.TypesInfo.Uses[x].(*types.PkgName)
+		if !ok {
+			return false
+		}
+
+		other, ok := p.Siblings[pkgName.Imported().Path()]
+		if !ok || other == p {
+			return false
+		}
+
+		qualifierReg := regexp.MustCompile(`\b` + regexp.QuoteMeta(x.Name) + `\.`)
+		typeStr = qualifierReg.ReplaceAllString(typeStr, other.OutputPkgName+".")
+		p.addImport(filename, other.OutputPkgName, other.OutputPkgPath)
+		return false
+	})
+
+	return typeStr
+}
+
+// typeString renders t as written in the output package, with referencesAsIDs
+// collections passed as type arguments are replaced by their ObjectIDs
+func (p *Package) typeString(t types.Type, filename string, referencesAsIDs bool) string {
+	switch x := t.(type) {
+	case *types.Pointer:
+		return "*" + p.typeString(x.Elem(), filename, referencesAsIDs)
+	case *types.Slice:
+		return "[]" + p.typeString(x.Elem(), filename, referencesAsIDs)
+	case *types.Array:
+		return fmt.Sprintf("[%d]%s", x.Len(), p.typeString(x.Elem(), filename, referencesAsIDs))
+	case *types.Map:
+		return "map[" + p.typeString(x.Key(), filename, referencesAsIDs) + "]" + p.typeString(x.Elem(), filename, referencesAsIDs)
+	case *types.Named:
+		if s := p.lookupStruct(x); referencesAsIDs && s != nil && s.IsCollection {
+			return "bson.ObjectID"
+		}
+
+		typeArgs := x.TypeArgs()
+		if typeArgs.Len() == 0 {
+			return types.TypeString(t, p.outputQualifier(filename))
+		}
+
+		args := make([]string, typeArgs.Len())
+		for i := range args {
+			args[i] = p.typeString(typeArgs.At(i), filename, referencesAsIDs)
+		}
+
+		name := x.Obj().Name()
+		if qualifier := p.outputQualifier(filename)(x.Obj().Pkg()); qualifier != "" {
+			name = qualifier + "." + name
+		}
+		return name + "[" + strings.Join(args, ", ") + "]"
+	default:
+		return types.TypeString(t, p.outputQualifier(filename))
+	}
+}
+
+// outputQualifier qualifies types of other packages from the output package,
+// models packages are substituted by their output packages
+func (p *Package) outputQualifier(filename string) types.Qualifier {
+	return func(pkg *types.Package) string {
+		if pkg == nil || pkg.Path() == p.InputUser.PkgPath {
+			return ""
+		}
+
+		if other, ok := p.Siblings[pkg.Path()]; ok {
+			p.addImport(filename, other.OutputPkgName, other.OutputPkgPath)
+			return other.OutputPkgName
+		}
+		return pkg.Name()
+	}
+}
+
+func (p *Package) addImport(filename, name, importPath string) {
+	quotedPath := strconv.Quote(importPath)
+	for _, i := range p.Imports[filename] {
+		if i.InputAST.Path.Value == quotedPath {
+			return
+		}
+	}
+
+	spec := &ast.ImportSpec{Path: &ast.BasicLit{Kind: token.STRING, Value: quotedPath}}
+	if name != path.Base(importPath) {
+		spec.Name = ast.NewIdent(name)
+	}
+
+	p.Imports[filename] = append(p.Imports[filename], &Import{
+		SourceFile: filename,
+		InputAST:   spec,
+	})
+}
+
+func (p *Package) sortedStructs() []*Struct {
+	structs := make([]*Struct, 0, len(p.Structs))
+	for _, s := range p.Structs {
+		structs = append(structs, s)
+	}
+
+	sort.Slice(structs, func(i, j int) bool {
+		return structs[i].Name < structs[j].Name
+	})
+	return structs
+}
+
+func (p *Package) prepareCollectionFuncs() {
+	generatedFuncNames := map[string]bool{}
+	for _, s := range p.Structs {
+		s.InitCollectionFuncs()
+		for _, f := range s.CollectionFuncs {
+			generatedFuncNames[f.Name] = true
+		}
+	}
+
+	// Previously generated functions are regenerated
+	for filename, funcs := range p.Funcs {
+		userFuncs := []*Func{}
+		for _, f := range funcs {
+			if !generatedFuncNames[f.Name] {
+				userFuncs = append(userFuncs, f)
+			}
+		}
+		p.Funcs[filename] = userFuncs
+	}
+}
+
+func (p *Package) prepareValidateMethods() {
+	for _, s := range p.Structs {
+		if !s.Generated {
+			s.InitValidateMethods()
+		}
+	}
 }
 
 func (p *Package) prepareStructs() {
@@ -163,7 +376,7 @@ func (p *Package) parseUserStructs() {
 	for _, file := range p.InputUser.Syntax {
 		filename := p.InputUser.Fset.File(file.Pos()).Name()
 		filename = utils.BaseFilename(filename)
-		if filename == "codegen_.go" || utils.Contains(p.IgnoredUserFiles, filename) {
+		if IsCodegenFile(filename) || utils.Contains(p.IgnoredUserFiles, filename) {
 			continue
 		}
 
@@ -174,7 +387,7 @@ func (p *Package) parseUserStructs() {
 					switch spec := spec.(type) {
 					case *ast.TypeSpec:
 						if isFuncValueNameReserved(spec.Name.Name) {
-							log.Printf("Skipping reserved name: %s", spec.Name.Name)
+							slog.Warn("Skipping reserved name", "file", filename, "name", spec.Name.Name)
 							continue
 						}
 						switch specType := spec.Type.(type) {
@@ -184,6 +397,8 @@ func (p *Package) parseUserStructs() {
 								SourceFile: filename,
 								InputAST:   specType,
 								Name:       structName,
+								TypeParams: spec.TypeParams,
+								Doc:        typeSpecDoc(decl, spec),
 							}
 						case *ast.Ident:
 							typeName := spec.Name.Name
@@ -191,6 +406,7 @@ func (p *Package) parseUserStructs() {
 								SourceFile: filename,
 								InputAST:   spec,
 								Name:       typeName,
+								Doc:        typeSpecDoc(decl, spec),
 							}
 						}
 					}
@@ -205,7 +421,7 @@ func (p *Package) parseGenerated() {
 	for _, file := range p.InputGenerated.Syntax {
 		filename := p.InputGenerated.Fset.File(file.Pos()).Name()
 		filename = utils.BaseFilename(filename)
-		if filename == "codegen_.go" || utils.Contains(p.IgnoredGeneratedFiles, filename) {
+		if IsCodegenFile(filename) || utils.Contains(p.IgnoredGeneratedFiles, filename) {
 			continue
 		}
 
@@ -231,6 +447,8 @@ func (p *Package) parseGenerated() {
 								SourceFile: filename,
 								InputAST:   specType,
 								Name:       structName,
+								TypeParams: spec.TypeParams,
+								Doc:        typeSpecDoc(decl, spec),
 								Generated:  true,
 							}
 						case *ast.InterfaceType:
@@ -239,6 +457,7 @@ func (p *Package) parseGenerated() {
 								&Interface{
 									SourceFile: filename,
 									InputAST:   decl,
+									FileSet:    p.InputGenerated.Fset,
 									Name:       interfaceName,
 								},
 							)
@@ -246,7 +465,7 @@ func (p *Package) parseGenerated() {
 					case *ast.ValueSpec:
 						varName := astIdentSliceToString(spec.Names)
 						if isFuncValueNameReserved(varName) {
-							log.Printf("Skipping reserved name: %s", varName)
+							slog.Warn("Skipping reserved name", "file", filename, "name", varName)
 							continue
 						}
 
@@ -255,6 +474,7 @@ func (p *Package) parseGenerated() {
 								SourceFile: filename,
 								InputTok:   decl.Tok,
 								InputAST:   spec,
+								Doc:        valueSpecDoc(decl, spec),
 								Name:       varName,
 							},
 						)
@@ -274,15 +494,7 @@ func (p *Package) parseGenerated() {
 						continue
 					}
 
-					rcvType := decl.Recv.List[0].Type
-
-					var structTypeName string
-					switch rcvType := rcvType.(type) {
-					case *ast.StarExpr:
-						structTypeName = astObjectToString(rcvType.X)
-					case *ast.Ident:
-						structTypeName = astObjectToString(rcvType)
-					}
+					structTypeName := astReceiverTypeName(decl.Recv.List[0].Type)
 
 					p.StructMethods[structTypeName] = append(p.StructMethods[structTypeName],
 						&Func{
@@ -306,7 +518,7 @@ func (p *Package) parseGenerated() {
 					}
 
 					if isFuncValueNameReserved(funcName) {
-						log.Printf("Skipping reserved name: %s", funcName)
+						slog.Warn("Skipping reserved name", "file", filename, "name", funcName)
 						continue
 					}
 
@@ -336,7 +548,7 @@ func (p *Package) parseUserStructsMigrate() {
 					switch spec := spec.(type) {
 					case *ast.TypeSpec:
 						if isFuncValueNameReserved(spec.Name.Name) {
-							log.Printf("Skipping reserved name: %s", spec.Name.Name)
+							slog.Warn("Skipping reserved name", "file", filename, "name", spec.Name.Name)
 							continue
 						}
 						switch specType := spec.Type.(type) {
@@ -346,6 +558,8 @@ func (p *Package) parseUserStructsMigrate() {
 								SourceFile: filename,
 								InputAST:   specType,
 								Name:       structName,
+								TypeParams: spec.TypeParams,
+								Doc:        typeSpecDoc(decl, spec),
 							}
 						case *ast.InterfaceType:
 							interfaceName := spec.Name.Name
@@ -357,7 +571,7 @@ func (p *Package) parseUserStructsMigrate() {
 								},
 							)
 						default:
-							log.Printf("Unsupported type found, contact the developer if you require support: %T", specType)
+							slog.Warn("Unsupported type found, contact the developer if you require support", "file", filename, "name", spec.Name.Name, "type", fmt.Sprintf("%T", specType))
 						}
 					case *ast.ValueSpec:
 						varName := astIdentSliceToString(spec.Names)
@@ -381,15 +595,7 @@ func (p *Package) parseUserStructsMigrate() {
 			case *ast.FuncDecl:
 				funcName := astObjectToString(decl.Name)
 				if decl.Recv != nil {
-					rcvType := decl.Recv.List[0].Type
-
-					var structTypeName string
-					switch rcvType := rcvType.(type) {
-					case *ast.StarExpr:
-						structTypeName = astObjectToString(rcvType.X)
-					case *ast.Ident:
-						structTypeName = astObjectToString(rcvType)
-					}
+					structTypeName := astReceiverTypeName(decl.Recv.List[0].Type)
 
 					p.StructMethods[structTypeName] = append(p.StructMethods[structTypeName],
 						&Func{
@@ -402,7 +608,7 @@ func (p *Package) parseUserStructsMigrate() {
 					)
 				} else {
 					if isFuncValueNameReserved(funcName) {
-						log.Printf("Skipping reserved name: %s", funcName)
+						slog.Warn("Skipping reserved name", "file", filename, "name", funcName)
 						continue
 					}
 
@@ -7,13 +7,14 @@ import (
 	"go/printer"
 	"go/token"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 
 	"github.com/jonoans/mongo-gen/config"
+	"github.com/jonoans/mongo-gen/utils"
 	"golang.org/x/tools/imports"
 )
 
@@ -42,11 +43,14 @@ func (p *PackageFile) WriteToFile(cfg *config.OutputConfig) {
 	p.writeVars(buffer)
 	p.writeInterfaces(buffer)
 	p.writeStructs(buffer)
+	p.writeStructCollectionTypes(buffer)
 	p.writeStructCollectionNameMethods(buffer)
 	p.writeFuncs(buffer)
 	p.writeStructHookMethods(buffer)
 	p.writeStructResolverMethods(buffer)
+	p.writeStructValidateMethods(buffer)
 	p.writeStructDatabaseMethods(buffer)
+	p.writeStructCollectionFuncs(buffer)
 	p.writeBufferToFile(cfg.PackagePath, buffer)
 }
 
@@ -68,38 +72,48 @@ func (p *PackageFile) writeCustomTypes(buffer *bytes.Buffer) {
 		return
 	}
 
-	decl := &ast.GenDecl{Tok: token.TYPE}
+	// Declared separately to keep the doc comment of each type
 	for _, v := range p.CustomTypes {
-		decl.Specs = append(decl.Specs, v.InputAST)
+		spec := *v.InputAST
+		spec.Doc, spec.Comment = nil, nil
+		p.writeDeclsToBuffer(buffer, &ast.GenDecl{Doc: v.Doc, Tok: token.TYPE, Specs: []ast.Spec{&spec}})
 	}
-
-	p.writeDeclsToBuffer(buffer, decl)
 }
 
 func (p *PackageFile) writeConsts(buffer *bytes.Buffer) {
-	if len(p.ConstValues) == 0 {
-		return
-	}
-
-	decl := &ast.GenDecl{Tok: token.CONST}
-	for _, v := range p.ConstValues {
-		decl.Specs = append(decl.Specs, v.InputAST)
-	}
-
-	p.writeDeclsToBuffer(buffer, decl)
+	p.writeValues(buffer, token.CONST, p.ConstValues)
 }
 
 func (p *PackageFile) writeVars(buffer *bytes.Buffer) {
-	if len(p.VarValues) == 0 {
+	p.writeValues(buffer, token.VAR, p.VarValues)
+}
+
+// writeValues groups the values in a single declaration, comments are written
+// around each spec as the printer misplaces them out of their file set
+func (p *PackageFile) writeValues(buffer *bytes.Buffer, tok token.Token, values []*Value) {
+	if len(values) == 0 {
 		return
 	}
 
-	decl := &ast.GenDecl{Tok: token.VAR}
-	for _, v := range p.VarValues {
-		decl.Specs = append(decl.Specs, v.InputAST)
-	}
+	fmt.Fprintf(buffer, "%s (\n", tok)
+	for _, v := range values {
+		if v.Doc != nil {
+			fmt.Fprintln(buffer, astCommentGroupToString(v.Doc))
+		}
 
-	p.writeDeclsToBuffer(buffer, decl)
+		spec := *v.InputAST
+		spec.Doc, spec.Comment = nil, nil
+		err := printer.Fprint(buffer, token.NewFileSet(), &spec)
+		if err != nil {
+			utils.Fatal("Could not write to buffer", "error", err)
+		}
+
+		if v.InputAST.Comment != nil {
+			fmt.Fprint(buffer, " ", astCommentGroupToString(v.InputAST.Comment))
+		}
+		fmt.Fprintln(buffer)
+	}
+	fmt.Fprint(buffer, ")\n\n")
 }
 
 func (p *PackageFile) writeTypes(buffer *bytes.Buffer) {
@@ -121,6 +135,10 @@ func (p *PackageFile) writeInterfaces(buffer *bytes.Buffer) {
 	}
 
 	for _, i := range p.Interfaces {
+		if i.FileSet != nil {
+			p.writeSourceLines(buffer, i.FileSet, i.InputAST.Doc, i.InputAST)
+			continue
+		}
 		p.writeDeclsToBuffer(buffer, i.InputAST)
 	}
 }
@@ -140,12 +158,29 @@ func (p *PackageFile) writeStructs(mainBuffer *bytes.Buffer) {
 	template := GetTemplate("struct")
 	err := template.Execute(buffer, p)
 	if err != nil {
-		log.Fatalf("Could not write to output file: %s", err)
+		utils.Fatal("Could not write to output file", "error", err)
 	}
 
 	mainBuffer.Write(buffer.Bytes())
 }
 
+func (p *PackageFile) writeStructCollectionTypes(buffer *bytes.Buffer) {
+	decl := &ast.GenDecl{Tok: token.TYPE}
+	for _, s := range p.Structs {
+		for _, t := range s.CollectionTypes {
+			decl.Specs = append(decl.Specs, t)
+		}
+	}
+
+	if len(decl.Specs) == 0 {
+		return
+	}
+
+	// The struct template ends right after the last closing brace
+	buffer.WriteString("\n")
+	p.writeDeclsToBuffer(buffer, decl)
+}
+
 func (p *PackageFile) writeStructCollectionNameMethods(buffer *bytes.Buffer) {
 	for _, s := range p.Structs {
 		if s.IsCollection {
@@ -172,6 +207,14 @@ func (p *PackageFile) writeStructResolverMethods(buffer *bytes.Buffer) {
 	}
 }
 
+func (p *PackageFile) writeStructValidateMethods(buffer *bytes.Buffer) {
+	for _, s := range p.Structs {
+		for _, m := range s.ValidateMethods {
+			p.writeFuncToBuffer(buffer, m)
+		}
+	}
+}
+
 func (p *PackageFile) writeStructDatabaseMethods(buffer *bytes.Buffer) {
 	for _, s := range p.Structs {
 		if s.IsCollection {
@@ -182,26 +225,67 @@ func (p *PackageFile) writeStructDatabaseMethods(buffer *bytes.Buffer) {
 	}
 }
 
+func (p *PackageFile) writeStructCollectionFuncs(buffer *bytes.Buffer) {
+	for _, s := range p.Structs {
+		for _, f := range s.CollectionFuncs {
+			p.writeFuncToBuffer(buffer, f)
+		}
+	}
+}
+
 func (p *PackageFile) writeFuncToBuffer(buffer io.Writer, f *Func) {
 	if f.FileSet != nil && f.Generated {
-		startLine, endLine := f.FileSet.Position(f.InputAST.Pos()).Line, f.FileSet.Position(f.InputAST.End()).Line
-		funcBytes := []byte(strings.Join(p.FileContents[startLine-1:endLine], "\n"))
-		_, err := buffer.Write(funcBytes)
-		if err != nil {
-			log.Fatalf("Could not write to output file: %s", err)
-		}
+		p.writeSourceLines(buffer, f.FileSet, f.InputAST.Doc, f.InputAST)
+		return
+	}
 
-		buffer.Write([]byte("\n\n")) // Shouldn't be an issue
+	if f.Source != "" {
+		fmt.Fprint(buffer, f.Source, "\n\n")
 		return
 	}
 	p.writeDeclsToBuffer(buffer, f.InputAST)
 }
 
+// writeSourceLines copies a declaration read from the output package as written, comments included
+func (p *PackageFile) writeSourceLines(buffer io.Writer, fset *token.FileSet, doc *ast.CommentGroup, node ast.Node) {
+	start := node.Pos()
+	if doc != nil {
+		start = doc.Pos()
+	}
+
+	startLine, endLine := fset.Position(start).Line, fset.Position(node.End()).Line
+	declBytes := []byte(strings.Join(p.FileContents[startLine-1:endLine], "\n"))
+	_, err := buffer.Write(declBytes)
+	if err != nil {
+		utils.Fatal("Could not write to output file", "error", err)
+	}
+
+	buffer.Write([]byte("\n\n")) // Shouldn't be an issue
+}
+
 func (*PackageFile) writeDeclsToBuffer(buffer io.Writer, decl ...ast.Decl) {
 	for _, d := range decl {
+		// The printer misplaces comments out of their file set, docs are written first
+		switch decl := d.(type) {
+		case *ast.FuncDecl:
+			if decl.Doc != nil {
+				fmt.Fprintln(buffer, astCommentGroupToString(decl.Doc))
+				undocumented := *decl
+				undocumented.Doc = nil
+				d = &undocumented
+			}
+		case *ast.GenDecl:
+			if decl.Doc != nil {
+				fmt.Fprintln(buffer, astCommentGroupToString(decl.Doc))
+				undocumented := *decl
+				undocumented.Doc = nil
+				d = &undocumented
+			}
+		}
+
 		err := printer.Fprint(buffer, token.NewFileSet(), d)
 		if err != nil {
-			log.Fatalf("Could not write to buffer: %s", err)
+			utils.Fatal("Could not write to buffer", "error", err)
 		}
 
 		buffer.Write([]byte("\n\n")) // Shouldn't be an issue
@@ -209,22 +293,23 @@ func (*PackageFile) writeDeclsToBuffer(buffer io.Writer, decl ...ast.Decl) {
 }
 
 func (p *PackageFile) writeBufferToFile(packagePath string, buffer *bytes.Buffer) {
-	outBytes, err := imports.Process(packagePath, buffer.Bytes(), &imports.Options{})
+	outBytes, err := imports.Process(packagePath, buffer.Bytes(), &imports.Options{Comments: true, TabIndent: true, TabWidth: 8})
 	if err != nil {
-		log.Fatalf("Error formatting package: %s", err)
+		utils.Fatal("Error formatting package", "error", err)
 	}
 
 	outputFilepath := filepath.Join(packagePath, p.Filename)
 	fh, err := os.OpenFile(outputFilepath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
 	defer fh.Close()
 	if err != nil {
-		log.Fatalf("Could not open output file: %s", err)
+		utils.Fatal("Could not open output file", "file", outputFilepath, "error", err)
 	}
 
 	_, err = fh.Write(outBytes)
 	if err != nil {
-		log.Fatalf("Could not write to output file: %s", err)
+		utils.Fatal("Could not write to output file", "file", outputFilepath, "error", err)
 	}
+	slog.Debug("Wrote output file", "file", outputFilepath)
 }
 
 func (p *PackageFile) Init() {
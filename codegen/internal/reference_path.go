@@ -0,0 +1,257 @@
+package internal
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// ReferencePath locates a resolvable field from its collection struct,
+// Fields lists the subdocument fields traversed followed by the resolvable field
+type ReferencePath struct {
+	Collection *Struct
+	Fields     []*Field
+}
+
+func (r *ReferencePath) with(field *Field) *ReferencePath {
+	fields := append(append([]*Field{}, r.Fields...), field)
+	return &ReferencePath{Collection: r.Collection, Fields: fields}
+}
+
+// Field returns the resolvable field
+func (r *ReferencePath) Field() *Field {
+	return r.Fields[len(r.Fields)-1]
+}
+
+// Hops returns the subdocument fields traversed to reach the resolvable field
+func (r *ReferencePath) Hops() []*Field {
+	return r.Fields[:len(r.Fields)-1]
+}
+
+func (r *ReferencePath) IsNested() bool {
+	return len(r.Fields) > 1
+}
+
+// Name joins the field names of the path with underscores
+func (r *ReferencePath) Name() string {
+	names := make([]string, len(r.Fields))
+	for i, f := range r.Fields {
+		names[i] = f.Name
+	}
+	return strings.Join(names, "_")
+}
+
+// selector returns the Go selector of the resolvable field from the collection struct
+func (r *ReferencePath) selector() string {
+	names := make([]string, len(r.Fields))
+	for i, f := range r.Fields {
+		names[i] = f.Name
+	}
+	return strings.Join(names, ".")
+}
+
+// BSONPath returns the dotted document path of the resolvable field
+func (r *ReferencePath) BSONPath() string {
+	return r.joinBSONNames(false)
+}
+
+// UpdatePath returns the document path used by update operators,
+// arrays of subdocuments are traversed with the all positional operator
+func (r *ReferencePath) UpdatePath() string {
+	return r.joinBSONNames(true)
+}
+
+func (r *ReferencePath) joinBSONNames(positional bool) string {
+	names := []string{}
+	for _, f := range r.Fields {
+		if f.IsEmbedded && isBSONInline(f) {
+			continue
+		}
+
+		names = append(names, f.BSONName())
+		if positional && f != r.Field() {
+			for range strings.Count(referenceShape(f.OwnType), "s") {
+				names = append(names, "$[]")
+			}
+		}
+	}
+	return strings.Join(names, ".")
+}
+
+// filteredUpdatePath returns the document path used by update operators setting
+// the resolvable field, the last array of subdocuments is traversed with the filtered
+// positional operator $[elem] and the path of elem matched by its array filter is returned
+func (r *ReferencePath) filteredUpdatePath() (string, string) {
+	names := strings.Split(r.UpdatePath(), ".")
+	for i := len(names) - 1; i >= 0; i-- {
+		if names[i] == "$[]" {
+			names[i] = "$[elem]"
+			return strings.Join(names, "."), strings.Join(append([]string{"elem"}, names[i+1:]...), ".")
+		}
+	}
+	return strings.Join(names, "."), ""
+}
+
+// Shape returns the containers wrapping the references at the path,
+// false is returned when a map of subdocuments is traversed
+func (r *ReferencePath) Shape() (string, bool) {
+	shape := ""
+	for _, f := range r.Hops() {
+		hopShape := referenceShape(f.OwnType)
+		if strings.Contains(hopShape, "m") {
+			return "", false
+		}
+		shape += hopShape
+	}
+	return shape + referenceShape(r.Field().OwnType), true
+}
+
+// inverseReferenceFilter returns the filter matching documents referencing the receiver
+func (r *ReferencePath) inverseReferenceFilter() ast.Expr {
+	shape, _ := r.Shape()
+	return &ast.CallExpr{
+		Fun: ast.NewIdent("inverseReferenceFilter"),
+		Args: []ast.Expr{
+			&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(r.BSONPath())},
+			ast.NewIdent("m.GetID()"),
+			&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(shape)},
+		},
+	}
+}
+
+// BuildNestedResolverMethods builds resolvers on the collection struct
+// delegating to the resolvers of the subdocument holding the reference
+func (r *ReferencePath) BuildNestedResolverMethods() []*Func {
+	field := r.Field()
+
+	name := "GetResolved_" + r.Name()
+	method := &Func{}
+	method.Parent = r.Collection
+	method.SourceFile = r.Collection.SourceFile
+	method.Name = name + "WithCtx"
+
+	// Create resolver method signature
+	resolverMethod := &ast.FuncDecl{}
+	resolverMethod.Doc = newDocComment("%s returns the %s referenced by %s, nil subdocuments resolve to the zero value", method.Name, field.ReferencedStruct.Name, r.selector())
+	resolverMethod.Recv = &ast.FieldList{}
+	resolverMethod.Recv.List = []*ast.Field{{
+		Names: []*ast.Ident{ast.NewIdent("m")},
+		Type:  ast.NewIdent("*" + r.Collection.Name),
+	}}
+	resolverMethod.Name = ast.NewIdent(method.Name)
+	resolverMethod.Type = &ast.FuncType{}
+	resolverMethod.Type.Params = &ast.FieldList{}
+	resolverMethod.Type.Params.List = []*ast.Field{
+		{Names: []*ast.Ident{ast.NewIdent("ctx")}, Type: ast.NewIdent("context.Context")},
+	}
+	resolverMethod.Type.Results = &ast.FieldList{}
+	resolverMethod.Type.Results.List = []*ast.Field{
+		{Type: ast.NewIdent(field.ResolverType)},
+		{Type: ast.NewIdent("error")},
+	}
+
+	// Create resolver method body, nil subdocuments resolve to the zero value
+	resolverMethod.Body = &ast.BlockStmt{}
+	selector := "m"
+	for _, hop := range r.Hops() {
+		selector += "." + hop.Name
+		if hop.IsPointer {
+			resolverMethod.Body.List = append(resolverMethod.Body.List, &ast.IfStmt{
+				Cond: &ast.BinaryExpr{
+					X:  ast.NewIdent(selector),
+					Op: token.EQL,
+					Y:  ast.NewIdent("nil"),
+				},
+				Body: &ast.BlockStmt{List: []ast.Stmt{
+					&ast.ReturnStmt{Results: []ast.Expr{
+						ast.NewIdent("*new(" + field.ResolverType + ")"),
+						ast.NewIdent("nil"),
+					}},
+				}},
+			})
+		}
+	}
+
+	resolverMethod.Body.List = append(resolverMethod.Body.List, &ast.ReturnStmt{
+		Results: []ast.Expr{
+			&ast.CallExpr{
+				Fun:  ast.NewIdent(selector + ".GetResolved_" + field.Name + "WithCtx"),
+				Args: []ast.Expr{ast.NewIdent("ctx")},
+			},
+		},
+	})
+
+	method.InputAST = resolverMethod
+	renderMethodTemplate(resolverTemplate, method, &MethodTemplateData{Struct: r.Collection, Field: field, Path: r})
+
+	doc := newDocComment("%s returns the %s referenced by %s with a new context, nil subdocuments resolve to the zero value", name, field.ReferencedStruct.Name, r.selector())
+	return []*Func{newResolverWrapperMethod(r.Collection, r.Collection.Name, name, field.ResolverType, doc), method}
+}
+
+// BuildInverseResolverMethod builds a method on the referenced struct
+// returning every document of the collection referencing it
+func (r *ReferencePath) BuildInverseResolverMethod(name string) *Func {
+	referencedStruct := r.Field().ReferencedStruct
+	resultsType := "[]" + r.Collection.Name
+
+	method := &Func{}
+	method.Parent = referencedStruct
+	method.SourceFile = referencedStruct.SourceFile
+	method.Name = name
+
+	// Create inverse resolver method signature
+	resolverMethod := &ast.FuncDecl{}
+	resolverMethod.Doc = newDocComment("%s returns the %s documents referencing the %s through %s", method.Name, r.Collection.Name, referencedStruct.Name, r.selector())
+	resolverMethod.Recv = &ast.FieldList{}
+	resolverMethod.Recv.List = []*ast.Field{{
+		Names: []*ast.Ident{ast.NewIdent("m")},
+		Type:  ast.NewIdent("*" + referencedStruct.Name),
+	}}
+	resolverMethod.Name = ast.NewIdent(method.Name)
+	resolverMethod.Type = &ast.FuncType{}
+	resolverMethod.Type.Params = &ast.FieldList{}
+	resolverMethod.Type.Params.List = []*ast.Field{
+		{Names: []*ast.Ident{ast.NewIdent("ctx")}, Type: ast.NewIdent("context.Context")},
+		{Names: []*ast.Ident{ast.NewIdent("opts")}, Type: ast.NewIdent("...options.Lister[options.FindOptions]")},
+	}
+	resolverMethod.Type.Results = &ast.FieldList{}
+	resolverMethod.Type.Results.List = []*ast.Field{
+		{Type: ast.NewIdent(resultsType)},
+		{Type: ast.NewIdent("error")},
+	}
+
+	// Create inverse resolver method body
+	resolverMethod.Body = &ast.BlockStmt{}
+	resolverMethod.Body.List = []ast.Stmt{
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("results")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{ast.NewIdent(resultsType + "{}")},
+		},
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("err")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{
+				&ast.CallExpr{
+					Fun: ast.NewIdent("FindManyWithCtx"),
+					Args: []ast.Expr{
+						ast.NewIdent("ctx"),
+						&ast.UnaryExpr{Op: token.AND, X: ast.NewIdent("results")},
+						r.inverseReferenceFilter(),
+						ast.NewIdent("opts..."),
+					},
+				},
+			},
+		},
+		&ast.ReturnStmt{
+			Results: []ast.Expr{
+				ast.NewIdent("results"),
+				ast.NewIdent("err"),
+			},
+		},
+	}
+
+	method.InputAST = resolverMethod
+	return method
+}
@@ -0,0 +1,352 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/ast"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jonoans/mongo-gen/config"
+	"github.com/jonoans/mongo-gen/utils"
+)
+
+// ValidatorsFilename is the file registering the $jsonSchema validators of the collections
+const ValidatorsFilename = "codegen_validators.go"
+
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// ValidatorsTemplateData is passed to the validators template
+type ValidatorsTemplateData struct {
+	PackageName string
+	Validators  []*CollectionSchema
+}
+
+// CollectionSchema is the schema of the documents stored by a collection struct
+type CollectionSchema struct {
+	Struct     *Struct
+	Collection string
+	node       *schemaNode
+}
+
+// JSONSchema renders the schema as a JSON Schema document
+func (c *CollectionSchema) JSONSchema() map[string]any {
+	schema := c.node.render(false)
+	schema["$schema"] = jsonSchemaDialect
+	schema["title"] = c.Struct.Name
+	return schema
+}
+
+// Validator renders the schema as the indented JSON of a MongoDB $jsonSchema validator
+func (c *CollectionSchema) Validator() string {
+	schema := c.node.render(true)
+	schema["title"] = c.Struct.Name
+	return marshalSchema(schema)
+}
+
+// schemaNode describes a stored value, rendered with JSON Schema types
+// or with the BSON types of MongoDB's $jsonSchema
+type schemaNode struct {
+	jsonType        string
+	bsonTypes       []string
+	format          string
+	pattern         string
+	contentEncoding string
+	nullable        bool
+
+	properties map[string]*schemaNode
+	required   []string
+	items      *schemaNode
+	values     *schemaNode // Values of maps
+}
+
+// schemaField is a struct field as seen by the driver
+type schemaField struct {
+	name     string
+	tag      string
+	t        types.Type
+	embedded bool
+	doc      *ast.CommentGroup
+}
+
+func (n *schemaNode) render(bsonTypes bool) map[string]any {
+	schema := map[string]any{}
+	if bsonTypes {
+		if len(n.bsonTypes) > 0 {
+			typeNames := append([]string{}, n.bsonTypes...)
+			if n.nullable {
+				typeNames = append(typeNames, "null")
+			}
+			schema["bsonType"] = schemaTypeValue(typeNames)
+		}
+	} else {
+		if n.jsonType != "" {
+			typeNames := []string{n.jsonType}
+			if n.nullable {
+				typeNames = append(typeNames, "null")
+			}
+			schema["type"] = schemaTypeValue(typeNames)
+		}
+		if n.format != "" {
+			schema["format"] = n.format
+		}
+		if n.pattern != "" {
+			schema["pattern"] = n.pattern
+		}
+		if n.contentEncoding != "" {
+			schema["contentEncoding"] = n.contentEncoding
+		}
+	}
+
+	if n.properties != nil {
+		properties := map[string]any{}
+		for name, property := range n.properties {
+			properties[name] = property.render(bsonTypes)
+		}
+		schema["properties"] = properties
+	}
+	if len(n.required) > 0 {
+		schema["required"] = n.required
+	}
+	if n.items != nil {
+		schema["items"] = n.items.render(bsonTypes)
+	}
+	if n.values != nil {
+		schema["additionalProperties"] = n.values.render(bsonTypes)
+	}
+	return schema
+}
+
+func schemaTypeValue(typeNames []string) any {
+	if len(typeNames) == 1 {
+		return typeNames[0]
+	}
+	return typeNames
+}
+
+func marshalSchema(schema any) string {
+	buffer := bytes.NewBuffer(nil)
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "\t")
+	if err := encoder.Encode(schema); err != nil {
+		utils.Fatal("Could not encode schema", "error", err)
+	}
+	return strings.TrimSpace(buffer.String())
+}
+
+// CollectionSchemas returns the schemas of the collection structs sorted by struct name
+func (p *Package) CollectionSchemas() []*CollectionSchema {
+	schemas := []*CollectionSchema{}
+	for _, s := range p.sortedStructs() {
+		if !s.IsCollection {
+			continue
+		}
+
+		schemas = append(schemas, &CollectionSchema{
+			Struct:     s,
+			Collection: s.collectionName(),
+			node:       p.structSchema(s, nil, map[*Struct]bool{}),
+		})
+	}
+	return schemas
+}
+
+// WriteValidators writes the file registering the $jsonSchema validator of every collection
+func (p *Package) WriteValidators(cfg *config.OutputConfig) {
+	data := &ValidatorsTemplateData{PackageName: cfg.PackageName, Validators: p.CollectionSchemas()}
+
+	buffer := bytes.NewBuffer(nil)
+	if err := GetTemplate("validators").Execute(buffer, data); err != nil {
+		utils.Fatal("Could not write validators", "error", err)
+	}
+
+	(&PackageFile{Filename: ValidatorsFilename}).writeBufferToFile(cfg.PackagePath, buffer)
+}
+
+// WriteSchemaFile writes the JSON Schema of the collection into dir as [COLLECTION].schema.json
+func (c *CollectionSchema) WriteSchemaFile(dir string) {
+	filename := filepath.Join(dir, c.Collection+".schema.json")
+	if err := os.WriteFile(filename, []byte(marshalSchema(c.JSONSchema())+"\n"), 0644); err != nil {
+		utils.Fatal("Could not write schema file", "file", filename, "error", err)
+	}
+}
+
+// structSchema describes the fields of a models struct, the fields of generic
+// structs take their types from the instantiation in named
+func (p *Package) structSchema(s *Struct, named *types.Named, visiting map[*Struct]bool) *schemaNode {
+	// Recursive subdocuments are left unconstrained below the first level
+	if visiting[s] {
+		return &schemaNode{jsonType: "object", bsonTypes: []string{"object"}}
+	}
+	visiting[s] = true
+	defer delete(visiting, s)
+
+	return p.fieldsSchema(s.schemaFields(named), visiting)
+}
+
+// schemaFields returns the fields of the struct in declaration order, the fields
+// of generic structs take their types from the instantiation in named
+func (s *Struct) schemaFields(named *types.Named) []schemaField {
+	var instantiated *types.Struct
+	if named != nil && named.TypeArgs().Len() > 0 {
+		instantiated, _ = named.Underlying().(*types.Struct)
+	}
+
+	fields := append(append([]*Field{}, s.EmbeddedFields...), s.Fields...)
+	sort.SliceStable(fields, func(i, j int) bool {
+		return fields[i].InputTypesVar.Pos() < fields[j].InputTypesVar.Pos()
+	})
+
+	schemaFields := []schemaField{}
+	for _, f := range fields {
+		t := f.OwnType
+		if instantiated != nil {
+			for i := 0; i < instantiated.NumFields(); i++ {
+				if instantiated.Field(i).Name() == f.InputTypesVar.Name() {
+					t = instantiated.Field(i).Type()
+				}
+			}
+		}
+
+		var doc *ast.CommentGroup
+		if f.InputAST != nil {
+			doc = f.InputAST.Doc
+			if doc == nil {
+				doc = f.InputAST.Comment
+			}
+		}
+		schemaFields = append(schemaFields, schemaField{name: f.InputTypesVar.Name(), tag: f.StructTag, t: t, embedded: f.IsEmbedded, doc: doc})
+	}
+	return schemaFields
+}
+
+// fieldsSchema describes a document holding fields, inlined structs are flattened as the driver does
+func (p *Package) fieldsSchema(fields []schemaField, visiting map[*Struct]bool) *schemaNode {
+	node := &schemaNode{jsonType: "object", bsonTypes: []string{"object"}, properties: map[string]*schemaNode{}}
+	for _, field := range fields {
+		tag, _ := structTagLookup(field.tag, "bson")
+		options := strings.Split(tag, ",")
+		key := options[0]
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = strings.ToLower(field.name)
+		}
+
+		fieldNode := p.typeSchema(field.t, visiting)
+		if field.embedded && utils.Contains(options[1:], "inline") {
+			for name, property := range fieldNode.properties {
+				node.properties[name] = property
+			}
+			node.required = append(node.required, fieldNode.required...)
+			continue
+		}
+
+		node.properties[key] = fieldNode
+		if !utils.Contains(options[1:], "omitempty") {
+			node.required = append(node.required, key)
+		}
+	}
+
+	sort.Strings(node.required)
+	return node
+}
+
+// typeSchema describes the values of t as encoded by the driver, references to collections are ObjectIDs
+func (p *Package) typeSchema(t types.Type, visiting map[*Struct]bool) *schemaNode {
+	t = types.Unalias(t)
+	switch x := t.(type) {
+	case *types.Pointer:
+		node := *p.typeSchema(x.Elem(), visiting)
+		node.nullable = true
+		return &node
+	case *types.Named:
+		if node := namedTypeSchema(x); node != nil {
+			return node
+		}
+
+		if s := p.lookupStruct(x); s != nil {
+			if s.IsCollection {
+				return objectIDSchema()
+			}
+			return p.structSchema(s, x, visiting)
+		}
+		return p.typeSchema(x.Underlying(), visiting)
+	case *types.Basic:
+		return basicTypeSchema(x)
+	case *types.Slice:
+		if isByte(x.Elem()) {
+			return &schemaNode{jsonType: "string", bsonTypes: []string{"binData"}, contentEncoding: "base64", nullable: true}
+		}
+		return &schemaNode{jsonType: "array", bsonTypes: []string{"array"}, items: p.typeSchema(x.Elem(), visiting), nullable: true}
+	case *types.Array:
+		if isByte(x.Elem()) {
+			return &schemaNode{jsonType: "string", bsonTypes: []string{"binData"}, contentEncoding: "base64"}
+		}
+		return &schemaNode{jsonType: "array", bsonTypes: []string{"array"}, items: p.typeSchema(x.Elem(), visiting)}
+	case *types.Map:
+		return &schemaNode{jsonType: "object", bsonTypes: []string{"object"}, values: p.typeSchema(x.Elem(), visiting), nullable: true}
+	case *types.Struct:
+		return p.fieldsSchema(anonymousStructFields(x), visiting)
+	default:
+		// Interfaces hold any value, functions and channels are not stored
+		return &schemaNode{}
+	}
+}
+
+// namedTypeSchema describes the types of the driver and the standard library
+// with a BSON representation of their own, nil is returned for other types
+func namedTypeSchema(named *types.Named) *schemaNode {
+	switch named.String() {
+	case "time.Time", "go.mongodb.org/mongo-driver/v2/bson.DateTime":
+		return &schemaNode{jsonType: "string", bsonTypes: []string{"date"}, format: "date-time"}
+	case "go.mongodb.org/mongo-driver/v2/bson.ObjectID":
+		return objectIDSchema()
+	case "go.mongodb.org/mongo-driver/v2/bson.Decimal128":
+		return &schemaNode{jsonType: "string", bsonTypes: []string{"decimal"}}
+	case "go.mongodb.org/mongo-driver/v2/bson.M", "go.mongodb.org/mongo-driver/v2/bson.D", "go.mongodb.org/mongo-driver/v2/bson.Raw":
+		return &schemaNode{jsonType: "object", bsonTypes: []string{"object"}, nullable: true}
+	case "go.mongodb.org/mongo-driver/v2/bson.A":
+		return &schemaNode{jsonType: "array", bsonTypes: []string{"array"}, nullable: true}
+	}
+
+	// Types marshalling themselves may be stored as any value
+	methods := types.NewMethodSet(types.NewPointer(named))
+	for _, name := range []string{"MarshalBSON", "MarshalBSONValue"} {
+		if methods.Lookup(named.Obj().Pkg(), name) != nil {
+			return &schemaNode{}
+		}
+	}
+	return nil
+}
+
+func objectIDSchema() *schemaNode {
+	return &schemaNode{jsonType: "string", bsonTypes: []string{"objectId"}, pattern: "^[0-9a-f]{24}$"}
+}
+
+func basicTypeSchema(basic *types.Basic) *schemaNode {
+	info := basic.Info()
+	switch {
+	case info&types.IsString != 0:
+		return &schemaNode{jsonType: "string", bsonTypes: []string{"string"}}
+	case info&types.IsBoolean != 0:
+		return &schemaNode{jsonType: "boolean", bsonTypes: []string{"bool"}}
+	case info&types.IsInteger != 0:
+		// Integers are stored as int32 or int64 depending on their kind and value
+		return &schemaNode{jsonType: "integer", bsonTypes: []string{"int", "long"}}
+	case info&types.IsFloat != 0:
+		return &schemaNode{jsonType: "number", bsonTypes: []string{"double"}}
+	default:
+		return &schemaNode{}
+	}
+}
+
+func isByte(t types.Type) bool {
+	basic, ok := t.Underlying().(*types.Basic)
+	return ok && basic.Kind() == types.Byte
+}
@@ -2,9 +2,16 @@ package internal
 
 import (
 	"go/ast"
+	"go/token"
 	"go/types"
+	"log/slog"
+	"reflect"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+
+	"github.com/jonoans/mongo-gen/utils"
 )
 
 var structDbMethods = []*structDbMethod{
@@ -97,13 +104,25 @@ type Struct struct {
 	DatabaseMethods      []*Func // Source file will be set to struct's source file
 	UserDefinedMethods   []*Func // Retain source file for user defined methods
 	ResolverMethods      []*Func // Source file will be set to struct's source file
+	ValidateMethods      []*Func // Empty for structs without rules and structs declared in the output package
+	CollectionFuncs      []*Func // Package level functions, source file will be set to struct's source file
+	CollectionTypes      []*ast.TypeSpec
 
 	Name           string
+	TypeParams     *ast.FieldList // Type parameters of generic structs
+	Doc            *ast.CommentGroup
 	Generated      bool
 	IsCollection   bool
 	EmbeddedFields []*Field
 	Fields         []*Field
 	ResolverFields []*Field
+	ReferencePaths []*ReferencePath
+	DeleteActions  []*deleteAction // onDelete actions of fields referencing the struct
+}
+
+// logger returns the default logger with the file and name of the struct
+func (s *Struct) logger() *slog.Logger {
+	return slog.With("file", s.SourceFile, "struct", s.Name)
 }
 
 func (s *Struct) Init() {
@@ -127,6 +146,9 @@ func (s *Struct) initFields() {
 		f.Init()
 
 		if f.IsBaseModelDerivative {
+			if s.TypeParams != nil {
+				utils.Fatal("Generic struct cannot embed BaseModel, collections must not have type parameters", "file", s.SourceFile, "struct", s.Name)
+			}
 			s.IsCollection = true
 		}
 
@@ -138,30 +160,226 @@ func (s *Struct) initFields() {
 	}
 }
 
+// DocComment returns the doc comment of the struct followed by a newline
+func (s *Struct) DocComment() string {
+	if s.Doc == nil {
+		return ""
+	}
+	return astCommentGroupToString(s.Doc) + "\n"
+}
+
+// TypeParamList returns the type parameter list of generic structs, e.g. [K comparable, V any]
+func (s *Struct) TypeParamList() string {
+	return astTypeParamsToString(s.TypeParams)
+}
+
+// receiverType returns the receiver type of the struct's methods, e.g. Pair[K, V]
+func (s *Struct) receiverType() string {
+	if s.TypeParams == nil {
+		return s.Name
+	}
+
+	names := []string{}
+	for _, param := range s.TypeParams.List {
+		for _, name := range param.Names {
+			names = append(names, name.Name)
+		}
+	}
+	return s.Name + "[" + strings.Join(names, ", ") + "]"
+}
+
 func (s *Struct) InitResolverFieldsAndMethods() {
-	packagePath := s.Parent.InputUser.PkgPath
-	packageTrimPrefix := packagePath + "."
 	for _, field := range s.Fields {
 		if field.IsReference {
-			fieldResolvedType := field.ResolvedType.String()
-			if strings.HasPrefix(fieldResolvedType, packageTrimPrefix) {
-				referencedType := strings.Replace(fieldResolvedType, packageTrimPrefix, "", 1)
-				referencedStruct := s.Parent.Structs[referencedType]
-				if referencedStruct.IsCollection {
-					field.IsResolvable = true
-					if field.IsMap || field.IsPointer || field.IsSlice {
-						field.CreateChildField()
-					}
-					s.ResolverMethods = append(s.ResolverMethods, field.BuildResolverMethod())
-					s.ResolverFields = append(s.ResolverFields, field.CreateStubResolvableFields()...)
-					field.MutateResolvableFieldType()
+			referencedStruct := s.Parent.lookupStruct(field.ResolvedType)
+			if referencedStruct != nil && referencedStruct.IsCollection {
+				if isArrayContained(field.OwnType) {
+					s.logger().Warn("Skipping reference, references in fixed-length arrays are not supported", "field", field.Name)
+					continue
 				}
+				s.initResolvableField(field, referencedStruct)
+				field.MutateResolvableFieldType()
+			} else if hasGenericReference(s.Parent, field.OwnType) {
+				s.initGenericReferences(field)
 			}
 		}
 	}
 }
 
+func (s *Struct) initResolvableField(field *Field, referencedStruct *Struct) {
+	field.IsResolvable = true
+	field.ReferencedStruct = referencedStruct
+	if field.IsMap || field.IsPointer || field.IsSlice {
+		field.CreateChildField()
+	}
+	s.ResolverMethods = append(s.ResolverMethods, field.BuildResolverMethods()...)
+	s.ResolverFields = append(s.ResolverFields, field.CreateStubResolvableFields()...)
+}
+
+// initGenericReferences stores collections passed as type arguments of generic fields as ObjectIDs,
+// fields of the generic struct holding them are resolved through GetResolved_[FIELD]_[GENERIC FIELD]
+func (s *Struct) initGenericReferences(field *Field) {
+	field.Type = s.Parent.typeString(field.OwnType, s.SourceFile, true)
+
+	for _, ref := range s.Parent.genericReferences(field.OwnType) {
+		genericField, resolvedType, referencedStruct := ref.field, ref.resolvedType, ref.referencedStruct
+
+		f := &Field{}
+		f.Parent = s
+		f.Name = field.Name + "_" + genericField.Name()
+		f.Selector = field.Name + "." + genericField.Name()
+		f.Type = s.Parent.typeString(genericField.Type(), s.SourceFile, false)
+		f.OwnType, f.ResolvedType = genericField.Type(), resolvedType
+		f.IsReference = true
+		f.IsMap = isMap(f.OwnType)
+		f.IsPointer = isPointer(f.OwnType)
+		f.IsSlice = isSlice(f.OwnType)
+		if f.IsMap {
+			match := mapKeyRegex.FindStringSubmatchIndex(f.Type)
+			f.MapKeyType = f.Type[match[2]:match[3]]
+		}
+
+		s.initResolvableField(f, referencedStruct)
+	}
+}
+
+// genericReference is a field of a generic struct instance holding a collection passed as type argument
+type genericReference struct {
+	field            *types.Var
+	resolvedType     types.Type
+	referencedStruct *Struct
+}
+
+// genericReferences returns the fields of the generic struct t referencing collections,
+// only generic structs held directly can be traversed without nil checks
+func (p *Package) genericReferences(t types.Type) []genericReference {
+	named, ok := t.(*types.Named)
+	if !ok || named.TypeArgs().Len() == 0 {
+		return nil
+	}
+
+	instance, ok := named.Underlying().(*types.Struct)
+	if !ok {
+		return nil
+	}
+
+	refs := []genericReference{}
+	origin := named.Origin().Underlying().(*types.Struct)
+	for i := 0; i < instance.NumFields(); i++ {
+		genericField := instance.Field(i)
+		if !genericField.Exported() || types.Identical(genericField.Type(), origin.Field(i).Type()) {
+			continue
+		}
+
+		resolvedType, isBuiltIn := isBuiltin(genericField.Type())
+		referencedStruct := p.lookupStruct(resolvedType)
+		if isBuiltIn || referencedStruct == nil || !referencedStruct.IsCollection {
+			continue
+		}
+		refs = append(refs, genericReference{field: genericField, resolvedType: resolvedType, referencedStruct: referencedStruct})
+	}
+	return refs
+}
+
+// InitReferencePaths collects the resolvable fields of a collection struct,
+// including those nested in subdocument structs at any depth
+func (s *Struct) InitReferencePaths() {
+	if s.IsCollection {
+		s.collectReferencePaths(&ReferencePath{Collection: s}, s, map[string]bool{s.Name: true})
+	}
+}
+
+func (s *Struct) collectReferencePaths(prefix *ReferencePath, current *Struct, visiting map[string]bool) {
+	fields := append(append([]*Field{}, current.EmbeddedFields...), current.Fields...)
+	for _, field := range fields {
+		if field.IsBaseModelDerivative || (!field.IsEmbedded && field.BSONName() == "") {
+			continue
+		}
+
+		path := prefix.with(field)
+		if field.IsResolvable {
+			s.ReferencePaths = append(s.ReferencePaths, path)
+			continue
+		}
+
+		subStruct := s.Parent.lookupStruct(field.ResolvedType)
+		if subStruct == nil || subStruct.IsCollection || subStruct.Parent != s.Parent || visiting[subStruct.Name] {
+			continue
+		}
+
+		visiting[subStruct.Name] = true
+		s.collectReferencePaths(path, subStruct, visiting)
+		delete(visiting, subStruct.Name)
+	}
+}
+
+func (s *Struct) InitReferencePathMethods() {
+	for _, path := range s.ReferencePaths {
+		s.initNestedResolverMethod(path)
+		s.initInverseResolverMethod(path)
+		s.initDeleteAction(path)
+	}
+}
+
+func (s *Struct) initNestedResolverMethod(path *ReferencePath) {
+	if !path.IsNested() {
+		return
+	}
+
+	for _, hop := range path.Hops() {
+		// Resolvers of embedded structs are promoted, elements of containers resolve themselves
+		if hop.IsEmbedded || hop.IsMap || hop.IsSlice {
+			return
+		}
+	}
+
+	s.ResolverMethods = append(s.ResolverMethods, path.BuildNestedResolverMethods()...)
+}
+
+func (s *Struct) initInverseResolverMethod(path *ReferencePath) {
+	field := path.Field()
+	name, ok := structTagMongogenOption(field, "inverse")
+	if !ok {
+		return
+	}
+
+	if field.ReferencedStruct.Parent != s.Parent {
+		s.logger().Warn("Skipping inverse resolver, inverting references across packages creates an import cycle", "field", path.Name())
+		return
+	}
+
+	if _, ok := path.Shape(); !ok {
+		s.logger().Warn("Skipping inverse resolver, references nested in maps of subdocuments cannot be inverted", "field", path.Name())
+		return
+	}
+
+	if name == "" {
+		name = "Find" + pluralise(s.Name) + "By" + strings.ReplaceAll(path.Name(), "_", "")
+	}
+
+	referencedStruct := field.ReferencedStruct
+	for _, m := range referencedStruct.ResolverMethods {
+		if m.Name == name {
+			utils.Fatal("Inverse resolver is declared more than once, name it explicitly with mongogen:\"inverse=Name\"", "file", referencedStruct.SourceFile, "struct", referencedStruct.Name, "method", name)
+		}
+	}
+
+	referencedStruct.removeUserDefinedMethod(name)
+	referencedStruct.ResolverMethods = append(referencedStruct.ResolverMethods, path.BuildInverseResolverMethod(name))
+}
+
 // ********** SECTION Methods ********** //
+// removeUserDefinedMethod drops a previously generated method read from the output package
+func (s *Struct) removeUserDefinedMethod(name string) {
+	methods := []*Func{}
+	for _, m := range s.UserDefinedMethods {
+		if m.Name != name {
+			methods = append(methods, m)
+		}
+	}
+	s.UserDefinedMethods = methods
+}
+
 func (s *Struct) classifyDefinedMethods() {
 	for _, f := range s.ParsedMethods {
 		// Sort
@@ -186,9 +404,12 @@ func (s *Struct) classifyDefinedMethods() {
 
 func (s *Struct) initMethods() {
 	if s.IsCollection {
-		if s.CollectionNameMethod == nil {
+		_, hasOption := s.collectionOption()
+		if s.CollectionNameMethod == nil || hasOption {
 			s.CollectionNameMethod = buildCollectionNameMethod(s)
 			s.CollectionNameMethod.Parent = s
+		} else {
+			s.checkCollectionNameMethod()
 		}
 
 		for _, m := range structDbMethods {
@@ -211,6 +432,135 @@ func (s *Struct) initMethods() {
 	}
 }
 
+// checkDuplicateBSONKeys warns about fields stored under the same document key,
+// fields of embedded structs are promoted only when inlined as the driver does
+func (s *Struct) checkDuplicateBSONKeys() {
+	keys := map[string][]string{}
+	s.collectBSONKeys(keys, "", append(append([]*Field{}, s.EmbeddedFields...), s.Fields...))
+
+	duplicates := []string{}
+	for key, fields := range keys {
+		if len(fields) > 1 {
+			duplicates = append(duplicates, key)
+		}
+	}
+
+	sort.Strings(duplicates)
+	for _, key := range duplicates {
+		s.logger().Warn("Duplicate BSON key", "key", key, "fields", strings.Join(keys[key], ", "))
+	}
+}
+
+func (s *Struct) collectBSONKeys(keys map[string][]string, prefix string, fields []*Field) {
+	for _, f := range fields {
+		name := prefix + f.InputTypesVar.Name()
+		if !f.IsEmbedded || !isBSONInline(f) {
+			if key := f.BSONName(); key != "" {
+				keys[key] = append(keys[key], name)
+			}
+			continue
+		}
+
+		resolvedType, _ := isBuiltin(f.OwnType)
+		if embedded := s.Parent.lookupStruct(resolvedType); embedded != nil {
+			embedded.collectBSONKeys(keys, name+".", append(append([]*Field{}, embedded.EmbeddedFields...), embedded.Fields...))
+			continue
+		}
+
+		// Structs of other packages, e.g. BaseModel
+		if underlying, ok := resolvedType.Underlying().(*types.Struct); ok {
+			collectTypeBSONKeys(keys, name+".", underlying)
+		}
+	}
+}
+
+func collectTypeBSONKeys(keys map[string][]string, prefix string, t *types.Struct) {
+	for i := 0; i < t.NumFields(); i++ {
+		field := t.Field(i)
+		if !field.Exported() {
+			continue
+		}
+
+		tag, _ := reflect.StructTag(t.Tag(i)).Lookup("bson")
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Embedded() && slices.Contains(strings.Split(options, ","), "inline") {
+			resolvedType, _ := isBuiltin(field.Type())
+			if underlying, ok := resolvedType.Underlying().(*types.Struct); ok {
+				collectTypeBSONKeys(keys, prefix+field.Name()+".", underlying)
+			}
+			continue
+		}
+
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = strings.ToLower(field.Name())
+		}
+		keys[name] = append(keys[name], prefix+field.Name())
+	}
+}
+
+// collectionName returns the collection of the struct, named by the collection option
+// of the embedded BaseModel or derived from the struct name
+// collectionName returns the name of the collection of the struct, named by the collection option of
+// the mongogen tag, by the CollectionName method of the output package or by the collection naming
+func (s *Struct) collectionName() string {
+	if name, ok := s.collectionOption(); ok {
+		return name
+	}
+	if name, ok := collectionNameMethodValue(s.CollectionNameMethod); ok {
+		return name
+	}
+	return applyCollectionNaming(s.Name, s.Parent.CollectionNaming)
+}
+
+func (s *Struct) collectionOption() (string, bool) {
+	for _, f := range s.EmbeddedFields {
+		if !f.IsBaseModelDerivative {
+			continue
+		}
+
+		if name, ok := structTagMongogenOption(f, "collection"); ok && name != "" {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// checkCollectionNameMethod warns when the CollectionName method kept from the output package
+// names another collection than the collection naming, documents stay in the collection of the method
+func (s *Struct) checkCollectionNameMethod() {
+	name, ok := collectionNameMethodValue(s.CollectionNameMethod)
+	configured := applyCollectionNaming(s.Name, s.Parent.CollectionNaming)
+	if !ok || name == configured || !s.Parent.CollectionNaming.IsSet() {
+		return
+	}
+
+	slog.Warn("CollectionName is kept as written in the output package and differs from the collection naming, remove it to rename the collection",
+		"file", s.CollectionNameMethod.SourceFile, "struct", s.Name, "collection", name, "configured", configured)
+}
+
+// collectionNameMethodValue returns the name returned by a CollectionName method made of a single return of a string literal
+func collectionNameMethodValue(f *Func) (string, bool) {
+	if f == nil || f.InputAST == nil || f.InputAST.Body == nil || len(f.InputAST.Body.List) != 1 {
+		return "", false
+	}
+
+	ret, ok := f.InputAST.Body.List[0].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) != 1 {
+		return "", false
+	}
+
+	lit, ok := ret.Results[0].(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+
+	name, err := strconv.Unquote(lit.Value)
+	return name, err == nil
+}
+
 func (s *Struct) sortHookMethods() {
 	nameIndex := make(map[string]int)
 	for i, n := range structHookMethodNames {
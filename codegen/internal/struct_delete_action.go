@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+
+	"github.com/jonoans/mongo-gen/utils"
+)
+
+const (
+	deleteActionCascade  = "cascade"
+	deleteActionSetNull  = "setNull"
+	deleteActionRestrict = "restrict"
+)
+
+var deleteActionMethodNames = []string{"checkDeleteRestrictions", "runDeleteActions"}
+
+type deleteAction struct {
+	Path   *ReferencePath
+	Action string
+}
+
+func (s *Struct) initDeleteAction(path *ReferencePath) {
+	field := path.Field()
+	action, ok := structTagMongogenOption(field, "onDelete")
+	if !ok {
+		return
+	}
+
+	switch action {
+	case deleteActionCascade, deleteActionRestrict:
+	case deleteActionSetNull:
+		if shape := referenceShape(field.OwnType); shape != "" && shape != "s" {
+			utils.Fatal("onDelete=setNull is not supported, only direct and slice references can be nullified", "file", s.SourceFile, "struct", s.Name, "field", path.Name())
+		}
+	default:
+		utils.Fatal("Unknown onDelete action", "file", s.SourceFile, "struct", s.Name, "field", path.Name(), "action", action)
+	}
+
+	// Dropping the action would leave dangling references behind, the tag is rejected instead
+	if field.ReferencedStruct.Parent != s.Parent {
+		utils.Fatal("onDelete is not supported across packages, the actions would create an import cycle", "file", s.SourceFile, "struct", s.Name, "field", path.Name(), "action", action)
+	}
+
+	if _, ok := path.Shape(); !ok {
+		utils.Fatal("onDelete is not supported on references nested in maps of subdocuments, they cannot be matched", "file", s.SourceFile, "struct", s.Name, "field", path.Name(), "action", action)
+	}
+
+	referencedStruct := field.ReferencedStruct
+	referencedStruct.DeleteActions = append(referencedStruct.DeleteActions, &deleteAction{Path: path, Action: action})
+}
+
+// InitDeleteActionMethods builds the methods enforcing the onDelete actions
+// of fields referencing the struct, they are run by DeleteWithCtx
+func (s *Struct) InitDeleteActionMethods() {
+	if len(s.DeleteActions) == 0 {
+		return
+	}
+
+	restrictions, actions := []ast.Stmt{}, []ast.Stmt{}
+	for _, a := range s.DeleteActions {
+		switch a.Action {
+		case deleteActionRestrict:
+			restrictions = append(restrictions, a.restrictStmt())
+		case deleteActionCascade:
+			actions = append(actions, a.cascadeStmt())
+		case deleteActionSetNull:
+			actions = append(actions, a.setNullStmt())
+		}
+	}
+
+	s.DatabaseMethods = append(s.DatabaseMethods,
+		buildDeleteActionMethod(s, deleteActionMethodNames[0], restrictions, "fails while restrict actions protect the %s"),
+		buildDeleteActionMethod(s, deleteActionMethodNames[1], actions, "applies the cascade and setNull actions of documents referencing the %s"),
+	)
+}
+
+func buildDeleteActionMethod(s *Struct, methodName string, body []ast.Stmt, docFormat string) *Func {
+	f := &Func{SourceFile: s.SourceFile, Name: methodName}
+	f.Parent = s
+
+	// Function signature
+	f.InputAST = &ast.FuncDecl{}
+	f.InputAST.Doc = newDocComment(methodName+" "+docFormat, s.Name)
+	f.InputAST.Recv = &ast.FieldList{}
+	f.InputAST.Recv.List = []*ast.Field{{Names: []*ast.Ident{ast.NewIdent("m")}, Type: &ast.StarExpr{X: &ast.Ident{Name: s.Name}}}}
+	f.InputAST.Name = ast.NewIdent(f.Name)
+	f.InputAST.Type = &ast.FuncType{}
+	f.InputAST.Type.Params = &ast.FieldList{}
+	f.InputAST.Type.Params.List = []*ast.Field{
+		{Names: []*ast.Ident{ast.NewIdent("ctx")}, Type: ast.NewIdent("context.Context")},
+	}
+	f.InputAST.Type.Results = &ast.FieldList{}
+	f.InputAST.Type.Results.List = []*ast.Field{{Type: ast.NewIdent("error")}}
+
+	// Function Body
+	f.InputAST.Body = &ast.BlockStmt{}
+	f.InputAST.Body.List = append(body, &ast.ReturnStmt{
+		Results: []ast.Expr{ast.NewIdent("nil")},
+	})
+
+	return f
+}
+
+func (a *deleteAction) returnOnError(call ast.Expr, results ...string) *ast.IfStmt {
+	lhs := []ast.Expr{}
+	for _, r := range results {
+		lhs = append(lhs, ast.NewIdent(r))
+	}
+
+	return &ast.IfStmt{
+		Init: &ast.AssignStmt{
+			Lhs: lhs,
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{call},
+		},
+		Cond: &ast.BinaryExpr{
+			X:  ast.NewIdent("err"),
+			Op: token.NEQ,
+			Y:  ast.NewIdent("nil"),
+		},
+		Body: &ast.BlockStmt{List: []ast.Stmt{
+			&ast.ReturnStmt{Results: []ast.Expr{ast.NewIdent("err")}},
+		}},
+	}
+}
+
+func (a *deleteAction) restrictStmt() ast.Stmt {
+	return a.returnOnError(&ast.CallExpr{
+		Fun: ast.NewIdent("restrictDelete"),
+		Args: []ast.Expr{
+			ast.NewIdent("ctx"),
+			newModelExpr(a.Path.Collection),
+			&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(a.Path.Name())},
+			a.Path.inverseReferenceFilter(),
+		},
+	}, "err")
+}
+
+func (a *deleteAction) cascadeStmt() ast.Stmt {
+	return a.returnOnError(&ast.CallExpr{
+		Fun: ast.NewIdent("cascadeDelete[" + a.Path.Collection.Name + "]"),
+		Args: []ast.Expr{
+			ast.NewIdent("ctx"),
+			a.Path.inverseReferenceFilter(),
+		},
+	}, "err")
+}
+
+func (a *deleteAction) setNullStmt() ast.Stmt {
+	args := []ast.Expr{ast.NewIdent("ctx"), newModelExpr(a.Path.Collection), a.Path.inverseReferenceFilter()}
+	if referenceShape(a.Path.Field().OwnType) == "s" {
+		updatePath := strconv.Quote(a.Path.UpdatePath())
+		args = append(args, ast.NewIdent("bson.M{\"$pull\": bson.M{"+updatePath+": m.GetID()}}"))
+		return a.returnOnError(&ast.CallExpr{Fun: ast.NewIdent("UpdateManyWithCtx"), Args: args}, "_", "err")
+	}
+
+	// Only the array elements holding the reference are nullified
+	updatePath, filterPath := a.Path.filteredUpdatePath()
+	args = append(args, ast.NewIdent("bson.M{\"$set\": bson.M{"+strconv.Quote(updatePath)+": nil}}"))
+	if filterPath != "" {
+		args = append(args, ast.NewIdent("options.UpdateMany().SetArrayFilters([]any{bson.M{"+strconv.Quote(filterPath)+": m.GetID()}})"))
+	}
+	return a.returnOnError(&ast.CallExpr{Fun: ast.NewIdent("UpdateManyWithCtx"), Args: args}, "_", "err")
+}
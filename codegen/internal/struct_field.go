@@ -4,9 +4,11 @@ import (
 	"go/ast"
 	"go/token"
 	"go/types"
-	"log"
 	"regexp"
+	"strconv"
 	"strings"
+
+	"github.com/jonoans/mongo-gen/utils"
 )
 
 var (
@@ -39,6 +41,7 @@ type Field struct {
 	ChildField  *Field
 
 	Name       string
+	Selector   string // Selector of fields nested in generic structs, defaults to Name
 	Type       string
 	StructTag  string
 	MapKeyType string
@@ -55,13 +58,15 @@ type Field struct {
 	IsPointer bool
 	IsSlice   bool
 
-	IsResolvable bool
-	References   *ResolverFieldReferences
+	IsResolvable     bool
+	ReferencedStruct *Struct
+	ResolverType     string // Return type of the resolver method
+	References       *ResolverFieldReferences
 }
 
 func getAlphabetLetter(i int) string {
 	if i > len(letters) {
-		log.Fatal("No. of levels > length of letters, your models are too deep")
+		utils.Fatal("No. of levels > length of letters, your models are too deep")
 	}
 	return string(letters[i])
 }
@@ -69,6 +74,9 @@ func getAlphabetLetter(i int) string {
 func (f *Field) Init() {
 	f.Name = astIdentSliceToString(f.InputAST.Names)
 	f.Type = astObjectToString(f.InputAST.Type)
+	if !f.Parent.Generated {
+		f.Type = f.Parent.Parent.qualifyModelTypes(f.InputAST.Type, f.Type, f.Parent.SourceFile)
+	}
 	f.StructTag = astObjectToString(f.InputAST.Tag)
 
 	f.OwnType = f.InputTypesVar.Type()
@@ -78,7 +86,7 @@ func (f *Field) Init() {
 	if !f.IsBuiltIn {
 		f.IsBaseModelDerivative = !structTagContainsMongogenFalse(f) && isBaseModel(f.OwnType)
 		if f.IsBaseModelDerivative && !f.IsEmbedded {
-			log.Fatal("BaseModel must be embedded")
+			utils.Fatal("BaseModel must be embedded", "file", f.Parent.SourceFile, "struct", f.Parent.Name, "field", f.Name)
 		}
 
 		if !f.IsBaseModelDerivative && !f.IsEmbedded && !isTime(f.ResolvedType) {
@@ -98,6 +106,43 @@ func (f *Field) Init() {
 	if f.IsEmbedded && !structTagContainsMongogenFalse(f) && !f.IsBaseModelDerivative {
 		f.IsBaseModelDerivative = f.checkEmbeddedIsBaseModelDerivative(f.OwnType)
 	}
+
+	if !f.Parent.Generated {
+		f.normaliseStructTag()
+	}
+}
+
+// BSONName returns the document key the driver uses for the field,
+// an empty string is returned for fields skipped with `bson:"-"`
+func (f *Field) BSONName() string {
+	tag, _ := structTagLookup(f.StructTag, "bson")
+	name := strings.Split(tag, ",")[0]
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return strings.ToLower(f.Name)
+	default:
+		return name
+	}
+}
+
+// Tag returns the value of key in the struct tag of the field
+func (f *Field) Tag(key string) string {
+	value, _ := structTagLookup(f.StructTag, key)
+	return value
+}
+
+// MongogenOption returns the value of a `key` or `key=value` option of the mongogen tag
+func (f *Field) MongogenOption(key string) string {
+	value, _ := structTagMongogenOption(f, key)
+	return value
+}
+
+// HasMongogenOption reports whether the mongogen tag of the field sets the option
+func (f *Field) HasMongogenOption(key string) bool {
+	_, ok := structTagMongogenOption(f, key)
+	return ok
 }
 
 func (f *Field) checkEmbeddedIsBaseModelDerivative(currType types.Type) bool {
@@ -120,6 +165,40 @@ func (f *Field) checkEmbeddedIsBaseModelDerivative(currType types.Type) bool {
 	return false
 }
 
+// runtimeQualifier returns the qualifier of the package storing the referenced struct,
+// references to other packages are resolved with their package functions
+func (f *Field) runtimeQualifier() string {
+	referencedStruct := f.getParent().ReferencedStruct
+	if referencedStruct == nil || referencedStruct.Parent == f.Parent.Parent {
+		return ""
+	}
+	return referencedStruct.Parent.OutputPkgName + "."
+}
+
+// DocComment returns the doc comment of the field followed by a newline
+func (f *Field) DocComment() string {
+	if f.InputAST == nil || f.InputAST.Doc == nil {
+		return ""
+	}
+	return astCommentGroupToString(f.InputAST.Doc) + "\n"
+}
+
+// LineComment returns the trailing comment of the field
+func (f *Field) LineComment() string {
+	if f.InputAST == nil {
+		return ""
+	}
+	return astCommentGroupToString(f.InputAST.Comment)
+}
+
+// selector returns the Go selector of the field from its struct
+func (f *Field) selector() string {
+	if f.Selector == "" {
+		return f.Name
+	}
+	return f.Selector
+}
+
 func (f *Field) getParent() *Field {
 	switch f.ParentField {
 	case nil:
@@ -248,6 +327,7 @@ func (f *Field) MutateResolvableFieldType() {
 // ********** SECTION Resolver Function ********** //
 func (f *Field) createResolverFieldReferences() {
 	rootFieldName := f.getParent().Name
+	rootSelector := f.getParent().selector()
 	f.References = &ResolverFieldReferences{}
 
 	if f.ParentField == nil {
@@ -256,31 +336,40 @@ func (f *Field) createResolverFieldReferences() {
 		f.References.Level = f.ParentField.References.Level + 1
 	}
 
-	f.References.RootField = "m." + rootFieldName
+	f.References.RootField = "m." + rootSelector
 	f.References.ErrorField = "m.err" + rootFieldName
 	f.References.InitBoolField = "m.init" + rootFieldName
 	f.References.ResolvedField = "m.resolved" + rootFieldName
 }
 
-func (f *Field) BuildResolverMethod() *Func {
+// BuildResolverMethods builds GetResolved_[FIELD]WithCtx, resolving the field in a span of its own,
+// and GetResolved_[FIELD] calling it with a new context
+func (f *Field) BuildResolverMethods() []*Func {
 	f.createResolverFieldReferences()
 	f.References.AssignmentVar = f.References.ResolvedField
 	f.References.IDReferenceVar = f.References.RootField
 
+	name := "GetResolved_" + f.Name
 	method := &Func{}
 	method.Parent = f.Parent
 	method.SourceFile = f.Parent.SourceFile
-	method.Name = "GetResolved_" + f.Name
+	method.Name = name + "WithCtx"
+	f.ResolverType = f.Type
 
 	// Create resolver method signature
 	resolverMethod := &ast.FuncDecl{}
+	resolverMethod.Doc = newDocComment("%s returns the %s referenced by %s, the result is cached after the first call", method.Name, f.ReferencedStruct.Name, f.selector())
 	resolverMethod.Recv = &ast.FieldList{}
 	resolverMethod.Recv.List = []*ast.Field{{
 		Names: []*ast.Ident{ast.NewIdent("m")},
-		Type:  ast.NewIdent("*" + f.Parent.Name),
+		Type:  ast.NewIdent("*" + f.Parent.receiverType()),
 	}}
 	resolverMethod.Name = ast.NewIdent(method.Name)
 	resolverMethod.Type = &ast.FuncType{}
+	resolverMethod.Type.Params = &ast.FieldList{}
+	resolverMethod.Type.Params.List = []*ast.Field{
+		{Names: []*ast.Ident{ast.NewIdent("ctx")}, Type: ast.NewIdent("context.Context")},
+	}
 	resolverMethod.Type.Results = &ast.FieldList{}
 	resolverMethod.Type.Results.List = []*ast.Field{
 		{
@@ -298,6 +387,29 @@ func (f *Field) BuildResolverMethod() *Func {
 			Cond: ast.NewIdent(f.References.InitBoolField),
 			Body: &ast.BlockStmt{List: []ast.Stmt{f.returnResolvedFieldAndError()}},
 		},
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("ctx"), ast.NewIdent("span")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{&ast.CallExpr{
+				Fun: ast.NewIdent("startResolver"),
+				Args: []ast.Expr{
+					ast.NewIdent("ctx"),
+					ast.NewIdent(strconv.Quote(f.Parent.Name)),
+					ast.NewIdent(strconv.Quote(name)),
+				},
+			}},
+		},
+		&ast.DeferStmt{Call: &ast.CallExpr{
+			Fun: &ast.FuncLit{
+				Type: &ast.FuncType{},
+				Body: &ast.BlockStmt{List: []ast.Stmt{
+					&ast.ExprStmt{X: &ast.CallExpr{
+						Fun:  ast.NewIdent("span.end"),
+						Args: []ast.Expr{ast.NewIdent("ctx"), ast.NewIdent(f.References.ErrorField)},
+					}},
+				}},
+			},
+		}},
 	}
 	resolverMethod.Body.List = append(resolverMethod.Body.List, f.buildResolverBody()...)
 	resolverMethod.Body.List = append(resolverMethod.Body.List,
@@ -306,6 +418,51 @@ func (f *Field) BuildResolverMethod() *Func {
 	)
 
 	method.InputAST = resolverMethod
+	renderMethodTemplate(resolverTemplate, method, &MethodTemplateData{Struct: f.Parent, Field: f})
+
+	doc := newDocComment("%s returns the %s referenced by %s with a new context, the result is cached after the first call", name, f.ReferencedStruct.Name, f.selector())
+	return []*Func{newResolverWrapperMethod(f.Parent, f.Parent.receiverType(), name, f.ResolverType, doc), method}
+}
+
+// newResolverWrapperMethod builds a resolver calling its WithCtx variant with a context timing out after the operation timeout
+func newResolverWrapperMethod(parent *Struct, receiverType, name, resolverType string, doc *ast.CommentGroup) *Func {
+	method := &Func{}
+	method.Parent = parent
+	method.SourceFile = parent.SourceFile
+	method.Name = name
+
+	wrapperMethod := &ast.FuncDecl{}
+	wrapperMethod.Doc = doc
+	wrapperMethod.Recv = &ast.FieldList{}
+	wrapperMethod.Recv.List = []*ast.Field{{
+		Names: []*ast.Ident{ast.NewIdent("m")},
+		Type:  ast.NewIdent("*" + receiverType),
+	}}
+	wrapperMethod.Name = ast.NewIdent(name)
+	wrapperMethod.Type = &ast.FuncType{}
+	wrapperMethod.Type.Results = &ast.FieldList{}
+	wrapperMethod.Type.Results.List = []*ast.Field{
+		{Type: ast.NewIdent(resolverType)},
+		{Type: ast.NewIdent("error")},
+	}
+
+	wrapperMethod.Body = &ast.BlockStmt{}
+	wrapperMethod.Body.List = []ast.Stmt{
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("ctx"), ast.NewIdent("cancel")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{&ast.CallExpr{Fun: ast.NewIdent("newCtx")}},
+		},
+		&ast.DeferStmt{Call: &ast.CallExpr{Fun: ast.NewIdent("cancel")}},
+		&ast.ReturnStmt{Results: []ast.Expr{
+			&ast.CallExpr{
+				Fun:  ast.NewIdent("m." + name + "WithCtx"),
+				Args: []ast.Expr{ast.NewIdent("ctx")},
+			},
+		}},
+	}
+
+	method.InputAST = wrapperMethod
 	return method
 }
 
@@ -338,13 +495,14 @@ func (f *Field) buildResolverBody() []ast.Stmt {
 				findMethod = f.findByObjectIDs
 			}
 
-			body = append(body, f.newAssignmentVar())
+			// Elements of maps are found into a local variable by beforeFindActions
+			if f.ParentField == nil || !f.ParentField.IsMap {
+				body = append(body, f.newAssignmentVar())
+			}
 			body = append(body, findMethod()...)
 			return body
 		} else if f.IsSlice && f.ChildField.ChildField == nil {
-			if f.ParentField == nil || !f.ParentField.IsPointer {
-				body = append(body, f.newAssignmentVar())
-			}
+			body = append(body, f.newAssignmentVar())
 			body = append(body, f.findByObjectIDs()...)
 			return body
 		}
@@ -359,7 +517,7 @@ func (f *Field) buildResolverBody() []ast.Stmt {
 			loopBodyFuncs := f.ChildField.buildResolverBody()
 			body = append(
 				body,
-				// f.newAssignmentVar(),
+				f.newAssignmentVar(),
 				f.forLoopMapSlice(
 					keyVar, valVar,
 					loopBodyFuncs...,
@@ -386,12 +544,10 @@ func (f *Field) buildResolverBody() []ast.Stmt {
 			body = append(body, f.newAssignmentVar())
 			alpha := getAlphabetLetter(f.References.Level)
 			f.ChildField.createResolverFieldReferences()
-			f.ChildField.References.AssignmentVar = alpha + "Assign"
+			// Results are written through the pointer so that the resolved field holds them
+			f.ChildField.References.AssignmentVar = "(*" + f.References.AssignmentVar + ")"
 			f.ChildField.References.IDReferenceVar = alpha + "ID"
-			body = append(body,
-				f.dereferenceAssignmentVarPtr(f.ChildField.References.AssignmentVar),
-				f.dereferenceIDReferenceVarPtr(f.ChildField.References.IDReferenceVar),
-			)
+			body = append(body, f.dereferenceIDReferenceVarPtr(f.ChildField.References.IDReferenceVar))
 			body = append(body, f.ChildField.buildResolverBody()...)
 			return body
 		}
@@ -418,23 +574,9 @@ func (f *Field) forLoopMapSlice(keyVar, valVar string, do ...ast.Stmt) *ast.Rang
 	return loop
 }
 
-func (f *Field) dereferenceAssignmentVarPtr(varName string) *ast.AssignStmt {
-	if !f.IsPointer {
-		log.Fatalf("ID reference variable is not a pointer: %s", f.References.IDReferenceVar)
-	}
-
-	return &ast.AssignStmt{
-		Lhs: []ast.Expr{ast.NewIdent(varName)},
-		Tok: token.DEFINE,
-		Rhs: []ast.Expr{&ast.StarExpr{
-			X: ast.NewIdent(f.References.AssignmentVar),
-		}},
-	}
-}
-
 func (f *Field) dereferenceIDReferenceVarPtr(varName string) *ast.AssignStmt {
 	if !f.IsPointer {
-		log.Fatalf("ID reference variable is not a pointer: %s", f.References.IDReferenceVar)
+		utils.Fatal("ID reference variable is not a pointer", "variable", f.References.IDReferenceVar)
 	}
 
 	return &ast.AssignStmt{
@@ -447,12 +589,31 @@ func (f *Field) dereferenceIDReferenceVarPtr(varName string) *ast.AssignStmt {
 }
 
 func (f *Field) getAssignmentToken() token.Token {
-	if strings.HasPrefix(f.References.AssignmentVar, "m.") {
+	if strings.HasPrefix(strings.TrimLeft(f.References.AssignmentVar, "(*"), "m.") {
 		return token.ASSIGN
 	}
 	return token.DEFINE
 }
 
+// assignmentLhs returns the assignment variable assigned to, a dereferenced pointer (*p) is assigned as *p
+func (f *Field) assignmentLhs() ast.Expr {
+	lhs := f.References.AssignmentVar
+	if strings.HasPrefix(lhs, "(*") && strings.HasSuffix(lhs, ")") {
+		lhs = lhs[1 : len(lhs)-1]
+	}
+	return ast.NewIdent(lhs)
+}
+
+// assignmentArg returns the assignment variable passed to the find functions,
+// the address of a dereferenced pointer (*p) is passed as p
+func (f *Field) assignmentArg() ast.Expr {
+	arg := f.References.AssignmentVar
+	if strings.HasPrefix(arg, "&(*") && strings.HasSuffix(arg, ")") {
+		arg = arg[3 : len(arg)-1]
+	}
+	return ast.NewIdent(arg)
+}
+
 func (f *Field) newTypeOfSelf() ast.Expr {
 	if f.IsMap {
 		return &ast.CallExpr{
@@ -493,7 +654,7 @@ func (f *Field) newTypeOfSelf() ast.Expr {
 func (f *Field) newAssignmentNil() *ast.AssignStmt {
 	assignmentToken := f.getAssignmentToken()
 	return &ast.AssignStmt{
-		Lhs: []ast.Expr{ast.NewIdent(f.References.AssignmentVar)},
+		Lhs: []ast.Expr{f.assignmentLhs()},
 		Tok: assignmentToken,
 		Rhs: []ast.Expr{ast.NewIdent("nil")},
 	}
@@ -502,7 +663,7 @@ func (f *Field) newAssignmentNil() *ast.AssignStmt {
 func (f *Field) newAssignmentVar() *ast.AssignStmt {
 	assignmentToken := f.getAssignmentToken()
 	return &ast.AssignStmt{
-		Lhs: []ast.Expr{ast.NewIdent(f.References.AssignmentVar)},
+		Lhs: []ast.Expr{f.assignmentLhs()},
 		Tok: assignmentToken,
 		Rhs: []ast.Expr{f.newTypeOfSelf()},
 	}
@@ -572,9 +733,10 @@ func (f *Field) findByObjectID() []ast.Stmt {
 			Lhs: []ast.Expr{ast.NewIdent(f.References.ErrorField)},
 			Rhs: []ast.Expr{
 				&ast.CallExpr{
-					Fun: ast.NewIdent("FindByObjectID"),
+					Fun: ast.NewIdent(f.runtimeQualifier() + "FindByObjectIDWithCtx"),
 					Args: []ast.Expr{
-						ast.NewIdent(f.References.AssignmentVar),
+						ast.NewIdent("ctx"),
+						f.assignmentArg(),
 						ast.NewIdent(f.References.IDReferenceVar),
 					},
 				},
@@ -615,9 +777,10 @@ func (f *Field) findByObjectIDs() []ast.Stmt {
 			Lhs: []ast.Expr{ast.NewIdent(f.References.ErrorField)},
 			Rhs: []ast.Expr{
 				&ast.CallExpr{
-					Fun: ast.NewIdent("FindByObjectIDs"),
+					Fun: ast.NewIdent(f.runtimeQualifier() + "FindByObjectIDsWithCtx"),
 					Args: []ast.Expr{
-						ast.NewIdent(f.References.AssignmentVar),
+						ast.NewIdent("ctx"),
+						f.assignmentArg(),
 						ast.NewIdent(f.References.IDReferenceVar),
 					},
 				},
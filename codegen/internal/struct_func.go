@@ -0,0 +1,282 @@
+package internal
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+)
+
+type structCollectionFunc struct {
+	namePrefix     string
+	globalFuncName string
+	params         []*structDbMethodParam
+	retTypes       []string
+}
+
+var structCollectionFuncs = []*structCollectionFunc{
+	{"Count", "CountWithCtx", []*structDbMethodParam{
+		{"ctx", "context.Context", "ctx"},
+		{"", "ModelInterface", "m"},
+		{"filter", "any", "filter"},
+		{"opts", "...options.Lister[options.CountOptions]", "opts..."},
+	}, []string{"int64", "error"}},
+	{"EstimatedCount", "EstimatedDocumentCountWithCtx", []*structDbMethodParam{
+		{"ctx", "context.Context", "ctx"},
+		{"", "ModelInterface", "m"},
+		{"opts", "...options.Lister[options.EstimatedDocumentCountOptions]", "opts..."},
+	}, []string{"int64", "error"}},
+	{"Exists", "ExistsWithCtx", []*structDbMethodParam{
+		{"ctx", "context.Context", "ctx"},
+		{"", "ModelInterface", "m"},
+		{"filter", "any", "filter"},
+	}, []string{"bool", "error"}},
+}
+
+// InitCollectionFuncs builds the typed package level functions of a collection struct,
+// it must be called after the resolvable field types have been mutated
+func (s *Struct) InitCollectionFuncs() {
+	if !s.IsCollection {
+		return
+	}
+
+	for _, fn := range structCollectionFuncs {
+		s.CollectionFuncs = append(s.CollectionFuncs, buildCollectionFunc(s, fn))
+	}
+
+	for _, field := range s.Fields {
+		if field.BSONName() == "" || !s.isDistinctField(field) {
+			continue
+		}
+		s.CollectionFuncs = append(s.CollectionFuncs, buildDistinctFunc(s, field))
+	}
+
+	changeEventType := buildChangeEventType(s)
+	s.CollectionTypes = append(s.CollectionTypes, changeEventType)
+	s.CollectionFuncs = append(s.CollectionFuncs, buildWatchFunc(s, changeEventType.Name.Name))
+}
+
+func newModelExpr(s *Struct) ast.Expr {
+	return &ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(s.Name + "{}")}
+}
+
+func buildCollectionFunc(s *Struct, fnInfo *structCollectionFunc) *Func {
+	funcParams := []*ast.Field{}
+	for _, param := range fnInfo.params {
+		if param.paramName == "" {
+			continue
+		}
+
+		funcParams = append(funcParams, &ast.Field{
+			Names: []*ast.Ident{ast.NewIdent(param.paramName)},
+			Type:  ast.NewIdent(param.paramType),
+		})
+	}
+
+	funcRetTypes := []*ast.Field{}
+	for _, retType := range fnInfo.retTypes {
+		funcRetTypes = append(funcRetTypes, &ast.Field{Type: ast.NewIdent(retType)})
+	}
+
+	funcArgs := []ast.Expr{}
+	for _, param := range fnInfo.params {
+		if param.paramName == "" {
+			funcArgs = append(funcArgs, newModelExpr(s))
+			continue
+		}
+		funcArgs = append(funcArgs, ast.NewIdent(param.argUsage))
+	}
+
+	f := &Func{SourceFile: s.SourceFile, Name: fnInfo.namePrefix + pluralise(s.Name)}
+	f.Parent = s
+
+	// Function signature
+	f.InputAST = &ast.FuncDecl{}
+	f.InputAST.Doc = newDocComment("%s runs %s on the %s collection", f.Name, fnInfo.globalFuncName, s.Name)
+	f.InputAST.Name = ast.NewIdent(f.Name)
+	f.InputAST.Type = &ast.FuncType{}
+	f.InputAST.Type.Params = &ast.FieldList{}
+	f.InputAST.Type.Params.List = funcParams
+	f.InputAST.Type.Results = &ast.FieldList{}
+	f.InputAST.Type.Results.List = funcRetTypes
+
+	// Function Body
+	f.InputAST.Body = &ast.BlockStmt{}
+	f.InputAST.Body.List = []ast.Stmt{
+		&ast.ReturnStmt{
+			Results: []ast.Expr{
+				&ast.CallExpr{
+					Fun:  ast.NewIdent(fnInfo.globalFuncName),
+					Args: funcArgs,
+				},
+			},
+		},
+	}
+
+	return f
+}
+
+// distinctValueType returns the type of a single value returned by the distinct command,
+// array fields are flattened by the server while byte slices are stored as binary values
+func distinctValueType(field *Field) string {
+	valueType := strings.TrimLeft(field.Type, "*")
+	if match := sliceTypeRegex.FindStringSubmatch(valueType); match != nil && !isBytes(field.OwnType) {
+		return match[1]
+	}
+	return valueType
+}
+
+// isDistinctField reports whether the values of the field are scalars, subdocuments and maps are compared
+// as whole documents by the distinct command and get no Distinct function
+func (s *Struct) isDistinctField(field *Field) bool {
+	t := derefType(field.OwnType)
+	if !isBytes(t) {
+		switch x := t.Underlying().(type) {
+		case *types.Slice:
+			t = derefType(x.Elem())
+		case *types.Array:
+			t = derefType(x.Elem())
+		}
+	}
+
+	switch t.Underlying().(type) {
+	case *types.Map:
+		return false
+	case *types.Struct:
+		// References are stored as ObjectIDs
+		if referenced := s.Parent.lookupStruct(t); referenced != nil && referenced.IsCollection {
+			return true
+		}
+		return isTime(t) || t.String() == "go.mongodb.org/mongo-driver/v2/bson.Decimal128"
+	default:
+		return true
+	}
+}
+
+// isBytes reports whether t is a byte slice or array, stored as a binary value
+func isBytes(t types.Type) bool {
+	switch x := derefType(t).Underlying().(type) {
+	case *types.Slice:
+		return isByte(x.Elem())
+	case *types.Array:
+		return isByte(x.Elem())
+	default:
+		return false
+	}
+}
+
+func derefType(t types.Type) types.Type {
+	if pointer, ok := types.Unalias(t).(*types.Pointer); ok {
+		return types.Unalias(pointer.Elem())
+	}
+	return types.Unalias(t)
+}
+
+func buildDistinctFunc(s *Struct, field *Field) *Func {
+	resultsType := "[]" + distinctValueType(field)
+
+	f := &Func{SourceFile: s.SourceFile, Name: "Distinct" + s.Name + field.Name}
+	f.Parent = s
+
+	// Function signature
+	f.InputAST = &ast.FuncDecl{}
+	f.InputAST.Doc = newDocComment("%s returns the distinct values of %s in the %s documents matching filter", f.Name, field.Name, s.Name)
+	f.InputAST.Name = ast.NewIdent(f.Name)
+	f.InputAST.Type = &ast.FuncType{}
+	f.InputAST.Type.Params = &ast.FieldList{}
+	f.InputAST.Type.Params.List = []*ast.Field{
+		{Names: []*ast.Ident{ast.NewIdent("ctx")}, Type: ast.NewIdent("context.Context")},
+		{Names: []*ast.Ident{ast.NewIdent("filter")}, Type: ast.NewIdent("any")},
+		{Names: []*ast.Ident{ast.NewIdent("opts")}, Type: ast.NewIdent("...options.Lister[options.DistinctOptions]")},
+	}
+	f.InputAST.Type.Results = &ast.FieldList{}
+	f.InputAST.Type.Results.List = []*ast.Field{
+		{Type: ast.NewIdent(resultsType)},
+		{Type: ast.NewIdent("error")},
+	}
+
+	// Function Body
+	f.InputAST.Body = &ast.BlockStmt{}
+	f.InputAST.Body.List = []ast.Stmt{
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("results")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{ast.NewIdent(resultsType + "{}")},
+		},
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("err")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{
+				&ast.CallExpr{
+					Fun: ast.NewIdent("DistinctWithCtx"),
+					Args: []ast.Expr{
+						ast.NewIdent("ctx"),
+						newModelExpr(s),
+						&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(field.BSONName())},
+						ast.NewIdent("filter"),
+						&ast.UnaryExpr{Op: token.AND, X: ast.NewIdent("results")},
+						ast.NewIdent("opts..."),
+					},
+				},
+			},
+		},
+		&ast.ReturnStmt{
+			Results: []ast.Expr{
+				ast.NewIdent("results"),
+				ast.NewIdent("err"),
+			},
+		},
+	}
+
+	return f
+}
+
+func buildChangeEventType(s *Struct) *ast.TypeSpec {
+	return &ast.TypeSpec{
+		Name:   ast.NewIdent(s.Name + "Change"),
+		Assign: 1,
+		Type:   ast.NewIdent("ChangeEvent[" + s.Name + "]"),
+	}
+}
+
+func buildWatchFunc(s *Struct, changeEventType string) *Func {
+	f := &Func{SourceFile: s.SourceFile, Name: "Watch" + pluralise(s.Name)}
+	f.Parent = s
+
+	// Function signature
+	f.InputAST = &ast.FuncDecl{}
+	f.InputAST.Doc = newDocComment("%s subscribes to the change stream of the %s collection", f.Name, s.Name)
+	f.InputAST.Name = ast.NewIdent(f.Name)
+	f.InputAST.Type = &ast.FuncType{}
+	f.InputAST.Type.Params = &ast.FieldList{}
+	f.InputAST.Type.Params.List = []*ast.Field{
+		{Names: []*ast.Ident{ast.NewIdent("ctx")}, Type: ast.NewIdent("context.Context")},
+		{Names: []*ast.Ident{ast.NewIdent("pipeline")}, Type: ast.NewIdent("any")},
+		{Names: []*ast.Ident{ast.NewIdent("opts")}, Type: ast.NewIdent("*WatchOptions")},
+	}
+	f.InputAST.Type.Results = &ast.FieldList{}
+	f.InputAST.Type.Results.List = []*ast.Field{
+		{Type: ast.NewIdent("<-chan " + changeEventType)},
+		{Type: ast.NewIdent("error")},
+	}
+
+	// Function Body
+	f.InputAST.Body = &ast.BlockStmt{}
+	f.InputAST.Body.List = []ast.Stmt{
+		&ast.ReturnStmt{
+			Results: []ast.Expr{
+				&ast.CallExpr{
+					Fun: ast.NewIdent("Watch[" + s.Name + "]"),
+					Args: []ast.Expr{
+						ast.NewIdent("ctx"),
+						ast.NewIdent("pipeline"),
+						ast.NewIdent("opts"),
+					},
+				},
+			},
+		},
+	}
+
+	return f
+}
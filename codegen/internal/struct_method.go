@@ -5,8 +5,6 @@ import (
 	"go/token"
 	"strconv"
 	"strings"
-
-	"github.com/iancoleman/strcase"
 )
 
 var (
@@ -34,6 +32,8 @@ func InitReservedValues(reservedFuncValues []string) {
 	// Initialiase reserved method names
 	reservedMethodNames = append(reservedMethodNames, structHookMethodNames...)
 	reservedMethodNames = append(reservedMethodNames, structDatabaseMethodNames...)
+	reservedMethodNames = append(reservedMethodNames, deleteActionMethodNames...)
+	reservedMethodNames = append(reservedMethodNames, validateRulesMethodName)
 
 }
 
@@ -142,6 +142,7 @@ func buildCollectionNameMethod(s *Struct) *Func {
 
 	// Function signature
 	f.InputAST = &ast.FuncDecl{}
+	f.InputAST.Doc = newDocComment("CollectionName returns the name of the collection storing %s documents", s.Name)
 	f.InputAST.Recv = &ast.FieldList{}
 	f.InputAST.Recv.List = []*ast.Field{{Type: &ast.StarExpr{X: &ast.Ident{Name: s.Name}}}}
 	f.InputAST.Name = ast.NewIdent(f.Name)
@@ -150,7 +151,7 @@ func buildCollectionNameMethod(s *Struct) *Func {
 	f.InputAST.Type.Results.List = []*ast.Field{{Type: ast.NewIdent("string")}}
 
 	// Function Body
-	collectionName := strcase.ToLowerCamel(s.Name)
+	collectionName := s.collectionName()
 	f.InputAST.Body = &ast.BlockStmt{}
 	f.InputAST.Body.List = []ast.Stmt{
 		&ast.ReturnStmt{
@@ -163,6 +164,7 @@ func buildCollectionNameMethod(s *Struct) *Func {
 		},
 	}
 
+	renderMethodTemplate(collectionNameTemplate, f, &MethodTemplateData{Struct: s})
 	return f
 }
 
@@ -194,6 +196,7 @@ func buildDatabaseMethod(s *Struct, dbMethodInfo *structDbMethod) *Func {
 
 	// Function signature
 	f.InputAST = &ast.FuncDecl{}
+	f.InputAST.Doc = newDocComment("%s runs %s on the %s", f.Name, dbMethodInfo.globalFuncName, s.Name)
 	f.InputAST.Recv = &ast.FieldList{}
 	f.InputAST.Recv.List = []*ast.Field{{Names: []*ast.Ident{ast.NewIdent("m")}, Type: &ast.StarExpr{X: &ast.Ident{Name: s.Name}}}}
 	f.InputAST.Name = ast.NewIdent(f.Name)
@@ -216,6 +219,7 @@ func buildDatabaseMethod(s *Struct, dbMethodInfo *structDbMethod) *Func {
 		},
 	}
 
+	renderMethodTemplate(databaseMethodTemplate, f, &MethodTemplateData{Struct: s, Target: dbMethodInfo.globalFuncName})
 	return f
 }
 
@@ -225,6 +229,7 @@ func buildHookMethod(s *Struct, methodName string) *Func {
 
 	// Function signature
 	f.InputAST = &ast.FuncDecl{}
+	f.InputAST.Doc = newDocComment("%s is the %s hook of %s, a returned error is wrapped in a *HookError", f.Name, f.Name, s.Name)
 	f.InputAST.Recv = &ast.FieldList{}
 	f.InputAST.Recv.List = []*ast.Field{{Names: []*ast.Ident{ast.NewIdent("m")}, Type: &ast.StarExpr{X: &ast.Ident{Name: s.Name}}}}
 	f.InputAST.Name = ast.NewIdent(f.Name)
@@ -242,5 +247,6 @@ func buildHookMethod(s *Struct, methodName string) *Func {
 		},
 	}
 
+	renderMethodTemplate(hookTemplate, f, &MethodTemplateData{Struct: s})
 	return f
 }
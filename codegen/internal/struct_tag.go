@@ -0,0 +1,200 @@
+package internal
+
+import (
+	"go/types"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jonoans/mongo-gen/config"
+)
+
+// structTag is a parsed struct tag keeping the order of its keys
+type structTag struct {
+	keys   []string
+	values map[string]string
+}
+
+// parseStructTag parses a conventional struct tag as reflect.StructTag.Lookup does,
+// false is returned for malformed tags
+func parseStructTag(tag string) (*structTag, bool) {
+	if unquoted, err := strconv.Unquote(tag); err == nil {
+		tag = unquoted
+	}
+
+	t := &structTag{values: map[string]string{}}
+	for {
+		tag = strings.TrimLeft(tag, " ")
+		if tag == "" {
+			return t, true
+		}
+
+		i := 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			return nil, false
+		}
+		key := tag[:i]
+		tag = tag[i+1:]
+
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			return nil, false
+		}
+
+		value, err := strconv.Unquote(tag[:i+1])
+		if err != nil {
+			return nil, false
+		}
+		tag = tag[i+1:]
+		t.set(key, value)
+	}
+}
+
+func (t *structTag) get(key string) (string, bool) {
+	value, ok := t.values[key]
+	return value, ok
+}
+
+func (t *structTag) set(key, value string) {
+	if _, ok := t.values[key]; !ok {
+		t.keys = append(t.keys, key)
+	}
+	t.values[key] = value
+}
+
+// String renders the tag as a raw string literal, bson and json come first
+func (t *structTag) String() string {
+	if len(t.keys) == 0 {
+		return ""
+	}
+
+	keys := []string{}
+	for _, key := range []string{"bson", "json"} {
+		if _, ok := t.values[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range t.keys {
+		if key != "bson" && key != "json" {
+			keys = append(keys, key)
+		}
+	}
+
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = key + ":" + strconv.Quote(t.values[key])
+	}
+	return "`" + strings.Join(parts, " ") + "`"
+}
+
+// normaliseStructTag adds the bson and json tags configured for the output package,
+// names written in the input tags are kept as they are
+func (f *Field) normaliseStructTag() {
+	cfg := f.Parent.Parent.Tags
+	if !cfg.BSON && !cfg.JSON {
+		return
+	}
+
+	tag, ok := parseStructTag(f.StructTag)
+	if !ok {
+		f.Parent.logger().Warn("Could not parse the struct tag, the tag is kept as written", "field", f.InputTypesVar.Name())
+		return
+	}
+
+	if f.IsEmbedded {
+		// The driver stores embedded structs as subdocuments unless inlined
+		if _, ok := tag.get("bson"); cfg.BSON && !ok && isStruct(f.OwnType) {
+			tag.set("bson", ",inline")
+		}
+		f.StructTag = tag.String()
+		return
+	}
+
+	bsonTag, _ := tag.get("bson")
+	if cfg.BSON {
+		tag.set("bson", f.normaliseTagValue(bsonTag))
+	}
+	if cfg.JSON {
+		jsonTag, ok := tag.get("json")
+		if !ok && bsonTag == "-" {
+			jsonTag = "-"
+		}
+		tag.set("json", f.normaliseTagValue(jsonTag))
+	}
+	f.StructTag = tag.String()
+}
+
+// normaliseTagValue names a bson or json tag value after the field and adds omitempty per type
+func (f *Field) normaliseTagValue(value string) string {
+	name, options, _ := strings.Cut(value, ",")
+	if name != "" {
+		return value
+	}
+
+	cfg := f.Parent.Parent.Tags
+	name = applyNamingCase(f.InputTypesVar.Name(), cfg.Case, config.CaseLower)
+	if omitsEmpty(f.OwnType, cfg.OmitEmpty) && !slices.Contains(strings.Split(options, ","), "omitempty") {
+		options = strings.Trim(options+",omitempty", ",")
+	}
+
+	if options == "" {
+		return name
+	}
+	return name + "," + options
+}
+
+// omitsEmpty reports whether a policy lists the kind of t, e.g. pointer or string, or the type itself, e.g. time.Time
+func omitsEmpty(t types.Type, policies []string) bool {
+	kind := typeKind(t)
+	qualified := types.TypeString(t, func(p *types.Package) string { return p.Name() })
+	for _, policy := range policies {
+		if policy == kind || policy == qualified || policy == t.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func typeKind(t types.Type) string {
+	switch x := t.Underlying().(type) {
+	case *types.Pointer:
+		return config.OmitEmptyPointer
+	case *types.Slice:
+		return config.OmitEmptySlice
+	case *types.Map:
+		return config.OmitEmptyMap
+	case *types.Array:
+		return config.OmitEmptyArray
+	case *types.Interface:
+		return config.OmitEmptyInterface
+	case *types.Struct:
+		return config.OmitEmptyStruct
+	case *types.Basic:
+		switch {
+		case x.Info()&types.IsString != 0:
+			return config.OmitEmptyString
+		case x.Info()&types.IsBoolean != 0:
+			return config.OmitEmptyBool
+		case x.Info()&types.IsNumeric != 0:
+			return config.OmitEmptyNumber
+		}
+	}
+	return ""
+}
+
+func isStruct(t types.Type) bool {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	_, ok := t.Underlying().(*types.Struct)
+	return ok
+}
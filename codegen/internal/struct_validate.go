@@ -0,0 +1,310 @@
+package internal
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jonoans/mongo-gen/utils"
+)
+
+// validationRuleNames are the options of the mongogen tag checked by Validate
+var validationRuleNames = []string{"required", "min", "max", "regex", "enum"}
+
+// validateRulesMethodName is the generated method checking the rules, called by Validate
+const validateRulesMethodName = "validateRules"
+
+// InitValidateMethods builds the validateRules method of structs with rules or validatable subdocuments
+// and the Validate method calling it. A Validate method written in the output package is kept
+func (s *Struct) InitValidateMethods() {
+	userValidate := s.userValidateMethod()
+	if userValidate == nil {
+		// Previously generated
+		s.removeUserDefinedMethod("Validate")
+	}
+
+	checks := s.validateStmts(s.validateFields(), "", "m.", map[*Struct]bool{})
+	callsRules := userValidate != nil && callsMethod(userValidate.InputAST.Body, validateRulesMethodName)
+	if len(checks) == 0 && !callsRules {
+		return
+	}
+
+	if userValidate == nil {
+		s.ValidateMethods = append(s.ValidateMethods, s.buildValidateMethod())
+	} else if !callsRules {
+		slog.Warn("Validate is kept as written in the output package and does not check the mongogen rules, call m.validateRules() from it",
+			"file", userValidate.SourceFile, "struct", s.Name)
+	}
+
+	f := &Func{SourceFile: s.SourceFile, Name: validateRulesMethodName}
+	f.Parent = s
+
+	// Function signature
+	f.InputAST = &ast.FuncDecl{}
+	f.InputAST.Doc = newDocComment("%s checks the fields of %s against the rules of their mongogen tags,\nbroken rules are returned in a *ValidationError", f.Name, s.Name)
+	f.InputAST.Recv = &ast.FieldList{}
+	f.InputAST.Recv.List = []*ast.Field{{Names: []*ast.Ident{ast.NewIdent("m")}, Type: ast.NewIdent("*" + s.receiverType())}}
+	f.InputAST.Name = ast.NewIdent(f.Name)
+	f.InputAST.Type = &ast.FuncType{}
+	f.InputAST.Type.Results = &ast.FieldList{}
+	f.InputAST.Type.Results.List = []*ast.Field{{Type: ast.NewIdent("error")}}
+
+	// Function Body
+	f.InputAST.Body = &ast.BlockStmt{}
+	s.ValidateMethods = append(s.ValidateMethods, f)
+	if len(checks) == 0 {
+		f.InputAST.Body.List = []ast.Stmt{&ast.ReturnStmt{Results: []ast.Expr{ast.NewIdent("nil")}}}
+		return
+	}
+
+	f.InputAST.Body.List = append(f.InputAST.Body.List,
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{X: ast.NewIdent("m"), Op: token.EQL, Y: ast.NewIdent("nil")},
+			Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ReturnStmt{Results: []ast.Expr{ast.NewIdent("nil")}}}},
+		},
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("v")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{&ast.UnaryExpr{Op: token.AND, X: &ast.CompositeLit{Type: ast.NewIdent("validation")}}},
+		},
+	)
+	f.InputAST.Body.List = append(f.InputAST.Body.List, checks...)
+	f.InputAST.Body.List = append(f.InputAST.Body.List, &ast.ReturnStmt{Results: []ast.Expr{
+		&ast.CallExpr{Fun: ast.NewIdent("v.err")},
+	}})
+}
+
+// buildValidateMethod builds the Validate method run before models are written, calling validateRules
+func (s *Struct) buildValidateMethod() *Func {
+	f := &Func{SourceFile: s.SourceFile, Name: "Validate"}
+	f.Parent = s
+
+	f.InputAST = &ast.FuncDecl{}
+	f.InputAST.Doc = newDocComment("Validate checks the rules of %s before it is written, write Validate in the output package\nto add checks of your own and call m.%s() from it", s.Name, validateRulesMethodName)
+	f.InputAST.Recv = &ast.FieldList{}
+	f.InputAST.Recv.List = []*ast.Field{{Names: []*ast.Ident{ast.NewIdent("m")}, Type: ast.NewIdent("*" + s.receiverType())}}
+	f.InputAST.Name = ast.NewIdent(f.Name)
+	f.InputAST.Type = &ast.FuncType{}
+	f.InputAST.Type.Results = &ast.FieldList{}
+	f.InputAST.Type.Results.List = []*ast.Field{{Type: ast.NewIdent("error")}}
+	f.InputAST.Body = &ast.BlockStmt{List: []ast.Stmt{&ast.ReturnStmt{Results: []ast.Expr{
+		&ast.CallExpr{Fun: ast.NewIdent("m." + validateRulesMethodName)},
+	}}}}
+	return f
+}
+
+// userValidateMethod returns the Validate method written in the output package, nil when
+// there is none or when it is the generated one made of a single call of validateRules
+func (s *Struct) userValidateMethod() *Func {
+	for _, m := range s.UserDefinedMethods {
+		if m.Name != "Validate" || m.InputAST == nil || m.InputAST.Body == nil {
+			continue
+		}
+
+		body := m.InputAST.Body.List
+		if len(body) == 1 {
+			if ret, ok := body[0].(*ast.ReturnStmt); ok && len(ret.Results) == 1 {
+				if call, ok := ret.Results[0].(*ast.CallExpr); ok && len(call.Args) == 0 {
+					if sel, ok := call.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == validateRulesMethodName {
+						return nil
+					}
+				}
+			}
+		}
+		return m
+	}
+	return nil
+}
+
+// validateFields returns the fields of the struct checked by validateRules in declaration order
+func (s *Struct) validateFields() []schemaField {
+	fields := append(append([]*Field{}, s.EmbeddedFields...), s.Fields...)
+	sort.SliceStable(fields, func(i, j int) bool {
+		return fields[i].InputTypesVar.Pos() < fields[j].InputTypesVar.Pos()
+	})
+
+	validateFields := []schemaField{}
+	for _, f := range fields {
+		if !f.IsBaseModelDerivative {
+			validateFields = append(validateFields, schemaField{name: f.InputTypesVar.Name(), tag: f.StructTag, t: f.OwnType, embedded: f.IsEmbedded})
+		}
+	}
+	return validateFields
+}
+
+// validates reports whether the struct has a Validate method, written in the output package
+// or generated for its rules and the rules of its subdocuments
+func (s *Struct) validates(visiting map[*Struct]bool) bool {
+	if s.IsCollection || s.Generated || visiting[s] {
+		return false
+	}
+	if s.userValidateMethod() != nil {
+		return true
+	}
+
+	visiting[s] = true
+	defer delete(visiting, s)
+	return len(s.validateStmts(s.validateFields(), "", "m.", visiting)) > 0
+}
+
+func callsMethod(node ast.Node, name string) bool {
+	found := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok && sel.Sel.Name == name {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// validateStmts checks the rules of fields read through selector, stored under the path prefix.
+// Fields of anonymous structs are checked in place, subdocuments by their own Validate method
+func (s *Struct) validateStmts(fields []schemaField, prefix, selector string, visiting map[*Struct]bool) []ast.Stmt {
+	stmts := []ast.Stmt{}
+	for _, field := range fields {
+		tag, _ := structTagLookup(field.tag, "bson")
+		options := strings.Split(tag, ",")
+		key := options[0]
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = strings.ToLower(field.name)
+		}
+
+		path := prefix + key
+		if field.embedded && utils.Contains(options[1:], "inline") {
+			path = strings.TrimSuffix(prefix, ".")
+		}
+		value := selector + field.name
+
+		for _, option := range mongogenOptions(field.tag) {
+			if utils.Contains(validationRuleNames, option[0]) {
+				stmts = append(stmts, s.validateRuleStmt(field, option[0], option[1], path, value))
+			}
+		}
+
+		if anonymous, ok := types.Unalias(field.t).(*types.Struct); ok {
+			stmts = append(stmts, s.validateStmts(anonymousStructFields(anonymous), path+".", value+".", visiting)...)
+			continue
+		}
+
+		if s.Parent.hasValidateMethod(field.t, visiting) {
+			stmts = append(stmts, validateCallStmt("nested", path, &ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(value)}))
+		}
+	}
+	return stmts
+}
+
+// validateRuleStmt checks a rule of the field, rules which cannot apply to its type stop the generation
+func (s *Struct) validateRuleStmt(field schemaField, rule, ruleValue, path, value string) ast.Stmt {
+	fatal := func(msg string, args ...any) {
+		utils.Fatal(msg, append([]any{"file", s.SourceFile, "struct", s.Name, "field", field.name, "rule", rule}, args...)...)
+	}
+
+	kind := validationKind(field.t)
+	args := []ast.Expr{ast.NewIdent(value)}
+	switch rule {
+	case "min", "max":
+		if kind == "" {
+			fatal("Rule only applies to numbers, strings, slices and maps")
+		}
+		limit, err := strconv.ParseFloat(ruleValue, 64)
+		if err != nil {
+			fatal("Rule expects a number", "value", ruleValue)
+		}
+		args = append(args, &ast.BasicLit{Kind: token.FLOAT, Value: strconv.FormatFloat(limit, 'g', -1, 64)})
+	case "regex":
+		if kind != "string" {
+			fatal("Rule only applies to strings")
+		}
+		if _, err := regexp.Compile(ruleValue); err != nil {
+			fatal("Rule expects a regular expression", "value", ruleValue, "error", err)
+		}
+		args = append(args, &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(ruleValue)})
+	case "enum":
+		if kind != "string" && kind != "number" {
+			fatal("Rule only applies to strings and numbers")
+		}
+		if ruleValue == "" {
+			fatal("Rule expects values separated by |")
+		}
+		for _, allowed := range strings.Split(ruleValue, "|") {
+			if _, err := strconv.ParseFloat(allowed, 64); kind == "number" && err != nil {
+				fatal("Rule expects numbers", "value", allowed)
+			}
+			args = append(args, &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(allowed)})
+		}
+	}
+	return validateCallStmt(rule, path, args...)
+}
+
+func validateCallStmt(method, path string, args ...ast.Expr) ast.Stmt {
+	return &ast.ExprStmt{X: &ast.CallExpr{
+		Fun:  ast.NewIdent("v." + method),
+		Args: append([]ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(path)}}, args...),
+	}}
+}
+
+func anonymousStructFields(t *types.Struct) []schemaField {
+	fields := []schemaField{}
+	for i := 0; i < t.NumFields(); i++ {
+		if v := t.Field(i); v.Exported() {
+			fields = append(fields, schemaField{name: v.Name(), tag: t.Tag(i), t: v.Type(), embedded: v.Embedded()})
+		}
+	}
+	return fields
+}
+
+// validationKind returns how min and max measure the values of t: "number", "string"
+// for their length in characters or "length" for the elements of slices and maps
+func validationKind(t types.Type) string {
+	for {
+		pointer, ok := t.Underlying().(*types.Pointer)
+		if !ok {
+			break
+		}
+		t = pointer.Elem()
+	}
+
+	switch x := t.Underlying().(type) {
+	case *types.Basic:
+		switch info := x.Info(); {
+		case info&types.IsString != 0:
+			return "string"
+		case info&(types.IsInteger|types.IsFloat) != 0:
+			return "number"
+		}
+	case *types.Slice, *types.Array, *types.Map:
+		return "length"
+	}
+	return ""
+}
+
+// hasValidateMethod reports whether the values of t hold subdocuments with a Validate method,
+// type parameters are checked when validating as their type arguments may have one
+func (p *Package) hasValidateMethod(t types.Type, visiting map[*Struct]bool) bool {
+	switch x := types.Unalias(t).(type) {
+	case *types.Pointer:
+		return p.hasValidateMethod(x.Elem(), visiting)
+	case *types.Slice:
+		return p.hasValidateMethod(x.Elem(), visiting)
+	case *types.Array:
+		return p.hasValidateMethod(x.Elem(), visiting)
+	case *types.Map:
+		return p.hasValidateMethod(x.Elem(), visiting)
+	case *types.TypeParam:
+		return true
+	case *types.Named:
+		s := p.lookupStruct(x)
+		return s != nil && s.validates(visiting)
+	default:
+		return false
+	}
+}
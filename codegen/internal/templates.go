@@ -1,43 +1,112 @@
 package internal
 
 import (
-	"io/ioutil"
-	"log"
+	"bytes"
+	"embed"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"text/template"
 
 	"github.com/jonoans/mongo-gen/utils"
 )
 
+//go:embed *.gotmpl
+var embeddedTemplates embed.FS
+
 var templateFiles = map[string]*template.Template{}
 
-func ReadAllTemplateFiles() {
-	myPath := utils.GetMyPath()
-	matches, err := filepath.Glob(filepath.Join(myPath, "*.gotmpl"))
+// Method templates, each is optional and replaces the code generated for the method
+const (
+	collectionNameTemplate = "collection_name"
+	hookTemplate           = "hook"
+	databaseMethodTemplate = "database_method"
+	resolverTemplate       = "resolver"
+)
+
+// MethodTemplateData is passed to the method templates
+type MethodTemplateData struct {
+	Struct  *Struct
+	Field   *Field         // Resolved field, resolver templates only
+	Path    *ReferencePath // Path of resolvers of nested references
+	Name    string         // Name of the generated method
+	Target  string         // Package function called by database methods
+	Default string         // Method generated when no template is provided
+}
+
+var templateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"quote": strconv.Quote,
+}
+
+// ReadAllTemplateFiles parses the embedded templates, templates found in
+// overrideDir replace the embedded ones of the same name or add method templates
+func ReadAllTemplateFiles(overrideDir string) {
+	readTemplateFiles(embeddedTemplates)
+	if overrideDir != "" {
+		readTemplateFiles(os.DirFS(overrideDir))
+	}
+}
+
+func readTemplateFiles(fsys fs.FS) {
+	matches, err := fs.Glob(fsys, "*.gotmpl")
 	if err != nil {
-		log.Fatalf("Error reading template files: %s", err)
+		utils.Fatal("Error reading template files", "error", err)
 	}
 
 	for _, match := range matches {
-		file, err := os.Open(match)
+		fileContents, err := fs.ReadFile(fsys, match)
 		if err != nil {
-			file.Close()
-			log.Fatalf("Error reading template files: %s", err)
+			utils.Fatal("Error reading template files", "error", err)
 		}
 
-		fileContents, err := ioutil.ReadAll(file)
-		if err != nil {
-			file.Close()
-			log.Fatalf("Error reading template files: %s", err)
-		}
-
-		base := utils.FilenameWoExt(match)
-		templateFiles[base] = template.Must(template.New(base).Parse(string(fileContents)))
-		file.Close()
+		base := utils.FilenameWoExt(filepath.Base(match))
+		templateFiles[base] = template.Must(template.New(base).Funcs(templateFuncs).Parse(string(fileContents)))
 	}
 }
 
 func GetTemplate(name string) *template.Template {
 	return templateFiles[name]
 }
+
+// renderMethodTemplate replaces the method with the output of the named template when provided,
+// the template must render a single method of the same name
+func renderMethodTemplate(name string, f *Func, data *MethodTemplateData) {
+	tmpl := GetTemplate(name)
+	if tmpl == nil {
+		return
+	}
+
+	defaultSource := bytes.NewBuffer(nil)
+	(&PackageFile{}).writeDeclsToBuffer(defaultSource, f.InputAST)
+	data.Name = f.Name
+	data.Default = strings.TrimSpace(defaultSource.String())
+
+	source := bytes.NewBuffer(nil)
+	if err := tmpl.Execute(source, data); err != nil {
+		utils.Fatal("Error executing template", "template", name, "method", f.Name, "error", err)
+	}
+
+	file, err := parser.ParseFile(token.NewFileSet(), name+".gotmpl", "package p\n\n"+source.String(), parser.ParseComments)
+	if err != nil {
+		utils.Fatal("Template rendered invalid code", "template", name, "method", f.Name, "error", err)
+	}
+
+	if len(file.Decls) != 1 {
+		utils.Fatal("Template must render a single method", "template", name, "method", f.Name, "declarations", len(file.Decls))
+	}
+
+	decl, ok := file.Decls[0].(*ast.FuncDecl)
+	if !ok || decl.Name.Name != f.Name {
+		utils.Fatal("Template must render the method of the same name", "template", name, "method", f.Name)
+	}
+
+	f.InputAST = decl
+	f.Source = strings.TrimSpace(source.String())
+}
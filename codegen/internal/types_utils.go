@@ -3,6 +3,8 @@ package internal
 import (
 	"go/types"
 	"reflect"
+	"slices"
+	"strconv"
 	"strings"
 )
 
@@ -21,6 +23,24 @@ func isSlice(t types.Type) bool {
 	return ok
 }
 
+// isArrayContained reports whether fixed-length arrays wrap the element type of t
+func isArrayContained(t types.Type) bool {
+	for {
+		switch x := t.(type) {
+		case *types.Array:
+			return true
+		case *types.Map:
+			t = x.Elem()
+		case *types.Pointer:
+			t = x.Elem()
+		case *types.Slice:
+			t = x.Elem()
+		default:
+			return false
+		}
+	}
+}
+
 func getElement(t types.Type) types.Type {
 	switch x := t.(type) {
 	case *types.Map:
@@ -38,7 +58,7 @@ func isBuiltin(t types.Type) (types.Type, bool) {
 	switch x := t.(type) {
 	case *types.Basic:
 		return t, true
-	case *types.Interface:
+	case *types.Interface, *types.Signature, *types.Chan:
 		return t, true
 	case *types.Map:
 		return isBuiltin(x.Elem())
@@ -56,8 +76,25 @@ func isBuiltin(t types.Type) (types.Type, bool) {
 	}
 }
 
+func structTagLookup(structTag, key string) (string, bool) {
+	if unquoted, err := strconv.Unquote(structTag); err == nil {
+		structTag = unquoted
+	}
+	return reflect.StructTag(structTag).Lookup(key)
+}
+
+func isBSONInline(f *Field) bool {
+	tag, _ := structTagLookup(f.StructTag, "bson")
+	for _, t := range strings.Split(tag, ",")[1:] {
+		if strings.TrimSpace(t) == "inline" {
+			return true
+		}
+	}
+	return false
+}
+
 func structTagContainsMongogenFalse(f *Field) bool {
-	tag := reflect.StructTag(f.StructTag).Get("mongogen")
+	tag, _ := structTagLookup(f.StructTag, "mongogen")
 	tags := strings.Split(tag, ",")
 	for _, t := range tags {
 		if strings.TrimSpace(t) == "false" {
@@ -67,6 +104,98 @@ func structTagContainsMongogenFalse(f *Field) bool {
 	return false
 }
 
+// structTagMongogenOption returns the value of a `key` or `key=value` option in the mongogen tag
+func structTagMongogenOption(f *Field, key string) (string, bool) {
+	for _, option := range mongogenOptions(f.StructTag) {
+		if option[0] == key {
+			return option[1], true
+		}
+	}
+	return "", false
+}
+
+// mongogenOptionNames are the options of the mongogen tag
+var mongogenOptionNames = []string{"false", "inverse", "onDelete", "collection", "required", "min", "max", "regex", "enum"}
+
+// mongogenOptions splits the mongogen tag of structTag into its key and value pairs,
+// commas of a regex pattern are kept until the next known option
+func mongogenOptions(structTag string) [][2]string {
+	tag, _ := structTagLookup(structTag, "mongogen")
+	if tag == "" {
+		return nil
+	}
+
+	options := [][2]string{}
+	for _, t := range strings.Split(tag, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(t), "=")
+		if last := len(options) - 1; last >= 0 && options[last][0] == "regex" && !slices.Contains(mongogenOptionNames, k) {
+			options[last][1] += "," + t
+			continue
+		}
+		options = append(options, [2]string{k, v})
+	}
+	return options
+}
+
+// referenceShape describes the containers wrapping a reference,
+// 's' for slices and 'm' for maps, pointers are transparent in documents
+func referenceShape(t types.Type) string {
+	shape := ""
+	for {
+		switch x := t.(type) {
+		case *types.Map:
+			shape += "m"
+			t = x.Elem()
+		case *types.Slice:
+			shape += "s"
+			t = x.Elem()
+		case *types.Pointer:
+			t = x.Elem()
+		default:
+			return shape
+		}
+	}
+}
+
+// referenceElem returns the type held by the maps, slices and pointers of t
+func referenceElem(t types.Type) types.Type {
+	for {
+		switch x := t.(type) {
+		case *types.Map:
+			t = x.Elem()
+		case *types.Slice:
+			t = x.Elem()
+		case *types.Pointer:
+			t = x.Elem()
+		default:
+			return t
+		}
+	}
+}
+
+// hasGenericReference reports whether collections are passed as type arguments of generic types in t
+func hasGenericReference(p *Package, t types.Type) bool {
+	switch x := t.(type) {
+	case *types.Map:
+		return hasGenericReference(p, x.Key()) || hasGenericReference(p, x.Elem())
+	case *types.Pointer:
+		return hasGenericReference(p, x.Elem())
+	case *types.Slice:
+		return hasGenericReference(p, x.Elem())
+	case *types.Array:
+		return hasGenericReference(p, x.Elem())
+	case *types.Named:
+		for i := 0; i < x.TypeArgs().Len(); i++ {
+			arg := x.TypeArgs().At(i)
+			resolvedType, _ := isBuiltin(arg)
+			if s := p.lookupStruct(resolvedType); (s != nil && s.IsCollection) || hasGenericReference(p, arg) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func isBaseModel(t types.Type) bool {
 	if typeStr := t.String(); typeStr == "github.com/jonoans/mongo-gen/codegen.BaseModel" {
 		return true
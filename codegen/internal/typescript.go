@@ -0,0 +1,517 @@
+package internal
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jonoans/mongo-gen/utils"
+)
+
+// typeScriptPopulatedSuffix names the interfaces holding the referenced documents in place of their IDs
+const typeScriptPopulatedSuffix = "Populated"
+
+var typeScriptIdentifierRegex = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// typeScriptWriter renders the types of a package as TypeScript declarations
+type typeScriptWriter struct {
+	p         *Package
+	imports   map[string]string // Module of the declarations of sibling packages by namespace
+	expanding map[*types.TypeName]bool
+}
+
+// typeScriptProperty is a property of an interface or object type
+type typeScriptProperty struct {
+	key      string
+	expr     string
+	optional bool
+	doc      *ast.CommentGroup
+}
+
+// WriteTypeScript writes the custom types and structs of the models as TypeScript declarations
+// of their JSON encoding, fields are named after their json tags or their Go names as encoding/json does
+func (p *Package) WriteTypeScript() {
+	w := &typeScriptWriter{p: p, imports: map[string]string{}, expanding: map[*types.TypeName]bool{}}
+
+	b := &strings.Builder{}
+	names := make([]string, 0, len(p.CustomTypes))
+	for name := range p.CustomTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w.writeCustomType(b, p.CustomTypes[name])
+	}
+
+	for _, s := range p.sortedStructs() {
+		if s.Generated {
+			continue
+		}
+
+		w.writeInterface(b, s, false)
+		if p.TypeScript.Populated && p.structHoldsReferences(s, map[*types.TypeName]bool{}) {
+			w.writeInterface(b, s, true)
+		}
+	}
+
+	file := &strings.Builder{}
+	file.WriteString("// Code generated by mongo-gen. DO NOT EDIT.\n\n")
+	namespaces := make([]string, 0, len(w.imports))
+	for namespace := range w.imports {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		fmt.Fprintf(file, "import type * as %s from %q;\n", namespace, w.imports[namespace])
+	}
+	if len(namespaces) > 0 {
+		file.WriteString("\n")
+	}
+	file.WriteString(strings.TrimSuffix(b.String(), "\n"))
+
+	filename := p.TypeScript.Path
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModeDir|os.ModePerm); err != nil {
+		utils.Fatal("Could not create the TypeScript declarations directory", "path", filepath.Dir(filename), "error", err)
+	}
+	if err := os.WriteFile(filename, []byte(file.String()), 0644); err != nil {
+		utils.Fatal("Could not write TypeScript declarations", "file", filename, "error", err)
+	}
+}
+
+// writeCustomType declares a custom type, types with constants are declared as the union of their values
+func (w *typeScriptWriter) writeCustomType(b *strings.Builder, c *CustomType) {
+	t := types.Unalias(w.p.InputUser.Types.Scope().Lookup(c.Name).Type())
+	expr := w.typeExpr(t.Underlying(), false)
+	if values := w.p.constantValues(t); len(values) > 0 {
+		expr = strings.Join(values, " | ")
+	}
+
+	writeTypeScriptDoc(b, "", c.Doc.Text())
+	fmt.Fprintf(b, "export type %s = %s;\n\n", c.Name, expr)
+}
+
+// writeInterface declares the interface of a struct, the populated interface
+// holds the referenced documents in place of their IDs
+func (w *typeScriptWriter) writeInterface(b *strings.Builder, s *Struct, populated bool) {
+	name, doc := s.Name, s.Doc.Text()
+	if populated {
+		name += typeScriptPopulatedSuffix
+		doc = fmt.Sprintf("%s is %s with the referenced documents in place of their IDs", name, s.Name)
+	}
+
+	params := []string{}
+	typeParams := w.p.InputUser.Types.Scope().Lookup(s.Name).Type().(*types.Named).TypeParams()
+	for i := 0; i < typeParams.Len(); i++ {
+		params = append(params, typeParams.At(i).Obj().Name())
+	}
+	if len(params) > 0 {
+		name += "<" + strings.Join(params, ", ") + ">"
+	}
+
+	writeTypeScriptDoc(b, "", doc)
+	properties := w.properties(s.schemaFields(nil), populated)
+	if len(properties) == 0 {
+		fmt.Fprintf(b, "export interface %s {}\n\n", name)
+		return
+	}
+
+	fmt.Fprintf(b, "export interface %s {\n", name)
+	for _, property := range properties {
+		writeTypeScriptDoc(b, "  ", property.doc.Text())
+		fmt.Fprintf(b, "  %s;\n", property)
+	}
+	b.WriteString("}\n\n")
+}
+
+func (p typeScriptProperty) String() string {
+	key := p.key
+	if !typeScriptIdentifierRegex.MatchString(key) {
+		key = strconv.Quote(key)
+	}
+	if p.optional {
+		key += "?"
+	}
+	return key + ": " + p.expr
+}
+
+// properties returns the properties of fields, pointers and fields tagged omitempty or omitzero
+// are optional and embedded structs without a json name are flattened
+func (w *typeScriptWriter) properties(fields []schemaField, populated bool) []typeScriptProperty {
+	properties := []typeScriptProperty{}
+	for _, field := range fields {
+		key, options, inline := typeScriptKey(field)
+		switch {
+		case inline:
+			properties = append(properties, w.embeddedProperties(field.t, populated)...)
+			continue
+		case key == "":
+			continue
+		}
+
+		expr := w.typeExpr(field.t, populated)
+		if utils.Contains(options, "string") && isQuotedScalar(field.t) {
+			expr = "string"
+			if isPointer(types.Unalias(field.t)) {
+				expr = typeScriptNullable(expr)
+			}
+		}
+
+		properties = append(properties, typeScriptProperty{
+			key:      key,
+			expr:     expr,
+			optional: isPointer(types.Unalias(field.t)) || utils.Contains(options, "omitempty") || utils.Contains(options, "omitzero"),
+			doc:      field.doc,
+		})
+	}
+	return properties
+}
+
+// typeScriptKey returns the key of a field as encoding/json does and the options of its json tag,
+// an empty key skips the field. Unexported fields, functions and channels are not encoded
+func typeScriptKey(field schemaField) (key string, options []string, inline bool) {
+	tag, _ := structTagLookup(field.tag, "json")
+	if tag == "-" {
+		return "", nil, false
+	}
+
+	options = strings.Split(tag, ",")
+	switch derefType(field.t).Underlying().(type) {
+	case *types.Signature, *types.Chan:
+		return "", nil, false
+	case *types.Struct:
+		if field.embedded && options[0] == "" {
+			return "", options[1:], true
+		}
+	}
+
+	if options[0] != "" {
+		return options[0], options[1:], false
+	}
+	if !token.IsExported(field.name) {
+		return "", nil, false
+	}
+	return field.name, options[1:], false
+}
+
+// isQuotedScalar reports whether the ",string" option of json tags encodes values of t as strings
+func isQuotedScalar(t types.Type) bool {
+	basic, ok := derefType(t).Underlying().(*types.Basic)
+	return ok && basic.Info()&(types.IsNumeric|types.IsBoolean|types.IsString) != 0
+}
+
+// embeddedProperties returns the properties of an inlined struct
+func (w *typeScriptWriter) embeddedProperties(t types.Type, populated bool) []typeScriptProperty {
+	t = types.Unalias(t)
+	if pointer, ok := t.(*types.Pointer); ok {
+		t = types.Unalias(pointer.Elem())
+	}
+
+	if named, ok := t.(*types.Named); ok {
+		if s := w.p.lookupStruct(named); s != nil {
+			return w.properties(s.schemaFields(named), populated)
+		}
+		t = named.Underlying()
+	}
+
+	if x, ok := t.(*types.Struct); ok {
+		return w.properties(anonymousStructFields(x), populated)
+	}
+	return nil
+}
+
+// typeExpr renders the values of t as encoded to JSON, references to collections are
+// their IDs unless populated and the structs of models are referenced by name
+func (w *typeScriptWriter) typeExpr(t types.Type, populated bool) string {
+	switch x := types.Unalias(t).(type) {
+	case *types.Pointer:
+		return typeScriptNullable(w.typeExpr(x.Elem(), populated))
+	case *types.TypeParam:
+		return x.Obj().Name()
+	case *types.Named:
+		if expr, ok := namedTypeScriptType(x); ok {
+			return expr
+		}
+
+		if s := w.p.lookupStruct(x); s != nil {
+			if s.IsCollection && !populated {
+				return "string"
+			}
+
+			name := s.Name
+			if populated && !s.IsCollection && s.Parent.TypeScript != nil && s.Parent.TypeScript.Populated && s.Parent.structHoldsReferences(s, map[*types.TypeName]bool{}) {
+				name += typeScriptPopulatedSuffix
+			}
+
+			args := []string{}
+			for i := 0; i < x.TypeArgs().Len(); i++ {
+				args = append(args, w.typeExpr(x.TypeArgs().At(i), populated))
+			}
+			if len(args) > 0 {
+				name += "<" + strings.Join(args, ", ") + ">"
+			}
+			return w.qualify(s.Parent, name)
+		}
+
+		if x.Obj().Pkg() == nil {
+			// The error interface
+			return "unknown"
+		}
+
+		if pkg := w.p.Siblings[x.Obj().Pkg().Path()]; pkg != nil && pkg.CustomTypes[x.Obj().Name()] != nil {
+			return w.qualify(pkg, x.Obj().Name())
+		}
+
+		if expr, ok := marshalerTypeScriptType(x); ok {
+			return expr
+		}
+
+		// Types of other packages are expanded, recursive ones only once
+		if w.expanding[x.Obj()] {
+			return "unknown"
+		}
+		w.expanding[x.Obj()] = true
+		defer delete(w.expanding, x.Obj())
+		return w.typeExpr(x.Underlying(), populated)
+	case *types.Basic:
+		info := x.Info()
+		switch {
+		case info&types.IsString != 0:
+			return "string"
+		case info&types.IsBoolean != 0:
+			return "boolean"
+		case info&types.IsNumeric != 0:
+			return "number"
+		default:
+			return "unknown"
+		}
+	case *types.Slice:
+		// Nil slices and maps are encoded as null
+		if isByte(x.Elem()) {
+			return typeScriptNullable("string")
+		}
+		return typeScriptNullable(typeScriptArray(w.typeExpr(x.Elem(), populated)))
+	case *types.Array:
+		if isByte(x.Elem()) {
+			return "string"
+		}
+		return typeScriptArray(w.typeExpr(x.Elem(), populated))
+	case *types.Map:
+		return typeScriptNullable(fmt.Sprintf("Record<%s, %s>", w.mapKeyExpr(x.Key()), w.typeExpr(x.Elem(), populated)))
+	case *types.Struct:
+		properties := w.properties(anonymousStructFields(x), populated)
+		if len(properties) == 0 {
+			return "{}"
+		}
+
+		rendered := make([]string, len(properties))
+		for i, property := range properties {
+			rendered[i] = property.String()
+		}
+		return "{ " + strings.Join(rendered, "; ") + " }"
+	default:
+		// Interfaces hold any value, functions and channels are not encoded
+		return "unknown"
+	}
+}
+
+// mapKeyExpr renders the keys of maps, encoded as strings. Custom string types of the models keep their name
+func (w *typeScriptWriter) mapKeyExpr(t types.Type) string {
+	named, ok := types.Unalias(t).(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return "string"
+	}
+
+	basic, ok := named.Underlying().(*types.Basic)
+	pkg := w.p.Siblings[named.Obj().Pkg().Path()]
+	if !ok || basic.Info()&types.IsString == 0 || pkg == nil || pkg.CustomTypes[named.Obj().Name()] == nil {
+		return "string"
+	}
+	return w.qualify(pkg, named.Obj().Name())
+}
+
+// qualify prefixes the name of a type declared by a sibling package with the namespace of its declarations,
+// types of siblings without declarations are left unconstrained
+func (w *typeScriptWriter) qualify(pkg *Package, name string) string {
+	if pkg == w.p {
+		return name
+	}
+
+	if pkg.TypeScript == nil {
+		slog.Warn("Referenced models package has no TypeScript declarations, add typescript to its output", "package", pkg.OutputPkgPath, "type", name)
+		return "unknown"
+	}
+
+	module, err := filepath.Rel(filepath.Dir(w.p.TypeScript.Path), strings.TrimSuffix(pkg.TypeScript.Path, ".d.ts"))
+	if err != nil {
+		utils.Fatal("Could not resolve the TypeScript declarations of a sibling package", "package", pkg.OutputPkgPath, "error", err)
+	}
+	module = filepath.ToSlash(module)
+	if !strings.HasPrefix(module, ".") {
+		module = "./" + module
+	}
+
+	w.imports[pkg.OutputPkgName] = module
+	return pkg.OutputPkgName + "." + name
+}
+
+// structHoldsReferences reports whether the fields of the struct hold references to collections
+func (p *Package) structHoldsReferences(s *Struct, visiting map[*types.TypeName]bool) bool {
+	for _, field := range s.schemaFields(nil) {
+		if p.holdsReferences(field.t, visiting) {
+			return true
+		}
+	}
+	return false
+}
+
+// holdsReferences reports whether the values of t hold references to collections,
+// type parameters do not as the references of their type arguments belong to the instantiation
+func (p *Package) holdsReferences(t types.Type, visiting map[*types.TypeName]bool) bool {
+	switch x := types.Unalias(t).(type) {
+	case *types.Pointer:
+		return p.holdsReferences(x.Elem(), visiting)
+	case *types.Slice:
+		return p.holdsReferences(x.Elem(), visiting)
+	case *types.Array:
+		return p.holdsReferences(x.Elem(), visiting)
+	case *types.Map:
+		return p.holdsReferences(x.Elem(), visiting)
+	case *types.Struct:
+		for i := 0; i < x.NumFields(); i++ {
+			if x.Field(i).Exported() && p.holdsReferences(x.Field(i).Type(), visiting) {
+				return true
+			}
+		}
+		return false
+	case *types.Named:
+		if visiting[x.Obj()] {
+			return false
+		}
+		visiting[x.Obj()] = true
+		defer delete(visiting, x.Obj())
+
+		s := p.lookupStruct(x)
+		if s == nil {
+			_, special := namedTypeScriptType(x)
+			return !special && p.holdsReferences(x.Underlying(), visiting)
+		}
+		if s.IsCollection {
+			return true
+		}
+
+		for i := 0; i < x.TypeArgs().Len(); i++ {
+			if p.holdsReferences(x.TypeArgs().At(i), visiting) {
+				return true
+			}
+		}
+		return s.Parent.structHoldsReferences(s, visiting)
+	default:
+		return false
+	}
+}
+
+// constantValues returns the values of the constants of type t declared by the models, in declaration order
+func (p *Package) constantValues(t types.Type) []string {
+	scope := p.InputUser.Types.Scope()
+	constants := []*types.Const{}
+	for _, name := range scope.Names() {
+		if c, ok := scope.Lookup(name).(*types.Const); ok && types.Identical(c.Type(), t) {
+			constants = append(constants, c)
+		}
+	}
+	sort.Slice(constants, func(i, j int) bool { return constants[i].Pos() < constants[j].Pos() })
+
+	values, seen := []string{}, map[string]bool{}
+	for _, c := range constants {
+		var value string
+		switch c.Val().Kind() {
+		case constant.String:
+			value = strconv.Quote(constant.StringVal(c.Val()))
+		case constant.Int:
+			value = c.Val().ExactString()
+		case constant.Float:
+			f, _ := constant.Float64Val(c.Val())
+			value = strconv.FormatFloat(f, 'g', -1, 64)
+		default:
+			return nil
+		}
+
+		if !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// namedTypeScriptType renders the types of the driver and the standard library
+// encoded as strings or documents
+func namedTypeScriptType(named *types.Named) (string, bool) {
+	switch named.String() {
+	case "time.Time", "go.mongodb.org/mongo-driver/v2/bson.DateTime":
+		// ISO 8601 date-time
+		return "string", true
+	case "go.mongodb.org/mongo-driver/v2/bson.ObjectID", "go.mongodb.org/mongo-driver/v2/bson.Decimal128":
+		return "string", true
+	case "go.mongodb.org/mongo-driver/v2/bson.M", "go.mongodb.org/mongo-driver/v2/bson.D", "go.mongodb.org/mongo-driver/v2/bson.Raw":
+		return "Record<string, unknown> | null", true
+	case "go.mongodb.org/mongo-driver/v2/bson.A":
+		return "unknown[] | null", true
+	}
+	return "", false
+}
+
+// marshalerTypeScriptType renders the types encoding themselves, text marshalers are encoded as strings
+func marshalerTypeScriptType(named *types.Named) (string, bool) {
+	methods := types.NewMethodSet(types.NewPointer(named))
+	switch {
+	case methods.Lookup(named.Obj().Pkg(), "MarshalJSON") != nil:
+		return "unknown", true
+	case methods.Lookup(named.Obj().Pkg(), "MarshalText") != nil:
+		return "string", true
+	default:
+		return "", false
+	}
+}
+
+// typeScriptNullable adds null to the values of expr
+func typeScriptNullable(expr string) string {
+	if expr == "unknown" || strings.HasSuffix(expr, " | null") {
+		return expr
+	}
+	return expr + " | null"
+}
+
+func typeScriptArray(elem string) string {
+	if strings.Contains(elem, "|") {
+		return "(" + elem + ")[]"
+	}
+	return elem + "[]"
+}
+
+// writeTypeScriptDoc writes a doc comment as JSDoc, Deprecated: paragraphs become @deprecated tags
+func writeTypeScriptDoc(b *strings.Builder, indent, doc string) {
+	doc = strings.TrimSpace(doc)
+	if doc == "" {
+		return
+	}
+
+	b.WriteString(indent + "/**\n")
+	for _, line := range strings.Split(doc, "\n") {
+		if rest, ok := strings.CutPrefix(line, "Deprecated: "); ok {
+			line = "@deprecated " + rest
+		}
+		line = strings.ReplaceAll(line, "*/", "*\\/")
+		b.WriteString(strings.TrimRight(indent+" * "+line, " ") + "\n")
+	}
+	b.WriteString(indent + " */\n")
+}
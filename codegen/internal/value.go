@@ -8,6 +8,7 @@ import (
 type Interface struct {
 	SourceFile string
 	InputAST   *ast.GenDecl
+	FileSet    *token.FileSet
 	Name       string
 }
 
@@ -15,6 +16,7 @@ type Value struct {
 	SourceFile string
 	InputTok   token.Token
 	InputAST   *ast.ValueSpec
+	Doc        *ast.CommentGroup
 	Name       string
 }
 
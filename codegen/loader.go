@@ -2,8 +2,12 @@ package codegen
 
 import (
 	"go/ast"
-	"log"
+	"go/printer"
+	"go/token"
+	"sort"
+	"strings"
 
+	"github.com/jonoans/mongo-gen/utils"
 	"golang.org/x/tools/go/packages"
 )
 
@@ -22,7 +26,7 @@ var (
 	loadDefMode = packages.NeedFiles | packages.NeedSyntax
 )
 
-func loadPackage(patterns ...string) ([]*packages.Package, error) {
+func loadPackage(dir string, patterns ...string) ([]*packages.Package, error) {
 	toLoad := []string{}
 	for _, pattern := range patterns {
 		if _, ok := cachedPkgs[pattern]; !ok {
@@ -30,7 +34,7 @@ func loadPackage(patterns ...string) ([]*packages.Package, error) {
 		}
 	}
 
-	pkgLoadCfg := packages.Config{Mode: mode}
+	pkgLoadCfg := packages.Config{Mode: mode, Dir: dir}
 	loadedPkgs, err := packages.Load(&pkgLoadCfg, toLoad...)
 	if err != nil {
 		return nil, err
@@ -48,21 +52,52 @@ func loadPackage(patterns ...string) ([]*packages.Package, error) {
 	return retVal, nil
 }
 
+// internalDefinitions holds the declarations of the definitions package,
+// each carrying the comments of its file, and the imports of its files merged
+type internalDefinitions struct {
+	Fset    *token.FileSet
+	Imports []string
+	Decls   []*printer.CommentedNode
+}
+
 // getInternalDefinitons return declarations from definitions package
 // and a list of identifiers found in the definitions package
-func getInternalDefinitions() ([]ast.Decl, []string) {
+func getInternalDefinitions() (*internalDefinitions, []string) {
 	loadCfg := &packages.Config{Mode: loadDefMode}
 	pkgs, err := packages.Load(loadCfg, definitionsPkgPath)
 	if err != nil {
-		log.Fatalf("Error loading internal package: %s", err)
+		utils.Fatal("Error loading internal package", "error", err)
 	}
 
+	definitions := &internalDefinitions{Fset: pkgs[0].Fset}
 	decls := []ast.Decl{}
+	imported := map[string]bool{}
 	for _, f := range pkgs[0].Syntax {
-		f.Comments = nil
+		for _, i := range f.Imports {
+			spec := i.Path.Value
+			if i.Name != nil {
+				spec = i.Name.Name + " " + spec
+			}
+
+			if !imported[spec] {
+				imported[spec] = true
+				definitions.Imports = append(definitions.Imports, spec)
+			}
+		}
+
+		for _, d := range f.Decls {
+			if d, ok := d.(*ast.GenDecl); ok && d.Tok == token.IMPORT {
+				continue
+			}
+			definitions.Decls = append(definitions.Decls, &printer.CommentedNode{Node: d, Comments: f.Comments})
+		}
 		decls = append(decls, f.Decls...)
 	}
 
+	sort.Slice(definitions.Imports, func(i, j int) bool {
+		return importSpecPath(definitions.Imports[i]) < importSpecPath(definitions.Imports[j])
+	})
+
 	idents := []string{}
 	for _, d := range decls {
 		switch d := d.(type) {
@@ -71,6 +106,10 @@ func getInternalDefinitions() ([]ast.Decl, []string) {
 				switch s := d.(type) {
 				case *ast.TypeSpec:
 					idents = append(idents, s.Name.Name)
+				case *ast.ValueSpec:
+					for _, n := range s.Names {
+						idents = append(idents, n.Name)
+					}
 				}
 			}
 		case *ast.FuncDecl:
@@ -78,5 +117,9 @@ func getInternalDefinitions() ([]ast.Decl, []string) {
 		}
 	}
 
-	return decls, idents
+	return definitions, idents
+}
+
+func importSpecPath(spec string) string {
+	return spec[strings.Index(spec, "\"")+1:]
 }
@@ -0,0 +1,93 @@
+package codegen
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/jonoans/mongo-gen/codegen/internal"
+	"github.com/jonoans/mongo-gen/config"
+)
+
+var errMigrationsNotConfigured = errors.New("migrations are not configured, set migrations.packagePath in orm.yml")
+
+// NewMigration writes an empty migration named name into the migrations package and registers it
+func NewMigration(cfg *config.ConfigFile, name string) (string, error) {
+	if cfg.Migrations == nil {
+		return "", errMigrationsNotConfigured
+	}
+
+	internal.ReadAllTemplateFiles(cfg.Templates)
+	return writeMigration(cfg, &internal.MigrationTemplateData{Version: internal.NewMigrationVersion(time.Now()), Name: name})
+}
+
+// DiffMigration scaffolds a migration renaming and removing the fields which changed since the
+// schema snapshot of each target, the codegen_validators.go file written by the last generation.
+// An empty filename is returned when the models match the snapshot
+func DiffMigration(cfg *config.ConfigFile, name string) (string, error) {
+	if cfg.Migrations == nil {
+		return "", errMigrationsNotConfigured
+	}
+
+	_, pkgs := loadPackages(cfg)
+	changes := []*internal.CollectionChange{}
+	for i, pkg := range pkgs {
+		outputCfg := &cfg.Targets[i].Output
+		snapshot, err := internal.ReadSchemaSnapshot(outputCfg.PackagePath)
+		if errors.Is(err, os.ErrNotExist) {
+			slog.Warn("No schema snapshot, run generate before changing the models", "target", cfg.Targets[i].Name, "file", filepath.Join(outputCfg.PackagePath, internal.ValidatorsFilename))
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		targetChanges, err := internal.SchemaChanges(snapshot, pkg.CollectionSchemas())
+		if err != nil {
+			return "", err
+		}
+		changes = append(changes, targetChanges...)
+	}
+
+	if len(changes) == 0 {
+		slog.Info("Models match the schema snapshot, no migration written")
+		return "", nil
+	}
+
+	filename, err := writeMigration(cfg, &internal.MigrationTemplateData{Version: internal.NewMigrationVersion(time.Now()), Name: name, Changes: changes})
+	if err == nil {
+		slog.Info("Review the migration and run generate to update the schema snapshot", "file", filename)
+	}
+	return filename, err
+}
+
+// RunMigrations registers the migrations and runs their package with args, e.g. up, down [STEPS] or status
+func RunMigrations(cfg *config.ConfigFile, args []string) error {
+	if cfg.Migrations == nil {
+		return errMigrationsNotConfigured
+	}
+
+	internal.ReadAllTemplateFiles(cfg.Templates)
+	if err := internal.WriteMigrationsRegistry(cfg); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("go", append([]string{"run", cfg.Migrations.ImportPath}, args...)...)
+	cmd.Dir = cfg.Dir
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	slog.Debug("Running migrations", "package", cfg.Migrations.ImportPath, "args", args)
+	return cmd.Run()
+}
+
+func writeMigration(cfg *config.ConfigFile, data *internal.MigrationTemplateData) (string, error) {
+	filename, err := internal.WriteMigrationFile(cfg.Migrations.PackagePath, data)
+	if err != nil {
+		return "", err
+	}
+
+	slog.Info("Wrote migration", "file", filename)
+	return filename, internal.WriteMigrationsRegistry(cfg)
+}
@@ -0,0 +1,54 @@
+package codegen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/jonoans/mongo-gen/config"
+)
+
+// WriteSchemas writes the JSON Schema of every collection of the target named target, or of every target when empty.
+// Schemas are written into dir as [COLLECTION].schema.json, or to w as a single document keyed by collection when dir is empty
+func WriteSchemas(cfg *config.ConfigFile, target string, dir string, w io.Writer) error {
+	if target != "" && cfg.Target(target) == nil {
+		return fmt.Errorf("unknown target %q", target)
+	}
+
+	if dir != "" {
+		if err := os.MkdirAll(dir, os.ModeDir|os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	schemas := map[string]any{}
+	_, pkgs := loadPackages(cfg)
+	for i, pkg := range pkgs {
+		if target != "" && cfg.Targets[i].Name != target {
+			continue
+		}
+
+		for _, schema := range pkg.CollectionSchemas() {
+			if _, ok := schemas[schema.Collection]; ok {
+				return fmt.Errorf("collection %q is declared by several targets", schema.Collection)
+			}
+			schemas[schema.Collection] = schema.JSONSchema()
+
+			if dir != "" {
+				schema.WriteSchemaFile(dir)
+				slog.Info("Wrote schema", "target", cfg.Targets[i].Name, "collection", schema.Collection, "dir", dir)
+			}
+		}
+	}
+
+	if dir != "" {
+		return nil
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(schemas)
+}
@@ -0,0 +1,6 @@
+package input
+
+// Ignored is in an ignored file and is not generated
+type Ignored struct {
+	Value string
+}
@@ -0,0 +1,20 @@
+package input
+
+import "github.com/jonoans/mongo-gen/codegen"
+
+// User belongs to a group
+type User struct {
+	codegen.BaseModel
+	Name  string
+	Group Group
+}
+
+type Group struct {
+	codegen.BaseModel `mongogen:"collection=teams"`
+	Title             string
+}
+
+// Config clashes with the definitions copied into the output package and is skipped
+type Config struct {
+	Value string
+}
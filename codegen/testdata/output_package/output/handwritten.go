@@ -0,0 +1,6 @@
+package output
+
+// Helper is in an ignored output file which is left untouched
+func Helper() string {
+	return "helper"
+}
@@ -0,0 +1,40 @@
+package output
+
+import (
+	"strings"
+
+	"github.com/jonoans/mongo-gen/codegen"
+)
+
+// User belongs to a group
+type User struct {
+	codegen.BaseModel
+	Name  string
+	Group string
+}
+
+// Audit is only declared in the output package and is kept
+type Audit struct {
+	Message string
+}
+
+// CollectionName is written by hand and is kept over the collection naming
+func (*User) CollectionName() string {
+	return "members"
+}
+
+// DisplayName is added in the output package and is kept
+func (m *User) DisplayName() string {
+	return strings.ToUpper(m.Name)
+}
+
+// Creating is written by hand and is kept
+func (m *User) Creating() error {
+	m.Name = strings.TrimSpace(m.Name)
+	return nil
+}
+
+// GetResolved_Group is outdated and is regenerated
+func (m *User) GetResolved_Group() (string, error) {
+	return m.Group, nil
+}
@@ -0,0 +1,46 @@
+package input
+
+import "github.com/jonoans/mongo-gen/codegen"
+
+// Author is referenced in every supported shape
+type Author struct {
+	codegen.BaseModel
+	Name string
+}
+
+// Post references authors directly, through pointers, slices and maps and nested in subdocuments
+type Post struct {
+	codegen.BaseModel
+	Author       Author             `mongogen:"inverse=Posts"`
+	Editor       *Author            `mongogen:"onDelete=setNull"`
+	Reviewers    []Author           `mongogen:"onDelete=setNull"`
+	ReviewRounds [][]*Author        `mongogen:"onDelete=cascade"`
+	Translators  map[string]Author  `mongogen:"inverse"`
+	Sponsors     map[string]*Author `mongogen:"onDelete=restrict"`
+	Backups      *[]Author
+	Aliases      *map[string]Author
+	Credits      Credits
+	CreditsPtr   *Credits
+	History      []Credits
+	Pinned       Pair[string, *Author]
+	Pairs        []Pair[string, Author]
+}
+
+// Credits holds references nested in a subdocument
+type Credits struct {
+	Owner  Author    `mongogen:"inverse"`
+	Others []*Author `mongogen:"onDelete=setNull"`
+	Editor *Author   `mongogen:"onDelete=setNull"`
+}
+
+type Pair[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// Audit is named with characters diagrams must quote
+type Audit struct {
+	codegen.BaseModel `mongogen:"collection=audit.log-entries"`
+	Post              *Post
+	Authors           map[string]*Author
+}
@@ -0,0 +1,69 @@
+package output
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestResolvers(t *testing.T) {
+	if err := Initialise(Config{Store: NewMemoryStore()}); err != nil {
+		t.Fatal(err)
+	}
+	defer Close()
+
+	ann, bob := &Author{Name: "ann"}, &Author{Name: "bob"}
+	for _, author := range []*Author{ann, bob} {
+		if err := InsertOne(author); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	post := &Post{
+		Translators: map[string]bson.ObjectID{"fr": ann.ID, "de": bob.ID},
+		Sponsors:    map[string]*bson.ObjectID{"gold": &bob.ID, "none": nil},
+		Backups:     &[]bson.ObjectID{bob.ID, ann.ID},
+		Aliases:     &map[string]bson.ObjectID{"a": ann.ID},
+	}
+
+	translators, err := post.GetResolved_Translators()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]Author{"fr": *ann, "de": *bob}; !reflect.DeepEqual(translators, want) {
+		t.Errorf("got translators %v, want %v", translators, want)
+	}
+
+	sponsors, err := post.GetResolved_Sponsors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]*Author{"gold": bob, "none": nil}; !reflect.DeepEqual(sponsors, want) {
+		t.Errorf("got sponsors %v, want %v", sponsors, want)
+	}
+
+	backups, err := post.GetResolved_Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Author{*bob, *ann}; backups == nil || !reflect.DeepEqual(*backups, want) {
+		t.Errorf("got backups %v, want %v", backups, want)
+	}
+
+	aliases, err := post.GetResolved_Aliases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]Author{"a": *ann}; aliases == nil || !reflect.DeepEqual(*aliases, want) {
+		t.Errorf("got aliases %v, want %v", aliases, want)
+	}
+
+	empty := &Post{Backups: new([]bson.ObjectID)}
+	if backups, err := empty.GetResolved_Backups(); err != nil || backups == nil || *backups != nil {
+		t.Errorf("got backups %v and error %v, want a pointer to a nil slice", backups, err)
+	}
+	if aliases, err := empty.GetResolved_Aliases(); err != nil || aliases != nil {
+		t.Errorf("got aliases %v and error %v, want nil", aliases, err)
+	}
+}
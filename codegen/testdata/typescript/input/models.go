@@ -0,0 +1,58 @@
+package input
+
+import (
+	"time"
+
+	"github.com/jonoans/mongo-gen/codegen"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Role grants the permissions of a member
+type Role string
+
+const (
+	RoleAdmin  Role = "admin"
+	RoleMember Role = "member"
+)
+
+type Priority int
+
+const (
+	PriorityLow Priority = iota + 1
+	PriorityHigh
+)
+
+type Team struct {
+	codegen.BaseModel `bson:",inline"`
+	Name              string `json:"name"`
+	Lead              *User  `bson:"lead,omitempty"`
+}
+
+type User struct {
+	codegen.BaseModel `bson:",inline"`
+	// Email is unique
+	Email      string                 `bson:"email" json:"emailAddress"`
+	Role       Role                   `json:"role"`
+	Roles      map[Role]bool          `json:"roles,omitempty"`
+	Priority   Priority               `json:"priority"`
+	Teams      []Team                 `json:"teams"`
+	Membership Membership             `bson:"membership"`
+	Joined     time.Time              `json:"joined"`
+	Avatar     []byte                 `json:"avatar"`
+	Secret     string                 `json:"-"`
+	Settings   bson.M                 `json:"settings"`
+	Tree       Node                   `bson:"tree"`
+	Scores     map[int][]*int         `bson:"scores"`
+	Display    struct{ Theme string } `bson:"display-options"`
+	Visits     int64                  `bson:"visits" json:"visits,string"`
+}
+
+type Membership struct {
+	Team  *Team     `json:"team,omitempty"`
+	Since time.Time `bson:"since"`
+}
+
+type Node struct {
+	Label    string
+	Children []Node
+}
@@ -0,0 +1,33 @@
+package input
+
+import "github.com/jonoans/mongo-gen/codegen"
+
+// Status is stored as its string value
+type Status string
+
+type Address struct {
+	Street  string `bson:"street" mongogen:"required,max=120"`
+	Country string `bson:"country" mongogen:"enum=FR|DE|US"`
+}
+
+type Audit struct {
+	Revision int `bson:"revision" mongogen:"min=1"`
+}
+
+type Customer struct {
+	codegen.BaseModel `bson:",inline"`
+	Audit             `bson:",inline"`
+	Name              string             `bson:"name" mongogen:"required,min=1,max=100"`
+	Slug              string             `bson:"slug" mongogen:"regex=^[a-z]{2,32}$"`
+	Status            Status             `bson:"status" mongogen:"required,enum=active|suspended"`
+	Tier              *int               `bson:"tier,omitempty" mongogen:"enum=1|2|3"`
+	Tags              []string           `bson:"tags" mongogen:"max=5"`
+	Address           Address            `bson:"address"`
+	Shipping          *Address           `bson:"shipping,omitempty"`
+	Previous          []Address          `bson:"previous"`
+	Contacts          map[string]Address `bson:"contacts"`
+	Referrer          *Customer          `bson:"referrer,omitempty" mongogen:"required"`
+	Billing           struct {
+		Email string `bson:"email" mongogen:"required,regex=^[^@]+@[^@]+$"`
+	} `bson:"billing"`
+}
@@ -0,0 +1,15 @@
+package output
+
+import "errors"
+
+type Customer struct {
+	Name string
+}
+
+// Validate is written by hand and is kept, the mongogen rules are checked by validateRules
+func (m *Customer) Validate() error {
+	if m.Name == "root" {
+		return errors.New("reserved name")
+	}
+	return m.validateRules()
+}
@@ -1,11 +1,10 @@
 package config
 
 import (
-	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
-	"regexp"
+	"strings"
 
 	"github.com/jonoans/mongo-gen/utils"
 	"gopkg.in/yaml.v2"
@@ -13,123 +12,336 @@ import (
 
 type ModelsConfig struct {
 	PackageName  string   `yaml:"packageName,omitempty"`
-	PackagePath  string   `yaml:"packagePath,omitempty"`
+	PackagePath  string   `yaml:"packagePath,omitempty"` // Relative to the config file
 	IgnoredFiles []string `yaml:"ignoredFiles,omitempty"`
-	PackageRoot  string
-	ModuleRoot   string
+	ImportPath   string   `yaml:"-"`
+	Module       *Module  `yaml:"-"`
 }
 
-var modReg = regexp.MustCompile(`module\s+(.*)`)
+func (mc *ModelsConfig) IsValid(dir string) bool {
+	if mc.PackageName == "" {
+		slog.Error("Model package name not specified")
+		return false
+	}
 
-func getModPath() string {
-	dir, err := os.Getwd()
-	if err != nil {
-		log.Fatal(err)
+	if mc.PackagePath == "" {
+		mc.PackagePath = resolvePath(dir, mc.PackageName)
+		if !utils.DirExists(mc.PackagePath) {
+			slog.Error("Model package path not specified", "package", mc.PackageName)
+			return false
+		}
+	} else {
+		mc.PackagePath = resolvePath(dir, mc.PackagePath)
+		if !utils.DirExists(mc.PackagePath) {
+			slog.Error("Model package path does not exist", "path", mc.PackagePath)
+			return false
+		}
 	}
 
-	modPath, err := utils.RecursiveSearchDirForFile(dir, "go.mod")
-	if err != nil {
-		log.Fatal(err)
+	var err error
+	if mc.Module, err = findModule(mc.PackagePath); err != nil {
+		slog.Error("Could not find the module of the models package", "path", mc.PackagePath, "error", err)
+		return false
 	}
 
-	if modPath == "" {
-		log.Fatal("Could not find go.mod file in current directory tree!")
+	if mc.ImportPath, err = mc.Module.ImportPath(mc.PackagePath); err != nil {
+		slog.Error("Could not resolve the import path of the models package", "path", mc.PackagePath, "error", err)
+		return false
 	}
+	return true
+}
+
+// Naming cases of collection names and struct tags
+const (
+	CaseLower      = "lower"
+	CaseLowerCamel = "lowerCamel"
+	CaseSnake      = "snake"
+	CaseKebab      = "kebab"
+)
 
-	modFileContents, err := ioutil.ReadFile(modPath)
-	modFileString := string(modFileContents)
-	modMatches := modReg.FindStringSubmatch(modFileString)
-	if len(modMatches) == 0 {
-		return ""
+// Kinds of types listed in omitEmpty policies, other entries name types such as time.Time
+const (
+	OmitEmptyPointer   = "pointer"
+	OmitEmptySlice     = "slice"
+	OmitEmptyMap       = "map"
+	OmitEmptyArray     = "array"
+	OmitEmptyInterface = "interface"
+	OmitEmptyStruct    = "struct"
+	OmitEmptyString    = "string"
+	OmitEmptyBool      = "bool"
+	OmitEmptyNumber    = "number"
+)
+
+// CollectionNamingConfig derives collection names from struct names
+type CollectionNamingConfig struct {
+	Case      string            `yaml:"case,omitempty"`
+	Plural    bool              `yaml:"plural,omitempty"`
+	Irregular map[string]string `yaml:"irregular,omitempty"` // Key: lower case singular word
+	Prefix    string            `yaml:"prefix,omitempty"`
+	Suffix    string            `yaml:"suffix,omitempty"`
+}
+
+// IsSet reports whether the naming differs from the default lowerCamel names
+func (cn *CollectionNamingConfig) IsSet() bool {
+	return (cn.Case != "" && cn.Case != CaseLowerCamel) || cn.Plural || cn.Prefix != "" || cn.Suffix != ""
+}
+
+func (cn *CollectionNamingConfig) IsValid() bool {
+	switch cn.Case {
+	case "", CaseLowerCamel, CaseSnake, CaseKebab:
+		return true
+	default:
+		slog.Error("Unknown collection naming case, expected lowerCamel, snake or kebab", "case", cn.Case)
+		return false
 	}
+}
 
-	return modMatches[1]
+// TagsConfig adds bson and json tags to generated fields, names of explicit tags are kept
+type TagsConfig struct {
+	BSON      bool     `yaml:"bson,omitempty"`
+	JSON      bool     `yaml:"json,omitempty"`
+	Case      string   `yaml:"case,omitempty"`      // lower (default, as the driver), lowerCamel, snake or kebab
+	OmitEmpty []string `yaml:"omitEmpty,omitempty"` // Kinds or types of fields tagged omitempty
 }
 
-func (mc *ModelsConfig) IsValid() bool {
-	if mc.PackageName == "" {
-		log.Println("Model package name not specified")
+func (tc *TagsConfig) IsValid() bool {
+	switch tc.Case {
+	case "", CaseLower, CaseLowerCamel, CaseSnake, CaseKebab:
+		return true
+	default:
+		slog.Error("Unknown tags case, expected lower, lowerCamel, snake or kebab", "case", tc.Case)
 		return false
 	}
+}
 
-	if mc.PackagePath == "" {
-		mc.PackagePath = mc.PackageName
-		if !utils.DirExists(mc.PackagePath) {
-			log.Println("Model package path not specified")
-			return false
-		}
-	} else if !utils.DirExists(mc.PackagePath) {
-		log.Println("Model package path does not exist")
+// TypeScriptConfig writes the models as TypeScript declarations for frontend consumers
+type TypeScriptConfig struct {
+	Path      string `yaml:"path,omitempty"`      // .d.ts file, relative to the config file
+	Populated bool   `yaml:"populated,omitempty"` // Also declare [MODEL]Populated with the referenced documents in place of their IDs
+}
+
+func (tc *TypeScriptConfig) IsValid(dir string) bool {
+	if tc.Path == "" {
+		slog.Error("TypeScript declarations path not specified")
 		return false
 	}
-	mc.PackagePath = utils.CleanPathInput(mc.PackagePath)
 
-	mc.ModuleRoot = getModPath()
-	mc.PackageRoot = filepath.Join(mc.ModuleRoot, mc.PackageName)
-	mc.PackageRoot = filepath.ToSlash(mc.PackageRoot)
+	if !strings.HasSuffix(tc.Path, ".d.ts") {
+		slog.Error("TypeScript declarations path must end with .d.ts", "path", tc.Path)
+		return false
+	}
+	tc.Path = resolvePath(dir, tc.Path)
 	return true
 }
 
 type OutputConfig struct {
-	PackageName  string   `yaml:"packageName,omitempty"`
-	PackagePath  string   `yaml:"packagePath,omitempty"`
-	IgnoredFiles []string `yaml:"ignoredFiles,omitempty"`
-	FileSuffix   string
+	PackageName      string                 `yaml:"packageName,omitempty"`
+	PackagePath      string                 `yaml:"packagePath,omitempty"` // Relative to the config file
+	IgnoredFiles     []string               `yaml:"ignoredFiles,omitempty"`
+	CollectionNaming CollectionNamingConfig `yaml:"collectionNaming,omitempty"`
+	Tags             TagsConfig             `yaml:"tags,omitempty"`
+	Mocks            bool                   `yaml:"mocks,omitempty"` // Generate mocks of the collections into codegen_mock.go
+	TypeScript       *TypeScriptConfig      `yaml:"typescript,omitempty"`
+	FileSuffix       string
+	ImportPath       string  `yaml:"-"`
+	Module           *Module `yaml:"-"`
 }
 
-func (oc *OutputConfig) IsValid() bool {
+func (oc *OutputConfig) IsValid(dir string) bool {
 	if oc.PackageName == "" {
-		log.Println("Output package name not specified")
+		slog.Error("Output package name not specified")
 		return false
 	}
 
 	if oc.PackagePath == "" {
-		log.Println("Output package path not specified")
+		slog.Error("Output package path not specified", "package", oc.PackageName)
+		return false
+	}
+	oc.PackagePath = resolvePath(dir, oc.PackagePath)
+
+	// The output package may not exist yet, its module is the one of the closest go.mod
+	var err error
+	if oc.Module, err = findModule(oc.PackagePath); err != nil {
+		slog.Error("Could not find the module of the output package", "path", oc.PackagePath, "error", err)
+		return false
+	}
+
+	if oc.ImportPath, err = oc.Module.ImportPath(oc.PackagePath); err != nil {
+		slog.Error("Could not resolve the import path of the output package", "path", oc.PackagePath, "error", err)
+		return false
+	}
+
+	if oc.TypeScript != nil && !oc.TypeScript.IsValid(dir) {
+		return false
+	}
+	return oc.CollectionNaming.IsValid() && oc.Tags.IsValid()
+}
+
+// TargetConfig maps a models package to the package generated from it
+type TargetConfig struct {
+	Name   string       `yaml:"name,omitempty"` // Defaults to the output package name
+	Models ModelsConfig `yaml:"models,omitempty"`
+	Output OutputConfig `yaml:"output,omitempty"`
+}
+
+func (tc *TargetConfig) IsValid(dir string) bool {
+	if !tc.Models.IsValid(dir) || !tc.Output.IsValid(dir) {
+		return false
+	}
+
+	if tc.Name == "" {
+		tc.Name = tc.Output.PackageName
+	}
+	return true
+}
+
+// MigrationsConfig locates the main package of the migrations, run against the database of a target
+type MigrationsConfig struct {
+	PackagePath string  `yaml:"packagePath,omitempty"` // Relative to the config file
+	Target      string  `yaml:"target,omitempty"`      // Defaults to the first target
+	ImportPath  string  `yaml:"-"`
+	Module      *Module `yaml:"-"`
+}
+
+func (mc *MigrationsConfig) IsValid(dir string) bool {
+	if mc.PackagePath == "" {
+		slog.Error("Migrations package path not specified")
 		return false
 	}
+	mc.PackagePath = resolvePath(dir, mc.PackagePath)
 
+	// The migrations package is created by the first migration
+	var err error
+	if mc.Module, err = findModule(mc.PackagePath); err != nil {
+		slog.Error("Could not find the module of the migrations package", "path", mc.PackagePath, "error", err)
+		return false
+	}
+
+	if mc.ImportPath, err = mc.Module.ImportPath(mc.PackagePath); err != nil {
+		slog.Error("Could not resolve the import path of the migrations package", "path", mc.PackagePath, "error", err)
+		return false
+	}
 	return true
 }
 
 type ConfigFile struct {
-	Filename string       `yaml:"-"`
-	Models   ModelsConfig `yaml:"models,omitempty"`
-	Output   OutputConfig `yaml:"output,omitempty"`
+	Filename  string         `yaml:"-"`
+	Dir       string         `yaml:"-"` // Directory of the config file, paths are relative to it
+	Workspace string         `yaml:"-"` // go.work file of the workspace, empty outside of workspaces
+	Models    ModelsConfig   `yaml:"models,omitempty"`
+	Output    OutputConfig   `yaml:"output,omitempty"`
+	Targets   []TargetConfig `yaml:"targets,omitempty"`
+	// Directory of templates overriding or adding to the embedded templates
+	Templates string `yaml:"templates,omitempty"`
+	// Package of the migrations run by `mongo-gen migrate`
+	Migrations *MigrationsConfig `yaml:"migrations,omitempty"`
 }
 
 func (c *ConfigFile) IsValid() bool {
-	return c.Models.IsValid() && c.Output.IsValid()
-}
+	// The top level models and output are the first target
+	targets := []TargetConfig{}
+	if c.Models.PackageName != "" || len(c.Targets) == 0 {
+		targets = append(targets, TargetConfig{Models: c.Models, Output: c.Output})
+	}
+	c.Targets = append(targets, c.Targets...)
 
-var cfg *ConfigFile
+	c.Dir = filepath.Dir(c.Filename)
+	c.Workspace = findWorkspace(c.Dir)
 
-func ParseConfig(filename string) *ConfigFile {
-	if cfg != nil {
-		return cfg
+	names, modelPaths, outputPaths := map[string]bool{}, map[string]bool{}, map[string]bool{}
+	for i := range c.Targets {
+		tc := &c.Targets[i]
+		if !tc.IsValid(c.Dir) {
+			return false
+		}
+
+		if names[tc.Name] {
+			slog.Error("Target is specified more than once", "target", tc.Name)
+			return false
+		}
+
+		if modelPaths[tc.Models.PackagePath] {
+			slog.Error("Model package is specified more than once", "path", tc.Models.PackagePath)
+			return false
+		}
+
+		outputPath := tc.Output.PackagePath
+		if outputPaths[outputPath] {
+			slog.Error("Output package is specified more than once", "path", outputPath)
+			return false
+		}
+
+		names[tc.Name], modelPaths[tc.Models.PackagePath], outputPaths[outputPath] = true, true, true
+
+		// Packages of several modules are loaded together only within a workspace
+		for _, module := range []*Module{tc.Models.Module, tc.Output.Module} {
+			if first := c.Targets[0].Models.Module; module.Dir != first.Dir && c.Workspace == "" {
+				slog.Error("Packages span several modules, add them to a go.work workspace", "module", first.Path, "other", module.Path)
+				return false
+			}
+		}
+	}
+
+	if c.Templates != "" {
+		c.Templates = resolvePath(c.Dir, c.Templates)
+	}
+	if c.Templates != "" && !utils.DirExists(c.Templates) {
+		slog.Error("Templates directory does not exist", "path", c.Templates)
+		return false
 	}
 
+	if c.Migrations != nil {
+		if !c.Migrations.IsValid(c.Dir) {
+			return false
+		}
+
+		if c.Migrations.Target == "" {
+			c.Migrations.Target = c.Targets[0].Name
+		}
+		if c.Target(c.Migrations.Target) == nil {
+			slog.Error("Unknown target of the migrations", "target", c.Migrations.Target)
+			return false
+		}
+	}
+
+	c.Models, c.Output = c.Targets[0].Models, c.Targets[0].Output
+	return true
+}
+
+// Target returns the target named name, nil if no target has the name
+func (c *ConfigFile) Target(name string) *TargetConfig {
+	for i := range c.Targets {
+		if c.Targets[i].Name == name {
+			return &c.Targets[i]
+		}
+	}
+	return nil
+}
+
+// ParseConfig reads and validates the config file, the default orm.yml is used when filename does not exist
+func ParseConfig(filename string) *ConfigFile {
 	filename, err := utils.AbsFilePath(filename)
 	if err != nil {
-		log.Fatal(err)
+		utils.Fatal("Could not resolve the config file path", "file", filename, "error", err)
 	}
 
 	cfgFilename := findConfigFile(filename)
-	fileContents, err := ioutil.ReadFile(cfgFilename)
+	fileContents, err := os.ReadFile(cfgFilename)
 	if err != nil {
-		log.Fatal(err)
+		utils.Fatal("Could not read the config file", "file", cfgFilename, "error", err)
 	}
 
-	cfg = &ConfigFile{}
+	cfg := &ConfigFile{}
 	err = yaml.Unmarshal(fileContents, cfg)
 	if err != nil {
-		log.Fatal(err)
+		utils.Fatal("Could not parse the config file", "file", cfgFilename, "error", err)
 	}
 
+	cfg.Filename = cfgFilename
 	if !cfg.IsValid() {
-		log.Fatal("Invalid config file")
+		utils.Fatal("Invalid config file", "file", cfgFilename)
 	}
 
-	cfg.Filename = cfgFilename
 	return cfg
 }
 
@@ -142,10 +354,10 @@ func findConfigFile(filename string) string {
 		}
 	}
 
-	if !utils.FileExists(defaultConfigFilename) {
-		log.Fatal("Config file not found")
+	// Generation may run from any directory below the config file
+	filename, ok := utils.FindFileUpwards(".", defaultConfigFilename)
+	if !ok {
+		utils.Fatal("Config file not found", "file", defaultConfigFilename)
 	}
-
-	filename, _ = utils.AbsFilePath(defaultConfigFilename)
 	return filename
 }
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jonoans/mongo-gen/utils"
+	"golang.org/x/mod/modfile"
+)
+
+// Module is the Go module containing a package directory
+type Module struct {
+	Path string // Module path declared in go.mod
+	Dir  string // Directory containing go.mod
+}
+
+// findModule walks upward from dir to the go.mod of the module containing it,
+// dir does not need to exist yet
+func findModule(dir string) (*Module, error) {
+	modFilename, ok := utils.FindFileUpwards(dir, "go.mod")
+	if !ok {
+		return nil, fmt.Errorf("could not find a go.mod file in %s or its parent directories", dir)
+	}
+
+	contents, err := os.ReadFile(modFilename)
+	if err != nil {
+		return nil, err
+	}
+
+	modPath := modfile.ModulePath(contents)
+	if modPath == "" {
+		return nil, fmt.Errorf("%s does not declare a module path", modFilename)
+	}
+	return &Module{Path: modPath, Dir: filepath.Dir(modFilename)}, nil
+}
+
+// ImportPath returns the import path of the package in dir
+func (m *Module) ImportPath(dir string) (string, error) {
+	rel, err := filepath.Rel(m.Dir, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of module %s", dir, m.Path)
+	}
+
+	if rel == "." {
+		return m.Path, nil
+	}
+	return path.Join(m.Path, filepath.ToSlash(rel)), nil
+}
+
+// findWorkspace returns the go.work file governing dir as the go command does, empty when workspaces are off
+func findWorkspace(dir string) string {
+	switch gowork := os.Getenv("GOWORK"); gowork {
+	case "off":
+		return ""
+	case "", "auto":
+		filename, _ := utils.FindFileUpwards(dir, "go.work")
+		return filename
+	default:
+		return gowork
+	}
+}
+
+// resolvePath resolves paths of the config file relative to its directory
+func resolvePath(dir, p string) string {
+	if filepath.IsAbs(p) {
+		return filepath.Clean(p)
+	}
+	return filepath.Join(dir, p)
+}
@@ -0,0 +1,8 @@
+package billing
+
+import "github.com/jonoans/mongo-gen/codegen"
+
+type Invoice struct {
+	codegen.BaseModel `bson:",inline" mongogen:"collection=billing_invoices"`
+	Number            string `bson:"number" mongogen:"required,regex=^INV-[0-9]+$"`
+}
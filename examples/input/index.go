@@ -1,26 +1,66 @@
 package input
 
-import "github.com/jonoans/mongo-gen/codegen"
+import (
+	"github.com/jonoans/mongo-gen/codegen"
+	"github.com/jonoans/mongo-gen/examples/input/billing"
+)
 
 type SubModel struct {
 }
+
+// Random is a custom string type
 type Random string
 
+// Ownership records who owns and approves a model
+type Ownership struct {
+	// Owner of the model
+	Owner     AnotherModel    `bson:"owner" mongogen:"inverse"`
+	Approvers []*AnotherModel `bson:"approvers" mongogen:"onDelete=setNull"` // Approvers are pulled when deleted
+}
+
+type Page[T any] struct {
+	Items []T `bson:"items"`
+	Total int `bson:"total" mongogen:"min=0"`
+}
+
+type Pair[K comparable, V any] struct {
+	Key   K `bson:"key"`
+	Value V `bson:"value"`
+}
+
 type AnotherModel struct {
 	codegen.BaseModel
 	Sub SubModel `bson:"sub"`
 }
 
+// Model demonstrates every supported reference shape
 type Model struct {
 	codegen.BaseModel
+	// Deprecated: Sub is kept for older documents, use Ownership instead.
 	Sub                   SubModel
 	Random                Random
-	Reference             AnotherModel
-	ReferencePtr          *AnotherModel
-	ReferenceSlice        []AnotherModel
-	ReferenceSliceInSlice [][]*AnotherModel
-	ReferenceMap          map[string]AnotherModel
-	ReferenceMapPtr       map[string]*AnotherModel
+	Reference             AnotherModel             `mongogen:"inverse=FindModelsByReference"`
+	ReferencePtr          *AnotherModel            `mongogen:"onDelete=setNull"`
+	ReferenceSlice        []AnotherModel           `mongogen:"onDelete=setNull"`
+	ReferenceSliceInSlice [][]*AnotherModel        `mongogen:"onDelete=cascade"`
+	ReferenceMap          map[string]AnotherModel  `mongogen:"inverse"`
+	ReferenceMapPtr       map[string]*AnotherModel `mongogen:"onDelete=restrict"`
 	ReferencePtrSlice     *[]AnotherModel
 	ReferencePtrMap       *map[string]AnotherModel
+	Ownership             *Ownership
+	Ownerships            []Ownership
+	Invoice               *billing.Invoice
+	Invoices              []billing.Invoice
+	Pages                 Page[Random]
+	Owners                Pair[string, *AnotherModel]
+	Approvals             []Pair[string, AnotherModel]
+	Scores                [3]int
+	Metadata              struct {
+		Source string `bson:"source"`
+		Tags   []string
+	}
+	Callback func() error                  `bson:"-"`
+	Events   chan string                   `bson:"-"`
+	Labeler  interface{ Label() string }   `bson:"-"`
+	Lookup   map[[2]string]func(int) error `bson:"-"`
 }
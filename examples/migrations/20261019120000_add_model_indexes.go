@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// up20261019120000 applies the add_model_indexes migration
+func up20261019120000(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("models").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "reference", Value: 1}},
+	})
+	return err
+}
+
+// down20261019120000 reverts the add_model_indexes migration, remove it when the migration cannot be reverted
+func down20261019120000(ctx context.Context, db *mongo.Database) error {
+	return db.Collection("models").Indexes().DropOne(ctx, "reference_1")
+}
@@ -0,0 +1,37 @@
+package main
+
+// Code generated by mongo-gen. DO NOT EDIT.
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	output "github.com/jonoans/mongo-gen/examples/output"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// migrations lists the migration files of the package in version order, run by `mongo-gen migrate`
+var migrations = []output.Migration{
+	{Version: "20261019120000", Name: "add_model_indexes", Up: up20261019120000, Down: down20261019120000},
+}
+
+// main runs the migration command of the arguments on the MONGODB_DATABASE database of the MONGODB_URI
+// deployment, declare main in a file of your own to configure the client instead
+func main() {
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		uri = "mongodb://localhost:27017"
+	}
+
+	err := output.Initialise(output.Config{DatabaseName: os.Getenv("MONGODB_DATABASE")}, options.Client().ApplyURI(uri))
+	if err == nil {
+		err = output.RunMigrations(context.Background(), migrations, os.Args[1:], os.Stdout)
+		output.Close()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,22 @@
+package billing
+
+// Code generated by mongo-gen. DO NOT EDIT.
+
+// The $jsonSchema validators of the collections, set on the database by ApplyValidators
+func init() {
+	registerValidator("billing_invoices", `{
+	"bsonType": "object",
+	"properties": {
+		"_id": {
+			"bsonType": "objectId"
+		},
+		"number": {
+			"bsonType": "string"
+		}
+	},
+	"required": [
+		"number"
+	],
+	"title": "Invoice"
+}`)
+}
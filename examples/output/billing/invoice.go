@@ -0,0 +1,161 @@
+package billing
+
+import (
+	"context"
+
+	"github.com/jonoans/mongo-gen/codegen"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type Invoice struct {
+	codegen.BaseModel `bson:",inline" mongogen:"collection=billing_invoices"`
+	Number            string `bson:"number" mongogen:"required,regex=^INV-[0-9]+$"`
+}
+
+type InvoiceChange = ChangeEvent[Invoice]
+
+// CollectionName returns the name of the collection storing Invoice documents
+func (*Invoice) CollectionName() string {
+	return "billing_invoices"
+}
+
+func (m *Invoice) Queried() error {
+	return nil
+}
+
+func (m *Invoice) Creating() error {
+	return nil
+}
+
+func (m *Invoice) Created() error {
+	return nil
+}
+
+func (m *Invoice) Saving() error {
+	return nil
+}
+
+func (m *Invoice) Saved() error {
+	return nil
+}
+
+func (m *Invoice) Updating() error {
+	return nil
+}
+
+func (m *Invoice) Updated() error {
+	return nil
+}
+
+func (m *Invoice) Deleting() error {
+	return nil
+}
+
+func (m *Invoice) Deleted() error {
+	return nil
+}
+
+// Validate checks the rules of Invoice before it is written, write Validate in the output package
+// to add checks of your own and call m.validateRules() from it
+func (m *Invoice) Validate() error {
+	return m.validateRules()
+}
+
+// validateRules checks the fields of Invoice against the rules of their mongogen tags,
+// broken rules are returned in a *ValidationError
+func (m *Invoice) validateRules() error {
+	if m == nil {
+		return nil
+	}
+	v := &validation{}
+	v.required("number", m.Number)
+	v.regex("number", m.Number, "^INV-[0-9]+$")
+	return v.err()
+}
+
+// AggregateFirst runs AggregateFirst on the Invoice
+func (m *Invoice) AggregateFirst(pipeline any, opts ...options.Lister[options.AggregateOptions]) (bool, error) {
+	return AggregateFirst(m, pipeline, opts...)
+}
+
+// AggregateFirstWithCtx runs AggregateFirstWithCtx on the Invoice
+func (m *Invoice) AggregateFirstWithCtx(ctx context.Context, pipeline any, opts ...options.Lister[options.AggregateOptions]) (bool, error) {
+	return AggregateFirstWithCtx(ctx, m, pipeline, opts...)
+}
+
+// Find runs FindOne on the Invoice
+func (m *Invoice) Find(query any, opts ...options.Lister[options.FindOneOptions]) error {
+	return FindOne(m, query, opts...)
+}
+
+// FindWithCtx runs FindOneWithCtx on the Invoice
+func (m *Invoice) FindWithCtx(ctx context.Context, query any, opts ...options.Lister[options.FindOneOptions]) error {
+	return FindOneWithCtx(ctx, m, query, opts...)
+}
+
+// FindByObjectID runs FindByObjectID on the Invoice
+func (m *Invoice) FindByObjectID(id any, opts ...options.Lister[options.FindOneOptions]) error {
+	return FindByObjectID(m, id, opts...)
+}
+
+// FindByObjectIDWithCtx runs FindByObjectIDWithCtx on the Invoice
+func (m *Invoice) FindByObjectIDWithCtx(ctx context.Context, id any, opts ...options.Lister[options.FindOneOptions]) error {
+	return FindByObjectIDWithCtx(ctx, m, id, opts...)
+}
+
+// Create runs InsertOne on the Invoice
+func (m *Invoice) Create(opts ...options.Lister[options.InsertOneOptions]) error {
+	return InsertOne(m, opts...)
+}
+
+// CreateWithCtx runs InsertOneWithCtx on the Invoice
+func (m *Invoice) CreateWithCtx(ctx context.Context, opts ...options.Lister[options.InsertOneOptions]) error {
+	return InsertOneWithCtx(ctx, m, opts...)
+}
+
+// Update runs Update on the Invoice
+func (m *Invoice) Update(opts ...options.Lister[options.UpdateOneOptions]) error {
+	return Update(m, opts...)
+}
+
+// UpdateWithCtx runs UpdateWithCtx on the Invoice
+func (m *Invoice) UpdateWithCtx(ctx context.Context, opts ...options.Lister[options.UpdateOneOptions]) error {
+	return UpdateWithCtx(ctx, m, opts...)
+}
+
+// Delete runs Delete on the Invoice
+func (m *Invoice) Delete(opts ...options.Lister[options.DeleteOneOptions]) error {
+	return Delete(m, opts...)
+}
+
+// DeleteWithCtx runs DeleteWithCtx on the Invoice
+func (m *Invoice) DeleteWithCtx(ctx context.Context, opts ...options.Lister[options.DeleteOneOptions]) error {
+	return DeleteWithCtx(ctx, m, opts...)
+}
+
+// CountInvoices runs CountWithCtx on the Invoice collection
+func CountInvoices(ctx context.Context, filter any, opts ...options.Lister[options.CountOptions]) (int64, error) {
+	return CountWithCtx(ctx, &Invoice{}, filter, opts...)
+}
+
+// EstimatedCountInvoices runs EstimatedDocumentCountWithCtx on the Invoice collection
+func EstimatedCountInvoices(ctx context.Context, opts ...options.Lister[options.EstimatedDocumentCountOptions]) (int64, error) {
+	return EstimatedDocumentCountWithCtx(ctx, &Invoice{}, opts...)
+}
+
+// ExistsInvoices runs ExistsWithCtx on the Invoice collection
+func ExistsInvoices(ctx context.Context, filter any) (bool, error) {
+	return ExistsWithCtx(ctx, &Invoice{}, filter)
+}
+
+// DistinctInvoiceNumber returns the distinct values of Number in the Invoice documents matching filter
+func DistinctInvoiceNumber(ctx context.Context, filter any, opts ...options.Lister[options.DistinctOptions]) ([]string, error) {
+	results := []string{}
+	err := DistinctWithCtx(ctx, &Invoice{}, "number", filter, &results, opts...)
+	return results, err
+}
+
+// WatchInvoices subscribes to the change stream of the Invoice collection
+func WatchInvoices(ctx context.Context, pipeline any, opts *WatchOptions) (<-chan InvoiceChange, error) {
+	return Watch[Invoice](ctx, pipeline, opts)
+}
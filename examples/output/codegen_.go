@@ -3,20 +3,43 @@ package output
 // Code generated by mongo-gen. DO NOT EDIT.
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
 	"time"
+	"unicode/utf8"
+
 	"github.com/jonoans/mongo-gen/codegen"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type ModelInterface interface {
 	CollectionName() string
+
+	// Field Information
 	GetID() any
 	SetID(id any)
+
+	// Hooks
 	Queried() error
 	Creating() error
 	Created() error
@@ -26,10 +49,9 @@ type ModelInterface interface {
 	Updated() error
 	Deleting() error
 	Deleted() error
-}// Field Information
-// Hooks
-
+}
 
+// Available query methods
 type ModelQueryMethods interface {
 	AggregateFirst(pipeline any, opts ...options.Lister[options.AggregateOptions]) (bool, error)
 	AggregateFirstWithCtx(ctx context.Context, pipeline any, opts ...options.Lister[options.AggregateOptions]) (bool, error)
@@ -43,56 +65,91 @@ type ModelQueryMethods interface {
 	UpdateWithCtx(context.Context, ...options.Lister[options.UpdateOneOptions]) error
 	Delete(...options.Lister[options.DeleteOneOptions]) error
 	DeleteWithCtx(context.Context, ...options.Lister[options.DeleteOneOptions]) error
-}// Available query methods
-
+}
 
 type Config struct {
 	OperationTimeout	time.Duration
 	DatabaseName		string
+
 	TxnSessionOptions	*options.SessionOptionsBuilder
+
+	// Store replaces the MongoDB client when set, e.g. with NewMemoryStore() in unit tests
+	Store	Store
+
+	// Spans and metrics of the operations are recorded with the global providers when nil
+	TracerProvider	trace.TracerProvider
+	MeterProvider	metric.MeterProvider
+
+	// Logger receives slow operations, hook failures and client lifecycle events, nothing is logged when nil
+	Logger	*slog.Logger
+	// Operations taking longer are logged as warnings, disabled when zero
+	SlowQueryThreshold	time.Duration
+
+	// Validation level and action of the validators set by ApplyValidators, strict and error by default
+	ValidationLevel		string
+	ValidationAction	string
 }
 
 func Initialise(cfg Config, opts ...*options.ClientOptions) error {
 	if err := checkConfig(&cfg); err != nil {
 		return err
 	}
-	if defaultClt != nil {
-		return errors.New("client is already initialised")
+
+	if defaultStore != nil {
+		return ErrAlreadyInitialised
 	}
+
 	defaultCfg = cfg
+	defaultTelemetry = newTelemetryInstruments(cfg.TracerProvider, cfg.MeterProvider)
+	if cfg.Store != nil {
+		defaultStore = cfg.Store
+		getLogger().Info("Store initialised", "store", fmt.Sprintf("%T", cfg.Store))
+		return nil
+	}
+
 	client, err := mongo.Connect(opts...)
 	if err != nil {
-		return err
+		getLogger().Error("Could not create the MongoDB client", "error", err)
+		return wrapError(err)
 	}
-	defaultClt = &databaseClient{client: client, collections: map[string]*mongo.Collection{}}
-	defaultClt.init()
+
+	defaultStore = newMongoStore(client, cfg.DatabaseName)
+	getLogger().Info("MongoDB client initialised", "database", cfg.DatabaseName)
 	return nil
 }
 
+// GetStore returns the store the models are read from and written to
+func GetStore() (Store, error) {
+	return getDefaultStore()
+}
+
 func GetClient() (*mongo.Client, error) {
-	clt, err := getDefaultClient()
+	store, err := getMongoStore()
 	if err != nil {
 		return nil, err
 	}
-	return clt.client, nil
+	return store.client, nil
 }
 
 func GetDatabase() (*mongo.Database, error) {
-	clt, err := getDefaultClient()
+	store, err := getMongoStore()
 	if err != nil {
 		return nil, err
 	}
-	return clt.getDatabase(), nil
+	return store.database, nil
 }
 
+// GetCollection returns the MongoDB collection, ErrNotSupported is returned by other stores such as MemoryStore
 func GetCollection(collectionName string) (*mongo.Collection, error) {
-	clt, err := getDefaultClient()
+	store, err := getMongoStore()
 	if err != nil {
 		return nil, err
 	}
-	return clt.getCollection(collectionName), nil
+	return store.getCollection(collectionName).coll, nil
 }
 
+// Coll returns the MongoDB collection of the model, nil with other stores such as MemoryStore,
+// GetCollection returns the error instead
 func Coll(model ModelInterface) *mongo.Collection {
 	name, err := getCollectionName(model)
 	if err != nil {
@@ -114,6 +171,18 @@ func AggregateFirst(model ModelInterface, pipeline any, opts ...options.Lister[o
 	return AggregateFirstWithCtx(ctx, model, pipeline, opts...)
 }
 
+func Count(model ModelInterface, filter any, opts ...options.Lister[options.CountOptions]) (int64, error) {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return CountWithCtx(ctx, model, filter, opts...)
+}
+
+func Distinct(model ModelInterface, fieldName string, filter any, results any, opts ...options.Lister[options.DistinctOptions]) error {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return DistinctWithCtx(ctx, model, fieldName, filter, results, opts...)
+}
+
 func Delete(model ModelInterface, opts ...options.Lister[options.DeleteOneOptions]) error {
 	ctx, cancel := newCtx()
 	defer cancel()
@@ -132,6 +201,18 @@ func DeleteMany(model ModelInterface, query any, opts ...options.Lister[options.
 	return DeleteManyWithCtx(ctx, model, query, opts...)
 }
 
+func EstimatedDocumentCount(model ModelInterface, opts ...options.Lister[options.EstimatedDocumentCountOptions]) (int64, error) {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return EstimatedDocumentCountWithCtx(ctx, model, opts...)
+}
+
+func Exists(model ModelInterface, filter any) (bool, error) {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return ExistsWithCtx(ctx, model, filter)
+}
+
 func FindOne(model ModelInterface, query any, opts ...options.Lister[options.FindOneOptions]) error {
 	ctx, cancel := newCtx()
 	defer cancel()
@@ -157,7 +238,12 @@ func FindByObjectIDs(results any, ids any, additionalPipeline ...any) error {
 }
 
 func FindByObjectIDsWithCtx(ctx context.Context, results any, ids any, additionalPipeline ...any) error {
-	pipeline := bson.A{bson.M{"$match": bson.M{"_id": bson.M{"$in": ids}}}, bson.M{"$addFields": bson.M{"_codegen_sort_index": bson.M{"$indexOfArray": bson.A{ids, "$_id"}}}}, bson.M{"$sort": bson.M{"_codegen_sort_index": 1}}, bson.M{"$project": bson.M{"_codegen_sort_index": 0}}}
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"_id": bson.M{"$in": ids}}},
+		bson.M{"$addFields": bson.M{"_codegen_sort_index": bson.M{"$indexOfArray": bson.A{ids, "$_id"}}}},
+		bson.M{"$sort": bson.M{"_codegen_sort_index": 1}},
+		bson.M{"$project": bson.M{"_codegen_sort_index": 0}},
+	}
 	pipeline = append(pipeline, additionalPipeline...)
 	return AggregateWithCtx(ctx, results, pipeline)
 }
@@ -186,136 +272,279 @@ func UpdateMany(model ModelInterface, filter any, update any, opts ...options.Li
 	return UpdateManyWithCtx(ctx, model, filter, update, opts...)
 }
 
-func AggregateWithCtx(ctx context.Context, results any, pipeline any, aggregateOpts ...options.Lister[options.AggregateOptions]) error {
+func AggregateWithCtx(ctx context.Context, results any, pipeline any, aggregateOpts ...options.Lister[options.AggregateOptions]) (err error) {
 	collectionName, err := getCollectionNameFromSlice(results)
 	if err != nil {
 		return err
 	}
-	collection, err := GetCollection(collectionName)
+
+	ctx, op := startOperation(ctx, "Aggregate", collectionName, pipeline)
+	defer func() { op.end(ctx, err) }()
+
+	collection, err := getStoreCollection(collectionName)
 	if err != nil {
 		return err
 	}
+
 	cur, err := collection.Aggregate(ctx, pipeline, aggregateOpts...)
 	if cur != nil {
 		defer cur.Close(ctx)
 	}
+
 	if err != nil {
-		return err
+		return wrapError(err)
 	}
+
 	if err := cur.All(ctx, results); err != nil {
-		return err
+		return wrapError(err)
 	}
-	if err := runFuncOnResultsSliceItems(results, callAfterQueryHooks); err != nil {
-		return err
-	}
-	return nil
+	op.setReturned(resultsLen(results))
+
+	return runFuncOnResultsSliceItems(results, func(model ModelInterface) error {
+		return callAfterQueryHooks(ctx, model)
+	})
 }
 
-func AggregateFirstWithCtx(ctx context.Context, result ModelInterface, pipeline any, aggregateOpts ...options.Lister[options.AggregateOptions]) (bool, error) {
+func AggregateFirstWithCtx(ctx context.Context, result ModelInterface, pipeline any, aggregateOpts ...options.Lister[options.AggregateOptions]) (found bool, err error) {
 	collectionName, err := getCollectionName(result)
 	if err != nil {
 		return false, err
 	}
-	collection, err := GetCollection(collectionName)
+
+	ctx, op := startOperation(ctx, "AggregateFirst", collectionName, pipeline)
+	defer func() { op.end(ctx, err) }()
+
+	collection, err := getStoreCollection(collectionName)
 	if err != nil {
 		return false, err
 	}
+
 	cur, err := collection.Aggregate(ctx, pipeline, aggregateOpts...)
 	if cur != nil {
 		defer cur.Close(ctx)
 	}
+
 	if err != nil {
-		return false, err
+		return false, wrapError(err)
 	}
+
 	if cur.Next(ctx) {
 		if err := cur.Decode(result); err != nil {
-			return false, err
+			return false, wrapError(err)
 		}
-		return true, callAfterQueryHooks(result)
+		op.setReturned(1)
+		return true, callAfterQueryHooks(ctx, result)
+	}
+
+	op.setReturned(0)
+	return false, wrapError(cur.Err())
+}
+
+func CountWithCtx(ctx context.Context, model ModelInterface, filter any, opts ...options.Lister[options.CountOptions]) (count int64, err error) {
+	collectionName, err := getCollectionName(model)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, op := startOperation(ctx, "Count", collectionName, filter)
+	defer func() { op.end(ctx, err) }()
+
+	coll, err := getStoreCollection(collectionName)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err = coll.CountDocuments(ctx, filter, opts...)
+	op.setReturned(int(count))
+	return count, wrapError(err)
+}
+
+func DistinctWithCtx(ctx context.Context, model ModelInterface, fieldName string, filter any, results any, opts ...options.Lister[options.DistinctOptions]) (err error) {
+	collectionName, err := getCollectionName(model)
+	if err != nil {
+		return err
+	}
+
+	ctx, op := startOperation(ctx, "Distinct", collectionName, filter)
+	defer func() { op.end(ctx, err) }()
+
+	coll, err := getStoreCollection(collectionName)
+	if err != nil {
+		return err
+	}
+
+	if err := coll.Distinct(ctx, fieldName, filter, results, opts...); err != nil {
+		return wrapError(err)
 	}
-	return false, nil
+	op.setReturned(resultsLen(results))
+	return nil
 }
 
-func DeleteWithCtx(ctx context.Context, model ModelInterface, opts ...options.Lister[options.DeleteOneOptions]) error {
-	if err := callBeforeDeleteHooks(model); err != nil {
+func DeleteWithCtx(ctx context.Context, model ModelInterface, opts ...options.Lister[options.DeleteOneOptions]) (err error) {
+	collectionName, err := getCollectionName(model)
+	if err != nil {
+		return err
+	}
+
+	ctx, op := startOperation(ctx, "Delete", collectionName, nil)
+	defer func() { op.end(ctx, err) }()
+
+	if err := callBeforeDeleteHooks(ctx, model); err != nil {
 		return err
 	}
-	if _, err := DeleteOneWithCtx(ctx, model, bson.M{"_id": model.GetID()}, opts...); err != nil {
+
+	actions, hasActions := model.(deleteActionsModel)
+	if !hasActions {
+		if _, err := DeleteOneWithCtx(ctx, model, bson.M{"_id": model.GetID()}, opts...); err != nil {
+			return err
+		}
+		return afterCommit(ctx, func() error { return callAfterDeleteHooks(ctx, model) })
+	}
+
+	err = runInTransaction(ctx, func(ctx context.Context) error {
+		if err := actions.checkDeleteRestrictions(ctx); err != nil {
+			return err
+		}
+
+		if _, err := DeleteOneWithCtx(ctx, model, bson.M{"_id": model.GetID()}, opts...); err != nil {
+			return err
+		}
+
+		return actions.runDeleteActions(ctx)
+	})
+	if err != nil {
 		return err
 	}
-	return callAfterDeleteHooks(model)
+
+	return afterCommit(ctx, func() error { return callAfterDeleteHooks(ctx, model) })
 }
 
-func DeleteOneWithCtx(ctx context.Context, model ModelInterface, query any, opts ...options.Lister[options.DeleteOneOptions]) (*mongo.DeleteResult, error) {
+func DeleteOneWithCtx(ctx context.Context, model ModelInterface, query any, opts ...options.Lister[options.DeleteOneOptions]) (result *mongo.DeleteResult, err error) {
 	collectionName, err := getCollectionName(model)
 	if err != nil {
 		return nil, err
 	}
-	coll, err := GetCollection(collectionName)
+
+	ctx, op := startOperation(ctx, "DeleteOne", collectionName, query)
+	defer func() { op.end(ctx, err) }()
+
+	coll, err := getStoreCollection(collectionName)
 	if err != nil {
 		return nil, err
 	}
-	result, err := coll.DeleteOne(ctx, query, opts...)
+
+	result, err = coll.DeleteOne(ctx, query, opts...)
 	if err != nil {
-		return result, err
+		return result, wrapError(err)
 	}
+
+	op.setReturned(int(result.DeletedCount))
 	return result, nil
 }
 
-func DeleteManyWithCtx(ctx context.Context, model ModelInterface, query any, opts ...options.Lister[options.DeleteManyOptions]) (*mongo.DeleteResult, error) {
+func DeleteManyWithCtx(ctx context.Context, model ModelInterface, query any, opts ...options.Lister[options.DeleteManyOptions]) (result *mongo.DeleteResult, err error) {
 	collectionName, err := getCollectionName(model)
 	if err != nil {
 		return nil, err
 	}
-	coll, err := GetCollection(collectionName)
+
+	ctx, op := startOperation(ctx, "DeleteMany", collectionName, query)
+	defer func() { op.end(ctx, err) }()
+
+	coll, err := getStoreCollection(collectionName)
 	if err != nil {
 		return nil, err
 	}
-	result, err := coll.DeleteMany(ctx, query, opts...)
+
+	result, err = coll.DeleteMany(ctx, query, opts...)
 	if err != nil {
-		return result, err
+		return result, wrapError(err)
 	}
+
+	op.setReturned(int(result.DeletedCount))
 	return result, nil
 }
 
-func FindOneWithCtx(ctx context.Context, model ModelInterface, query any, opts ...options.Lister[options.FindOneOptions]) error {
+func EstimatedDocumentCountWithCtx(ctx context.Context, model ModelInterface, opts ...options.Lister[options.EstimatedDocumentCountOptions]) (count int64, err error) {
 	collectionName, err := getCollectionName(model)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	ctx, op := startOperation(ctx, "EstimatedDocumentCount", collectionName, nil)
+	defer func() { op.end(ctx, err) }()
+
+	coll, err := getStoreCollection(collectionName)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err = coll.EstimatedDocumentCount(ctx, opts...)
+	op.setReturned(int(count))
+	return count, wrapError(err)
+}
+
+func ExistsWithCtx(ctx context.Context, model ModelInterface, filter any) (bool, error) {
+	count, err := CountWithCtx(ctx, model, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
 	}
-	coll, err := GetCollection(collectionName)
+	return count > 0, nil
+}
+
+func FindOneWithCtx(ctx context.Context, model ModelInterface, query any, opts ...options.Lister[options.FindOneOptions]) (err error) {
+	collectionName, err := getCollectionName(model)
 	if err != nil {
 		return err
 	}
-	if err := coll.FindOne(ctx, query, opts...).Decode(model); err != nil {
+
+	ctx, op := startOperation(ctx, "FindOne", collectionName, query)
+	defer func() { op.end(ctx, err) }()
+
+	coll, err := getStoreCollection(collectionName)
+	if err != nil {
 		return err
 	}
-	return callAfterQueryHooks(model)
+
+	if err := coll.FindOne(ctx, query, model, opts...); err != nil {
+		return wrapError(err)
+	}
+	op.setReturned(1)
+
+	return callAfterQueryHooks(ctx, model)
 }
 
-func FindManyWithCtx(ctx context.Context, results any, query any, opts ...options.Lister[options.FindOptions]) error {
+func FindManyWithCtx(ctx context.Context, results any, query any, opts ...options.Lister[options.FindOptions]) (err error) {
 	collectionName, err := getCollectionNameFromSlice(results)
 	if err != nil {
 		return err
 	}
-	coll, err := GetCollection(collectionName)
+
+	ctx, op := startOperation(ctx, "FindMany", collectionName, query)
+	defer func() { op.end(ctx, err) }()
+
+	coll, err := getStoreCollection(collectionName)
 	if err != nil {
 		return err
 	}
+
 	cur, err := coll.Find(ctx, query, opts...)
 	if cur != nil {
 		defer cur.Close(ctx)
 	}
+
 	if err != nil {
-		return err
+		return wrapError(err)
 	}
+
 	if err := cur.All(ctx, results); err != nil {
-		return err
+		return wrapError(err)
 	}
-	if err := runFuncOnResultsSliceItems(results, callAfterQueryHooks); err != nil {
-		return err
-	}
-	return nil
+	op.setReturned(resultsLen(results))
+
+	return runFuncOnResultsSliceItems(results, func(model ModelInterface) error {
+		return callAfterQueryHooks(ctx, model)
+	})
 }
 
 func FindByObjectIDWithCtx(ctx context.Context, model ModelInterface, id any, opts ...options.Lister[options.FindOneOptions]) error {
@@ -326,72 +555,111 @@ func FindByObjectIDWithCtx(ctx context.Context, model ModelInterface, id any, op
 	return FindOneWithCtx(ctx, model, bson.M{"_id": oid}, opts...)
 }
 
-func InsertOneWithCtx(ctx context.Context, model ModelInterface, opts ...options.Lister[options.InsertOneOptions]) error {
+func InsertOneWithCtx(ctx context.Context, model ModelInterface, opts ...options.Lister[options.InsertOneOptions]) (err error) {
 	collectionName, err := getCollectionName(model)
 	if err != nil {
 		return err
 	}
-	if err := callBeforeCreateHooks(model); err != nil {
+
+	ctx, op := startOperation(ctx, "InsertOne", collectionName, nil)
+	defer func() { op.end(ctx, err) }()
+
+	if err := validateModel(model); err != nil {
+		return err
+	}
+
+	if err := callBeforeCreateHooks(ctx, model); err != nil {
 		return err
 	}
-	coll, err := GetCollection(collectionName)
+
+	coll, err := getStoreCollection(collectionName)
 	if err != nil {
 		return err
 	}
+
 	result, err := coll.InsertOne(ctx, model, opts...)
 	if err != nil {
-		return err
+		return wrapError(err)
 	}
-	return callAfterCreateHooks(model, result)
+	op.setReturned(1)
+
+	return callAfterCreateHooks(ctx, model, result)
 }
 
-func UpdateWithCtx(ctx context.Context, model ModelInterface, opts ...options.Lister[options.UpdateOneOptions]) error {
+func UpdateWithCtx(ctx context.Context, model ModelInterface, opts ...options.Lister[options.UpdateOneOptions]) (err error) {
 	collectionName, err := getCollectionName(model)
 	if err != nil {
 		return err
 	}
-	if err := callBeforeUpdateHooks(model); err != nil {
+
+	ctx, op := startOperation(ctx, "Update", collectionName, nil)
+	defer func() { op.end(ctx, err) }()
+
+	if err := validateModel(model); err != nil {
+		return err
+	}
+
+	if err := callBeforeUpdateHooks(ctx, model); err != nil {
 		return err
 	}
-	coll, err := GetCollection(collectionName)
+
+	coll, err := getStoreCollection(collectionName)
 	if err != nil {
 		return err
 	}
-	if _, err = coll.UpdateByID(ctx, model.GetID(), bson.M{"$set": model}, opts...); err != nil {
-		return err
+
+	result, err := coll.UpdateByID(ctx, model.GetID(), bson.M{"$set": model}, opts...)
+	if err != nil {
+		return wrapError(err)
 	}
-	return callAfterUpdateHooks(model)
+	op.setReturned(int(result.MatchedCount))
+
+	return callAfterUpdateHooks(ctx, model)
 }
 
-func UpdateOneWithCtx(ctx context.Context, model ModelInterface, filter any, update any, opts ...options.Lister[options.UpdateOneOptions]) (*mongo.UpdateResult, error) {
+func UpdateOneWithCtx(ctx context.Context, model ModelInterface, filter any, update any, opts ...options.Lister[options.UpdateOneOptions]) (result *mongo.UpdateResult, err error) {
 	collectionName, err := getCollectionName(model)
 	if err != nil {
 		return nil, err
 	}
-	coll, err := GetCollection(collectionName)
+
+	ctx, op := startOperation(ctx, "UpdateOne", collectionName, filter)
+	defer func() { op.end(ctx, err) }()
+
+	coll, err := getStoreCollection(collectionName)
 	if err != nil {
 		return nil, err
 	}
-	result, err := coll.UpdateOne(ctx, filter, update, opts...)
+
+	result, err = coll.UpdateOne(ctx, filter, update, opts...)
 	if err != nil {
-		return result, err
+		return result, wrapError(err)
 	}
+
+	op.setReturned(int(result.MatchedCount))
 	return result, nil
 }
 
-func UpdateManyWithCtx(ctx context.Context, model ModelInterface, filter any, update any, opts ...options.Lister[options.UpdateManyOptions]) (*mongo.UpdateResult, error) {
+func UpdateManyWithCtx(ctx context.Context, model ModelInterface, filter any, update any, opts ...options.Lister[options.UpdateManyOptions]) (result *mongo.UpdateResult, err error) {
 	collectionName, err := getCollectionName(model)
 	if err != nil {
 		return nil, err
 	}
-	coll, err := GetCollection(collectionName)
+
+	ctx, op := startOperation(ctx, "UpdateMany", collectionName, filter)
+	defer func() { op.end(ctx, err) }()
+
+	coll, err := getStoreCollection(collectionName)
 	if err != nil {
 		return nil, err
 	}
-	result, err := coll.UpdateMany(ctx, filter, update, opts...)
+
+	result, err = coll.UpdateMany(ctx, filter, update, opts...)
 	if err != nil {
-		return result, err
+		return result, wrapError(err)
 	}
+
+	op.setReturned(int(result.MatchedCount))
 	return result, nil
 }
 
@@ -411,202 +679,3335 @@ func TransactionWithOptions(fn codegen.TransactionFunc, opts *options.SessionOpt
 	return TransactionWithCtxOptions(ctx, fn, opts)
 }
 
-func TransactionWithCtxOptions(ctx context.Context, fn codegen.TransactionFunc, opts *options.SessionOptionsBuilder) error {
-	client, err := GetClient()
+func TransactionWithCtxOptions(ctx context.Context, fn codegen.TransactionFunc, opts *options.SessionOptionsBuilder) (err error) {
+	store, err := getDefaultStore()
 	if err != nil {
 		return err
 	}
-	return client.UseSessionWithOptions(ctx, opts, func(ctx context.Context) error {
-		sess := mongo.SessionFromContext(ctx)
-		if err := sess.StartTransaction(); err != nil {
-			return err
-		}
-		return fn(ctx)
-	})
-}
 
-func Close() {
-	if defaultClt != nil {
-		ctx, cancel := newCtx()
-		defer cancel()
-		_ = defaultClt.client.Disconnect(ctx)
-		defaultClt = nil
-	}
-}
+	ctx, op := startOperation(ctx, "Transaction", "", nil)
+	defer func() { op.end(ctx, err) }()
 
-type databaseClient struct {
-	client		*mongo.Client
-	database	*mongo.Database
-	collections	map[string]*mongo.Collection
+	return transaction(ctx, store, opts, fn)
 }
 
-func (c *databaseClient) init() {
-	c.collections = make(map[string]*mongo.Collection)
+// afterCommitKey is the context key of the hooks deferred until the transaction commits
+type afterCommitKey struct{}
+
+type afterCommitHooks struct {
+	hooks []func() error
 }
 
-func (c *databaseClient) getDatabase() *mongo.Database {
-	if c.database == nil {
-		c.database = c.client.Database(defaultCfg.DatabaseName)
+// afterCommit runs hook once the transaction of ctx commits, or right away outside of transactions
+func afterCommit(ctx context.Context, hook func() error) error {
+	if pending, ok := ctx.Value(afterCommitKey{}).(*afterCommitHooks); ok {
+		pending.hooks = append(pending.hooks, hook)
+		return nil
 	}
-	return c.database
+	return hook()
 }
 
-func (c *databaseClient) getCollection(name string) *mongo.Collection {
-	if coll, ok := c.collections[name]; ok {
-		return coll
+// transaction runs fn in a transaction of store then the hooks fn deferred with afterCommit,
+// the hooks of attempts which were retried or aborted are dropped
+func transaction(ctx context.Context, store Store, opts *options.SessionOptionsBuilder, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(afterCommitKey{}).(*afterCommitHooks); ok {
+		return store.Transaction(ctx, opts, fn)
+	}
+
+	pending := &afterCommitHooks{}
+	err := store.Transaction(ctx, opts, func(ctx context.Context) error {
+		pending.hooks = nil
+		return fn(context.WithValue(ctx, afterCommitKey{}, pending))
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range pending.hooks {
+		if err := hook(); err != nil {
+			return err
+		}
 	}
-	c.collections[name] = c.getDatabase().Collection(name)
-	return c.collections[name]
+	return nil
 }
 
-var (
-	defaultClt	*databaseClient
-	defaultCfg	Config
-)
+// ResumeTokenStore persists change stream resume tokens so that a stream
+// can continue from the last delivered event after a restart
+type ResumeTokenStore interface {
+	LoadResumeToken(ctx context.Context, name string) (bson.Raw, error)
+	SaveResumeToken(ctx context.Context, name string, token bson.Raw) error
+}
 
-func Ctx() context.Context {
-	ctx, _ := newCtx()
-	return ctx
+type WatchOptions struct {
+	// Name identifies the stream in the ResumeTokenStore, defaults to the collection name
+	Name			string
+	ResumeTokenStore	ResumeTokenStore
+	ChangeStreamOptions	*options.ChangeStreamOptionsBuilder
+	BufferSize		int
 }
 
-func newCtx() (context.Context, func()) {
-	return context.WithTimeout(context.Background(), defaultCfg.OperationTimeout)
+type UpdateDescription struct {
+	UpdatedFields	bson.M		`bson:"updatedFields"`
+	RemovedFields	[]string	`bson:"removedFields"`
+	TruncatedArrays	[]bson.M	`bson:"truncatedArrays"`
 }
 
-func getDefaultClient() (*databaseClient, error) {
-	if defaultClt == nil {
-		return nil, errors.New("client is not initialised, please call the Initialise method first!")
-	}
-	return defaultClt, nil
+// ChangeEvent is a change stream event decoded into the model type,
+// Err is set on the last event sent before the channel is closed due to an error.
+// Events which fail to decode or whose Queried hook fails stop the stream without
+// saving their resume token, a restarted stream receives them again
+type ChangeEvent[T any] struct {
+	OperationType		string
+	DocumentKey		bson.M
+	FullDocument		*T
+	UpdateDescription	*UpdateDescription
+	ResumeToken		bson.Raw
+	Err			error
 }
 
-func assertObjectID(id any) (bson.ObjectID, error) {
-	switch v := id.(type) {
-	case bson.ObjectID:
-		return v, nil
-	case *bson.ObjectID:
-		return *v, nil
-	case string:
-		return bson.ObjectIDFromHex(v)
-	default:
-		return bson.NilObjectID, errors.New("invalid object id")
-	}
+type changeEventDocument struct {
+	OperationType		string			`bson:"operationType"`
+	DocumentKey		bson.M			`bson:"documentKey"`
+	FullDocument		bson.Raw		`bson:"fullDocument"`
+	UpdateDescription	*UpdateDescription	`bson:"updateDescription"`
 }
 
-func checkConfig(cfg *Config) error {
-	if cfg.DatabaseName == "" {
-		return errors.New("database name is empty")
+func Watch[T any, P interface {
+	*T
+	ModelInterface
+}](ctx context.Context, pipeline any, opts *WatchOptions) (<-chan ChangeEvent[T], error) {
+	if opts == nil {
+		opts = &WatchOptions{}
 	}
-	if cfg.OperationTimeout == 0 {
-		cfg.OperationTimeout = time.Second * 15
+
+	collectionName, err := getCollectionName(P(new(T)))
+	if err != nil {
+		return nil, err
 	}
-	if cfg.TxnSessionOptions == nil {
-		cfg.TxnSessionOptions = options.Session()
+
+	coll, err := getStoreCollection(collectionName)
+	if err != nil {
+		return nil, err
 	}
-	return nil
-}
 
-func getCollectionName(model ModelInterface) (string, error) {
-	name := model.CollectionName()
+	name := opts.Name
 	if name == "" {
-		return "", errors.New("collection name is empty")
+		name = collectionName
 	}
-	return name, nil
-}
 
-func getCollectNameFromInterface(model any) (string, error) {
-	if v, ok := model.(ModelInterface); ok {
-		return getCollectionName(v)
+	streamOpts := []options.Lister[options.ChangeStreamOptions]{}
+	if opts.ChangeStreamOptions != nil {
+		streamOpts = append(streamOpts, opts.ChangeStreamOptions)
 	}
-	return "", errors.New("model is not a ModelInterface")
-}
 
-func getCollectionNameFromSlice(results any) (string, error) {
-	resultsType := reflect.TypeOf(results)
-	if resultsType.Kind() != reflect.Ptr {
-		return "", errors.New("results is not a pointer")
-	}
-	resultsType = reflect.Indirect(reflect.ValueOf(results)).Type()
-	if resultsType.Kind() != reflect.Slice {
-		return "", errors.New("results is not a pointer to a slice")
-	}
-	elemValue := reflect.New(resultsType.Elem()).Interface()
-	return getCollectNameFromInterface(elemValue)
-}
+	if opts.ResumeTokenStore != nil {
+		token, err := opts.ResumeTokenStore.LoadResumeToken(ctx, name)
+		if err != nil {
+			return nil, err
+		}
 
-func runFuncOnResultsSliceItems(results any, callback func(model ModelInterface) error) error {
-	resultsPtr := reflect.ValueOf(results)
-	resultsSlice := reflect.Indirect(resultsPtr)
-	resultsSliceLen := resultsSlice.Len()
-	for i := // Only use when sure results is slice of ModelInterface
-	range resultsSliceLen {
-		item := resultsSlice.Index(i).Addr().Interface()
-		if err := callback(item.(ModelInterface)); err != nil {
-			return err
+		// Applied after the options of the caller, which are left untouched
+		if token != nil {
+			streamOpts = append(streamOpts, options.ChangeStream().SetResumeAfter(token))
 		}
 	}
-	return nil
-}
 
-func callAfterQueryHooks(model ModelInterface) error {
-	if err := model.Queried(); err != nil {
-		return err
+	if pipeline == nil {
+		pipeline = mongo.Pipeline{}
 	}
-	return nil
-}
 
-func callBeforeCreateHooks(model ModelInterface) error {
-	if err := model.Creating(); err != nil {
-		return err
+	stream, err := coll.Watch(ctx, pipeline, streamOpts...)
+	if err != nil {
+		return nil, wrapError(err)
 	}
-	if err := model.Saving(); err != nil {
-		return err
+
+	events := make(chan ChangeEvent[T], opts.BufferSize)
+	go watchChangeStream[T, P](ctx, stream, name, opts.ResumeTokenStore, events)
+	return events, nil
+}
+
+// changeStream iterates over change stream events, *mongo.ChangeStream implements it
+type changeStream interface {
+	Next(ctx context.Context) bool
+	Decode(val any) error
+	ResumeToken() bson.Raw
+	Err() error
+	Close(ctx context.Context) error
+}
+
+// watchChangeStream sends the events of stream until it ends or an event fails,
+// the resume token of each event is saved once the event is sent
+func watchChangeStream[T any, P interface {
+	*T
+	ModelInterface
+}](ctx context.Context, stream changeStream, name string, tokenStore ResumeTokenStore, events chan<- ChangeEvent[T]) {
+	defer close(events)
+	defer stream.Close(context.Background())
+
+	send := func(event ChangeEvent[T]) bool {
+		select {
+		case events <- event:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for stream.Next(ctx) {
+		event := decodeChangeEvent[T, P](ctx, stream)
+		if !send(event) || event.Err != nil {
+			return
+		}
+
+		if tokenStore != nil {
+			if err := tokenStore.SaveResumeToken(ctx, name, event.ResumeToken); err != nil {
+				send(ChangeEvent[T]{Err: err})
+				return
+			}
+		}
+	}
+
+	if err := stream.Err(); err != nil && ctx.Err() == nil {
+		send(ChangeEvent[T]{Err: wrapError(err)})
 	}
-	return nil
 }
 
-func callAfterCreateHooks(model ModelInterface, result *mongo.InsertOneResult) error {
-	model.SetID(result.InsertedID)
-	if err := model.Created(); err != nil {
+func decodeChangeEvent[T any, P interface {
+	*T
+	ModelInterface
+}](ctx context.Context, stream changeStream) ChangeEvent[T] {
+	event := ChangeEvent[T]{ResumeToken: stream.ResumeToken()}
+
+	doc := changeEventDocument{}
+	if err := stream.Decode(&doc); err != nil {
+		event.Err = wrapError(err)
+		return event
+	}
+
+	event.OperationType = doc.OperationType
+	event.DocumentKey = doc.DocumentKey
+	event.UpdateDescription = doc.UpdateDescription
+
+	if len(doc.FullDocument) > 0 {
+		fullDocument := new(T)
+		if err := bson.Unmarshal(doc.FullDocument, fullDocument); err != nil {
+			event.Err = wrapError(err)
+			return event
+		}
+
+		if err := callAfterQueryHooks(ctx, P(fullDocument)); err != nil {
+			event.Err = err
+			return event
+		}
+		event.FullDocument = fullDocument
+	}
+
+	return event
+}
+
+// CollectionResumeTokenStore stores resume tokens in a collection of the default database
+type CollectionResumeTokenStore struct {
+	CollectionName string
+}
+
+func (s *CollectionResumeTokenStore) LoadResumeToken(ctx context.Context, name string) (bson.Raw, error) {
+	coll, err := getStoreCollection(s.CollectionName)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := struct {
+		Token bson.Raw `bson:"token"`
+	}{}
+	err = coll.FindOne(ctx, bson.M{"_id": name}, &doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	} else if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return doc.Token, nil
+}
+
+func (s *CollectionResumeTokenStore) SaveResumeToken(ctx context.Context, name string, token bson.Raw) error {
+	coll, err := getStoreCollection(s.CollectionName)
+	if err != nil {
 		return err
 	}
-	if err := model.Saved(); err != nil {
+
+	_, err = coll.UpdateByID(ctx, name, bson.M{"$set": bson.M{"token": token}}, options.UpdateOne().SetUpsert(true))
+	return wrapError(err)
+}
+
+func Close() {
+	if defaultStore != nil {
+		ctx, cancel := newCtx()
+		defer cancel()
+		if err := defaultStore.Close(ctx); err != nil {
+			getLogger().Error("Could not close the store", "error", err)
+		} else {
+			getLogger().Info("Store closed")
+		}
+		defaultStore = nil
+	}
+}
+
+// Repository is the collection API of the model type T, NewRepository returns the one
+// backed by the store and generated mocks implement it for unit tests
+type Repository[T any] interface {
+	FindByObjectID(ctx context.Context, id any, opts ...options.Lister[options.FindOneOptions]) (*T, error)
+	FindOne(ctx context.Context, query any, opts ...options.Lister[options.FindOneOptions]) (*T, error)
+	FindMany(ctx context.Context, query any, opts ...options.Lister[options.FindOptions]) ([]T, error)
+	Create(ctx context.Context, model *T, opts ...options.Lister[options.InsertOneOptions]) error
+	Update(ctx context.Context, model *T, opts ...options.Lister[options.UpdateOneOptions]) error
+	Delete(ctx context.Context, model *T, opts ...options.Lister[options.DeleteOneOptions]) error
+	Count(ctx context.Context, filter any, opts ...options.Lister[options.CountOptions]) (int64, error)
+}
+
+// NewRepository returns the Repository of the model type T, e.g. NewRepository[Model]()
+func NewRepository[T any, P interface {
+	*T
+	ModelInterface
+}]() Repository[T] {
+	return storeRepository[T, P]{}
+}
+
+type storeRepository[T any, P interface {
+	*T
+	ModelInterface
+}] struct{}
+
+func (storeRepository[T, P]) FindByObjectID(ctx context.Context, id any, opts ...options.Lister[options.FindOneOptions]) (*T, error) {
+	model := new(T)
+	if err := FindByObjectIDWithCtx(ctx, P(model), id, opts...); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+func (storeRepository[T, P]) FindOne(ctx context.Context, query any, opts ...options.Lister[options.FindOneOptions]) (*T, error) {
+	model := new(T)
+	if err := FindOneWithCtx(ctx, P(model), query, opts...); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+func (storeRepository[T, P]) FindMany(ctx context.Context, query any, opts ...options.Lister[options.FindOptions]) ([]T, error) {
+	results := []T{}
+	if err := FindManyWithCtx(ctx, &results, query, opts...); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (storeRepository[T, P]) Create(ctx context.Context, model *T, opts ...options.Lister[options.InsertOneOptions]) error {
+	return InsertOneWithCtx(ctx, P(model), opts...)
+}
+
+func (storeRepository[T, P]) Update(ctx context.Context, model *T, opts ...options.Lister[options.UpdateOneOptions]) error {
+	return UpdateWithCtx(ctx, P(model), opts...)
+}
+
+func (storeRepository[T, P]) Delete(ctx context.Context, model *T, opts ...options.Lister[options.DeleteOneOptions]) error {
+	return DeleteWithCtx(ctx, P(model), opts...)
+}
+
+func (storeRepository[T, P]) Count(ctx context.Context, filter any, opts ...options.Lister[options.CountOptions]) (int64, error) {
+	return CountWithCtx(ctx, P(new(T)), filter, opts...)
+}
+
+// validatorSchemas holds the $jsonSchema of each collection, registered by the generated code
+var validatorSchemas = map[string]string{}
+
+func registerValidator(collectionName string, schema string) {
+	validatorSchemas[collectionName] = schema
+}
+
+// ApplyValidators sets the $jsonSchema validators generated from the models on their collections
+// with collMod, collections which do not exist yet are created with createCollection
+func ApplyValidators(ctx context.Context) error {
+	store, err := getMongoStore()
+	if err != nil {
 		return err
 	}
+
+	collectionNames := make([]string, 0, len(validatorSchemas))
+	for collectionName := range validatorSchemas {
+		collectionNames = append(collectionNames, collectionName)
+	}
+	sort.Strings(collectionNames)
+
+	for _, collectionName := range collectionNames {
+		if err := applyValidator(ctx, store.database, collectionName); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func callBeforeUpdateHooks(model ModelInterface) error {
-	if err := model.Updating(); err != nil {
+func applyValidator(ctx context.Context, database *mongo.Database, collectionName string) (err error) {
+	ctx, op := startOperation(ctx, "ApplyValidator", collectionName, nil)
+	defer func() { op.end(ctx, err) }()
+
+	schema := bson.D{}
+	if err := bson.UnmarshalExtJSON([]byte(validatorSchemas[collectionName]), false, &schema); err != nil {
 		return err
 	}
-	if err := model.Saving(); err != nil {
-		return err
+	validator := bson.D{{Key: "$jsonSchema", Value: schema}}
+
+	existing, err := database.ListCollectionNames(ctx, bson.D{{Key: "name", Value: collectionName}})
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if len(existing) == 0 {
+		opts := options.CreateCollection().
+			SetValidator(validator).
+			SetValidationLevel(defaultCfg.ValidationLevel).
+			SetValidationAction(defaultCfg.ValidationAction)
+		err = database.CreateCollection(ctx, collectionName, opts)
+	} else {
+		err = database.RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: collectionName},
+			{Key: "validator", Value: validator},
+			{Key: "validationLevel", Value: defaultCfg.ValidationLevel},
+			{Key: "validationAction", Value: defaultCfg.ValidationAction},
+		}).Err()
+	}
+	if err != nil {
+		return wrapError(err)
 	}
+
+	getLogger().InfoContext(ctx, "Validator applied", "collection", collectionName, "created", len(existing) == 0)
 	return nil
 }
 
-func callAfterUpdateHooks(model ModelInterface) error {
-	if err := model.Updated(); err != nil {
-		return err
+// validation collects the rules broken by a document, checked by the generated Validate methods
+type validation struct {
+	fields []*FieldError
+}
+
+func (v *validation) add(path string, rule string, format string, args ...any) {
+	v.fields = append(v.fields, &FieldError{Path: path, Rule: rule, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validation) err() error {
+	if len(v.fields) == 0 {
+		return nil
 	}
-	if err := model.Saved(); err != nil {
-		return err
+	return &ValidationError{Fields: v.fields}
+}
+
+func (v *validation) required(path string, value any) {
+	rv := reflect.ValueOf(value)
+	switch {
+	case !rv.IsValid() || rv.IsZero():
+		v.add(path, "required", "is required")
+	case (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Map) && rv.Len() == 0:
+		v.add(path, "required", "is required")
+	}
+}
+
+func (v *validation) min(path string, value any, min float64) {
+	if size, unit, ok := validationSize(value); ok && size < min {
+		v.add(path, "min", "must be at least %v%s", min, unit)
+	}
+}
+
+func (v *validation) max(path string, value any, max float64) {
+	if size, unit, ok := validationSize(value); ok && size > max {
+		v.add(path, "max", "must be at most %v%s", max, unit)
+	}
+}
+
+func (v *validation) regex(path string, value any, pattern string) {
+	rv, ok := validationValue(value)
+	if !ok {
+		return
+	}
+
+	if !validationPattern(pattern).MatchString(rv.String()) {
+		v.add(path, "regex", "must match %s", pattern)
+	}
+}
+
+func (v *validation) enum(path string, value any, values ...string) {
+	rv, ok := validationValue(value)
+	if !ok {
+		return
+	}
+
+	current := fmt.Sprint(rv.Interface())
+	for _, allowed := range values {
+		if current == allowed {
+			return
+		}
+	}
+	v.add(path, "enum", "must be one of %s", strings.Join(values, ", "))
+}
+
+// nested validates the subdocuments held by value with their Validate method,
+// walking through pointers, slices and maps
+func (v *validation) nested(path string, value any) {
+	v.nestedValue(path, reflect.ValueOf(value))
+}
+
+func (v *validation) nestedValue(path string, rv reflect.Value) {
+	if rv.Kind() != reflect.Pointer && rv.CanAddr() {
+		rv = rv.Addr()
+	}
+
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return
+		}
+	}
+
+	if validator, ok := rv.Interface().(validatable); ok {
+		v.merge(path, validator.Validate())
+		return
+	}
+
+	if rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		v.nestedValue(path, rv)
+	case reflect.Slice, reflect.Array:
+		if !mayHoldSubdocuments(rv.Type().Elem()) {
+			return
+		}
+		for i := 0; i < rv.Len(); i++ {
+			v.nestedValue(joinValidationPath(path, strconv.Itoa(i)), rv.Index(i))
+		}
+	case reflect.Map:
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, key := range keys {
+			// Map values are not addressable, pointer receivers are reached through a copy
+			elem := reflect.New(rv.Type().Elem()).Elem()
+			elem.Set(rv.MapIndex(key))
+			v.nestedValue(joinValidationPath(path, fmt.Sprint(key.Interface())), elem)
+		}
+	}
+}
+
+// merge adds the rules broken by a subdocument stored at path, errors which are
+// not a *ValidationError of this package are reported as a single field
+func (v *validation) merge(path string, err error) {
+	if err == nil {
+		return
+	}
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		v.add(path, "validate", "%s", err)
+		return
+	}
+
+	for _, field := range validationErr.Fields {
+		v.fields = append(v.fields, &FieldError{Path: joinValidationPath(path, field.Path), Rule: field.Rule, Message: field.Message})
+	}
+}
+
+type validatable interface {
+	Validate() error
+}
+
+// validateModel runs the Validate method of models before they are written
+func validateModel(model ModelInterface) error {
+	if validator, ok := model.(validatable); ok {
+		return validator.Validate()
 	}
 	return nil
 }
 
-func callBeforeDeleteHooks(model ModelInterface) error {
-	if err := model.Deleting(); err != nil {
+// mayHoldSubdocuments reports whether values of t may have a Validate method or hold such values,
+// slices of plain values such as ObjectIDs are not walked
+func mayHoldSubdocuments(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Array, reflect.Map:
+		return true
+	default:
+		return reflect.PointerTo(t).Implements(reflect.TypeFor[validatable]())
+	}
+}
+
+func joinValidationPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	if key == "" {
+		return path
+	}
+	return path + "." + key
+}
+
+// validationValue dereferences value, false is returned for nil pointers which only the required rule checks
+func validationValue(value any) (reflect.Value, bool) {
+	rv := reflect.ValueOf(value)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return rv, false
+		}
+		rv = rv.Elem()
+	}
+	return rv, rv.IsValid()
+}
+
+// validationSize returns the value compared by min and max with its unit:
+// numbers, the characters of strings or the elements of slices and maps
+func validationSize(value any) (float64, string, bool) {
+	rv, ok := validationValue(value)
+	if !ok {
+		return 0, "", false
+	}
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), "", true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), "", true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), "", true
+	case reflect.String:
+		return float64(utf8.RuneCountInString(rv.String())), " characters", true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(rv.Len()), " elements", true
+	default:
+		return 0, "", false
+	}
+}
+
+var validationPatterns sync.Map
+
+// validationPattern compiles the patterns of regex rules once, they are checked by the generator
+func validationPattern(pattern string) *regexp.Regexp {
+	if compiled, ok := validationPatterns.Load(pattern); ok {
+		return compiled.(*regexp.Regexp)
+	}
+
+	compiled := regexp.MustCompile(pattern)
+	validationPatterns.Store(pattern, compiled)
+	return compiled
+}
+
+// Migration is a versioned change of the database, listed by the registry generated into the migrations package
+type Migration struct {
+	Version	string	// UTC timestamp of the migration file, e.g. 20261019153000
+	Name	string
+	Up	func(ctx context.Context, db *mongo.Database) error
+	Down	func(ctx context.Context, db *mongo.Database) error	// Nil for irreversible migrations
+}
+
+// MigrationStatus is a migration with the time it was applied, nil while pending
+type MigrationStatus struct {
+	Migration
+	AppliedAt	*time.Time
+}
+
+const (
+	migrationsCollectionName	= "_migrations"
+	migrationLockID			= "lock"
+	// Locks left by crashed runs are taken over once expired
+	migrationLockTimeout	= time.Hour
+)
+
+type migrationRecord struct {
+	Version		string		`bson:"_id"`
+	Name		string		`bson:"name"`
+	AppliedAt	time.Time	`bson:"appliedAt"`
+}
+
+// MigrateUp applies the pending migrations in version order, the applied migrations are returned
+func MigrateUp(ctx context.Context, migrations []Migration) (applied []Migration, err error) {
+	err = withMigrationLock(ctx, func(db *mongo.Database, records map[string]*migrationRecord) error {
+		for _, migration := range sortedMigrations(migrations) {
+			if records[migration.Version] != nil {
+				continue
+			}
+
+			if err := runMigration(ctx, "MigrateUp", migration, migration.Up, db); err != nil {
+				return err
+			}
+
+			record := &migrationRecord{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now().UTC()}
+			if _, err := db.Collection(migrationsCollectionName).InsertOne(ctx, record); err != nil {
+				return wrapError(err)
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// MigrateDown reverts the last steps applied migrations, latest first
+func MigrateDown(ctx context.Context, migrations []Migration, steps int) (reverted []Migration, err error) {
+	err = withMigrationLock(ctx, func(db *mongo.Database, records map[string]*migrationRecord) error {
+		known := map[string]Migration{}
+		for _, migration := range migrations {
+			known[migration.Version] = migration
+		}
+
+		versions := make([]string, 0, len(records))
+		for version := range records {
+			versions = append(versions, version)
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(versions)))
+
+		for _, version := range versions[:min(steps, len(versions))] {
+			migration, ok := known[version]
+			if !ok {
+				return fmt.Errorf("migration %s_%s is not registered", version, records[version].Name)
+			}
+			if migration.Down == nil {
+				return fmt.Errorf("migration %s_%s cannot be reverted", version, migration.Name)
+			}
+
+			if err := runMigration(ctx, "MigrateDown", migration, migration.Down, db); err != nil {
+				return err
+			}
+
+			if _, err := db.Collection(migrationsCollectionName).DeleteOne(ctx, bson.D{{Key: "_id", Value: version}}); err != nil {
+				return wrapError(err)
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// MigrationStatuses returns the registered migrations in version order, followed by
+// the applied migrations missing from the registry
+func MigrationStatuses(ctx context.Context, migrations []Migration) ([]MigrationStatus, error) {
+	store, err := getMongoStore()
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := findMigrationRecords(ctx, store.database)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []MigrationStatus{}
+	for _, migration := range sortedMigrations(migrations) {
+		status := MigrationStatus{Migration: migration}
+		if record := records[migration.Version]; record != nil {
+			status.AppliedAt = &record.AppliedAt
+			delete(records, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+
+	unknown := []MigrationStatus{}
+	for _, record := range records {
+		unknown = append(unknown, MigrationStatus{Migration: Migration{Version: record.Version, Name: record.Name}, AppliedAt: &record.AppliedAt})
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Version < unknown[j].Version })
+	return append(statuses, unknown...), nil
+}
+
+// RunMigrations runs the up, down [STEPS] or status command of args, reporting to w
+func RunMigrations(ctx context.Context, migrations []Migration, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("expected a command: up, down [STEPS] or status")
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := MigrateUp(ctx, migrations)
+		for _, migration := range applied {
+			fmt.Fprintf(w, "Applied %s_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintln(w, "No pending migrations")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+
+		reverted, err := MigrateDown(ctx, migrations, steps)
+		for _, migration := range reverted {
+			fmt.Fprintf(w, "Reverted %s_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(reverted) == 0 {
+			fmt.Fprintln(w, "No applied migrations")
+		}
 		return err
+	case "status":
+		statuses, err := MigrationStatuses(ctx, migrations)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			if status.Up == nil {
+				appliedAt += " (not registered)"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown command %q, expected up, down [STEPS] or status", args[0])
+	}
+}
+
+func sortedMigrations(migrations []Migration) []Migration {
+	sorted := append([]Migration{}, migrations...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	return sorted
+}
+
+func runMigration(ctx context.Context, operation string, migration Migration, fn func(context.Context, *mongo.Database) error, db *mongo.Database) (err error) {
+	ctx, op := startOperation(ctx, operation, migrationsCollectionName, nil)
+	defer func() { op.end(ctx, err) }()
+
+	if err = fn(ctx, db); err != nil {
+		return fmt.Errorf("migration %s_%s failed: %w", migration.Version, migration.Name, err)
 	}
+
+	getLogger().InfoContext(ctx, "Migration run", "operation", operation, "version", migration.Version, "name", migration.Name)
 	return nil
 }
 
-func callAfterDeleteHooks(model ModelInterface) error {
-	if err := model.Deleted(); err != nil {
+// withMigrationLock runs fn with the applied migrations while holding the lock document
+// of the _migrations collection, ErrMigrationLocked is returned when another run holds it
+func withMigrationLock(ctx context.Context, fn func(db *mongo.Database, records map[string]*migrationRecord) error) error {
+	store, err := getMongoStore()
+	if err != nil {
 		return err
 	}
-	return nil
+	coll := store.database.Collection(migrationsCollectionName)
+
+	// The upsert inserts the lock unless a lock which has not expired exists, failing on its _id
+	lockedAt := time.Now().UTC()
+	_, err = coll.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: migrationLockID}, {Key: "expiresAt", Value: bson.D{{Key: "$lt", Value: lockedAt}}}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "lockedAt", Value: lockedAt}, {Key: "expiresAt", Value: lockedAt.Add(migrationLockTimeout)}}}},
+		options.UpdateOne().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrMigrationLocked
+	}
+	if err != nil {
+		return wrapError(err)
+	}
+
+	defer func() {
+		filter := bson.D{{Key: "_id", Value: migrationLockID}, {Key: "lockedAt", Value: lockedAt}}
+		if _, err := coll.DeleteOne(context.WithoutCancel(ctx), filter); err != nil {
+			getLogger().ErrorContext(ctx, "Could not release the migrations lock", "error", err)
+		}
+	}()
+
+	records, err := findMigrationRecords(ctx, store.database)
+	if err != nil {
+		return err
+	}
+	return fn(store.database, records)
+}
+
+func findMigrationRecords(ctx context.Context, db *mongo.Database) (map[string]*migrationRecord, error) {
+	cursor, err := db.Collection(migrationsCollectionName).Find(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$ne", Value: migrationLockID}}}})
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	records := []*migrationRecord{}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, wrapError(err)
+	}
+
+	recordsByVersion := map[string]*migrationRecord{}
+	for _, record := range records {
+		recordsByVersion[record.Version] = record
+	}
+	return recordsByVersion, nil
+}
+
+// MockCall is a call recorded by a generated mock, Args excludes the context
+type MockCall struct {
+	Method	string
+	Args	[]any
+}
+
+// MockCalls records the calls of the mocks generated with output.mocks
+type MockCalls struct {
+	mu	sync.Mutex
+	calls	[]MockCall
+}
+
+func (c *MockCalls) Record(method string, args ...any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, MockCall{Method: method, Args: args})
+}
+
+// Calls returns the recorded calls in order, those of method only when given
+func (c *MockCalls) Calls(method ...string) []MockCall {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	calls := []MockCall{}
+	for _, call := range c.calls {
+		if len(method) == 0 || call.Method == method[0] {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (c *MockCalls) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = nil
+}
+
+// Store holds the collections of the models, the MongoDB client is used unless Config.Store is set
+type Store interface {
+	Collection(name string) Collection
+	// Transaction runs fn atomically, fn runs in the ongoing transaction of ctx if any.
+	// ErrTransactionsNotSupported is returned before running fn when the deployment has no transactions
+	Transaction(ctx context.Context, opts *options.SessionOptionsBuilder, fn func(ctx context.Context) error) error
+	Close(ctx context.Context) error
+}
+
+// Collection holds the documents of a model, its methods mirror those of *mongo.Collection
+type Collection interface {
+	Aggregate(ctx context.Context, pipeline any, opts ...options.Lister[options.AggregateOptions]) (Cursor, error)
+	CountDocuments(ctx context.Context, filter any, opts ...options.Lister[options.CountOptions]) (int64, error)
+	DeleteOne(ctx context.Context, filter any, opts ...options.Lister[options.DeleteOneOptions]) (*mongo.DeleteResult, error)
+	DeleteMany(ctx context.Context, filter any, opts ...options.Lister[options.DeleteManyOptions]) (*mongo.DeleteResult, error)
+	Distinct(ctx context.Context, fieldName string, filter any, results any, opts ...options.Lister[options.DistinctOptions]) error
+	EstimatedDocumentCount(ctx context.Context, opts ...options.Lister[options.EstimatedDocumentCountOptions]) (int64, error)
+	Find(ctx context.Context, filter any, opts ...options.Lister[options.FindOptions]) (Cursor, error)
+	FindOne(ctx context.Context, filter any, result any, opts ...options.Lister[options.FindOneOptions]) error
+	InsertOne(ctx context.Context, document any, opts ...options.Lister[options.InsertOneOptions]) (*mongo.InsertOneResult, error)
+	UpdateByID(ctx context.Context, id any, update any, opts ...options.Lister[options.UpdateOneOptions]) (*mongo.UpdateResult, error)
+	UpdateOne(ctx context.Context, filter any, update any, opts ...options.Lister[options.UpdateOneOptions]) (*mongo.UpdateResult, error)
+	UpdateMany(ctx context.Context, filter any, update any, opts ...options.Lister[options.UpdateManyOptions]) (*mongo.UpdateResult, error)
+	Watch(ctx context.Context, pipeline any, opts ...options.Lister[options.ChangeStreamOptions]) (*mongo.ChangeStream, error)
+}
+
+// Cursor iterates over the documents found in a Collection, *mongo.Cursor implements it
+type Cursor interface {
+	Next(ctx context.Context) bool
+	Decode(val any) error
+	All(ctx context.Context, results any) error
+	Err() error
+	Close(ctx context.Context) error
+}
+
+// mongoStore is the Store of a MongoDB database
+type mongoStore struct {
+	client		*mongo.Client
+	database	*mongo.Database
+	mu		sync.Mutex
+	collections	map[string]*mongoCollection
+	transactions	*bool
+}
+
+func newMongoStore(client *mongo.Client, databaseName string) *mongoStore {
+	return &mongoStore{
+		client:		client,
+		database:	client.Database(databaseName),
+		collections:	map[string]*mongoCollection{},
+	}
+}
+
+func (s *mongoStore) Collection(name string) Collection {
+	return s.getCollection(name)
+}
+
+func (s *mongoStore) getCollection(name string) *mongoCollection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if coll, ok := s.collections[name]; ok {
+		return coll
+	}
+	s.collections[name] = &mongoCollection{coll: s.database.Collection(name)}
+	return s.collections[name]
+}
+
+func (s *mongoStore) Transaction(ctx context.Context, opts *options.SessionOptionsBuilder, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	supported, err := s.supportsTransactions(ctx)
+	if err != nil {
+		return wrapError(err)
+	}
+	if !supported {
+		return ErrTransactionsNotSupported
+	}
+
+	return s.client.UseSessionWithOptions(ctx, opts, func(ctx context.Context) error {
+		sess := mongo.SessionFromContext(ctx)
+		_, err := sess.WithTransaction(ctx, func(ctx context.Context) (any, error) {
+			return nil, fn(ctx)
+		})
+		return err
+	})
+}
+
+// supportsTransactions reports whether the deployment is a replica set or a sharded cluster,
+// standalone servers do not support transactions
+func (s *mongoStore) supportsTransactions(ctx context.Context) (bool, error) {
+	s.mu.Lock()
+	supported := s.transactions
+	s.mu.Unlock()
+	if supported != nil {
+		return *supported, nil
+	}
+
+	var hello struct {
+		SetName	string	`bson:"setName"`
+		Msg	string	`bson:"msg"`
+	}
+	if err := s.database.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return false, err
+	}
+
+	result := hello.SetName != "" || hello.Msg == "isdbgrid"
+	s.mu.Lock()
+	s.transactions = &result
+	s.mu.Unlock()
+	return result, nil
+}
+
+func (s *mongoStore) Close(ctx context.Context) error {
+	return s.client.Disconnect(ctx)
+}
+
+// mongoCollection adapts *mongo.Collection to Collection
+type mongoCollection struct {
+	coll *mongo.Collection
+}
+
+func (c *mongoCollection) Aggregate(ctx context.Context, pipeline any, opts ...options.Lister[options.AggregateOptions]) (Cursor, error) {
+	cur, err := c.coll.Aggregate(ctx, pipeline, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return cur, nil
+}
+
+func (c *mongoCollection) CountDocuments(ctx context.Context, filter any, opts ...options.Lister[options.CountOptions]) (int64, error) {
+	return c.coll.CountDocuments(ctx, filter, opts...)
+}
+
+func (c *mongoCollection) DeleteOne(ctx context.Context, filter any, opts ...options.Lister[options.DeleteOneOptions]) (*mongo.DeleteResult, error) {
+	return c.coll.DeleteOne(ctx, filter, opts...)
+}
+
+func (c *mongoCollection) DeleteMany(ctx context.Context, filter any, opts ...options.Lister[options.DeleteManyOptions]) (*mongo.DeleteResult, error) {
+	return c.coll.DeleteMany(ctx, filter, opts...)
+}
+
+func (c *mongoCollection) Distinct(ctx context.Context, fieldName string, filter any, results any, opts ...options.Lister[options.DistinctOptions]) error {
+	return c.coll.Distinct(ctx, fieldName, filter, opts...).Decode(results)
+}
+
+func (c *mongoCollection) EstimatedDocumentCount(ctx context.Context, opts ...options.Lister[options.EstimatedDocumentCountOptions]) (int64, error) {
+	return c.coll.EstimatedDocumentCount(ctx, opts...)
+}
+
+func (c *mongoCollection) Find(ctx context.Context, filter any, opts ...options.Lister[options.FindOptions]) (Cursor, error) {
+	cur, err := c.coll.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return cur, nil
+}
+
+func (c *mongoCollection) FindOne(ctx context.Context, filter any, result any, opts ...options.Lister[options.FindOneOptions]) error {
+	return c.coll.FindOne(ctx, filter, opts...).Decode(result)
+}
+
+func (c *mongoCollection) InsertOne(ctx context.Context, document any, opts ...options.Lister[options.InsertOneOptions]) (*mongo.InsertOneResult, error) {
+	return c.coll.InsertOne(ctx, document, opts...)
+}
+
+func (c *mongoCollection) UpdateByID(ctx context.Context, id any, update any, opts ...options.Lister[options.UpdateOneOptions]) (*mongo.UpdateResult, error) {
+	return c.coll.UpdateByID(ctx, id, update, opts...)
+}
+
+func (c *mongoCollection) UpdateOne(ctx context.Context, filter any, update any, opts ...options.Lister[options.UpdateOneOptions]) (*mongo.UpdateResult, error) {
+	return c.coll.UpdateOne(ctx, filter, update, opts...)
+}
+
+func (c *mongoCollection) UpdateMany(ctx context.Context, filter any, update any, opts ...options.Lister[options.UpdateManyOptions]) (*mongo.UpdateResult, error) {
+	return c.coll.UpdateMany(ctx, filter, update, opts...)
+}
+
+func (c *mongoCollection) Watch(ctx context.Context, pipeline any, opts ...options.Lister[options.ChangeStreamOptions]) (*mongo.ChangeStream, error) {
+	return c.coll.Watch(ctx, pipeline, opts...)
+}
+
+const instrumentationName = "github.com/jonoans/mongo-gen"
+
+// telemetryInstruments records a span and metrics for every operation, the global
+// OpenTelemetry providers are no-ops until the application configures them
+type telemetryInstruments struct {
+	tracer		trace.Tracer
+	duration	metric.Float64Histogram
+	errors		metric.Int64Counter
+}
+
+var defaultTelemetry = newTelemetryInstruments(nil, nil)
+
+func newTelemetryInstruments(tracerProvider trace.TracerProvider, meterProvider metric.MeterProvider) *telemetryInstruments {
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+	if meterProvider == nil {
+		meterProvider = otel.GetMeterProvider()
+	}
+
+	meter := meterProvider.Meter(instrumentationName)
+	duration, err := meter.Float64Histogram("mongogen.operation.duration", metric.WithUnit("s"), metric.WithDescription("Duration of the operations, hooks included"))
+	if err != nil {
+		otel.Handle(err)
+		duration = noop.Float64Histogram{}
+	}
+
+	errorCount, err := meter.Int64Counter("mongogen.operation.errors", metric.WithUnit("{error}"), metric.WithDescription("Number of failed operations"))
+	if err != nil {
+		otel.Handle(err)
+		errorCount = noop.Int64Counter{}
+	}
+
+	return &telemetryInstruments{
+		tracer:		tracerProvider.Tracer(instrumentationName),
+		duration:	duration,
+		errors:		errorCount,
+	}
+}
+
+type operationSpanKey struct{}
+
+// operationSpan is the span of a running operation, hooks called with its context add to its hook time
+type operationSpan struct {
+	span		trace.Span
+	start		time.Time
+	attrs		[]attribute.KeyValue
+	operation	string
+	collection	string
+	filter		any
+	returned	int
+	hookTime	time.Duration
+}
+
+// startOperation starts the span of an operation on collection, the filter is recorded with its values redacted
+func startOperation(ctx context.Context, operation string, collection string, filter any) (context.Context, *operationSpan) {
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system.name", "mongodb"),
+		attribute.String("db.operation.name", operation),
+	}
+	if collection != "" {
+		attrs = append(attrs, attribute.String("db.collection.name", collection))
+	}
+
+	name := operation
+	if collection != "" {
+		name += " " + collection
+	}
+
+	ctx, span := defaultTelemetry.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	if defaultCfg.DatabaseName != "" {
+		span.SetAttributes(attribute.String("db.namespace", defaultCfg.DatabaseName))
+	}
+	if filter != nil && span.IsRecording() {
+		span.SetAttributes(attribute.String("db.query.summary", filterShape(filter)))
+	}
+
+	op := &operationSpan{span: span, start: time.Now(), attrs: attrs, operation: operation, collection: collection, filter: filter, returned: -1}
+	return context.WithValue(ctx, operationSpanKey{}, op), op
+}
+
+// startResolver starts the span of a GetResolved_ method, its queries are recorded as child spans
+func startResolver(ctx context.Context, model string, method string) (context.Context, *operationSpan) {
+	attrs := []attribute.KeyValue{
+		attribute.String("mongogen.model", model),
+		attribute.String("mongogen.resolver", method),
+	}
+
+	ctx, span := defaultTelemetry.tracer.Start(ctx, model+"."+method, trace.WithAttributes(attrs...))
+	return ctx, &operationSpan{span: span, start: time.Now(), returned: -1}
+}
+
+// setReturned records the number of documents returned or affected by the operation
+func (o *operationSpan) setReturned(n int) {
+	o.returned = n
+}
+
+// end records the outcome of the operation and ends its span, operations without
+// attributes are resolvers and are not recorded in the metrics nor logged as slow
+func (o *operationSpan) end(ctx context.Context, err error) {
+	elapsed := time.Since(o.start)
+	if o.returned >= 0 {
+		o.span.SetAttributes(attribute.Int("db.response.returned_rows", o.returned))
+	}
+	if o.hookTime > 0 {
+		o.span.SetAttributes(attribute.Float64("mongogen.hook.duration", o.hookTime.Seconds()))
+	}
+
+	if err != nil {
+		o.span.RecordError(err)
+		o.span.SetStatus(codes.Error, err.Error())
+	}
+
+	if o.attrs != nil {
+		attrs := metric.WithAttributes(o.attrs...)
+		defaultTelemetry.duration.Record(ctx, elapsed.Seconds(), attrs)
+		if err != nil {
+			defaultTelemetry.errors.Add(ctx, 1, attrs)
+		}
+
+		if threshold := defaultCfg.SlowQueryThreshold; threshold > 0 && elapsed >= threshold {
+			args := []any{"operation", o.operation, "collection", o.collection, "duration", elapsed, "hookDuration", o.hookTime}
+			if o.filter != nil {
+				args = append(args, "filter", filterShape(o.filter))
+			}
+			if o.returned >= 0 {
+				args = append(args, "returned", o.returned)
+			}
+			getLogger().WarnContext(ctx, "Slow operation", args...)
+		}
+	}
+
+	o.span.End()
+}
+
+// addHookTime adds the time spent in a hook to the operation running in ctx
+func addHookTime(ctx context.Context, elapsed time.Duration) {
+	if op, ok := ctx.Value(operationSpanKey{}).(*operationSpan); ok {
+		op.hookTime += elapsed
+	}
+}
+
+// resultsLen returns the length of the slice results points to
+func resultsLen(results any) int {
+	value := reflect.Indirect(reflect.ValueOf(results))
+	if value.Kind() != reflect.Slice {
+		return 0
+	}
+	return value.Len()
+}
+
+// filterShape renders the filter with its values replaced by ?, keeping the fields and operators
+func filterShape(filter any) string {
+	raw, err := bson.Marshal(bson.M{"v": filter})
+	if err != nil {
+		return "?"
+	}
+
+	wrapped := bson.D{}
+	if err := bson.Unmarshal(raw, &wrapped); err != nil || len(wrapped) != 1 {
+		return "?"
+	}
+
+	shape := &strings.Builder{}
+	writeFilterShape(shape, wrapped[0].Value)
+	return shape.String()
+}
+
+func writeFilterShape(shape *strings.Builder, value any) {
+	switch v := value.(type) {
+	case bson.D:
+		shape.WriteString("{")
+		for i, elem := range v {
+			if i > 0 {
+				shape.WriteString(", ")
+			}
+			shape.WriteString(strconv.Quote(elem.Key))
+			shape.WriteString(": ")
+			writeFilterShape(shape, elem.Value)
+		}
+		shape.WriteString("}")
+	case bson.A:
+		// Arrays of values are redacted as a whole so that their length is not recorded
+		documents := len(v) > 0
+		for _, elem := range v {
+			if _, ok := elem.(bson.D); !ok {
+				documents = false
+			}
+		}
+		if !documents {
+			shape.WriteString("?")
+			return
+		}
+
+		shape.WriteString("[")
+		for i, elem := range v {
+			if i > 0 {
+				shape.WriteString(", ")
+			}
+			writeFilterShape(shape, elem)
+		}
+		shape.WriteString("]")
+	default:
+		shape.WriteString("?")
+	}
+}
+
+var (
+	ErrNotInitialised		= errors.New("client is not initialised, please call the Initialise method first")
+	ErrAlreadyInitialised		= errors.New("client is already initialised")
+	ErrNotFound			= errors.New("document not found")
+	ErrInvalidID			= errors.New("invalid object id")
+	ErrEmptyDatabaseName		= errors.New("database name is empty")
+	ErrEmptyCollectionName		= errors.New("collection name is empty")
+	ErrNotModel			= errors.New("model is not a ModelInterface")
+	ErrInvalidResults		= errors.New("results is not a pointer to a slice")
+	ErrDeleteRestricted		= errors.New("delete restricted by referencing documents")
+	ErrTransactionsNotSupported	= errors.New("deployment does not support transactions")
+	ErrNotSupported			= errors.New("operation is not supported by the store")
+	ErrValidation			= errors.New("validation failed")
+	ErrMigrationLocked		= errors.New("migrations are locked by another run")
+)
+
+// HookError is returned when a model hook fails
+type HookError struct {
+	Hook	string
+	Model	string
+	Err	error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook of %s failed: %s", e.Hook, e.Model, e.Err)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError is returned by Validate and by the writes of documents breaking
+// the rules of their mongogen tags, it matches ErrValidation
+type ValidationError struct {
+	Fields []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Error()
+	}
+	return fmt.Sprintf("%s: %s", ErrValidation, strings.Join(messages, "; "))
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// FieldError is a rule broken by the value stored at Path, e.g. ownerships.0.name
+type FieldError struct {
+	Path	string
+	Rule	string
+	Message	string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s %s", e.Path, e.Message)
+}
+
+// DuplicateKeyError is returned when a write violates a unique index
+type DuplicateKeyError struct {
+	Index	string
+	Keys	bson.M
+	Err	error
+}
+
+func (e *DuplicateKeyError) Error() string {
+	if e.Index == "" {
+		return fmt.Sprintf("duplicate key: %s", e.Err)
+	}
+	return fmt.Sprintf("duplicate key on index %s: %s", e.Index, e.Err)
+}
+
+func (e *DuplicateKeyError) Unwrap() error {
+	return e.Err
+}
+
+var duplicateKeyIndexReg = regexp.MustCompile(`index: (\S+) dup key`)
+
+// wrapError wraps driver errors with the package's error types,
+// the original driver error remains available through errors.Is/errors.As
+func wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	if mongo.IsDuplicateKeyError(err) {
+		return newDuplicateKeyError(err)
+	}
+
+	return err
+}
+
+func newDuplicateKeyError(err error) *DuplicateKeyError {
+	dupErr := &DuplicateKeyError{Err: err}
+
+	var writeException mongo.WriteException
+	if errors.As(err, &writeException) {
+		for _, writeErr := range writeException.WriteErrors {
+			if writeErr.HasErrorCode(11000) {
+				if keyValue, lookupErr := writeErr.Raw.LookupErr("keyValue"); lookupErr == nil {
+					_ = keyValue.Unmarshal(&dupErr.Keys)
+				}
+				break
+			}
+		}
+	}
+
+	if matches := duplicateKeyIndexReg.FindStringSubmatch(err.Error()); matches != nil {
+		dupErr.Index = matches[1]
+	}
+
+	return dupErr
+}
+
+func modelName(model ModelInterface) string {
+	return reflect.Indirect(reflect.ValueOf(model)).Type().Name()
+}
+
+var (
+	defaultStore	Store
+	defaultCfg	Config
+)
+
+func Ctx() context.Context {
+	ctx, _ := newCtx()
+	return ctx
+}
+
+func newCtx() (context.Context, func()) {
+	// Can't cancel context
+	return context.WithTimeout(context.Background(), defaultCfg.OperationTimeout)
+}
+
+// getLogger returns the configured logger, discarding the records when none is configured
+func getLogger() *slog.Logger {
+	if defaultCfg.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return defaultCfg.Logger
+}
+
+func getDefaultStore() (Store, error) {
+	if defaultStore == nil {
+		return nil, ErrNotInitialised
+	}
+	return defaultStore, nil
+}
+
+// getMongoStore returns the default store for functions exposing the driver's types
+func getMongoStore() (*mongoStore, error) {
+	store, err := getDefaultStore()
+	if err != nil {
+		return nil, err
+	}
+
+	mongoStore, ok := store.(*mongoStore)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T is not a MongoDB store", ErrNotSupported, store)
+	}
+	return mongoStore, nil
+}
+
+func getStoreCollection(name string) (Collection, error) {
+	store, err := getDefaultStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.Collection(name), nil
+}
+
+func assertObjectID(id any) (bson.ObjectID, error) {
+	switch v := id.(type) {
+	case bson.ObjectID:
+		return v, nil
+	case *bson.ObjectID:
+		return *v, nil
+	case string:
+		oid, err := bson.ObjectIDFromHex(v)
+		if err != nil {
+			return bson.NilObjectID, fmt.Errorf("%w: %w", ErrInvalidID, err)
+		}
+		return oid, nil
+	default:
+		return bson.NilObjectID, ErrInvalidID
+	}
+}
+
+func checkConfig(cfg *Config) error {
+	if cfg.DatabaseName == "" && cfg.Store == nil {
+		return ErrEmptyDatabaseName
+	}
+
+	// Fill default
+	if cfg.OperationTimeout == 0 {
+		cfg.OperationTimeout = time.Second * 15
+	}
+
+	if cfg.TxnSessionOptions == nil {
+		cfg.TxnSessionOptions = options.Session()
+	}
+
+	if cfg.ValidationLevel == "" {
+		cfg.ValidationLevel = "strict"
+	}
+
+	if cfg.ValidationAction == "" {
+		cfg.ValidationAction = "error"
+	}
+
+	return nil
+}
+
+func getCollectionName(model ModelInterface) (string, error) {
+	name := model.CollectionName()
+	if name == "" {
+		return "", ErrEmptyCollectionName
+	}
+	return name, nil
+}
+
+func getCollectNameFromInterface(model any) (string, error) {
+	if v, ok := model.(ModelInterface); ok {
+		return getCollectionName(v)
+	}
+	return "", ErrNotModel
+}
+
+func getCollectionNameFromSlice(results any) (string, error) {
+	resultsType := reflect.TypeOf(results)
+	if resultsType.Kind() != reflect.Ptr {
+		return "", ErrInvalidResults
+	}
+
+	resultsType = reflect.Indirect(reflect.ValueOf(results)).Type()
+	if resultsType.Kind() != reflect.Slice {
+		return "", ErrInvalidResults
+	}
+
+	elemValue := reflect.New(resultsType.Elem()).Interface()
+	return getCollectNameFromInterface(elemValue)
+}
+
+// inverseReferenceFilter matches documents whose field at path references id,
+// shape lists the slice ('s') and map ('m') containers wrapping the reference
+func inverseReferenceFilter(path string, id any, shape string) bson.M {
+	if shape == "" || shape == "s" {
+		return bson.M{path: id}
+	}
+	return bson.M{"$expr": bson.M{"$in": bson.A{id, flattenReferencesExpr("$"+path, shape)}}}
+}
+
+// flattenReferencesExpr builds an aggregation expression flattening
+// nested slices and maps of references into a single array
+func flattenReferencesExpr(expr any, shape string) any {
+	if shape == "" {
+		return bson.A{expr}
+	}
+
+	var input, item any
+	switch shape[0] {
+	case 'm':
+		input = bson.M{"$objectToArray": bson.M{"$ifNull": bson.A{expr, bson.M{}}}}
+		item = "$$this.v"
+	default:
+		input = bson.M{"$ifNull": bson.A{expr, bson.A{}}}
+		item = "$$this"
+	}
+
+	return bson.M{"$reduce": bson.M{
+		"input":	input,
+		"initialValue":	bson.A{},
+		"in":		bson.M{"$concatArrays": bson.A{"$$value", flattenReferencesExpr(item, shape[1:])}},
+	}}
+}
+
+// deleteActionsModel is implemented by models referenced by fields declaring onDelete actions
+type deleteActionsModel interface {
+	checkDeleteRestrictions(ctx context.Context) error
+	runDeleteActions(ctx context.Context) error
+}
+
+func restrictDelete(ctx context.Context, model ModelInterface, field string, filter any) error {
+	exists, err := ExistsWithCtx(ctx, model, filter)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return fmt.Errorf("%w: referenced by %s.%s", ErrDeleteRestricted, modelName(model), field)
+	}
+	return nil
+}
+
+// cascadeDelete deletes the documents matching filter and runs their own referential actions,
+// their Deleting hooks run in the transaction and may run again when it is retried,
+// their Deleted hooks run once it commits
+func cascadeDelete[T any, P interface {
+	*T
+	ModelInterface
+}](ctx context.Context, filter any) error {
+	results := []T{}
+	if err := FindManyWithCtx(ctx, &results, filter); err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		return nil
+	}
+
+	ids := make(bson.A, 0, len(results))
+	for i := range results {
+		model := P(&results[i])
+		if err := callBeforeDeleteHooks(ctx, model); err != nil {
+			return err
+		}
+		if actions, ok := any(model).(deleteActionsModel); ok {
+			if err := actions.checkDeleteRestrictions(ctx); err != nil {
+				return err
+			}
+		}
+		ids = append(ids, model.GetID())
+	}
+
+	if _, err := DeleteManyWithCtx(ctx, P(new(T)), bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return err
+	}
+
+	for i := range results {
+		model := P(&results[i])
+		if actions, ok := any(model).(deleteActionsModel); ok {
+			if err := actions.runDeleteActions(ctx); err != nil {
+				return err
+			}
+		}
+		if err := afterCommit(ctx, func() error { return callAfterDeleteHooks(ctx, model) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runInTransaction runs fn in the context's transaction, or a new transaction
+// when the deployment supports them
+func runInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(afterCommitKey{}).(*afterCommitHooks); ok {
+		return fn(ctx)
+	}
+
+	store, err := getDefaultStore()
+	if err != nil {
+		return err
+	}
+
+	// Standalone deployments do not support transactions, the store
+	// reports it before fn runs so that its writes are never repeated
+	err = transaction(ctx, store, defaultCfg.TxnSessionOptions, fn)
+	if errors.Is(err, ErrTransactionsNotSupported) {
+		return fn(ctx)
+	}
+	return err
+}
+
+// Only use when sure results is slice of ModelInterface
+func runFuncOnResultsSliceItems(results any, callback func(model ModelInterface) error) error {
+	resultsPtr := reflect.ValueOf(results)
+	resultsSlice := reflect.Indirect(resultsPtr)
+	resultsSliceLen := resultsSlice.Len()
+	for i := range resultsSliceLen {
+		item := resultsSlice.Index(i).Addr().Interface()
+		if err := callback(item.(ModelInterface)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// callHook runs a hook of the model, its duration is added to the hook time of the operation running in ctx
+func callHook(ctx context.Context, model ModelInterface, hook string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	addHookTime(ctx, time.Since(start))
+
+	if err != nil {
+		getLogger().ErrorContext(ctx, "Hook failed", "model", modelName(model), "hook", hook, "error", err)
+		return &HookError{Hook: hook, Model: modelName(model), Err: err}
+	}
+	return nil
+}
+
+func callAfterQueryHooks(ctx context.Context, model ModelInterface) error {
+	if err := callHook(ctx, model, "Queried", model.Queried); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func callBeforeCreateHooks(ctx context.Context, model ModelInterface) error {
+	if err := callHook(ctx, model, "Creating", model.Creating); err != nil {
+		return err
+	}
+
+	if err := callHook(ctx, model, "Saving", model.Saving); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func callAfterCreateHooks(ctx context.Context, model ModelInterface, result *mongo.InsertOneResult) error {
+	model.SetID(result.InsertedID)
+
+	if err := callHook(ctx, model, "Created", model.Created); err != nil {
+		return err
+	}
+
+	if err := callHook(ctx, model, "Saved", model.Saved); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func callBeforeUpdateHooks(ctx context.Context, model ModelInterface) error {
+	if err := callHook(ctx, model, "Updating", model.Updating); err != nil {
+		return err
+	}
+
+	if err := callHook(ctx, model, "Saving", model.Saving); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func callAfterUpdateHooks(ctx context.Context, model ModelInterface) error {
+	if err := callHook(ctx, model, "Updated", model.Updated); err != nil {
+		return err
+	}
+
+	if err := callHook(ctx, model, "Saved", model.Saved); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func callBeforeDeleteHooks(ctx context.Context, model ModelInterface) error {
+	if err := callHook(ctx, model, "Deleting", model.Deleting); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func callAfterDeleteHooks(ctx context.Context, model ModelInterface) error {
+	if err := callHook(ctx, model, "Deleted", model.Deleted); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MemoryStore keeps collections in memory for unit tests of code using the models.
+// Filters support equality, $eq, $ne, $gt, $gte, $lt, $lte, $in, $nin, $exists, $not,
+// $and, $or, $nor and $expr, updates support $set, $unset, $inc, $push, $addToSet and $pull
+// with the $, $[] and $[identifier] positional operators, aggregations support the $match,
+// $addFields, $set, $project, $sort, $skip and $limit stages, expressions the operators listed
+// by evalMemoryExpression. Other operators and change streams return ErrNotSupported.
+// GetClient, GetDatabase and GetCollection return ErrNotSupported and Coll returns nil
+type MemoryStore struct {
+	mu		sync.Mutex
+	txnMu		sync.Mutex
+	collections	map[string][]bson.Raw
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{collections: map[string][]bson.Raw{}}
+}
+
+func (s *MemoryStore) Collection(name string) Collection {
+	return &memoryCollection{store: s, name: name}
+}
+
+type memoryTransactionKey struct{}
+
+// memoryTransaction journals the documents written by a transaction to undo them when it fails
+type memoryTransaction struct {
+	store	*MemoryStore
+	journal	[]memoryJournalEntry
+}
+
+// memoryJournalEntry holds a document as it was before a write, before is nil for inserted documents
+type memoryJournalEntry struct {
+	collection	string
+	id		bson.RawValue
+	before		bson.Raw
+}
+
+// Transaction runs transactions one at a time and undoes the writes of fn when it fails,
+// writes made outside of the transaction are kept unless they changed the documents it wrote
+func (s *MemoryStore) Transaction(ctx context.Context, _ *options.SessionOptionsBuilder, fn func(ctx context.Context) error) error {
+	if txn, ok := ctx.Value(memoryTransactionKey{}).(*memoryTransaction); ok && txn.store == s {
+		return fn(ctx)
+	}
+
+	s.txnMu.Lock()
+	defer s.txnMu.Unlock()
+
+	txn := &memoryTransaction{store: s}
+	if err := fn(context.WithValue(ctx, memoryTransactionKey{}, txn)); err != nil {
+		txn.rollback()
+		return err
+	}
+	return nil
+}
+
+// rollback restores the documents written by the transaction, the latest writes first
+func (t *memoryTransaction) rollback() {
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+
+	for i := len(t.journal) - 1; i >= 0; i-- {
+		entry := t.journal[i]
+		docs := t.store.collections[entry.collection]
+
+		index := -1
+		for j, raw := range docs {
+			if id, err := raw.LookupErr("_id"); err == nil && id.Equal(entry.id) {
+				index = j
+				break
+			}
+		}
+
+		switch {
+		case index >= 0 && entry.before == nil:
+			docs = append(docs[:index:index], docs[index+1:]...)
+		case index >= 0:
+			docs[index] = entry.before
+		case entry.before != nil:
+			docs = append(docs, entry.before)
+		}
+		t.store.collections[entry.collection] = docs
+	}
+}
+
+func (s *MemoryStore) Close(ctx context.Context) error {
+	return nil
+}
+
+// memoryCollection stores documents as raw BSON, each operation works on decoded copies
+type memoryCollection struct {
+	store	*MemoryStore
+	name	string
+}
+
+func (c *memoryCollection) documents() ([]bson.D, error) {
+	docs := make([]bson.D, len(c.store.collections[c.name]))
+	for i, raw := range c.store.collections[c.name] {
+		if err := bson.Unmarshal(raw, &docs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+// journal records raw as written by the transaction of ctx, if any, before is nil for inserted documents.
+// It is called with the store locked
+func (c *memoryCollection) journal(ctx context.Context, raw bson.Raw, before bson.Raw) {
+	txn, ok := ctx.Value(memoryTransactionKey{}).(*memoryTransaction)
+	if !ok || txn.store != c.store {
+		return
+	}
+	txn.journal = append(txn.journal, memoryJournalEntry{collection: c.name, id: raw.Lookup("_id"), before: before})
+}
+
+// matching returns the indexes and documents matching filter
+func (c *memoryCollection) matching(filter any) ([]int, []bson.D, error) {
+	filterDoc, err := toMemoryDocument(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	docs, err := c.documents()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	indexes, matches := []int{}, []bson.D{}
+	for i, doc := range docs {
+		ok, err := matchMemoryFilter(doc, filterDoc)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if ok {
+			indexes, matches = append(indexes, i), append(matches, doc)
+		}
+	}
+	return indexes, matches, nil
+}
+
+func (c *memoryCollection) Aggregate(ctx context.Context, pipeline any, opts ...options.Lister[options.AggregateOptions]) (Cursor, error) {
+	stages, err := toMemoryValue(pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	c.store.mu.Lock()
+	docs, err := c.documents()
+	c.store.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	stageList, ok := stages.(bson.A)
+	if !ok {
+		return nil, fmt.Errorf("%w: pipeline is not an array of stages", ErrNotSupported)
+	}
+
+	for _, stage := range stageList {
+		stageDoc, ok := stage.(bson.D)
+		if !ok || len(stageDoc) != 1 {
+			return nil, fmt.Errorf("%w: stage %v", ErrNotSupported, stage)
+		}
+
+		if docs, err = applyMemoryStage(docs, stageDoc[0]); err != nil {
+			return nil, err
+		}
+	}
+	return newMemoryCursor(docs)
+}
+
+func (c *memoryCollection) CountDocuments(ctx context.Context, filter any, opts ...options.Lister[options.CountOptions]) (int64, error) {
+	countOpts, err := applyMemoryOptions(opts)
+	if err != nil {
+		return 0, err
+	}
+
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	indexes, _, err := c.matching(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	count := int64(len(indexes))
+	if countOpts.Skip != nil {
+		count = max(count-*countOpts.Skip, 0)
+	}
+	if countOpts.Limit != nil && *countOpts.Limit > 0 {
+		count = min(count, *countOpts.Limit)
+	}
+	return count, nil
+}
+
+func (c *memoryCollection) DeleteOne(ctx context.Context, filter any, opts ...options.Lister[options.DeleteOneOptions]) (*mongo.DeleteResult, error) {
+	return c.delete(ctx, filter, 1)
+}
+
+func (c *memoryCollection) DeleteMany(ctx context.Context, filter any, opts ...options.Lister[options.DeleteManyOptions]) (*mongo.DeleteResult, error) {
+	return c.delete(ctx, filter, -1)
+}
+
+// delete removes up to limit matching documents, every one when limit is negative
+func (c *memoryCollection) delete(ctx context.Context, filter any, limit int) (*mongo.DeleteResult, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	indexes, _, err := c.matching(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit >= 0 && len(indexes) > limit {
+		indexes = indexes[:limit]
+	}
+
+	deleted := map[int]bool{}
+	for _, i := range indexes {
+		deleted[i] = true
+	}
+
+	kept := []bson.Raw{}
+	for i, raw := range c.store.collections[c.name] {
+		if !deleted[i] {
+			kept = append(kept, raw)
+			continue
+		}
+		c.journal(ctx, raw, raw)
+	}
+	c.store.collections[c.name] = kept
+	return &mongo.DeleteResult{DeletedCount: int64(len(indexes)), Acknowledged: true}, nil
+}
+
+func (c *memoryCollection) Distinct(ctx context.Context, fieldName string, filter any, results any, opts ...options.Lister[options.DistinctOptions]) error {
+	c.store.mu.Lock()
+	_, docs, err := c.matching(filter)
+	c.store.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	values := bson.A{}
+	for _, doc := range docs {
+		for _, value := range memoryPathValues(doc, strings.Split(fieldName, ".")) {
+			if _, isArray := value.(bson.A); isArray || containsMemoryValue(values, value) {
+				continue
+			}
+			values = append(values, value)
+		}
+	}
+
+	raw, err := bson.Marshal(bson.D{{Key: "values", Value: values}})
+	if err != nil {
+		return err
+	}
+	return bson.Raw(raw).Lookup("values").Unmarshal(results)
+}
+
+func (c *memoryCollection) EstimatedDocumentCount(ctx context.Context, opts ...options.Lister[options.EstimatedDocumentCountOptions]) (int64, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	return int64(len(c.store.collections[c.name])), nil
+}
+
+func (c *memoryCollection) Find(ctx context.Context, filter any, opts ...options.Lister[options.FindOptions]) (Cursor, error) {
+	findOpts, err := applyMemoryOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c.store.mu.Lock()
+	_, docs, err := c.matching(filter)
+	c.store.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	limit := int64(0)
+	if findOpts.Limit != nil {
+		limit = *findOpts.Limit
+	}
+
+	docs, err = sliceMemoryDocuments(docs, findOpts.Sort, findOpts.Skip, limit, findOpts.Projection)
+	if err != nil {
+		return nil, err
+	}
+	return newMemoryCursor(docs)
+}
+
+func (c *memoryCollection) FindOne(ctx context.Context, filter any, result any, opts ...options.Lister[options.FindOneOptions]) error {
+	findOpts, err := applyMemoryOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	c.store.mu.Lock()
+	_, docs, err := c.matching(filter)
+	c.store.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	docs, err = sliceMemoryDocuments(docs, findOpts.Sort, findOpts.Skip, 1, findOpts.Projection)
+	if err != nil {
+		return err
+	}
+
+	if len(docs) == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return decodeMemoryDocument(docs[0], result)
+}
+
+func (c *memoryCollection) InsertOne(ctx context.Context, document any, opts ...options.Lister[options.InsertOneOptions]) (*mongo.InsertOneResult, error) {
+	doc, err := toMemoryDocument(document)
+	if err != nil {
+		return nil, err
+	}
+
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	id, err := c.insert(ctx, doc)
+	if err != nil {
+		return nil, err
+	}
+	return &mongo.InsertOneResult{InsertedID: id, Acknowledged: true}, nil
+}
+
+// insert adds doc, generating its _id when missing
+func (c *memoryCollection) insert(ctx context.Context, doc bson.D) (any, error) {
+	id, ok := lookupMemoryField(doc, "_id")
+	if !ok {
+		id = bson.NewObjectID()
+		doc = append(bson.D{{Key: "_id", Value: id}}, doc...)
+	}
+
+	docs, err := c.documents()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, existing := range docs {
+		if existingID, _ := lookupMemoryField(existing, "_id"); compareMemoryValues(existingID, id) == 0 {
+			return nil, mongo.WriteException{WriteErrors: mongo.WriteErrors{{
+				Code:		11000,
+				Message:	fmt.Sprintf("E11000 duplicate key error collection: %s index: _id_ dup key: { _id: %v }", c.name, id),
+			}}}
+		}
+	}
+
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	c.store.collections[c.name] = append(c.store.collections[c.name], raw)
+	c.journal(ctx, raw, nil)
+	return id, nil
+}
+
+func (c *memoryCollection) UpdateByID(ctx context.Context, id any, update any, opts ...options.Lister[options.UpdateOneOptions]) (*mongo.UpdateResult, error) {
+	return c.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}}, update, opts...)
+}
+
+func (c *memoryCollection) UpdateOne(ctx context.Context, filter any, update any, opts ...options.Lister[options.UpdateOneOptions]) (*mongo.UpdateResult, error) {
+	updateOpts, err := applyMemoryOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.update(ctx, filter, update, updateOpts.ArrayFilters, 1, updateOpts.Upsert != nil && *updateOpts.Upsert)
+}
+
+func (c *memoryCollection) UpdateMany(ctx context.Context, filter any, update any, opts ...options.Lister[options.UpdateManyOptions]) (*mongo.UpdateResult, error) {
+	updateOpts, err := applyMemoryOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.update(ctx, filter, update, updateOpts.ArrayFilters, -1, updateOpts.Upsert != nil && *updateOpts.Upsert)
+}
+
+// update applies update to up to limit matching documents, every one when limit is negative,
+// upserted documents start from the equality conditions of filter
+func (c *memoryCollection) update(ctx context.Context, filter any, update any, arrayFilters []any, limit int, upsert bool) (*mongo.UpdateResult, error) {
+	updateDoc, err := toMemoryDocument(update)
+	if err != nil {
+		return nil, err
+	}
+
+	filterDoc, err := toMemoryDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	positional, err := newMemoryPositional(filterDoc, arrayFilters)
+	if err != nil {
+		return nil, err
+	}
+
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	indexes, docs, err := c.matching(filterDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &mongo.UpdateResult{Acknowledged: true}
+	if len(indexes) == 0 && upsert {
+		doc := bson.D{}
+		for _, e := range filterDoc {
+			if cond, isDoc := e.Value.(bson.D); !strings.HasPrefix(e.Key, "$") && (!isDoc || !isMemoryOperatorDocument(cond)) {
+				doc = append(doc, e)
+			}
+		}
+
+		if doc, err = applyMemoryUpdate(doc, updateDoc, positional); err != nil {
+			return nil, err
+		}
+
+		if result.UpsertedID, err = c.insert(ctx, doc); err != nil {
+			return nil, err
+		}
+		result.UpsertedCount = 1
+		return result, nil
+	}
+
+	if limit >= 0 && len(indexes) > limit {
+		indexes = indexes[:limit]
+	}
+
+	for n, i := range indexes {
+		updated, err := applyMemoryUpdate(docs[n], updateDoc, positional)
+		if err != nil {
+			return nil, err
+		}
+
+		raw, err := bson.Marshal(updated)
+		if err != nil {
+			return nil, err
+		}
+
+		result.MatchedCount++
+		if before := c.store.collections[c.name][i]; !bytes.Equal(raw, before) {
+			result.ModifiedCount++
+			c.store.collections[c.name][i] = raw
+			c.journal(ctx, before, before)
+		}
+	}
+	return result, nil
+}
+
+func (c *memoryCollection) Watch(ctx context.Context, pipeline any, opts ...options.Lister[options.ChangeStreamOptions]) (*mongo.ChangeStream, error) {
+	return nil, fmt.Errorf("%w: change streams", ErrNotSupported)
+}
+
+// memoryCursor iterates over documents decoded from the memory store
+type memoryCursor struct {
+	docs	[]bson.Raw
+	current	bson.Raw
+}
+
+func newMemoryCursor(docs []bson.D) (*memoryCursor, error) {
+	cur := &memoryCursor{}
+	for _, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		cur.docs = append(cur.docs, raw)
+	}
+	return cur, nil
+}
+
+func (c *memoryCursor) Next(ctx context.Context) bool {
+	if len(c.docs) == 0 {
+		return false
+	}
+	c.current, c.docs = c.docs[0], c.docs[1:]
+	return true
+}
+
+func (c *memoryCursor) Decode(val any) error {
+	return bson.Unmarshal(c.current, val)
+}
+
+func (c *memoryCursor) All(ctx context.Context, results any) error {
+	resultsValue := reflect.ValueOf(results)
+	if resultsValue.Kind() != reflect.Ptr || resultsValue.Elem().Kind() != reflect.Slice {
+		return ErrInvalidResults
+	}
+
+	sliceValue := resultsValue.Elem()
+	sliceValue.Set(sliceValue.Slice(0, 0))
+	for c.Next(ctx) {
+		item := reflect.New(sliceValue.Type().Elem())
+		if err := c.Decode(item.Interface()); err != nil {
+			return err
+		}
+		sliceValue.Set(reflect.Append(sliceValue, item.Elem()))
+	}
+	return nil
+}
+
+func (c *memoryCursor) Err() error {
+	return nil
+}
+
+func (c *memoryCursor) Close(ctx context.Context) error {
+	c.docs = nil
+	return nil
+}
+
+func applyMemoryOptions[T any](listers []options.Lister[T]) (*T, error) {
+	opts := new(T)
+	for _, lister := range listers {
+		if lister == nil {
+			continue
+		}
+
+		for _, set := range lister.List() {
+			if err := set(opts); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return opts, nil
+}
+
+// toMemoryValue converts a Go value to its BSON form, documents become bson.D and arrays bson.A
+func toMemoryValue(value any) (any, error) {
+	raw, err := bson.Marshal(bson.D{{Key: "v", Value: value}})
+	if err != nil {
+		return nil, err
+	}
+
+	doc := bson.D{}
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	return doc[0].Value, nil
+}
+
+func toMemoryDocument(value any) (bson.D, error) {
+	if value == nil {
+		return bson.D{}, nil
+	}
+
+	converted, err := toMemoryValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, ok := converted.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T is not a document", ErrNotSupported, value)
+	}
+	return doc, nil
+}
+
+func decodeMemoryDocument(doc bson.D, result any) error {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, result)
+}
+
+func lookupMemoryField(doc bson.D, key string) (any, bool) {
+	for _, e := range doc {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+func isMemoryOperatorDocument(doc bson.D) bool {
+	return len(doc) > 0 && strings.HasPrefix(doc[0].Key, "$")
+}
+
+// memoryPathValues returns the values at a dotted path, arrays along the path are traversed
+// and arrays at its end contribute both themselves and their elements, as in MongoDB filters
+func memoryPathValues(value any, path []string) []any {
+	if len(path) == 0 {
+		if array, ok := value.(bson.A); ok {
+			return append([]any{array}, array...)
+		}
+		return []any{value}
+	}
+
+	switch v := value.(type) {
+	case bson.D:
+		field, ok := lookupMemoryField(v, path[0])
+		if !ok {
+			return nil
+		}
+		return memoryPathValues(field, path[1:])
+	case bson.A:
+		if i, err := strconv.Atoi(path[0]); err == nil {
+			if i < len(v) {
+				return memoryPathValues(v[i], path[1:])
+			}
+			return nil
+		}
+
+		values := []any{}
+		for _, item := range v {
+			values = append(values, memoryPathValues(item, path)...)
+		}
+		return values
+	}
+	return nil
+}
+
+// memoryPathValue returns the value at a dotted path as aggregation expressions do,
+// arrays along the path map to the arrays of the values of their elements
+func memoryPathValue(value any, path []string) any {
+	if len(path) == 0 {
+		return value
+	}
+
+	switch v := value.(type) {
+	case bson.D:
+		field, _ := lookupMemoryField(v, path[0])
+		return memoryPathValue(field, path[1:])
+	case bson.A:
+		values := bson.A{}
+		for _, item := range v {
+			if itemValue := memoryPathValue(item, path); itemValue != nil {
+				values = append(values, itemValue)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func matchMemoryFilter(doc bson.D, filter bson.D) (bool, error) {
+	for _, e := range filter {
+		var matched bool
+		var err error
+
+		switch e.Key {
+		case "$and", "$or", "$nor":
+			matched, err = matchMemoryLogical(doc, e.Key, e.Value)
+		case "$expr":
+			var value any
+			value, err = evalMemoryExpression(doc, e.Value, nil)
+			matched = isMemoryTruthy(value)
+		default:
+			if strings.HasPrefix(e.Key, "$") {
+				return false, fmt.Errorf("%w: filter operator %s", ErrNotSupported, e.Key)
+			}
+			matched, err = matchMemoryCondition(memoryPathValues(doc, strings.Split(e.Key, ".")), e.Value)
+		}
+
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchMemoryLogical(doc bson.D, op string, value any) (bool, error) {
+	filters, ok := value.(bson.A)
+	if !ok {
+		return false, fmt.Errorf("%s requires an array", op)
+	}
+
+	matches := 0
+	for _, filter := range filters {
+		filterDoc, ok := filter.(bson.D)
+		if !ok {
+			return false, fmt.Errorf("%s requires an array of documents", op)
+		}
+
+		matched, err := matchMemoryFilter(doc, filterDoc)
+		if err != nil {
+			return false, err
+		}
+
+		if matched {
+			matches++
+		}
+	}
+
+	switch op {
+	case "$and":
+		return matches == len(filters), nil
+	case "$or":
+		return matches > 0, nil
+	default:
+		return matches == 0, nil
+	}
+}
+
+// matchMemoryCondition matches the values found at a path against a value or a document of operators
+func matchMemoryCondition(values []any, cond any) (bool, error) {
+	ops, ok := cond.(bson.D)
+	if !ok || !isMemoryOperatorDocument(ops) {
+		return memoryValuesEqual(values, cond), nil
+	}
+
+	for _, op := range ops {
+		var matched bool
+		switch op.Key {
+		case "$eq":
+			matched = memoryValuesEqual(values, op.Value)
+		case "$ne":
+			matched = !memoryValuesEqual(values, op.Value)
+		case "$gt", "$gte", "$lt", "$lte":
+			matched = memoryValuesCompare(values, op.Key, op.Value)
+		case "$in", "$nin":
+			candidates, ok := op.Value.(bson.A)
+			if !ok {
+				return false, fmt.Errorf("%s requires an array", op.Key)
+			}
+
+			for _, candidate := range candidates {
+				if memoryValuesEqual(values, candidate) {
+					matched = true
+					break
+				}
+			}
+			matched = matched == (op.Key == "$in")
+		case "$exists":
+			matched = (len(values) > 0) == isMemoryTruthy(op.Value)
+		case "$not":
+			notMatched, err := matchMemoryCondition(values, op.Value)
+			if err != nil {
+				return false, err
+			}
+			matched = !notMatched
+		default:
+			return false, fmt.Errorf("%w: filter operator %s", ErrNotSupported, op.Key)
+		}
+
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// memoryValuesEqual reports whether a value equals target, null matches missing fields
+func memoryValuesEqual(values []any, target any) bool {
+	if target == nil && len(values) == 0 {
+		return true
+	}
+
+	return containsMemoryValue(values, target)
+}
+
+func memoryValuesCompare(values []any, op string, target any) bool {
+	for _, value := range values {
+		if memoryTypeRank(value) != memoryTypeRank(target) {
+			continue
+		}
+
+		cmp := compareMemoryValues(value, target)
+		if (op == "$gt" && cmp > 0) || (op == "$gte" && cmp >= 0) || (op == "$lt" && cmp < 0) || (op == "$lte" && cmp <= 0) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsMemoryValue(values bson.A, value any) bool {
+	for _, v := range values {
+		if memoryTypeRank(v) == memoryTypeRank(value) && compareMemoryValues(v, value) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func isMemoryTruthy(value any) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case int32, int64, float64:
+		return memoryNumber(v) != 0
+	default:
+		return true
+	}
+}
+
+func memoryNumber(value any) float64 {
+	switch v := value.(type) {
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	default:
+		return 0
+	}
+}
+
+// memoryTypeRank orders values of different types as MongoDB's comparison order does
+func memoryTypeRank(value any) int {
+	switch value.(type) {
+	case nil, bson.Undefined:
+		return 1
+	case int32, int64, float64:
+		return 2
+	case string, bson.Symbol:
+		return 3
+	case bson.D:
+		return 4
+	case bson.A:
+		return 5
+	case bson.Binary:
+		return 6
+	case bson.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case bson.DateTime:
+		return 9
+	case bson.Timestamp:
+		return 10
+	case bson.Regex:
+		return 11
+	default:
+		return 12
+	}
+}
+
+func compareMemoryValues(a, b any) int {
+	if rankA, rankB := memoryTypeRank(a), memoryTypeRank(b); rankA != rankB {
+		return rankA - rankB
+	}
+
+	switch x := a.(type) {
+	case int32, int64, float64:
+		numA, numB := memoryNumber(x), memoryNumber(b)
+		switch {
+		case numA < numB:
+			return -1
+		case numA > numB:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(x, fmt.Sprint(b))
+	case bson.D:
+		y := b.(bson.D)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if cmp := strings.Compare(x[i].Key, y[i].Key); cmp != 0 {
+				return cmp
+			}
+			if cmp := compareMemoryValues(x[i].Value, y[i].Value); cmp != 0 {
+				return cmp
+			}
+		}
+		return len(x) - len(y)
+	case bson.A:
+		y := b.(bson.A)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if cmp := compareMemoryValues(x[i], y[i]); cmp != 0 {
+				return cmp
+			}
+		}
+		return len(x) - len(y)
+	case bson.Binary:
+		return bytes.Compare(x.Data, b.(bson.Binary).Data)
+	case bson.ObjectID:
+		y := b.(bson.ObjectID)
+		return bytes.Compare(x[:], y[:])
+	case bool:
+		if x == b.(bool) {
+			return 0
+		} else if x {
+			return 1
+		}
+		return -1
+	case bson.DateTime:
+		y := b.(bson.DateTime)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case nil:
+		return 0
+	default:
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	}
+}
+
+// sliceMemoryDocuments sorts, skips, limits and projects found documents as find options do
+func sliceMemoryDocuments(docs []bson.D, sortSpec any, skip *int64, limit int64, projection any) ([]bson.D, error) {
+	if sortSpec != nil {
+		if err := sortMemoryDocuments(docs, sortSpec); err != nil {
+			return nil, err
+		}
+	}
+
+	if skip != nil {
+		docs = docs[min(max(*skip, 0), int64(len(docs))):]
+	}
+
+	if limit < 0 {
+		limit = -limit
+	}
+	if limit > 0 && int64(len(docs)) > limit {
+		docs = docs[:limit]
+	}
+
+	if projection != nil {
+		projectionDoc, err := toMemoryDocument(projection)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range docs {
+			if docs[i], err = projectMemoryDocument(docs[i], projectionDoc); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return docs, nil
+}
+
+func sortMemoryDocuments(docs []bson.D, sortSpec any) error {
+	spec, err := toMemoryDocument(sortSpec)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range spec {
+		if direction := memoryNumber(e.Value); direction != 1 && direction != -1 {
+			return fmt.Errorf("%w: sort direction %v of %s", ErrNotSupported, e.Value, e.Key)
+		}
+	}
+
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, e := range spec {
+			path := strings.Split(e.Key, ".")
+			cmp := compareMemoryValues(memoryPathValue(docs[i], path), memoryPathValue(docs[j], path))
+			if cmp != 0 {
+				return (cmp < 0) == (memoryNumber(e.Value) > 0)
+			}
+		}
+		return false
+	})
+	return nil
+}
+
+// projectMemoryDocument includes or excludes top level fields, _id is included unless excluded
+// and a projection of _id alone includes it only
+func projectMemoryDocument(doc bson.D, projection bson.D) (bson.D, error) {
+	include, excludeID := false, false
+	for _, e := range projection {
+		if strings.Contains(e.Key, ".") {
+			return nil, fmt.Errorf("%w: projection of nested field %s", ErrNotSupported, e.Key)
+		}
+
+		if e.Key == "_id" {
+			excludeID = !isMemoryTruthy(e.Value)
+		} else if isMemoryTruthy(e.Value) {
+			include = true
+		}
+	}
+	if len(projection) == 1 && projection[0].Key == "_id" && !excludeID {
+		include = true
+	}
+
+	projected := bson.D{}
+	for _, e := range doc {
+		value, listed := lookupMemoryField(projection, e.Key)
+		switch {
+		case e.Key == "_id":
+			if !excludeID {
+				projected = append(projected, e)
+			}
+		case include && listed && isMemoryTruthy(value), !include && !listed:
+			projected = append(projected, e)
+		}
+	}
+	return projected, nil
+}
+
+func applyMemoryStage(docs []bson.D, stage bson.E) ([]bson.D, error) {
+	switch stage.Key {
+	case "$match":
+		filter, ok := stage.Value.(bson.D)
+		if !ok {
+			return nil, errors.New("$match requires a document")
+		}
+
+		matches := []bson.D{}
+		for _, doc := range docs {
+			matched, err := matchMemoryFilter(doc, filter)
+			if err != nil {
+				return nil, err
+			}
+
+			if matched {
+				matches = append(matches, doc)
+			}
+		}
+		return matches, nil
+	case "$addFields", "$set":
+		fields, ok := stage.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("%s requires a document", stage.Key)
+		}
+
+		for i, doc := range docs {
+			for _, field := range fields {
+				value, err := evalMemoryExpression(doc, field.Value, nil)
+				if err != nil {
+					return nil, err
+				}
+
+				if docs[i], err = setMemoryPath(docs[i], field.Key, value); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return docs, nil
+	case "$project":
+		projection, ok := stage.Value.(bson.D)
+		if !ok {
+			return nil, errors.New("$project requires a document")
+		}
+		return sliceMemoryDocuments(docs, nil, nil, 0, projection)
+	case "$sort":
+		return docs, sortMemoryDocuments(docs, stage.Value)
+	case "$skip", "$limit":
+		n := int64(memoryNumber(stage.Value))
+		if stage.Key == "$skip" {
+			return sliceMemoryDocuments(docs, nil, &n, 0, nil)
+		}
+		return sliceMemoryDocuments(docs, nil, nil, n, nil)
+	default:
+		return nil, fmt.Errorf("%w: aggregation stage %s", ErrNotSupported, stage.Key)
+	}
+}
+
+// evalMemoryExpression evaluates field paths, $$variables, literals and the $literal, $eq, $in,
+// $indexOfArray, $ifNull, $concatArrays, $objectToArray and $reduce operators
+func evalMemoryExpression(doc bson.D, expr any, vars map[string]any) (any, error) {
+	switch e := expr.(type) {
+	case string:
+		if strings.HasPrefix(e, "$$") {
+			path := strings.Split(e[2:], ".")
+			value, ok := vars[path[0]]
+			if !ok {
+				return nil, fmt.Errorf("%w: variable %s", ErrNotSupported, e)
+			}
+			return memoryPathValue(value, path[1:]), nil
+		}
+		if strings.HasPrefix(e, "$") {
+			return memoryPathValue(doc, strings.Split(e[1:], ".")), nil
+		}
+		return e, nil
+	case bson.A:
+		values := bson.A{}
+		for _, item := range e {
+			value, err := evalMemoryExpression(doc, item, vars)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case bson.D:
+		if !isMemoryOperatorDocument(e) {
+			values := bson.D{}
+			for _, field := range e {
+				value, err := evalMemoryExpression(doc, field.Value, vars)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, bson.E{Key: field.Key, Value: value})
+			}
+			return values, nil
+		}
+		return evalMemoryOperator(doc, e[0], vars)
+	default:
+		return expr, nil
+	}
+}
+
+func evalMemoryOperator(doc bson.D, op bson.E, vars map[string]any) (any, error) {
+	switch op.Key {
+	case "$literal":
+		return op.Value, nil
+	case "$reduce":
+		return evalMemoryReduce(doc, op.Value, vars)
+	}
+
+	args, err := evalMemoryExpression(doc, op.Value, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	argList, ok := args.(bson.A)
+	if !ok {
+		argList = bson.A{args}
+	}
+
+	switch op.Key {
+	case "$eq", "$in", "$indexOfArray":
+		if len(argList) != 2 {
+			return nil, fmt.Errorf("%s requires 2 arguments", op.Key)
+		}
+
+		if op.Key == "$eq" {
+			return memoryValuesEqual([]any{argList[0]}, argList[1]), nil
+		}
+
+		array, item := argList[0], argList[1]
+		if op.Key == "$in" {
+			array, item = argList[1], argList[0]
+		}
+
+		arrayList, ok := array.(bson.A)
+		if !ok && (op.Key == "$in" || array != nil) {
+			return nil, fmt.Errorf("%s requires an array", op.Key)
+		}
+
+		for i, candidate := range arrayList {
+			if memoryValuesEqual([]any{candidate}, item) {
+				if op.Key == "$in" {
+					return true, nil
+				}
+				return int32(i), nil
+			}
+		}
+
+		if op.Key == "$in" {
+			return false, nil
+		} else if array == nil {
+			return nil, nil
+		}
+		return int32(-1), nil
+	case "$ifNull":
+		for _, arg := range argList {
+			if arg != nil {
+				return arg, nil
+			}
+		}
+		return nil, nil
+	case "$concatArrays":
+		values := bson.A{}
+		for _, arg := range argList {
+			if arg == nil {
+				return nil, nil
+			}
+
+			array, ok := arg.(bson.A)
+			if !ok {
+				return nil, errors.New("$concatArrays requires arrays")
+			}
+			values = append(values, array...)
+		}
+		return values, nil
+	case "$objectToArray":
+		if len(argList) != 1 {
+			return nil, errors.New("$objectToArray requires 1 argument")
+		}
+
+		object, ok := argList[0].(bson.D)
+		if !ok {
+			return nil, errors.New("$objectToArray requires a document")
+		}
+
+		values := bson.A{}
+		for _, field := range object {
+			values = append(values, bson.D{{Key: "k", Value: field.Key}, {Key: "v", Value: field.Value}})
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("%w: expression operator %s", ErrNotSupported, op.Key)
+	}
+}
+
+func evalMemoryReduce(doc bson.D, spec any, vars map[string]any) (any, error) {
+	specDoc, ok := spec.(bson.D)
+	if !ok {
+		return nil, errors.New("$reduce requires a document")
+	}
+
+	input, _ := lookupMemoryField(specDoc, "input")
+	initialValue, _ := lookupMemoryField(specDoc, "initialValue")
+	in, _ := lookupMemoryField(specDoc, "in")
+
+	inputValue, err := evalMemoryExpression(doc, input, vars)
+	if err != nil || inputValue == nil {
+		return nil, err
+	}
+
+	array, ok := inputValue.(bson.A)
+	if !ok {
+		return nil, errors.New("$reduce requires an array input")
+	}
+
+	value, err := evalMemoryExpression(doc, initialValue, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range array {
+		itemVars := map[string]any{"this": item, "value": value}
+		for name, v := range vars {
+			if name != "this" && name != "value" {
+				itemVars[name] = v
+			}
+		}
+
+		if value, err = evalMemoryExpression(doc, in, itemVars); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+func applyMemoryUpdate(doc bson.D, update bson.D, positional *memoryPositional) (bson.D, error) {
+	if !isMemoryOperatorDocument(update) {
+		return nil, fmt.Errorf("%w: replacement updates", ErrNotSupported)
+	}
+
+	for _, op := range update {
+		fields, ok := op.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("%s requires a document", op.Key)
+		}
+
+		for _, field := range fields {
+			var err error
+			switch op.Key {
+			case "$set":
+				doc, err = updateMemoryPath(doc, field.Key, positional, func(any, bool) (any, bool, error) {
+					return field.Value, false, nil
+				})
+			case "$unset":
+				doc, err = updateMemoryPath(doc, field.Key, positional, func(any, bool) (any, bool, error) {
+					return nil, true, nil
+				})
+			case "$inc":
+				doc, err = updateMemoryPath(doc, field.Key, positional, func(current any, exists bool) (any, bool, error) {
+					return addMemoryNumbers(current, field.Value), false, nil
+				})
+			case "$push", "$addToSet":
+				doc, err = updateMemoryPath(doc, field.Key, positional, func(current any, exists bool) (any, bool, error) {
+					return pushMemoryValues(current, field.Value, op.Key == "$addToSet")
+				})
+			case "$pull":
+				doc, err = updateMemoryPath(doc, field.Key, positional, func(current any, exists bool) (any, bool, error) {
+					return pullMemoryValues(current, field.Value)
+				})
+			default:
+				return nil, fmt.Errorf("%w: update operator %s", ErrNotSupported, op.Key)
+			}
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return doc, nil
+}
+
+func setMemoryPath(doc bson.D, path string, value any) (bson.D, error) {
+	return updateMemoryPath(doc, path, &memoryPositional{}, func(any, bool) (any, bool, error) {
+		return value, false, nil
+	})
+}
+
+// memoryPositional selects the array elements updated through the positional operators of update paths,
+// $ from the conditions of the query filter on the array and $[identifier] from the array filters
+type memoryPositional struct {
+	filter		bson.D
+	arrayFilters	map[string][]memoryElementCondition
+}
+
+// memoryElementCondition holds a condition on the value at path within array elements
+type memoryElementCondition struct {
+	path	[]string
+	cond	any
+}
+
+func newMemoryPositional(filter bson.D, arrayFilters []any) (*memoryPositional, error) {
+	positional := &memoryPositional{filter: filter, arrayFilters: map[string][]memoryElementCondition{}}
+	for _, arrayFilter := range arrayFilters {
+		doc, err := toMemoryDocument(arrayFilter)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range doc {
+			if strings.HasPrefix(e.Key, "$") {
+				return nil, fmt.Errorf("%w: array filter operator %s", ErrNotSupported, e.Key)
+			}
+
+			path := strings.Split(e.Key, ".")
+			positional.arrayFilters[path[0]] = append(positional.arrayFilters[path[0]], memoryElementCondition{path: path[1:], cond: e.Value})
+		}
+	}
+	return positional, nil
+}
+
+// selected returns which elements of the array found at prefix are selected by the path segment,
+// $[] selects every element, $ the first one matching the filter, $[identifier] the ones matching
+// its array filter and a number the element at that index
+func (p *memoryPositional) selected(segment string, prefix []string, array bson.A) ([]bool, error) {
+	selected := make([]bool, len(array))
+	switch {
+	case segment == "$[]":
+		for i := range selected {
+			selected[i] = true
+		}
+	case segment == "$":
+		conditions := p.filterConditions(p.filter, prefix)
+		for i, item := range array {
+			matched, err := matchMemoryElement(item, conditions)
+			if err != nil {
+				return nil, err
+			}
+
+			if matched && len(conditions) > 0 {
+				selected[i] = true
+				return selected, nil
+			}
+		}
+		return nil, fmt.Errorf("the positional operator did not find the match needed from the query for %s", strings.Join(prefix, "."))
+	case strings.HasPrefix(segment, "$[") && strings.HasSuffix(segment, "]"):
+		identifier := segment[2 : len(segment)-1]
+		conditions, ok := p.arrayFilters[identifier]
+		if !ok {
+			return nil, fmt.Errorf("no array filter found for identifier %s", identifier)
+		}
+
+		for i, item := range array {
+			matched, err := matchMemoryElement(item, conditions)
+			if err != nil {
+				return nil, err
+			}
+			selected[i] = matched
+		}
+	default:
+		i, err := strconv.Atoi(segment)
+		if err != nil || i < 0 {
+			return nil, fmt.Errorf("cannot update %s of an array", segment)
+		}
+
+		if i >= len(array) {
+			return nil, fmt.Errorf("%w: updating array element %d past the end", ErrNotSupported, i)
+		}
+		selected[i] = true
+	}
+	return selected, nil
+}
+
+// filterConditions returns the conditions of filter, and of its $and filters, on the elements of the array at prefix
+func (p *memoryPositional) filterConditions(filter bson.D, prefix []string) []memoryElementCondition {
+	conditions := []memoryElementCondition{}
+	for _, e := range filter {
+		if e.Key == "$and" {
+			filters, _ := e.Value.(bson.A)
+			for _, f := range filters {
+				if filterDoc, ok := f.(bson.D); ok {
+					conditions = append(conditions, p.filterConditions(filterDoc, prefix)...)
+				}
+			}
+			continue
+		}
+
+		path := strings.Split(e.Key, ".")
+		if len(path) >= len(prefix) && slices.Equal(path[:len(prefix)], prefix) {
+			conditions = append(conditions, memoryElementCondition{path: path[len(prefix):], cond: e.Value})
+		}
+	}
+	return conditions
+}
+
+// matchMemoryElement reports whether an array element satisfies every condition
+func matchMemoryElement(item any, conditions []memoryElementCondition) (bool, error) {
+	for _, c := range conditions {
+		matched, err := matchMemoryCondition(memoryPathValues(item, c.path), c.cond)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// updateMemoryPath replaces the value at a dotted path with the result of fn or removes it,
+// documents are created along the path and positional operators apply the rest of the path
+// to the array elements they select
+func updateMemoryPath(doc bson.D, path string, positional *memoryPositional, fn func(current any, exists bool) (any, bool, error)) (bson.D, error) {
+	updated, err := updateMemoryValue(doc, strings.Split(path, "."), nil, positional, fn)
+	if err != nil {
+		return nil, err
+	}
+	return updated.(bson.D), nil
+}
+
+// updateMemoryValue updates the value at path within value, prefix holds the field names traversed to reach it
+func updateMemoryValue(value any, path []string, prefix []string, positional *memoryPositional, fn func(current any, exists bool) (any, bool, error)) (any, error) {
+	if array, ok := value.(bson.A); ok {
+		selected, err := positional.selected(path[0], prefix, array)
+		if err != nil {
+			return nil, err
+		}
+
+		updated := bson.A{}
+		for i, item := range array {
+			if !selected[i] {
+				updated = append(updated, item)
+				continue
+			}
+
+			if len(path) == 1 {
+				// Elements are not removed from arrays, $unset sets them to null as MongoDB does
+				newItem, remove, err := fn(item, true)
+				if err != nil {
+					return nil, err
+				}
+
+				if remove {
+					newItem = nil
+				}
+				updated = append(updated, newItem)
+				continue
+			}
+
+			newItem, err := updateMemoryValue(item, path[1:], prefix, positional, fn)
+			if err != nil {
+				return nil, err
+			}
+			updated = append(updated, newItem)
+		}
+		return updated, nil
+	}
+
+	doc, ok := value.(bson.D)
+	if value == nil {
+		doc, ok = bson.D{}, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("cannot update %s of a %T", path[0], value)
+	}
+
+	index := -1
+	for i, e := range doc {
+		if e.Key == path[0] {
+			index = i
+			break
+		}
+	}
+
+	var current any
+	if index >= 0 {
+		current = doc[index].Value
+	}
+
+	var newValue any
+	if len(path) == 1 {
+		var remove bool
+		var err error
+		if newValue, remove, err = fn(current, index >= 0); err != nil {
+			return nil, err
+		}
+
+		if remove {
+			if index >= 0 {
+				doc = append(doc[:index:index], doc[index+1:]...)
+			}
+			return doc, nil
+		}
+	} else {
+		var err error
+		if newValue, err = updateMemoryValue(current, path[1:], append(prefix[:len(prefix):len(prefix)], path[0]), positional, fn); err != nil {
+			return nil, err
+		}
+	}
+
+	if index >= 0 {
+		doc[index].Value = newValue
+	} else {
+		doc = append(doc, bson.E{Key: path[0], Value: newValue})
+	}
+	return doc, nil
+}
+
+func addMemoryNumbers(a, b any) any {
+	switch {
+	case a == nil:
+		return b
+	case reflect.TypeOf(a) == reflect.TypeOf(b) && reflect.TypeOf(a).Kind() == reflect.Int32:
+		return a.(int32) + b.(int32)
+	default:
+		if _, isFloat := a.(float64); isFloat {
+			return memoryNumber(a) + memoryNumber(b)
+		}
+		if _, isFloat := b.(float64); isFloat {
+			return memoryNumber(a) + memoryNumber(b)
+		}
+		return int64(memoryNumber(a)) + int64(memoryNumber(b))
+	}
+}
+
+// pushMemoryValues appends value, or the values of $each, to an array
+func pushMemoryValues(current any, value any, unique bool) (any, bool, error) {
+	array, ok := current.(bson.A)
+	if current != nil && !ok {
+		return nil, false, fmt.Errorf("cannot push to a %T", current)
+	}
+
+	values := bson.A{value}
+	if each, ok := value.(bson.D); ok && len(each) == 1 && each[0].Key == "$each" {
+		if values, ok = each[0].Value.(bson.A); !ok {
+			return nil, false, errors.New("$each requires an array")
+		}
+	}
+
+	for _, v := range values {
+		if !unique || !containsMemoryValue(array, v) {
+			array = append(array, v)
+		}
+	}
+	return array, false, nil
+}
+
+// pullMemoryValues removes the elements equal to cond, or matching its operators or filter
+func pullMemoryValues(current any, cond any) (any, bool, error) {
+	array, ok := current.(bson.A)
+	if !ok {
+		return current, current == nil, nil
+	}
+
+	kept := bson.A{}
+	for _, item := range array {
+		var matched bool
+		var err error
+
+		condDoc, isDoc := cond.(bson.D)
+		itemDoc, itemIsDoc := item.(bson.D)
+		switch {
+		case isDoc && !isMemoryOperatorDocument(condDoc) && itemIsDoc:
+			matched, err = matchMemoryFilter(itemDoc, condDoc)
+		default:
+			matched, err = matchMemoryCondition([]any{item}, cond)
+		}
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		if !matched {
+			kept = append(kept, item)
+		}
+	}
+	return kept, false, nil
 }
 
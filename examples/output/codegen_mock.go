@@ -0,0 +1,331 @@
+package output
+
+// Code generated by mongo-gen. DO NOT EDIT.
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// AnotherModelMock implements ModelQueryMethods of AnotherModel without a database, calls are
+// recorded and answered by the function of the same name, nil functions succeed
+type AnotherModelMock struct {
+	AnotherModel
+	MockCalls
+
+	AggregateFirstFunc func(ctx context.Context, m *AnotherModel, pipeline any, opts ...options.Lister[options.AggregateOptions]) (bool, error)
+	FindFunc           func(ctx context.Context, m *AnotherModel, query any, opts ...options.Lister[options.FindOneOptions]) error
+	FindByObjectIDFunc func(ctx context.Context, m *AnotherModel, id any, opts ...options.Lister[options.FindOneOptions]) error
+	CreateFunc         func(ctx context.Context, m *AnotherModel, opts ...options.Lister[options.InsertOneOptions]) error
+	UpdateFunc         func(ctx context.Context, m *AnotherModel, opts ...options.Lister[options.UpdateOneOptions]) error
+	DeleteFunc         func(ctx context.Context, m *AnotherModel, opts ...options.Lister[options.DeleteOneOptions]) error
+}
+
+var _ ModelQueryMethods = (*AnotherModelMock)(nil)
+
+func (m *AnotherModelMock) AggregateFirst(pipeline any, opts ...options.Lister[options.AggregateOptions]) (bool, error) {
+	return m.AggregateFirstWithCtx(context.Background(), pipeline, opts...)
+}
+
+func (m *AnotherModelMock) AggregateFirstWithCtx(ctx context.Context, pipeline any, opts ...options.Lister[options.AggregateOptions]) (bool, error) {
+	m.Record("AggregateFirst", pipeline, opts)
+	if m.AggregateFirstFunc == nil {
+		return false, nil
+	}
+	return m.AggregateFirstFunc(ctx, &m.AnotherModel, pipeline, opts...)
+}
+
+func (m *AnotherModelMock) Find(query any, opts ...options.Lister[options.FindOneOptions]) error {
+	return m.FindWithCtx(context.Background(), query, opts...)
+}
+
+func (m *AnotherModelMock) FindWithCtx(ctx context.Context, query any, opts ...options.Lister[options.FindOneOptions]) error {
+	m.Record("Find", query, opts)
+	if m.FindFunc == nil {
+		return nil
+	}
+	return m.FindFunc(ctx, &m.AnotherModel, query, opts...)
+}
+
+func (m *AnotherModelMock) FindByObjectID(id any, opts ...options.Lister[options.FindOneOptions]) error {
+	return m.FindByObjectIDWithCtx(context.Background(), id, opts...)
+}
+
+func (m *AnotherModelMock) FindByObjectIDWithCtx(ctx context.Context, id any, opts ...options.Lister[options.FindOneOptions]) error {
+	m.Record("FindByObjectID", id, opts)
+	if m.FindByObjectIDFunc == nil {
+		return nil
+	}
+	return m.FindByObjectIDFunc(ctx, &m.AnotherModel, id, opts...)
+}
+
+func (m *AnotherModelMock) Create(opts ...options.Lister[options.InsertOneOptions]) error {
+	return m.CreateWithCtx(context.Background(), opts...)
+}
+
+func (m *AnotherModelMock) CreateWithCtx(ctx context.Context, opts ...options.Lister[options.InsertOneOptions]) error {
+	m.Record("Create", opts)
+	if m.CreateFunc == nil {
+		return nil
+	}
+	return m.CreateFunc(ctx, &m.AnotherModel, opts...)
+}
+
+func (m *AnotherModelMock) Update(opts ...options.Lister[options.UpdateOneOptions]) error {
+	return m.UpdateWithCtx(context.Background(), opts...)
+}
+
+func (m *AnotherModelMock) UpdateWithCtx(ctx context.Context, opts ...options.Lister[options.UpdateOneOptions]) error {
+	m.Record("Update", opts)
+	if m.UpdateFunc == nil {
+		return nil
+	}
+	return m.UpdateFunc(ctx, &m.AnotherModel, opts...)
+}
+
+func (m *AnotherModelMock) Delete(opts ...options.Lister[options.DeleteOneOptions]) error {
+	return m.DeleteWithCtx(context.Background(), opts...)
+}
+
+func (m *AnotherModelMock) DeleteWithCtx(ctx context.Context, opts ...options.Lister[options.DeleteOneOptions]) error {
+	m.Record("Delete", opts)
+	if m.DeleteFunc == nil {
+		return nil
+	}
+	return m.DeleteFunc(ctx, &m.AnotherModel, opts...)
+}
+
+// AnotherModelRepositoryMock implements Repository[AnotherModel] without a database, calls are recorded
+// and answered by the function of the same name, nil functions return ErrNotFound for
+// single documents and succeed otherwise
+type AnotherModelRepositoryMock struct {
+	MockCalls
+
+	FindByObjectIDFunc func(ctx context.Context, id any, opts ...options.Lister[options.FindOneOptions]) (*AnotherModel, error)
+	FindOneFunc        func(ctx context.Context, query any, opts ...options.Lister[options.FindOneOptions]) (*AnotherModel, error)
+	FindManyFunc       func(ctx context.Context, query any, opts ...options.Lister[options.FindOptions]) ([]AnotherModel, error)
+	CreateFunc         func(ctx context.Context, model *AnotherModel, opts ...options.Lister[options.InsertOneOptions]) error
+	UpdateFunc         func(ctx context.Context, model *AnotherModel, opts ...options.Lister[options.UpdateOneOptions]) error
+	DeleteFunc         func(ctx context.Context, model *AnotherModel, opts ...options.Lister[options.DeleteOneOptions]) error
+	CountFunc          func(ctx context.Context, filter any, opts ...options.Lister[options.CountOptions]) (int64, error)
+}
+
+var _ Repository[AnotherModel] = (*AnotherModelRepositoryMock)(nil)
+
+func (r *AnotherModelRepositoryMock) FindByObjectID(ctx context.Context, id any, opts ...options.Lister[options.FindOneOptions]) (*AnotherModel, error) {
+	r.Record("FindByObjectID", id, opts)
+	if r.FindByObjectIDFunc == nil {
+		return nil, ErrNotFound
+	}
+	return r.FindByObjectIDFunc(ctx, id, opts...)
+}
+
+func (r *AnotherModelRepositoryMock) FindOne(ctx context.Context, query any, opts ...options.Lister[options.FindOneOptions]) (*AnotherModel, error) {
+	r.Record("FindOne", query, opts)
+	if r.FindOneFunc == nil {
+		return nil, ErrNotFound
+	}
+	return r.FindOneFunc(ctx, query, opts...)
+}
+
+func (r *AnotherModelRepositoryMock) FindMany(ctx context.Context, query any, opts ...options.Lister[options.FindOptions]) ([]AnotherModel, error) {
+	r.Record("FindMany", query, opts)
+	if r.FindManyFunc == nil {
+		return []AnotherModel{}, nil
+	}
+	return r.FindManyFunc(ctx, query, opts...)
+}
+
+func (r *AnotherModelRepositoryMock) Create(ctx context.Context, model *AnotherModel, opts ...options.Lister[options.InsertOneOptions]) error {
+	r.Record("Create", model, opts)
+	if r.CreateFunc == nil {
+		return nil
+	}
+	return r.CreateFunc(ctx, model, opts...)
+}
+
+func (r *AnotherModelRepositoryMock) Update(ctx context.Context, model *AnotherModel, opts ...options.Lister[options.UpdateOneOptions]) error {
+	r.Record("Update", model, opts)
+	if r.UpdateFunc == nil {
+		return nil
+	}
+	return r.UpdateFunc(ctx, model, opts...)
+}
+
+func (r *AnotherModelRepositoryMock) Delete(ctx context.Context, model *AnotherModel, opts ...options.Lister[options.DeleteOneOptions]) error {
+	r.Record("Delete", model, opts)
+	if r.DeleteFunc == nil {
+		return nil
+	}
+	return r.DeleteFunc(ctx, model, opts...)
+}
+
+func (r *AnotherModelRepositoryMock) Count(ctx context.Context, filter any, opts ...options.Lister[options.CountOptions]) (int64, error) {
+	r.Record("Count", filter, opts)
+	if r.CountFunc == nil {
+		return 0, nil
+	}
+	return r.CountFunc(ctx, filter, opts...)
+}
+
+// ModelMock implements ModelQueryMethods of Model without a database, calls are
+// recorded and answered by the function of the same name, nil functions succeed
+type ModelMock struct {
+	Model
+	MockCalls
+
+	AggregateFirstFunc func(ctx context.Context, m *Model, pipeline any, opts ...options.Lister[options.AggregateOptions]) (bool, error)
+	FindFunc           func(ctx context.Context, m *Model, query any, opts ...options.Lister[options.FindOneOptions]) error
+	FindByObjectIDFunc func(ctx context.Context, m *Model, id any, opts ...options.Lister[options.FindOneOptions]) error
+	CreateFunc         func(ctx context.Context, m *Model, opts ...options.Lister[options.InsertOneOptions]) error
+	UpdateFunc         func(ctx context.Context, m *Model, opts ...options.Lister[options.UpdateOneOptions]) error
+	DeleteFunc         func(ctx context.Context, m *Model, opts ...options.Lister[options.DeleteOneOptions]) error
+}
+
+var _ ModelQueryMethods = (*ModelMock)(nil)
+
+func (m *ModelMock) AggregateFirst(pipeline any, opts ...options.Lister[options.AggregateOptions]) (bool, error) {
+	return m.AggregateFirstWithCtx(context.Background(), pipeline, opts...)
+}
+
+func (m *ModelMock) AggregateFirstWithCtx(ctx context.Context, pipeline any, opts ...options.Lister[options.AggregateOptions]) (bool, error) {
+	m.Record("AggregateFirst", pipeline, opts)
+	if m.AggregateFirstFunc == nil {
+		return false, nil
+	}
+	return m.AggregateFirstFunc(ctx, &m.Model, pipeline, opts...)
+}
+
+func (m *ModelMock) Find(query any, opts ...options.Lister[options.FindOneOptions]) error {
+	return m.FindWithCtx(context.Background(), query, opts...)
+}
+
+func (m *ModelMock) FindWithCtx(ctx context.Context, query any, opts ...options.Lister[options.FindOneOptions]) error {
+	m.Record("Find", query, opts)
+	if m.FindFunc == nil {
+		return nil
+	}
+	return m.FindFunc(ctx, &m.Model, query, opts...)
+}
+
+func (m *ModelMock) FindByObjectID(id any, opts ...options.Lister[options.FindOneOptions]) error {
+	return m.FindByObjectIDWithCtx(context.Background(), id, opts...)
+}
+
+func (m *ModelMock) FindByObjectIDWithCtx(ctx context.Context, id any, opts ...options.Lister[options.FindOneOptions]) error {
+	m.Record("FindByObjectID", id, opts)
+	if m.FindByObjectIDFunc == nil {
+		return nil
+	}
+	return m.FindByObjectIDFunc(ctx, &m.Model, id, opts...)
+}
+
+func (m *ModelMock) Create(opts ...options.Lister[options.InsertOneOptions]) error {
+	return m.CreateWithCtx(context.Background(), opts...)
+}
+
+func (m *ModelMock) CreateWithCtx(ctx context.Context, opts ...options.Lister[options.InsertOneOptions]) error {
+	m.Record("Create", opts)
+	if m.CreateFunc == nil {
+		return nil
+	}
+	return m.CreateFunc(ctx, &m.Model, opts...)
+}
+
+func (m *ModelMock) Update(opts ...options.Lister[options.UpdateOneOptions]) error {
+	return m.UpdateWithCtx(context.Background(), opts...)
+}
+
+func (m *ModelMock) UpdateWithCtx(ctx context.Context, opts ...options.Lister[options.UpdateOneOptions]) error {
+	m.Record("Update", opts)
+	if m.UpdateFunc == nil {
+		return nil
+	}
+	return m.UpdateFunc(ctx, &m.Model, opts...)
+}
+
+func (m *ModelMock) Delete(opts ...options.Lister[options.DeleteOneOptions]) error {
+	return m.DeleteWithCtx(context.Background(), opts...)
+}
+
+func (m *ModelMock) DeleteWithCtx(ctx context.Context, opts ...options.Lister[options.DeleteOneOptions]) error {
+	m.Record("Delete", opts)
+	if m.DeleteFunc == nil {
+		return nil
+	}
+	return m.DeleteFunc(ctx, &m.Model, opts...)
+}
+
+// ModelRepositoryMock implements Repository[Model] without a database, calls are recorded
+// and answered by the function of the same name, nil functions return ErrNotFound for
+// single documents and succeed otherwise
+type ModelRepositoryMock struct {
+	MockCalls
+
+	FindByObjectIDFunc func(ctx context.Context, id any, opts ...options.Lister[options.FindOneOptions]) (*Model, error)
+	FindOneFunc        func(ctx context.Context, query any, opts ...options.Lister[options.FindOneOptions]) (*Model, error)
+	FindManyFunc       func(ctx context.Context, query any, opts ...options.Lister[options.FindOptions]) ([]Model, error)
+	CreateFunc         func(ctx context.Context, model *Model, opts ...options.Lister[options.InsertOneOptions]) error
+	UpdateFunc         func(ctx context.Context, model *Model, opts ...options.Lister[options.UpdateOneOptions]) error
+	DeleteFunc         func(ctx context.Context, model *Model, opts ...options.Lister[options.DeleteOneOptions]) error
+	CountFunc          func(ctx context.Context, filter any, opts ...options.Lister[options.CountOptions]) (int64, error)
+}
+
+var _ Repository[Model] = (*ModelRepositoryMock)(nil)
+
+func (r *ModelRepositoryMock) FindByObjectID(ctx context.Context, id any, opts ...options.Lister[options.FindOneOptions]) (*Model, error) {
+	r.Record("FindByObjectID", id, opts)
+	if r.FindByObjectIDFunc == nil {
+		return nil, ErrNotFound
+	}
+	return r.FindByObjectIDFunc(ctx, id, opts...)
+}
+
+func (r *ModelRepositoryMock) FindOne(ctx context.Context, query any, opts ...options.Lister[options.FindOneOptions]) (*Model, error) {
+	r.Record("FindOne", query, opts)
+	if r.FindOneFunc == nil {
+		return nil, ErrNotFound
+	}
+	return r.FindOneFunc(ctx, query, opts...)
+}
+
+func (r *ModelRepositoryMock) FindMany(ctx context.Context, query any, opts ...options.Lister[options.FindOptions]) ([]Model, error) {
+	r.Record("FindMany", query, opts)
+	if r.FindManyFunc == nil {
+		return []Model{}, nil
+	}
+	return r.FindManyFunc(ctx, query, opts...)
+}
+
+func (r *ModelRepositoryMock) Create(ctx context.Context, model *Model, opts ...options.Lister[options.InsertOneOptions]) error {
+	r.Record("Create", model, opts)
+	if r.CreateFunc == nil {
+		return nil
+	}
+	return r.CreateFunc(ctx, model, opts...)
+}
+
+func (r *ModelRepositoryMock) Update(ctx context.Context, model *Model, opts ...options.Lister[options.UpdateOneOptions]) error {
+	r.Record("Update", model, opts)
+	if r.UpdateFunc == nil {
+		return nil
+	}
+	return r.UpdateFunc(ctx, model, opts...)
+}
+
+func (r *ModelRepositoryMock) Delete(ctx context.Context, model *Model, opts ...options.Lister[options.DeleteOneOptions]) error {
+	r.Record("Delete", model, opts)
+	if r.DeleteFunc == nil {
+		return nil
+	}
+	return r.DeleteFunc(ctx, model, opts...)
+}
+
+func (r *ModelRepositoryMock) Count(ctx context.Context, filter any, opts ...options.Lister[options.CountOptions]) (int64, error) {
+	r.Record("Count", filter, opts)
+	if r.CountFunc == nil {
+		return 0, nil
+	}
+	return r.CountFunc(ctx, filter, opts...)
+}
@@ -0,0 +1,293 @@
+package output
+
+// Code generated by mongo-gen. DO NOT EDIT.
+
+// The $jsonSchema validators of the collections, set on the database by ApplyValidators
+func init() {
+	registerValidator("another_models", `{
+	"bsonType": "object",
+	"properties": {
+		"_id": {
+			"bsonType": "objectId"
+		},
+		"sub": {
+			"bsonType": "object",
+			"properties": {}
+		}
+	},
+	"required": [
+		"sub"
+	],
+	"title": "AnotherModel"
+}`)
+	registerValidator("models", `{
+	"bsonType": "object",
+	"properties": {
+		"_id": {
+			"bsonType": "objectId"
+		},
+		"approvals": {
+			"bsonType": [
+				"array",
+				"null"
+			],
+			"items": {
+				"bsonType": "object",
+				"properties": {
+					"key": {
+						"bsonType": "string"
+					},
+					"value": {
+						"bsonType": "objectId"
+					}
+				},
+				"required": [
+					"key",
+					"value"
+				]
+			}
+		},
+		"invoice": {
+			"bsonType": [
+				"objectId",
+				"null"
+			]
+		},
+		"invoices": {
+			"bsonType": [
+				"array",
+				"null"
+			],
+			"items": {
+				"bsonType": "objectId"
+			}
+		},
+		"metadata": {
+			"bsonType": "object",
+			"properties": {
+				"source": {
+					"bsonType": "string"
+				},
+				"tags": {
+					"bsonType": [
+						"array",
+						"null"
+					],
+					"items": {
+						"bsonType": "string"
+					}
+				}
+			},
+			"required": [
+				"source",
+				"tags"
+			]
+		},
+		"owners": {
+			"bsonType": "object",
+			"properties": {
+				"key": {
+					"bsonType": "string"
+				},
+				"value": {
+					"bsonType": [
+						"objectId",
+						"null"
+					]
+				}
+			},
+			"required": [
+				"key",
+				"value"
+			]
+		},
+		"ownership": {
+			"bsonType": [
+				"object",
+				"null"
+			],
+			"properties": {
+				"approvers": {
+					"bsonType": [
+						"array",
+						"null"
+					],
+					"items": {
+						"bsonType": [
+							"objectId",
+							"null"
+						]
+					}
+				},
+				"owner": {
+					"bsonType": "objectId"
+				}
+			},
+			"required": [
+				"approvers",
+				"owner"
+			]
+		},
+		"ownerships": {
+			"bsonType": [
+				"array",
+				"null"
+			],
+			"items": {
+				"bsonType": "object",
+				"properties": {
+					"approvers": {
+						"bsonType": [
+							"array",
+							"null"
+						],
+						"items": {
+							"bsonType": [
+								"objectId",
+								"null"
+							]
+						}
+					},
+					"owner": {
+						"bsonType": "objectId"
+					}
+				},
+				"required": [
+					"approvers",
+					"owner"
+				]
+			}
+		},
+		"pages": {
+			"bsonType": "object",
+			"properties": {
+				"items": {
+					"bsonType": [
+						"array",
+						"null"
+					],
+					"items": {
+						"bsonType": "string"
+					}
+				},
+				"total": {
+					"bsonType": [
+						"int",
+						"long"
+					]
+				}
+			},
+			"required": [
+				"items",
+				"total"
+			]
+		},
+		"random": {
+			"bsonType": "string"
+		},
+		"reference": {
+			"bsonType": "objectId"
+		},
+		"referenceMap": {
+			"additionalProperties": {
+				"bsonType": "objectId"
+			},
+			"bsonType": [
+				"object",
+				"null"
+			]
+		},
+		"referenceMapPtr": {
+			"additionalProperties": {
+				"bsonType": [
+					"objectId",
+					"null"
+				]
+			},
+			"bsonType": [
+				"object",
+				"null"
+			]
+		},
+		"referencePtr": {
+			"bsonType": [
+				"objectId",
+				"null"
+			]
+		},
+		"referencePtrMap": {
+			"additionalProperties": {
+				"bsonType": "objectId"
+			},
+			"bsonType": [
+				"object",
+				"null"
+			]
+		},
+		"referencePtrSlice": {
+			"bsonType": [
+				"array",
+				"null"
+			],
+			"items": {
+				"bsonType": "objectId"
+			}
+		},
+		"referenceSlice": {
+			"bsonType": [
+				"array",
+				"null"
+			],
+			"items": {
+				"bsonType": "objectId"
+			}
+		},
+		"referenceSliceInSlice": {
+			"bsonType": [
+				"array",
+				"null"
+			],
+			"items": {
+				"bsonType": [
+					"array",
+					"null"
+				],
+				"items": {
+					"bsonType": [
+						"objectId",
+						"null"
+					]
+				}
+			}
+		},
+		"scores": {
+			"bsonType": "array",
+			"items": {
+				"bsonType": [
+					"int",
+					"long"
+				]
+			}
+		},
+		"sub": {
+			"bsonType": "object",
+			"properties": {}
+		}
+	},
+	"required": [
+		"approvals",
+		"invoices",
+		"metadata",
+		"owners",
+		"ownerships",
+		"pages",
+		"random",
+		"reference",
+		"referenceSlice",
+		"referenceSliceInSlice",
+		"scores",
+		"sub"
+	],
+	"title": "Model"
+}`)
+}
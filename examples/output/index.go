@@ -4,29 +4,49 @@ import (
 	"context"
 
 	"github.com/jonoans/mongo-gen/codegen"
+	"github.com/jonoans/mongo-gen/examples/output/billing"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
+// Random is a custom string type
 type Random string
 
 type AnotherModel struct {
-	codegen.BaseModel
-	Sub SubModel `bson:"sub"`
+	codegen.BaseModel `bson:",inline"`
+	Sub               SubModel `bson:"sub" json:"sub"`
 }
 
+// Model demonstrates every supported reference shape
 type Model struct {
-	codegen.BaseModel
-	Sub                   SubModel
-	Random                Random
-	Reference             bson.ObjectID
-	ReferencePtr          *bson.ObjectID
-	ReferenceSlice        []bson.ObjectID
-	ReferenceSliceInSlice [][]*bson.ObjectID
-	ReferenceMap          map[string]bson.ObjectID
-	ReferenceMapPtr       map[string]*bson.ObjectID
-	ReferencePtrSlice     *[]bson.ObjectID
-	ReferencePtrMap       *map[string]bson.ObjectID
+	codegen.BaseModel `bson:",inline"`
+	// Deprecated: Sub is kept for older documents, use Ownership instead.
+	Sub                   SubModel                      `bson:"sub" json:"sub"`
+	Random                Random                        `bson:"random" json:"random"`
+	Reference             bson.ObjectID                 `bson:"reference" json:"reference" mongogen:"inverse=FindModelsByReference"`
+	ReferencePtr          *bson.ObjectID                `bson:"referencePtr,omitempty" json:"referencePtr,omitempty" mongogen:"onDelete=setNull"`
+	ReferenceSlice        []bson.ObjectID               `bson:"referenceSlice" json:"referenceSlice" mongogen:"onDelete=setNull"`
+	ReferenceSliceInSlice [][]*bson.ObjectID            `bson:"referenceSliceInSlice" json:"referenceSliceInSlice" mongogen:"onDelete=cascade"`
+	ReferenceMap          map[string]bson.ObjectID      `bson:"referenceMap,omitempty" json:"referenceMap,omitempty" mongogen:"inverse"`
+	ReferenceMapPtr       map[string]*bson.ObjectID     `bson:"referenceMapPtr,omitempty" json:"referenceMapPtr,omitempty" mongogen:"onDelete=restrict"`
+	ReferencePtrSlice     *[]bson.ObjectID              `bson:"referencePtrSlice,omitempty" json:"referencePtrSlice,omitempty"`
+	ReferencePtrMap       *map[string]bson.ObjectID     `bson:"referencePtrMap,omitempty" json:"referencePtrMap,omitempty"`
+	Ownership             *Ownership                    `bson:"ownership,omitempty" json:"ownership,omitempty"`
+	Ownerships            []Ownership                   `bson:"ownerships" json:"ownerships"`
+	Invoice               *bson.ObjectID                `bson:"invoice,omitempty" json:"invoice,omitempty"`
+	Invoices              []bson.ObjectID               `bson:"invoices" json:"invoices"`
+	Pages                 Page[Random]                  `bson:"pages" json:"pages"`
+	Owners                Pair[string, *bson.ObjectID]  `bson:"owners" json:"owners"`
+	Approvals             []Pair[string, bson.ObjectID] `bson:"approvals" json:"approvals"`
+	Scores                [3]int                        `bson:"scores" json:"scores"`
+	Metadata              struct {
+		Source string `bson:"source"`
+		Tags   []string
+	} `bson:"metadata" json:"metadata"`
+	Callback func() error                  `bson:"-" json:"-"`
+	Events   chan string                   `bson:"-" json:"-"`
+	Labeler  interface{ Label() string }   `bson:"-" json:"-"`
+	Lookup   map[[2]string]func(int) error `bson:"-" json:"-"`
 
 	errReference                  error
 	initReference                 bool
@@ -52,6 +72,39 @@ type Model struct {
 	errReferencePtrMap            error
 	initReferencePtrMap           bool
 	resolvedReferencePtrMap       *map[string]AnotherModel
+	errInvoice                    error
+	initInvoice                   bool
+	resolvedInvoice               *billing.Invoice
+	errInvoices                   error
+	initInvoices                  bool
+	resolvedInvoices              []billing.Invoice
+	errOwners_Value               error
+	initOwners_Value              bool
+	resolvedOwners_Value          *AnotherModel
+}
+
+// Ownership records who owns and approves a model
+type Ownership struct {
+	// Owner of the model
+	Owner     bson.ObjectID    `bson:"owner" json:"owner" mongogen:"inverse"`
+	Approvers []*bson.ObjectID `bson:"approvers" json:"approvers" mongogen:"onDelete=setNull"` // Approvers are pulled when deleted
+
+	errOwner          error
+	initOwner         bool
+	resolvedOwner     AnotherModel
+	errApprovers      error
+	initApprovers     bool
+	resolvedApprovers []*AnotherModel
+}
+
+type Page[T any] struct {
+	Items []T `bson:"items" json:"items"`
+	Total int `bson:"total" json:"total" mongogen:"min=0"`
+}
+
+type Pair[K comparable, V any] struct {
+	Key   K `bson:"key" json:"key"`
+	Value V `bson:"value" json:"value"`
 }
 
 type StructAddedInOutput struct {
@@ -60,12 +113,19 @@ type StructAddedInOutput struct {
 type SubModel struct {
 }
 
+type (
+	AnotherModelChange = ChangeEvent[AnotherModel]
+	ModelChange        = ChangeEvent[Model]
+)
+
+// CollectionName returns the name of the collection storing AnotherModel documents
 func (*AnotherModel) CollectionName() string {
-	return "anotherModel"
+	return "another_models"
 }
 
+// CollectionName returns the name of the collection storing Model documents
 func (*Model) CollectionName() string {
-	return "model"
+	return "models"
 }
 
 func (m *AnotherModel) Queried() error {
@@ -140,47 +200,123 @@ func (m *Model) Deleted() error {
 	return nil
 }
 
+// FindModelsByReference returns the Model documents referencing the AnotherModel through Reference
+func (m *AnotherModel) FindModelsByReference(ctx context.Context, opts ...options.Lister[options.FindOptions]) ([]Model, error) {
+	results := []Model{}
+	err := FindManyWithCtx(ctx, &results, inverseReferenceFilter("reference", m.GetID(), ""), opts...)
+	return results, err
+}
+
+// FindModelsByReferenceMap returns the Model documents referencing the AnotherModel through ReferenceMap
+func (m *AnotherModel) FindModelsByReferenceMap(ctx context.Context, opts ...options.Lister[options.FindOptions]) ([]Model, error) {
+	results := []Model{}
+	err := FindManyWithCtx(ctx, &results, inverseReferenceFilter("referenceMap", m.GetID(), "m"), opts...)
+	return results, err
+}
+
+// FindModelsByOwnershipOwner returns the Model documents referencing the AnotherModel through Ownership.Owner
+func (m *AnotherModel) FindModelsByOwnershipOwner(ctx context.Context, opts ...options.Lister[options.FindOptions]) ([]Model, error) {
+	results := []Model{}
+	err := FindManyWithCtx(ctx, &results, inverseReferenceFilter("ownership.owner", m.GetID(), ""), opts...)
+	return results, err
+}
+
+// FindModelsByOwnershipsOwner returns the Model documents referencing the AnotherModel through Ownerships.Owner
+func (m *AnotherModel) FindModelsByOwnershipsOwner(ctx context.Context, opts ...options.Lister[options.FindOptions]) ([]Model, error) {
+	results := []Model{}
+	err := FindManyWithCtx(ctx, &results, inverseReferenceFilter("ownerships.owner", m.GetID(), "s"), opts...)
+	return results, err
+}
+
+// GetResolved_Reference returns the AnotherModel referenced by Reference with a new context, the result is cached after the first call
 func (m *Model) GetResolved_Reference() (AnotherModel, error) {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return m.GetResolved_ReferenceWithCtx(ctx)
+}
+
+// GetResolved_ReferenceWithCtx returns the AnotherModel referenced by Reference, the result is cached after the first call
+func (m *Model) GetResolved_ReferenceWithCtx(ctx context.Context) (AnotherModel, error) {
 	if m.initReference {
 		return m.resolvedReference, m.errReference
 	}
-	m.errReference = FindByObjectID(&m.resolvedReference, m.Reference)
+	ctx, span := startResolver(ctx, "Model", "GetResolved_Reference")
+	defer func() {
+		span.end(ctx, m.errReference)
+	}()
+	m.errReference = FindByObjectIDWithCtx(ctx, &m.resolvedReference, m.Reference)
 	m.initReference = true
 	return m.resolvedReference, m.errReference
 }
 
+// GetResolved_ReferencePtr returns the AnotherModel referenced by ReferencePtr with a new context, the result is cached after the first call
 func (m *Model) GetResolved_ReferencePtr() (*AnotherModel, error) {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return m.GetResolved_ReferencePtrWithCtx(ctx)
+}
+
+// GetResolved_ReferencePtrWithCtx returns the AnotherModel referenced by ReferencePtr, the result is cached after the first call
+func (m *Model) GetResolved_ReferencePtrWithCtx(ctx context.Context) (*AnotherModel, error) {
 	if m.initReferencePtr {
 		return m.resolvedReferencePtr, m.errReferencePtr
 	}
+	ctx, span := startResolver(ctx, "Model", "GetResolved_ReferencePtr")
+	defer func() {
+		span.end(ctx, m.errReferencePtr)
+	}()
 	if m.ReferencePtr == nil {
 		m.initReferencePtr = true
 		return m.resolvedReferencePtr, m.errReferencePtr
 	}
 	m.resolvedReferencePtr = new(AnotherModel)
-	m.errReferencePtr = FindByObjectID(m.resolvedReferencePtr, m.ReferencePtr)
+	m.errReferencePtr = FindByObjectIDWithCtx(ctx, m.resolvedReferencePtr, m.ReferencePtr)
 	m.initReferencePtr = true
 	return m.resolvedReferencePtr, m.errReferencePtr
 }
 
+// GetResolved_ReferenceSlice returns the AnotherModel referenced by ReferenceSlice with a new context, the result is cached after the first call
 func (m *Model) GetResolved_ReferenceSlice() ([]AnotherModel, error) {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return m.GetResolved_ReferenceSliceWithCtx(ctx)
+}
+
+// GetResolved_ReferenceSliceWithCtx returns the AnotherModel referenced by ReferenceSlice, the result is cached after the first call
+func (m *Model) GetResolved_ReferenceSliceWithCtx(ctx context.Context) ([]AnotherModel, error) {
 	if m.initReferenceSlice {
 		return m.resolvedReferenceSlice, m.errReferenceSlice
 	}
+	ctx, span := startResolver(ctx, "Model", "GetResolved_ReferenceSlice")
+	defer func() {
+		span.end(ctx, m.errReferenceSlice)
+	}()
 	if m.ReferenceSlice == nil {
 		m.initReferenceSlice = true
 		return m.resolvedReferenceSlice, m.errReferenceSlice
 	}
 	m.resolvedReferenceSlice = make([]AnotherModel, 0)
-	m.errReferenceSlice = FindByObjectIDs(&m.resolvedReferenceSlice, m.ReferenceSlice)
+	m.errReferenceSlice = FindByObjectIDsWithCtx(ctx, &m.resolvedReferenceSlice, m.ReferenceSlice)
 	m.initReferenceSlice = true
 	return m.resolvedReferenceSlice, m.errReferenceSlice
 }
 
+// GetResolved_ReferenceSliceInSlice returns the AnotherModel referenced by ReferenceSliceInSlice with a new context, the result is cached after the first call
 func (m *Model) GetResolved_ReferenceSliceInSlice() ([][]*AnotherModel, error) {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return m.GetResolved_ReferenceSliceInSliceWithCtx(ctx)
+}
+
+// GetResolved_ReferenceSliceInSliceWithCtx returns the AnotherModel referenced by ReferenceSliceInSlice, the result is cached after the first call
+func (m *Model) GetResolved_ReferenceSliceInSliceWithCtx(ctx context.Context) ([][]*AnotherModel, error) {
 	if m.initReferenceSliceInSlice {
 		return m.resolvedReferenceSliceInSlice, m.errReferenceSliceInSlice
 	}
+	ctx, span := startResolver(ctx, "Model", "GetResolved_ReferenceSliceInSlice")
+	defer func() {
+		span.end(ctx, m.errReferenceSliceInSlice)
+	}()
 	if m.ReferenceSliceInSlice == nil {
 		m.initReferenceSliceInSlice = true
 		return m.resolvedReferenceSliceInSlice, m.errReferenceSliceInSlice
@@ -196,7 +332,7 @@ func (m *Model) GetResolved_ReferenceSliceInSlice() ([][]*AnotherModel, error) {
 				continue
 			}
 			m.resolvedReferenceSliceInSlice[ka][kb] = new(AnotherModel)
-			m.errReferenceSliceInSlice = FindByObjectID(m.resolvedReferenceSliceInSlice[ka][kb], vb)
+			m.errReferenceSliceInSlice = FindByObjectIDWithCtx(ctx, m.resolvedReferenceSliceInSlice[ka][kb], vb)
 			if m.errReferenceSliceInSlice != nil {
 				m.initReferenceSliceInSlice = true
 				return m.resolvedReferenceSliceInSlice, m.errReferenceSliceInSlice
@@ -207,17 +343,30 @@ func (m *Model) GetResolved_ReferenceSliceInSlice() ([][]*AnotherModel, error) {
 	return m.resolvedReferenceSliceInSlice, m.errReferenceSliceInSlice
 }
 
+// GetResolved_ReferenceMap returns the AnotherModel referenced by ReferenceMap with a new context, the result is cached after the first call
 func (m *Model) GetResolved_ReferenceMap() (map[string]AnotherModel, error) {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return m.GetResolved_ReferenceMapWithCtx(ctx)
+}
+
+// GetResolved_ReferenceMapWithCtx returns the AnotherModel referenced by ReferenceMap, the result is cached after the first call
+func (m *Model) GetResolved_ReferenceMapWithCtx(ctx context.Context) (map[string]AnotherModel, error) {
 	if m.initReferenceMap {
 		return m.resolvedReferenceMap, m.errReferenceMap
 	}
+	ctx, span := startResolver(ctx, "Model", "GetResolved_ReferenceMap")
+	defer func() {
+		span.end(ctx, m.errReferenceMap)
+	}()
 	if m.ReferenceMap == nil {
 		m.initReferenceMap = true
 		return m.resolvedReferenceMap, m.errReferenceMap
 	}
+	m.resolvedReferenceMap = make(map[string]AnotherModel)
 	for ka, va := range m.ReferenceMap {
 		bAssign := AnotherModel{}
-		m.errReferenceMap = FindByObjectID(&bAssign, va)
+		m.errReferenceMap = FindByObjectIDWithCtx(ctx, &bAssign, va)
 		m.resolvedReferenceMap[ka] = bAssign
 		if m.errReferenceMap != nil {
 			m.initReferenceMap = true
@@ -228,22 +377,34 @@ func (m *Model) GetResolved_ReferenceMap() (map[string]AnotherModel, error) {
 	return m.resolvedReferenceMap, m.errReferenceMap
 }
 
+// GetResolved_ReferenceMapPtr returns the AnotherModel referenced by ReferenceMapPtr with a new context, the result is cached after the first call
 func (m *Model) GetResolved_ReferenceMapPtr() (map[string]*AnotherModel, error) {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return m.GetResolved_ReferenceMapPtrWithCtx(ctx)
+}
+
+// GetResolved_ReferenceMapPtrWithCtx returns the AnotherModel referenced by ReferenceMapPtr, the result is cached after the first call
+func (m *Model) GetResolved_ReferenceMapPtrWithCtx(ctx context.Context) (map[string]*AnotherModel, error) {
 	if m.initReferenceMapPtr {
 		return m.resolvedReferenceMapPtr, m.errReferenceMapPtr
 	}
+	ctx, span := startResolver(ctx, "Model", "GetResolved_ReferenceMapPtr")
+	defer func() {
+		span.end(ctx, m.errReferenceMapPtr)
+	}()
 	if m.ReferenceMapPtr == nil {
 		m.initReferenceMapPtr = true
 		return m.resolvedReferenceMapPtr, m.errReferenceMapPtr
 	}
+	m.resolvedReferenceMapPtr = make(map[string]*AnotherModel)
 	for ka, va := range m.ReferenceMapPtr {
 		if va == nil {
 			m.resolvedReferenceMapPtr[ka] = nil
 			continue
 		}
-		m.resolvedReferenceMapPtr[ka] = new(AnotherModel)
 		bAssign := new(AnotherModel)
-		m.errReferenceMapPtr = FindByObjectID(bAssign, va)
+		m.errReferenceMapPtr = FindByObjectIDWithCtx(ctx, bAssign, va)
 		m.resolvedReferenceMapPtr[ka] = bAssign
 		if m.errReferenceMapPtr != nil {
 			m.initReferenceMapPtr = true
@@ -254,45 +415,69 @@ func (m *Model) GetResolved_ReferenceMapPtr() (map[string]*AnotherModel, error)
 	return m.resolvedReferenceMapPtr, m.errReferenceMapPtr
 }
 
+// GetResolved_ReferencePtrSlice returns the AnotherModel referenced by ReferencePtrSlice with a new context, the result is cached after the first call
 func (m *Model) GetResolved_ReferencePtrSlice() (*[]AnotherModel, error) {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return m.GetResolved_ReferencePtrSliceWithCtx(ctx)
+}
+
+// GetResolved_ReferencePtrSliceWithCtx returns the AnotherModel referenced by ReferencePtrSlice, the result is cached after the first call
+func (m *Model) GetResolved_ReferencePtrSliceWithCtx(ctx context.Context) (*[]AnotherModel, error) {
 	if m.initReferencePtrSlice {
 		return m.resolvedReferencePtrSlice, m.errReferencePtrSlice
 	}
+	ctx, span := startResolver(ctx, "Model", "GetResolved_ReferencePtrSlice")
+	defer func() {
+		span.end(ctx, m.errReferencePtrSlice)
+	}()
 	if m.ReferencePtrSlice == nil {
 		m.initReferencePtrSlice = true
 		return m.resolvedReferencePtrSlice, m.errReferencePtrSlice
 	}
 	m.resolvedReferencePtrSlice = new([]AnotherModel)
-	aAssign := *m.resolvedReferencePtrSlice
 	aID := *m.ReferencePtrSlice
 	if aID == nil {
 		m.initReferencePtrSlice = true
 		return m.resolvedReferencePtrSlice, m.errReferencePtrSlice
 	}
-	m.errReferencePtrSlice = FindByObjectIDs(&aAssign, aID)
+	*m.resolvedReferencePtrSlice = make([]AnotherModel, 0)
+	m.errReferencePtrSlice = FindByObjectIDsWithCtx(ctx, m.resolvedReferencePtrSlice, aID)
 	m.initReferencePtrSlice = true
 	return m.resolvedReferencePtrSlice, m.errReferencePtrSlice
 }
 
+// GetResolved_ReferencePtrMap returns the AnotherModel referenced by ReferencePtrMap with a new context, the result is cached after the first call
 func (m *Model) GetResolved_ReferencePtrMap() (*map[string]AnotherModel, error) {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return m.GetResolved_ReferencePtrMapWithCtx(ctx)
+}
+
+// GetResolved_ReferencePtrMapWithCtx returns the AnotherModel referenced by ReferencePtrMap, the result is cached after the first call
+func (m *Model) GetResolved_ReferencePtrMapWithCtx(ctx context.Context) (*map[string]AnotherModel, error) {
 	if m.initReferencePtrMap {
 		return m.resolvedReferencePtrMap, m.errReferencePtrMap
 	}
+	ctx, span := startResolver(ctx, "Model", "GetResolved_ReferencePtrMap")
+	defer func() {
+		span.end(ctx, m.errReferencePtrMap)
+	}()
 	if m.ReferencePtrMap == nil {
 		m.initReferencePtrMap = true
 		return m.resolvedReferencePtrMap, m.errReferencePtrMap
 	}
 	m.resolvedReferencePtrMap = new(map[string]AnotherModel)
-	aAssign := *m.resolvedReferencePtrMap
 	aID := *m.ReferencePtrMap
 	if aID == nil {
 		m.initReferencePtrMap = true
 		return m.resolvedReferencePtrMap, m.errReferencePtrMap
 	}
+	*m.resolvedReferencePtrMap = make(map[string]AnotherModel)
 	for kb, vb := range aID {
 		cAssign := AnotherModel{}
-		m.errReferencePtrMap = FindByObjectID(&cAssign, vb)
-		aAssign[kb] = cAssign
+		m.errReferencePtrMap = FindByObjectIDWithCtx(ctx, &cAssign, vb)
+		(*m.resolvedReferencePtrMap)[kb] = cAssign
 		if m.errReferencePtrMap != nil {
 			m.initReferencePtrMap = true
 			return m.resolvedReferencePtrMap, m.errReferencePtrMap
@@ -302,98 +487,473 @@ func (m *Model) GetResolved_ReferencePtrMap() (*map[string]AnotherModel, error)
 	return m.resolvedReferencePtrMap, m.errReferencePtrMap
 }
 
+// GetResolved_Invoice returns the Invoice referenced by Invoice with a new context, the result is cached after the first call
+func (m *Model) GetResolved_Invoice() (*billing.Invoice, error) {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return m.GetResolved_InvoiceWithCtx(ctx)
+}
+
+// GetResolved_InvoiceWithCtx returns the Invoice referenced by Invoice, the result is cached after the first call
+func (m *Model) GetResolved_InvoiceWithCtx(ctx context.Context) (*billing.Invoice, error) {
+	if m.initInvoice {
+		return m.resolvedInvoice, m.errInvoice
+	}
+	ctx, span := startResolver(ctx, "Model", "GetResolved_Invoice")
+	defer func() {
+		span.end(ctx, m.errInvoice)
+	}()
+	if m.Invoice == nil {
+		m.initInvoice = true
+		return m.resolvedInvoice, m.errInvoice
+	}
+	m.resolvedInvoice = new(billing.Invoice)
+	m.errInvoice = billing.FindByObjectIDWithCtx(ctx, m.resolvedInvoice, m.Invoice)
+	m.initInvoice = true
+	return m.resolvedInvoice, m.errInvoice
+}
+
+// GetResolved_Invoices returns the Invoice referenced by Invoices with a new context, the result is cached after the first call
+func (m *Model) GetResolved_Invoices() ([]billing.Invoice, error) {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return m.GetResolved_InvoicesWithCtx(ctx)
+}
+
+// GetResolved_InvoicesWithCtx returns the Invoice referenced by Invoices, the result is cached after the first call
+func (m *Model) GetResolved_InvoicesWithCtx(ctx context.Context) ([]billing.Invoice, error) {
+	if m.initInvoices {
+		return m.resolvedInvoices, m.errInvoices
+	}
+	ctx, span := startResolver(ctx, "Model", "GetResolved_Invoices")
+	defer func() {
+		span.end(ctx, m.errInvoices)
+	}()
+	if m.Invoices == nil {
+		m.initInvoices = true
+		return m.resolvedInvoices, m.errInvoices
+	}
+	m.resolvedInvoices = make([]billing.Invoice, 0)
+	m.errInvoices = billing.FindByObjectIDsWithCtx(ctx, &m.resolvedInvoices, m.Invoices)
+	m.initInvoices = true
+	return m.resolvedInvoices, m.errInvoices
+}
+
+// GetResolved_Owners_Value returns the AnotherModel referenced by Owners.Value with a new context, the result is cached after the first call
+func (m *Model) GetResolved_Owners_Value() (*AnotherModel, error) {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return m.GetResolved_Owners_ValueWithCtx(ctx)
+}
+
+// GetResolved_Owners_ValueWithCtx returns the AnotherModel referenced by Owners.Value, the result is cached after the first call
+func (m *Model) GetResolved_Owners_ValueWithCtx(ctx context.Context) (*AnotherModel, error) {
+	if m.initOwners_Value {
+		return m.resolvedOwners_Value, m.errOwners_Value
+	}
+	ctx, span := startResolver(ctx, "Model", "GetResolved_Owners_Value")
+	defer func() {
+		span.end(ctx, m.errOwners_Value)
+	}()
+	if m.Owners.Value == nil {
+		m.initOwners_Value = true
+		return m.resolvedOwners_Value, m.errOwners_Value
+	}
+	m.resolvedOwners_Value = new(AnotherModel)
+	m.errOwners_Value = FindByObjectIDWithCtx(ctx, m.resolvedOwners_Value, m.Owners.Value)
+	m.initOwners_Value = true
+	return m.resolvedOwners_Value, m.errOwners_Value
+}
+
+// GetResolved_Ownership_Owner returns the AnotherModel referenced by Ownership.Owner with a new context, nil subdocuments resolve to the zero value
+func (m *Model) GetResolved_Ownership_Owner() (AnotherModel, error) {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return m.GetResolved_Ownership_OwnerWithCtx(ctx)
+}
+
+// GetResolved_Ownership_OwnerWithCtx returns the AnotherModel referenced by Ownership.Owner, nil subdocuments resolve to the zero value
+func (m *Model) GetResolved_Ownership_OwnerWithCtx(ctx context.Context) (AnotherModel, error) {
+	if m.Ownership == nil {
+		return *new(AnotherModel), nil
+	}
+	return m.Ownership.GetResolved_OwnerWithCtx(ctx)
+}
+
+// GetResolved_Ownership_Approvers returns the AnotherModel referenced by Ownership.Approvers with a new context, nil subdocuments resolve to the zero value
+func (m *Model) GetResolved_Ownership_Approvers() ([]*AnotherModel, error) {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return m.GetResolved_Ownership_ApproversWithCtx(ctx)
+}
+
+// GetResolved_Ownership_ApproversWithCtx returns the AnotherModel referenced by Ownership.Approvers, nil subdocuments resolve to the zero value
+func (m *Model) GetResolved_Ownership_ApproversWithCtx(ctx context.Context) ([]*AnotherModel, error) {
+	if m.Ownership == nil {
+		return *new([]*AnotherModel), nil
+	}
+	return m.Ownership.GetResolved_ApproversWithCtx(ctx)
+}
+
+// GetResolved_Owner returns the AnotherModel referenced by Owner with a new context, the result is cached after the first call
+func (m *Ownership) GetResolved_Owner() (AnotherModel, error) {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return m.GetResolved_OwnerWithCtx(ctx)
+}
+
+// GetResolved_OwnerWithCtx returns the AnotherModel referenced by Owner, the result is cached after the first call
+func (m *Ownership) GetResolved_OwnerWithCtx(ctx context.Context) (AnotherModel, error) {
+	if m.initOwner {
+		return m.resolvedOwner, m.errOwner
+	}
+	ctx, span := startResolver(ctx, "Ownership", "GetResolved_Owner")
+	defer func() {
+		span.end(ctx, m.errOwner)
+	}()
+	m.errOwner = FindByObjectIDWithCtx(ctx, &m.resolvedOwner, m.Owner)
+	m.initOwner = true
+	return m.resolvedOwner, m.errOwner
+}
+
+// GetResolved_Approvers returns the AnotherModel referenced by Approvers with a new context, the result is cached after the first call
+func (m *Ownership) GetResolved_Approvers() ([]*AnotherModel, error) {
+	ctx, cancel := newCtx()
+	defer cancel()
+	return m.GetResolved_ApproversWithCtx(ctx)
+}
+
+// GetResolved_ApproversWithCtx returns the AnotherModel referenced by Approvers, the result is cached after the first call
+func (m *Ownership) GetResolved_ApproversWithCtx(ctx context.Context) ([]*AnotherModel, error) {
+	if m.initApprovers {
+		return m.resolvedApprovers, m.errApprovers
+	}
+	ctx, span := startResolver(ctx, "Ownership", "GetResolved_Approvers")
+	defer func() {
+		span.end(ctx, m.errApprovers)
+	}()
+	if m.Approvers == nil {
+		m.initApprovers = true
+		return m.resolvedApprovers, m.errApprovers
+	}
+	m.resolvedApprovers = make([]*AnotherModel, len(m.Approvers))
+	for ka, va := range m.Approvers {
+		if va == nil {
+			continue
+		}
+		m.resolvedApprovers[ka] = new(AnotherModel)
+		m.errApprovers = FindByObjectIDWithCtx(ctx, m.resolvedApprovers[ka], va)
+		if m.errApprovers != nil {
+			m.initApprovers = true
+			return m.resolvedApprovers, m.errApprovers
+		}
+	}
+	m.initApprovers = true
+	return m.resolvedApprovers, m.errApprovers
+}
+
+// Validate checks the rules of Model before it is written, write Validate in the output package
+// to add checks of your own and call m.validateRules() from it
+func (m *Model) Validate() error {
+	return m.validateRules()
+}
+
+// validateRules checks the fields of Model against the rules of their mongogen tags,
+// broken rules are returned in a *ValidationError
+func (m *Model) validateRules() error {
+	if m == nil {
+		return nil
+	}
+	v := &validation{}
+	v.nested("pages", &m.Pages)
+	v.nested("owners", &m.Owners)
+	v.nested("approvals", &m.Approvals)
+	return v.err()
+}
+
+// Validate checks the rules of Page before it is written, write Validate in the output package
+// to add checks of your own and call m.validateRules() from it
+func (m *Page[T]) Validate() error {
+	return m.validateRules()
+}
+
+// validateRules checks the fields of Page against the rules of their mongogen tags,
+// broken rules are returned in a *ValidationError
+func (m *Page[T]) validateRules() error {
+	if m == nil {
+		return nil
+	}
+	v := &validation{}
+	v.nested("items", &m.Items)
+	v.min("total", m.Total, 0)
+	return v.err()
+}
+
+// Validate checks the rules of Pair before it is written, write Validate in the output package
+// to add checks of your own and call m.validateRules() from it
+func (m *Pair[K, V]) Validate() error {
+	return m.validateRules()
+}
+
+// validateRules checks the fields of Pair against the rules of their mongogen tags,
+// broken rules are returned in a *ValidationError
+func (m *Pair[K, V]) validateRules() error {
+	if m == nil {
+		return nil
+	}
+	v := &validation{}
+	v.nested("key", &m.Key)
+	v.nested("value", &m.Value)
+	return v.err()
+}
+
+// AggregateFirst runs AggregateFirst on the AnotherModel
 func (m *AnotherModel) AggregateFirst(pipeline any, opts ...options.Lister[options.AggregateOptions]) (bool, error) {
 	return AggregateFirst(m, pipeline, opts...)
 }
 
+// AggregateFirstWithCtx runs AggregateFirstWithCtx on the AnotherModel
 func (m *AnotherModel) AggregateFirstWithCtx(ctx context.Context, pipeline any, opts ...options.Lister[options.AggregateOptions]) (bool, error) {
 	return AggregateFirstWithCtx(ctx, m, pipeline, opts...)
 }
 
+// Find runs FindOne on the AnotherModel
 func (m *AnotherModel) Find(query any, opts ...options.Lister[options.FindOneOptions]) error {
 	return FindOne(m, query, opts...)
 }
 
+// FindWithCtx runs FindOneWithCtx on the AnotherModel
 func (m *AnotherModel) FindWithCtx(ctx context.Context, query any, opts ...options.Lister[options.FindOneOptions]) error {
 	return FindOneWithCtx(ctx, m, query, opts...)
 }
 
+// FindByObjectID runs FindByObjectID on the AnotherModel
 func (m *AnotherModel) FindByObjectID(id any, opts ...options.Lister[options.FindOneOptions]) error {
 	return FindByObjectID(m, id, opts...)
 }
 
+// FindByObjectIDWithCtx runs FindByObjectIDWithCtx on the AnotherModel
 func (m *AnotherModel) FindByObjectIDWithCtx(ctx context.Context, id any, opts ...options.Lister[options.FindOneOptions]) error {
 	return FindByObjectIDWithCtx(ctx, m, id, opts...)
 }
 
+// Create runs InsertOne on the AnotherModel
 func (m *AnotherModel) Create(opts ...options.Lister[options.InsertOneOptions]) error {
 	return InsertOne(m, opts...)
 }
 
+// CreateWithCtx runs InsertOneWithCtx on the AnotherModel
 func (m *AnotherModel) CreateWithCtx(ctx context.Context, opts ...options.Lister[options.InsertOneOptions]) error {
 	return InsertOneWithCtx(ctx, m, opts...)
 }
 
+// Update runs Update on the AnotherModel
 func (m *AnotherModel) Update(opts ...options.Lister[options.UpdateOneOptions]) error {
 	return Update(m, opts...)
 }
 
+// UpdateWithCtx runs UpdateWithCtx on the AnotherModel
 func (m *AnotherModel) UpdateWithCtx(ctx context.Context, opts ...options.Lister[options.UpdateOneOptions]) error {
 	return UpdateWithCtx(ctx, m, opts...)
 }
 
+// Delete runs Delete on the AnotherModel
 func (m *AnotherModel) Delete(opts ...options.Lister[options.DeleteOneOptions]) error {
 	return Delete(m, opts...)
 }
 
+// DeleteWithCtx runs DeleteWithCtx on the AnotherModel
 func (m *AnotherModel) DeleteWithCtx(ctx context.Context, opts ...options.Lister[options.DeleteOneOptions]) error {
 	return DeleteWithCtx(ctx, m, opts...)
 }
 
+// checkDeleteRestrictions fails while restrict actions protect the AnotherModel
+func (m *AnotherModel) checkDeleteRestrictions(ctx context.Context) error {
+	if err := restrictDelete(ctx, &Model{}, "ReferenceMapPtr", inverseReferenceFilter("referenceMapPtr", m.GetID(), "m")); err != nil {
+		return err
+	}
+	return nil
+}
+
+// runDeleteActions applies the cascade and setNull actions of documents referencing the AnotherModel
+func (m *AnotherModel) runDeleteActions(ctx context.Context) error {
+	if _, err := UpdateManyWithCtx(ctx, &Model{}, inverseReferenceFilter("referencePtr", m.GetID(), ""), bson.M{"$set": bson.M{"referencePtr": nil}}); err != nil {
+		return err
+	}
+	if _, err := UpdateManyWithCtx(ctx, &Model{}, inverseReferenceFilter("referenceSlice", m.GetID(), "s"), bson.M{"$pull": bson.M{"referenceSlice": m.GetID()}}); err != nil {
+		return err
+	}
+	if err := cascadeDelete[Model](ctx, inverseReferenceFilter("referenceSliceInSlice", m.GetID(), "ss")); err != nil {
+		return err
+	}
+	if _, err := UpdateManyWithCtx(ctx, &Model{}, inverseReferenceFilter("ownership.approvers", m.GetID(), "s"), bson.M{"$pull": bson.M{"ownership.approvers": m.GetID()}}); err != nil {
+		return err
+	}
+	if _, err := UpdateManyWithCtx(ctx, &Model{}, inverseReferenceFilter("ownerships.approvers", m.GetID(), "ss"), bson.M{"$pull": bson.M{"ownerships.$[].approvers": m.GetID()}}); err != nil {
+		return err
+	}
+	return nil
+}
+
+// AggregateFirst runs AggregateFirst on the Model
 func (m *Model) AggregateFirst(pipeline any, opts ...options.Lister[options.AggregateOptions]) (bool, error) {
 	return AggregateFirst(m, pipeline, opts...)
 }
 
+// AggregateFirstWithCtx runs AggregateFirstWithCtx on the Model
 func (m *Model) AggregateFirstWithCtx(ctx context.Context, pipeline any, opts ...options.Lister[options.AggregateOptions]) (bool, error) {
 	return AggregateFirstWithCtx(ctx, m, pipeline, opts...)
 }
 
+// Find runs FindOne on the Model
 func (m *Model) Find(query any, opts ...options.Lister[options.FindOneOptions]) error {
 	return FindOne(m, query, opts...)
 }
 
+// FindWithCtx runs FindOneWithCtx on the Model
 func (m *Model) FindWithCtx(ctx context.Context, query any, opts ...options.Lister[options.FindOneOptions]) error {
 	return FindOneWithCtx(ctx, m, query, opts...)
 }
 
+// FindByObjectID runs FindByObjectID on the Model
 func (m *Model) FindByObjectID(id any, opts ...options.Lister[options.FindOneOptions]) error {
 	return FindByObjectID(m, id, opts...)
 }
 
+// FindByObjectIDWithCtx runs FindByObjectIDWithCtx on the Model
 func (m *Model) FindByObjectIDWithCtx(ctx context.Context, id any, opts ...options.Lister[options.FindOneOptions]) error {
 	return FindByObjectIDWithCtx(ctx, m, id, opts...)
 }
 
+// Create runs InsertOne on the Model
 func (m *Model) Create(opts ...options.Lister[options.InsertOneOptions]) error {
 	return InsertOne(m, opts...)
 }
 
+// CreateWithCtx runs InsertOneWithCtx on the Model
 func (m *Model) CreateWithCtx(ctx context.Context, opts ...options.Lister[options.InsertOneOptions]) error {
 	return InsertOneWithCtx(ctx, m, opts...)
 }
 
+// Update runs Update on the Model
 func (m *Model) Update(opts ...options.Lister[options.UpdateOneOptions]) error {
 	return Update(m, opts...)
 }
 
+// UpdateWithCtx runs UpdateWithCtx on the Model
 func (m *Model) UpdateWithCtx(ctx context.Context, opts ...options.Lister[options.UpdateOneOptions]) error {
 	return UpdateWithCtx(ctx, m, opts...)
 }
 
+// Delete runs Delete on the Model
 func (m *Model) Delete(opts ...options.Lister[options.DeleteOneOptions]) error {
 	return Delete(m, opts...)
 }
 
+// DeleteWithCtx runs DeleteWithCtx on the Model
 func (m *Model) DeleteWithCtx(ctx context.Context, opts ...options.Lister[options.DeleteOneOptions]) error {
 	return DeleteWithCtx(ctx, m, opts...)
 }
+
+// CountAnotherModels runs CountWithCtx on the AnotherModel collection
+func CountAnotherModels(ctx context.Context, filter any, opts ...options.Lister[options.CountOptions]) (int64, error) {
+	return CountWithCtx(ctx, &AnotherModel{}, filter, opts...)
+}
+
+// EstimatedCountAnotherModels runs EstimatedDocumentCountWithCtx on the AnotherModel collection
+func EstimatedCountAnotherModels(ctx context.Context, opts ...options.Lister[options.EstimatedDocumentCountOptions]) (int64, error) {
+	return EstimatedDocumentCountWithCtx(ctx, &AnotherModel{}, opts...)
+}
+
+// ExistsAnotherModels runs ExistsWithCtx on the AnotherModel collection
+func ExistsAnotherModels(ctx context.Context, filter any) (bool, error) {
+	return ExistsWithCtx(ctx, &AnotherModel{}, filter)
+}
+
+// WatchAnotherModels subscribes to the change stream of the AnotherModel collection
+func WatchAnotherModels(ctx context.Context, pipeline any, opts *WatchOptions) (<-chan AnotherModelChange, error) {
+	return Watch[AnotherModel](ctx, pipeline, opts)
+}
+
+// CountModels runs CountWithCtx on the Model collection
+func CountModels(ctx context.Context, filter any, opts ...options.Lister[options.CountOptions]) (int64, error) {
+	return CountWithCtx(ctx, &Model{}, filter, opts...)
+}
+
+// EstimatedCountModels runs EstimatedDocumentCountWithCtx on the Model collection
+func EstimatedCountModels(ctx context.Context, opts ...options.Lister[options.EstimatedDocumentCountOptions]) (int64, error) {
+	return EstimatedDocumentCountWithCtx(ctx, &Model{}, opts...)
+}
+
+// ExistsModels runs ExistsWithCtx on the Model collection
+func ExistsModels(ctx context.Context, filter any) (bool, error) {
+	return ExistsWithCtx(ctx, &Model{}, filter)
+}
+
+// DistinctModelRandom returns the distinct values of Random in the Model documents matching filter
+func DistinctModelRandom(ctx context.Context, filter any, opts ...options.Lister[options.DistinctOptions]) ([]Random, error) {
+	results := []Random{}
+	err := DistinctWithCtx(ctx, &Model{}, "random", filter, &results, opts...)
+	return results, err
+}
+
+// DistinctModelReference returns the distinct values of Reference in the Model documents matching filter
+func DistinctModelReference(ctx context.Context, filter any, opts ...options.Lister[options.DistinctOptions]) ([]bson.ObjectID, error) {
+	results := []bson.ObjectID{}
+	err := DistinctWithCtx(ctx, &Model{}, "reference", filter, &results, opts...)
+	return results, err
+}
+
+// DistinctModelReferencePtr returns the distinct values of ReferencePtr in the Model documents matching filter
+func DistinctModelReferencePtr(ctx context.Context, filter any, opts ...options.Lister[options.DistinctOptions]) ([]bson.ObjectID, error) {
+	results := []bson.ObjectID{}
+	err := DistinctWithCtx(ctx, &Model{}, "referencePtr", filter, &results, opts...)
+	return results, err
+}
+
+// DistinctModelReferenceSlice returns the distinct values of ReferenceSlice in the Model documents matching filter
+func DistinctModelReferenceSlice(ctx context.Context, filter any, opts ...options.Lister[options.DistinctOptions]) ([]bson.ObjectID, error) {
+	results := []bson.ObjectID{}
+	err := DistinctWithCtx(ctx, &Model{}, "referenceSlice", filter, &results, opts...)
+	return results, err
+}
+
+// DistinctModelReferenceSliceInSlice returns the distinct values of ReferenceSliceInSlice in the Model documents matching filter
+func DistinctModelReferenceSliceInSlice(ctx context.Context, filter any, opts ...options.Lister[options.DistinctOptions]) ([][]*bson.ObjectID, error) {
+	results := [][]*bson.ObjectID{}
+	err := DistinctWithCtx(ctx, &Model{}, "referenceSliceInSlice", filter, &results, opts...)
+	return results, err
+}
+
+// DistinctModelReferencePtrSlice returns the distinct values of ReferencePtrSlice in the Model documents matching filter
+func DistinctModelReferencePtrSlice(ctx context.Context, filter any, opts ...options.Lister[options.DistinctOptions]) ([]bson.ObjectID, error) {
+	results := []bson.ObjectID{}
+	err := DistinctWithCtx(ctx, &Model{}, "referencePtrSlice", filter, &results, opts...)
+	return results, err
+}
+
+// DistinctModelInvoice returns the distinct values of Invoice in the Model documents matching filter
+func DistinctModelInvoice(ctx context.Context, filter any, opts ...options.Lister[options.DistinctOptions]) ([]bson.ObjectID, error) {
+	results := []bson.ObjectID{}
+	err := DistinctWithCtx(ctx, &Model{}, "invoice", filter, &results, opts...)
+	return results, err
+}
+
+// DistinctModelInvoices returns the distinct values of Invoices in the Model documents matching filter
+func DistinctModelInvoices(ctx context.Context, filter any, opts ...options.Lister[options.DistinctOptions]) ([]bson.ObjectID, error) {
+	results := []bson.ObjectID{}
+	err := DistinctWithCtx(ctx, &Model{}, "invoices", filter, &results, opts...)
+	return results, err
+}
+
+// DistinctModelScores returns the distinct values of Scores in the Model documents matching filter
+func DistinctModelScores(ctx context.Context, filter any, opts ...options.Lister[options.DistinctOptions]) ([]int, error) {
+	results := []int{}
+	err := DistinctWithCtx(ctx, &Model{}, "scores", filter, &results, opts...)
+	return results, err
+}
+
+// WatchModels subscribes to the change stream of the Model collection
+func WatchModels(ctx context.Context, pipeline any, opts *WatchOptions) (<-chan ModelChange, error) {
+	return Watch[Model](ctx, pipeline, opts)
+}
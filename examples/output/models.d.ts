@@ -0,0 +1,111 @@
+// Code generated by mongo-gen. DO NOT EDIT.
+
+import type * as billing from "./billing/models";
+
+/**
+ * Random is a custom string type
+ */
+export type Random = string;
+
+export interface AnotherModel {
+  ID: string;
+  sub: SubModel;
+}
+
+/**
+ * Model demonstrates every supported reference shape
+ */
+export interface Model {
+  ID: string;
+  /**
+   * @deprecated Sub is kept for older documents, use Ownership instead.
+   */
+  sub: SubModel;
+  random: Random;
+  reference: string;
+  referencePtr?: string | null;
+  referenceSlice: string[] | null;
+  referenceSliceInSlice: ((string | null)[] | null)[] | null;
+  referenceMap?: Record<string, string> | null;
+  referenceMapPtr?: Record<string, string | null> | null;
+  referencePtrSlice?: string[] | null;
+  referencePtrMap?: Record<string, string> | null;
+  ownership?: Ownership | null;
+  ownerships: Ownership[] | null;
+  invoice?: string | null;
+  invoices: string[] | null;
+  pages: Page<Random>;
+  owners: Pair<string, string | null>;
+  approvals: Pair<string, string>[] | null;
+  scores: number[];
+  metadata: { Source: string; Tags: string[] | null };
+}
+
+/**
+ * ModelPopulated is Model with the referenced documents in place of their IDs
+ */
+export interface ModelPopulated {
+  ID: string;
+  /**
+   * @deprecated Sub is kept for older documents, use Ownership instead.
+   */
+  sub: SubModel;
+  random: Random;
+  reference: AnotherModel;
+  referencePtr?: AnotherModel | null;
+  referenceSlice: AnotherModel[] | null;
+  referenceSliceInSlice: ((AnotherModel | null)[] | null)[] | null;
+  referenceMap?: Record<string, AnotherModel> | null;
+  referenceMapPtr?: Record<string, AnotherModel | null> | null;
+  referencePtrSlice?: AnotherModel[] | null;
+  referencePtrMap?: Record<string, AnotherModel> | null;
+  ownership?: OwnershipPopulated | null;
+  ownerships: OwnershipPopulated[] | null;
+  invoice?: billing.Invoice | null;
+  invoices: billing.Invoice[] | null;
+  pages: Page<Random>;
+  owners: Pair<string, AnotherModel | null>;
+  approvals: Pair<string, AnotherModel>[] | null;
+  scores: number[];
+  metadata: { Source: string; Tags: string[] | null };
+}
+
+/**
+ * Ownership records who owns and approves a model
+ */
+export interface Ownership {
+  /**
+   * Owner of the model
+   */
+  owner: string;
+  /**
+   * Approvers are pulled when deleted
+   */
+  approvers: (string | null)[] | null;
+}
+
+/**
+ * OwnershipPopulated is Ownership with the referenced documents in place of their IDs
+ */
+export interface OwnershipPopulated {
+  /**
+   * Owner of the model
+   */
+  owner: AnotherModel;
+  /**
+   * Approvers are pulled when deleted
+   */
+  approvers: (AnotherModel | null)[] | null;
+}
+
+export interface Page<T> {
+  items: T[] | null;
+  total: number;
+}
+
+export interface Pair<K, V> {
+  key: K;
+  value: V;
+}
+
+export interface SubModel {}
@@ -2,11 +2,12 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 
 	"github.com/jonoans/mongo-gen/codegen"
 	"github.com/jonoans/mongo-gen/config"
+	"github.com/jonoans/mongo-gen/utils"
 
 	cli "github.com/urfave/cli/v2"
 )
@@ -21,12 +22,136 @@ var generateCmd = &cli.Command{
 			Usage:       "Config file",
 			DefaultText: "orm.yml",
 		},
+		&cli.StringFlag{
+			Name:        "target",
+			Aliases:     []string{"t"},
+			Usage:       "Target to generate",
+			DefaultText: "every target",
+		},
 	},
 	Action: func(c *cli.Context) error {
 		configFilename := c.String("file")
 		config := config.ParseConfig(configFilename)
-		codegen.Generate(config)
-		return nil
+		return codegen.GenerateTarget(config, c.String("target"))
+	},
+}
+
+var schemaCmd = &cli.Command{
+	Name:  "schema",
+	Usage: "Export the JSON Schema of every collection",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "file",
+			Aliases:     []string{"f"},
+			Usage:       "Config file",
+			DefaultText: "orm.yml",
+		},
+		&cli.StringFlag{
+			Name:        "target",
+			Aliases:     []string{"t"},
+			Usage:       "Target to export",
+			DefaultText: "every target",
+		},
+		&cli.StringFlag{
+			Name:        "out",
+			Aliases:     []string{"o"},
+			Usage:       "Directory of the [COLLECTION].schema.json files",
+			DefaultText: "standard output",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		config := config.ParseConfig(c.String("file"))
+		return codegen.WriteSchemas(config, c.String("target"), c.String("out"), os.Stdout)
+	},
+}
+
+var graphCmd = &cli.Command{
+	Name:  "graph",
+	Usage: "Print the collections, their fields and references as a diagram",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "file",
+			Aliases:     []string{"f"},
+			Usage:       "Config file",
+			DefaultText: "orm.yml",
+		},
+		&cli.StringFlag{
+			Name:        "target",
+			Aliases:     []string{"t"},
+			Usage:       "Target to render",
+			DefaultText: "every target",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "Diagram format: mermaid or dot",
+			Value: "mermaid",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		config := config.ParseConfig(c.String("file"))
+		return codegen.WriteGraph(config, c.String("target"), c.String("format"), os.Stdout)
+	},
+}
+
+var migrateCmd = &cli.Command{
+	Name:  "migrate",
+	Usage: "Create and run the migrations of the database",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "file",
+			Aliases:     []string{"f"},
+			Usage:       "Config file",
+			DefaultText: "orm.yml",
+		},
+	},
+	Subcommands: []*cli.Command{
+		{
+			Name:      "new",
+			Usage:     "Create an empty migration",
+			ArgsUsage: "NAME",
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return fmt.Errorf("expected the name of the migration")
+				}
+				_, err := codegen.NewMigration(config.ParseConfig(c.String("file")), c.Args().First())
+				return err
+			},
+		},
+		{
+			Name:      "diff",
+			Usage:     "Create a migration renaming and removing the fields changed since the last generation",
+			ArgsUsage: "[NAME]",
+			Action: func(c *cli.Context) error {
+				name := "schema_changes"
+				if c.NArg() > 0 {
+					name = c.Args().First()
+				}
+				_, err := codegen.DiffMigration(config.ParseConfig(c.String("file")), name)
+				return err
+			},
+		},
+		{
+			Name:  "up",
+			Usage: "Apply the pending migrations",
+			Action: func(c *cli.Context) error {
+				return codegen.RunMigrations(config.ParseConfig(c.String("file")), []string{"up"})
+			},
+		},
+		{
+			Name:      "down",
+			Usage:     "Revert the last applied migrations, one by default",
+			ArgsUsage: "[STEPS]",
+			Action: func(c *cli.Context) error {
+				return codegen.RunMigrations(config.ParseConfig(c.String("file")), append([]string{"down"}, c.Args().Slice()...))
+			},
+		},
+		{
+			Name:  "status",
+			Usage: "List the migrations and when they were applied",
+			Action: func(c *cli.Context) error {
+				return codegen.RunMigrations(config.ParseConfig(c.String("file")), []string{"status"})
+			},
+		},
 	},
 }
 
@@ -34,14 +159,37 @@ func main() {
 	app := cli.NewApp()
 	app.Name = "Go MongoORM"
 	app.Usage = "Generate usable model code from struct models"
+	app.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:  "log-level",
+			Usage: "Minimum level of the logged diagnostics: debug, info, warn or error",
+			Value: "info",
+		},
+		&cli.StringFlag{
+			Name:  "log-format",
+			Usage: "Format of the logged diagnostics: text or json",
+			Value: utils.LogFormatText,
+		},
+	}
+	app.Before = func(c *cli.Context) error {
+		logger, err := utils.NewLogger(os.Stderr, c.String("log-level"), c.String("log-format"))
+		if err != nil {
+			return err
+		}
+		slog.SetDefault(logger)
+		return nil
+	}
 	app.Action = func(c *cli.Context) error {
 		fmt.Println("Setup your orm.yml file and run `go run github.com/jonoans/mongo-gen generate` to begin!")
 		return nil
 	}
 	app.Commands = []*cli.Command{
 		generateCmd,
+		schemaCmd,
+		graphCmd,
+		migrateCmd,
 	}
 	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
+		utils.Fatal("Could not run command", "error", err)
 	}
 }
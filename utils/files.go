@@ -47,31 +47,24 @@ func DirExists(dirname string) bool {
 	return err == nil && i.IsDir()
 }
 
-func RecursiveSearchDirForFile(currDir, searchFilename string) (string, error) {
-	i, err := os.Stat(currDir)
+// FindFileUpwards looks for filename in dir and its parent directories,
+// dir itself does not need to exist
+func FindFileUpwards(dir, filename string) (string, bool) {
+	dir, err := filepath.Abs(dir)
 	if err != nil {
-		return "", err
+		return "", false
 	}
 
-	if i.IsDir() {
-		if files, err := os.ReadDir(currDir); err == nil {
-			for _, file := range files {
-				newPath := filepath.Join(currDir, file.Name())
-				path, err := RecursiveSearchDirForFile(newPath, searchFilename)
-				if path != "" {
-					return path, nil
-				} else if err != nil {
-					return "", err
-				}
-			}
-		} else {
-			return "", err
+	for {
+		candidate := filepath.Join(dir, filename)
+		if FileExists(candidate) {
+			return candidate, true
 		}
-	} else {
-		if i.Name() == searchFilename {
-			return currDir, nil
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
 		}
+		dir = parent
 	}
-
-	return "", nil
 }